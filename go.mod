@@ -5,17 +5,20 @@ go 1.23.0
 toolchain go1.23.2
 
 require (
+	filippo.io/edwards25519 v1.0.0
 	github.com/blockfrost/blockfrost-go v0.3.0
 	github.com/bwmarrin/discordgo v0.28.1
 	github.com/cardano-community/koios-go-client/v4 v4.0.0
+	github.com/fogleman/gg v1.3.0
+	github.com/tyler-smith/go-bip39 v1.1.0
 	go.mongodb.org/mongo-driver v1.17.1
+	golang.org/x/crypto v0.26.0
 	golang.org/x/exp v0.0.0-20250210185358-939b2ce775ac
+	golang.org/x/text v0.25.0
 )
 
 require (
-	filippo.io/edwards25519 v1.0.0 // indirect
 	github.com/echovl/ed25519 v0.2.0 // indirect
-	github.com/fogleman/gg v1.3.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
@@ -32,11 +35,9 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78 // indirect
-	golang.org/x/crypto v0.26.0 // indirect
 	golang.org/x/image v0.27.0 // indirect
 	golang.org/x/mod v0.23.0 // indirect
 	golang.org/x/sync v0.14.0 // indirect
 	golang.org/x/sys v0.30.0 // indirect
-	golang.org/x/text v0.25.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 )
@@ -9,9 +9,12 @@ import (
 	"strings"
 	"time"
 
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/cv"
 	mongo "cardano-valley/pkg/db"
 	"cardano-valley/pkg/discord"
 	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/web"
 
 	mongodb "go.mongodb.org/mongo-driver/mongo"
 
@@ -43,6 +46,17 @@ var (
 		&discord.LINK_WALLET_COMMAND,
 		&discord.WITHDRAW_COMMAND,
 		&discord.CREATE_AIRDROP_COMMAND,
+		&discord.SCHEDULE_AIRDROP_COMMAND,
+		&discord.AIRDROP_SCHEDULES_COMMAND,
+		&discord.FARM_DELEGATE_COMMAND,
+		&discord.MIGRATE_KEYS_COMMAND,
+		&discord.FARM_MULTISIG_COMMAND,
+		&discord.FARM_CLAIM_FEE_COMMAND,
+		&discord.WALLETS_COMMAND,
+		&discord.CONTESTED_WALLETS_COMMAND,
+		&discord.REWARD_HOLD_PERIOD_COMMAND,
+		&discord.REWARD_RULE_COMMAND,
+		&discord.CHECK_ELIGIBILITY_COMMAND,
 	}
 
 	commandHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
@@ -56,25 +70,58 @@ var (
 		discord.LINK_WALLET_COMMAND.Name:          discord.LINK_WALLET_HANDLER,
 		discord.WITHDRAW_COMMAND.Name:             discord.WITHDRAW_HANDLER,
 		discord.CREATE_AIRDROP_COMMAND.Name:       discord.CREATE_AIRDROP_HANDLER,
+		discord.SCHEDULE_AIRDROP_COMMAND.Name:     discord.SCHEDULE_AIRDROP_HANDLER,
+		discord.AIRDROP_SCHEDULES_COMMAND.Name:    discord.AIRDROP_SCHEDULES_HANDLER,
+		discord.FARM_DELEGATE_COMMAND.Name:        discord.FARM_DELEGATE_HANDLER,
+		discord.MIGRATE_KEYS_COMMAND.Name:         discord.MIGRATE_KEYS_HANDLER,
+		discord.FARM_MULTISIG_COMMAND.Name:        discord.FARM_MULTISIG_HANDLER,
+		discord.FARM_CLAIM_FEE_COMMAND.Name:       discord.FARM_CLAIM_FEE_HANDLER,
+		discord.WALLETS_COMMAND.Name:              discord.WALLETS_HANDLER,
+		discord.CONTESTED_WALLETS_COMMAND.Name:    discord.CONTESTED_WALLETS_HANDLER,
+		discord.REWARD_HOLD_PERIOD_COMMAND.Name:   discord.REWARD_HOLD_PERIOD_HANDLER,
+		discord.REWARD_RULE_COMMAND.Name:          discord.REWARD_RULE_HANDLER,
+		discord.CHECK_ELIGIBILITY_COMMAND.Name:    discord.CHECK_ELIGIBILITY_HANDLER,
 	}
 
 	// Modal Handlers: Must be in this format! `name-of-modal` then finished with `_something`
 	modals = []string{
 		discord.CONFIGURE_REWARD_NAME_MODAL_NAME,
 		discord.LINK_WALLET_MODAL_NAME,
+		discord.MULTISIG_WITNESS_MODAL_NAME,
+		discord.LINK_WALLET_SIGNATURE_MODAL_NAME,
+		discord.REWARD_RULE_MODAL_NAME,
 	}
 	modalHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ModalSubmitInteractionData){
 		discord.CONFIGURE_REWARD_NAME_MODAL_NAME: discord.CONFIGURE_REWARD_NAME_MODAL_HANDLER,
 		discord.LINK_WALLET_MODAL_NAME:            discord.LINK_WALLET_MODAL_HANDLER,
+		discord.MULTISIG_WITNESS_MODAL_NAME:       discord.MULTISIG_WITNESS_MODAL_HANDLER,
+		discord.LINK_WALLET_SIGNATURE_MODAL_NAME:  discord.LINK_WALLET_SIGNATURE_MODAL_HANDLER,
+		discord.REWARD_RULE_MODAL_NAME:            discord.REWARD_RULE_MODAL_HANDLER,
 	}
 
 	components = []string{
 		discord.CONFIGURE_REWARD_ASSET_COMPONENT_NAME,
 		discord.WITHDRAW_COMMAND_OPTIONLIST_NAME,
+		discord.MULTISIG_SIGN_COMPONENT_NAME,
+		discord.MULTISIG_TX_COMPONENT_NAME,
+		discord.MULTISIG_REJECT_COMPONENT_NAME,
+		discord.LINK_WALLET_SIGN_COMPONENT_NAME,
+		discord.LINK_WALLET_SEND_COMPONENT_NAME,
+		discord.WALLETS_UNLINK_COMPONENT_NAME,
+		discord.WALLETS_RENAME_COMPONENT_NAME,
+		discord.WALLETS_PRIMARY_COMPONENT_NAME,
 	}
 	componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, selected discordgo.MessageComponentInteractionData){
 		discord.CONFIGURE_REWARD_ASSET_COMPONENT_NAME: discord.CONFIGURE_REWARD_ASSET_COMPONENT_HANDLER,
 		discord.WITHDRAW_COMMAND_OPTIONLIST_NAME:      discord.WITHDRAW_COMMAND_OPTIONLIST_HANDLER,
+		discord.MULTISIG_SIGN_COMPONENT_NAME:          discord.MULTISIG_SIGN_COMPONENT_HANDLER,
+		discord.MULTISIG_TX_COMPONENT_NAME:            discord.MULTISIG_TX_COMPONENT_HANDLER,
+		discord.MULTISIG_REJECT_COMPONENT_NAME:        discord.MULTISIG_REJECT_COMPONENT_HANDLER,
+		discord.LINK_WALLET_SIGN_COMPONENT_NAME:       discord.LINK_WALLET_SIGN_COMPONENT_HANDLER,
+		discord.LINK_WALLET_SEND_COMPONENT_NAME:       discord.LINK_WALLET_SEND_COMPONENT_HANDLER,
+		discord.WALLETS_UNLINK_COMPONENT_NAME:         discord.WALLETS_UNLINK_COMPONENT_HANDLER,
+		discord.WALLETS_RENAME_COMPONENT_NAME:         discord.WALLETS_RENAME_COMPONENT_HANDLER,
+		discord.WALLETS_PRIMARY_COMPONENT_NAME:        discord.WALLETS_PRIMARY_COMPONENT_HANDLER,
 	}
 
 	lockout         = make(map[string]struct{})
@@ -111,7 +158,7 @@ type (
 	}
 )
 
-func init() {
+func connectDB() {
 	// Setup DB
     mdb, ctx, cancel, err := mongo.Connect()
     if err != nil {
@@ -124,7 +171,12 @@ func init() {
 }
 
 func main() {
+	mongo.LoadKeys()
+	connectDB()
 	defer mongo.Close(mongo.DB, dbctx, dbcancel)
+	if err := cv.EnsureIndexes(dbctx); err != nil {
+		log.Printf("cannot create indexes: %v", err)
+	}
 	l := logger.Record
 
 	// http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -148,6 +200,24 @@ func main() {
 	// log.Println("Server started at http://localhost:8080")
 	// http.ListenAndServe(":8080", nil)
 
+	// `cardano-valley hd-backup` prints the HD root phrase for an offline
+	// backup. It only needs the database, so it runs before the bot starts.
+	if len(os.Args) > 1 && os.Args[1] == "hd-backup" {
+		phrase, err := cardano.HDMnemonic()
+		if err != nil {
+			l.Error("HD BACKUP", "ERROR", err)
+			return
+		}
+		fmt.Println(phrase)
+		return
+	}
+
+	// Only the bot itself starts the background workers and registers commands
+	discord.Start()
+
+	// Signing pages for txs users pay from their own wallet
+	go web.Serve()
+
 	// Setup discord
 	discord.S.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
 		if strings.Contains(strings.ToUpper(m.Author.GlobalName), "ANNOUNCEMENTS") || strings.Contains(strings.ToUpper(m.Author.GlobalName), "ADMIN") {
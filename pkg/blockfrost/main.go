@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
 
 	bfg "github.com/blockfrost/blockfrost-go"
 )
@@ -43,7 +44,10 @@ func loadBlockfrostProjectID() string {
 }
 
 func init() {
-	client = bfg.NewAPIClient(bfg.APIClientOptions{ProjectID: loadBlockfrostProjectID()})
+	client = bfg.NewAPIClient(bfg.APIClientOptions{
+		ProjectID: loadBlockfrostProjectID(),
+		Server:    network.Current.BlockfrostURL,
+	})
 }
 
 func GetLastTransaction(ctx context.Context, address string) (bfg.TransactionUTXOs, error) {
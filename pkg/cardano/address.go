@@ -0,0 +1,206 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/network"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+type (
+	// AddressType is the high nibble of a Shelley address header (CIP-19).
+	AddressType byte
+
+	Address struct {
+		Bech32        string
+		Type          AddressType
+		NetworkID     byte
+		Payment       []byte // Payment key or script hash, nil for stake addresses
+		Stake         []byte // Stake key or script hash, nil when there is no stake part
+		Pointer       *StakePointer
+		PaymentScript bool
+		StakeScript   bool
+	}
+
+	// StakePointer locates the certificate that registered the stake key of
+	// a pointer address.
+	StakePointer struct {
+		Slot      uint64
+		TxIndex   uint64
+		CertIndex uint64
+	}
+)
+
+const (
+	AddressBase       AddressType = 0 // payment key, stake key
+	AddressPointer    AddressType = 4 // payment key, stake pointer
+	AddressEnterprise AddressType = 6 // payment key, no stake part
+	AddressByron      AddressType = 8
+	AddressStake      AddressType = 14 // stake key (reward address)
+
+	credentialSize = 28
+)
+
+var (
+	ErrByronAddress      = errors.New("byron addresses are not supported")
+	ErrWrongNetwork      = errors.New("address is for a different network")
+	ErrNotPaymentAddress = errors.New("not a payment address")
+	ErrMalformedAddress  = errors.New("malformed address")
+)
+
+// ParseAddress decodes a bech32 Shelley address and checks it belongs to the
+// configured network.
+func ParseAddress(s string) (*Address, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "Ae2") || strings.HasPrefix(s, "DdzFF") {
+		return nil, ErrByronAddress
+	}
+
+	hrp, data, err := Bech32Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 1+credentialSize {
+		return nil, fmt.Errorf("%w: too short", ErrMalformedAddress)
+	}
+
+	header := data[0]
+	addr := &Address{
+		Bech32:    s,
+		Type:      AddressType(header >> 4),
+		NetworkID: header & 0x0f,
+	}
+
+	switch {
+	case addr.Type <= 3:
+		if len(data) != 1+2*credentialSize {
+			return nil, fmt.Errorf("%w: base address must be %d bytes", ErrMalformedAddress, 1+2*credentialSize)
+		}
+		addr.Payment = data[1 : 1+credentialSize]
+		addr.Stake = data[1+credentialSize:]
+		addr.PaymentScript = addr.Type&1 == 1
+		addr.StakeScript = addr.Type&2 == 2
+	case addr.Type == 4 || addr.Type == 5:
+		pointer, err := decodePointer(data[1+credentialSize:])
+		if err != nil {
+			return nil, err
+		}
+		addr.Payment = data[1 : 1+credentialSize]
+		addr.Pointer = pointer
+		addr.PaymentScript = addr.Type == 5
+	case addr.Type == 6 || addr.Type == 7:
+		if len(data) != 1+credentialSize {
+			return nil, fmt.Errorf("%w: enterprise address must be %d bytes", ErrMalformedAddress, 1+credentialSize)
+		}
+		addr.Payment = data[1:]
+		addr.PaymentScript = addr.Type == 7
+	case addr.Type == AddressByron:
+		return nil, ErrByronAddress
+	case addr.Type == 14 || addr.Type == 15:
+		if len(data) != 1+credentialSize {
+			return nil, fmt.Errorf("%w: stake address must be %d bytes", ErrMalformedAddress, 1+credentialSize)
+		}
+		addr.Stake = data[1:]
+		addr.StakeScript = addr.Type == 15
+	default:
+		return nil, fmt.Errorf("%w: unknown header type %d", ErrMalformedAddress, addr.Type)
+	}
+
+	wantPrefix := addressPrefix(addr.Type, addr.NetworkID)
+	if hrp != wantPrefix {
+		return nil, fmt.Errorf("%w: prefix %q does not match header", ErrMalformedAddress, hrp)
+	}
+
+	if addr.NetworkID != network.Current.NetworkID {
+		return nil, fmt.Errorf("%w: network id %d", ErrWrongNetwork, addr.NetworkID)
+	}
+
+	return addr, nil
+}
+
+// ValidatePaymentAddress returns an error unless s is a Shelley payment address
+// on the configured network.
+func ValidatePaymentAddress(s string) error {
+	addr, err := ParseAddress(s)
+	if err != nil {
+		return err
+	}
+	if addr.Payment == nil {
+		return ErrNotPaymentAddress
+	}
+	return nil
+}
+
+// IsPayment reports whether funds can be sent to the address.
+func (a Address) IsPayment() bool {
+	return a.Payment != nil
+}
+
+// AddressFromBytes reads an address in its raw form, as CIP-30 wallets and
+// CIP-8 signatures carry it.
+func AddressFromBytes(data []byte) (*Address, error) {
+	if len(data) < 1 {
+		return nil, fmt.Errorf("%w: empty", ErrMalformedAddress)
+	}
+	header := data[0]
+	s, err := Bech32Encode(addressPrefix(AddressType(header>>4), header&0x0f), data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAddress(s)
+}
+
+// StakeAddress is the reward address of the address's stake part, or "" when
+// it has none.
+func (a Address) StakeAddress() (string, error) {
+	if a.Stake == nil {
+		return "", nil
+	}
+	t := AddressStake
+	if a.StakeScript {
+		t++
+	}
+	header := byte(t)<<4 | a.NetworkID
+	return Bech32Encode(addressPrefix(t, a.NetworkID), append([]byte{header}, a.Stake...))
+}
+
+// decodePointer reads the three variable length naturals of a pointer: 7
+// bits a byte, most significant first, the high bit set on every byte but
+// the last. Nothing may follow them.
+func decodePointer(data []byte) (*StakePointer, error) {
+	var values [3]uint64
+	for n := range values {
+		var v uint64
+		for {
+			if len(data) == 0 {
+				return nil, fmt.Errorf("%w: truncated stake pointer", ErrMalformedAddress)
+			}
+			if v > math.MaxUint64>>7 {
+				return nil, fmt.Errorf("%w: stake pointer overflows", ErrMalformedAddress)
+			}
+			b := data[0]
+			data = data[1:]
+			v = v<<7 | uint64(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		values[n] = v
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%w: %d bytes after the stake pointer", ErrMalformedAddress, len(data))
+	}
+	return &StakePointer{Slot: values[0], TxIndex: values[1], CertIndex: values[2]}, nil
+}
+
+func addressPrefix(t AddressType, networkID byte) string {
+	prefix := "addr"
+	if t == 14 || t == 15 {
+		prefix = "stake"
+	}
+	if networkID == 0 {
+		prefix += "_test"
+	}
+	return prefix
+}
@@ -0,0 +1,209 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/network"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// CIP-19 test vectors, built from these credentials:
+//
+//	payment key hash  9493315cd92eb5d8c4304e67b7e16ae36d61d34502694657811a2c8e
+//	script hash       c37b1b5dc0669f1d3c61a6fddb2e8fde96be87b881c60bce8e8d542f
+//	stake key hash    337b62cfff6403a06a3acbc34f8c46003c69fe79a3628cefa9c47251
+//	pointer           (2498243, 27, 3)
+const (
+	cip19PaymentKey = "9493315cd92eb5d8c4304e67b7e16ae36d61d34502694657811a2c8e"
+	cip19Script     = "c37b1b5dc0669f1d3c61a6fddb2e8fde96be87b881c60bce8e8d542f"
+	cip19StakeKey   = "337b62cfff6403a06a3acbc34f8c46003c69fe79a3628cefa9c47251"
+)
+
+type cip19Vector struct {
+	address       string
+	typ           AddressType
+	payment       string
+	stake         string
+	paymentScript bool
+	stakeScript   bool
+	pointer       bool
+}
+
+var cip19Mainnet = []cip19Vector{
+	{"addr1qx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer3n0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgse35a3x", 0, cip19PaymentKey, cip19StakeKey, false, false, false},
+	{"addr1z8phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gten0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgs9yc0hh", 1, cip19Script, cip19StakeKey, true, false, false},
+	{"addr1yx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerkr0vd4msrxnuwnccdxlhdjar77j6lg0wypcc9uar5d2shs2z78ve", 2, cip19PaymentKey, cip19Script, false, true, false},
+	{"addr1x8phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gt7r0vd4msrxnuwnccdxlhdjar77j6lg0wypcc9uar5d2shskhj42g", 3, cip19Script, cip19Script, true, true, false},
+	{"addr1gx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer5pnz75xxcrzqf96k", 4, cip19PaymentKey, "", false, false, true},
+	{"addr128phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtupnz75xxcrtw79hu", 5, cip19Script, "", true, false, true},
+	{"addr1vx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzers66hrl8", 6, cip19PaymentKey, "", false, false, false},
+	{"addr1w8phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcyjy7wx", 7, cip19Script, "", true, false, false},
+	{"stake1uyehkck0lajq8gr28t9uxnuvgcqrc6070x3k9r8048z8y5gh6ffgw", 14, "", cip19StakeKey, false, false, false},
+	{"stake178phkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcccycj5", 15, "", cip19Script, false, true, false},
+}
+
+var cip19Testnet = []cip19Vector{
+	{"addr_test1qz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer3n0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgs68faae", 0, cip19PaymentKey, cip19StakeKey, false, false, false},
+	{"addr_test1zrphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gten0d3vllmyqwsx5wktcd8cc3sq835lu7drv2xwl2wywfgsxj90mg", 1, cip19Script, cip19StakeKey, true, false, false},
+	{"addr_test1yz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerkr0vd4msrxnuwnccdxlhdjar77j6lg0wypcc9uar5d2shsf5r8qx", 2, cip19PaymentKey, cip19Script, false, true, false},
+	{"addr_test1xrphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gt7r0vd4msrxnuwnccdxlhdjar77j6lg0wypcc9uar5d2shs4p04xh", 3, cip19Script, cip19Script, true, true, false},
+	{"addr_test1gz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzer5pnz75xxcrdw5vky", 4, cip19PaymentKey, "", false, false, true},
+	{"addr_test12rphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtupnz75xxcryqrvmw", 5, cip19Script, "", true, false, true},
+	{"addr_test1vz2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzerspjrlsz", 6, cip19PaymentKey, "", false, false, false},
+	{"addr_test1wrphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcl6szpr", 7, cip19Script, "", true, false, false},
+	{"stake_test1uqehkck0lajq8gr28t9uxnuvgcqrc6070x3k9r8048z8y5gssrtvn", 14, "", cip19StakeKey, false, false, false},
+	{"stake_test17rphkx6acpnf78fuvxn0mkew3l0fd058hzquvz7w36x4gtcljw6kf", 15, "", cip19Script, false, true, false},
+}
+
+// useNetwork switches network.Current for the rest of the test.
+func useNetwork(t *testing.T, name string) {
+	t.Helper()
+	n, err := network.Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := network.Current
+	network.Current = n
+	t.Cleanup(func() { network.Current = previous })
+}
+
+func TestParseAddressCIP19(t *testing.T) {
+	for _, set := range []struct {
+		network string
+		vectors []cip19Vector
+	}{
+		{network.Mainnet, cip19Mainnet},
+		{network.Preprod, cip19Testnet},
+	} {
+		t.Run(set.network, func(t *testing.T) {
+			useNetwork(t, set.network)
+			for _, v := range set.vectors {
+				addr, err := ParseAddress(v.address)
+				if err != nil {
+					t.Errorf("%s: %v", v.address, err)
+					continue
+				}
+				if addr.Type != v.typ {
+					t.Errorf("%s: type %d, want %d", v.address, addr.Type, v.typ)
+				}
+				if got := hex.EncodeToString(addr.Payment); got != v.payment {
+					t.Errorf("%s: payment %s, want %s", v.address, got, v.payment)
+				}
+				if got := hex.EncodeToString(addr.Stake); got != v.stake {
+					t.Errorf("%s: stake %s, want %s", v.address, got, v.stake)
+				}
+				if addr.PaymentScript != v.paymentScript || addr.StakeScript != v.stakeScript {
+					t.Errorf("%s: scripts %v/%v, want %v/%v", v.address, addr.PaymentScript, addr.StakeScript, v.paymentScript, v.stakeScript)
+				}
+				if v.pointer {
+					want := StakePointer{Slot: 2498243, TxIndex: 27, CertIndex: 3}
+					if addr.Pointer == nil || *addr.Pointer != want {
+						t.Errorf("%s: pointer %+v, want %+v", v.address, addr.Pointer, want)
+					}
+				} else if addr.Pointer != nil {
+					t.Errorf("%s: unexpected pointer %+v", v.address, addr.Pointer)
+				}
+				if addr.IsPayment() != (v.payment != "") {
+					t.Errorf("%s: IsPayment %v", v.address, addr.IsPayment())
+				}
+			}
+		})
+	}
+}
+
+func TestStakeAddressCIP19(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+	want := map[string]string{
+		cip19Mainnet[0].address: cip19Mainnet[8].address, // base, stake key
+		cip19Mainnet[2].address: cip19Mainnet[9].address, // base, stake script
+		cip19Mainnet[4].address: "",                      // pointer
+		cip19Mainnet[6].address: "",                      // enterprise
+	}
+	for address, stake := range want {
+		addr, err := ParseAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := addr.StakeAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != stake {
+			t.Errorf("%s: stake address %q, want %q", address, got, stake)
+		}
+	}
+}
+
+func TestAddressFromBytesRoundTrip(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+	for _, v := range cip19Mainnet {
+		_, data, err := Bech32Decode(v.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := AddressFromBytes(data)
+		if err != nil {
+			t.Errorf("%s: %v", v.address, err)
+			continue
+		}
+		if addr.Bech32 != v.address {
+			t.Errorf("round trip gave %s, want %s", addr.Bech32, v.address)
+		}
+	}
+}
+
+func TestParseAddressRejects(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+
+	header := func(b byte, rest string) []byte {
+		data, err := hex.DecodeString(rest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append([]byte{b}, data...)
+	}
+	encode := func(hrp string, data []byte) string {
+		s, err := Bech32Encode(hrp, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		err     error
+	}{
+		{"testnet address on mainnet", cip19Testnet[0].address, ErrWrongNetwork},
+		{"byron", "Ae2tdPwUPEZFRbyhz3cpfC2CumGzNkFBN2L42rcUc2yjQpEkxDbkPodpMAi", ErrByronAddress},
+		{"bad checksum", cip19Mainnet[0].address[:len(cip19Mainnet[0].address)-1] + "q", ErrInvalidBech32},
+		{"mixed case", "addr1VX2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzers66hrl8", ErrInvalidBech32},
+		{"prefix does not match header", encode("stake", header(0x61, cip19PaymentKey)), ErrMalformedAddress},
+		{"short base address", encode("addr", header(0x01, cip19PaymentKey+"33")), ErrMalformedAddress},
+		{"long enterprise address", encode("addr", header(0x61, cip19PaymentKey+"00")), ErrMalformedAddress},
+		{"pointer without pointer", encode("addr", header(0x41, cip19PaymentKey)), ErrMalformedAddress},
+		{"truncated pointer", encode("addr", header(0x41, cip19PaymentKey+"8198bd431b")), ErrMalformedAddress},
+		{"bytes after pointer", encode("addr", header(0x41, cip19PaymentKey+"8198bd431b0300")), ErrMalformedAddress},
+		{"overlong pointer", encode("addr", header(0x41, cip19PaymentKey+"ffffffffffffffffffff7f0000")), ErrMalformedAddress},
+		{"unknown header", encode("addr", header(0x91, cip19PaymentKey)), ErrMalformedAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAddress(tt.address)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseAddress(%s) = %v, want %v", tt.address, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidatePaymentAddress(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+	if err := ValidatePaymentAddress(cip19Mainnet[0].address); err != nil {
+		t.Errorf("base address: %v", err)
+	}
+	if err := ValidatePaymentAddress(cip19Mainnet[8].address); !errors.Is(err, ErrNotPaymentAddress) {
+		t.Errorf("stake address: %v, want %v", err, ErrNotPaymentAddress)
+	}
+}
@@ -0,0 +1,72 @@
+package cardano
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// BIP-173 test vectors. The 90 character limit is not enforced, Cardano
+// addresses are longer than that.
+func TestBech32DecodeBIP173(t *testing.T) {
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	}
+	for _, s := range valid {
+		hrp, _, err := Bech32Decode(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if want := strings.ToLower(s[:strings.LastIndexByte(s, '1')]); hrp != want {
+			t.Errorf("%s: prefix %q, want %q", s, hrp, want)
+		}
+	}
+
+	invalid := []string{
+		"\x201nwldj5",   // prefix character out of range
+		"\x7f1axkwrx",   // prefix character out of range
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty prefix
+		"x1b4n0q5v",     // invalid data character
+		"li1dgmt3",      // checksum too short
+		"de1lg7wt\xff",  // invalid character in checksum
+		"A1G7SGD8",      // checksum calculated with uppercase prefix
+		"10a06t8",       // empty prefix
+		"1qzzfhee",      // empty prefix
+		"a12uel5L",      // mixed case
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx", // bad checksum
+	}
+	for _, s := range invalid {
+		if _, _, err := Bech32Decode(s); !errors.Is(err, ErrInvalidBech32) {
+			t.Errorf("%q: %v, want %v", s, err, ErrInvalidBech32)
+		}
+	}
+}
+
+func TestBech32RoundTrip(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0x00},
+		{0xff, 0x00, 0x7f},
+		bytes.Repeat([]byte{0xa5}, 57),
+	} {
+		s, err := Bech32Encode("addr", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hrp, got, err := Bech32Decode(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if hrp != "addr" || !bytes.Equal(got, data) {
+			t.Errorf("%s decoded to %s %x, want addr %x", s, hrp, got, data)
+		}
+	}
+}
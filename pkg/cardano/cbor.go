@@ -0,0 +1,421 @@
+package cardano
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Just enough CBOR (RFC 8949) for transactions, key envelopes and COSE.
+// Values map onto Go as:
+//
+//	uint64, int64 (negative ints), []byte, string, []any, cborMap, cborTag,
+//	bool, nil
+//
+// cborRaw is written as-is, which keeps hashed parts (e.g. a tx body) byte for
+// byte identical when re-encoding.
+
+type (
+	cborPair struct {
+		Key   any
+		Value any
+	}
+
+	// cborMap keeps the key order it was built or decoded with
+	cborMap []cborPair
+
+	cborTag struct {
+		Number  uint64
+		Content any
+	}
+
+	cborRaw []byte
+)
+
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMapT   = 5
+	cborTagT   = 6
+	cborSimple = 7
+
+	// tag 258 marks a set in Conway era transactions
+	cborSetTag = 258
+
+	// nesting limit, so hostile input cannot exhaust the stack
+	cborMaxDepth = 128
+)
+
+var ErrCBOR = errors.New("invalid cbor")
+
+// Get returns the value stored under key, comparing uints and strings.
+func (m cborMap) Get(key any) (any, bool) {
+	for _, p := range m {
+		if cborKeyEqual(p.Key, key) {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+func cborKeyEqual(a, b any) bool {
+	switch a := a.(type) {
+	case uint64:
+		switch b := b.(type) {
+		case uint64:
+			return a == b
+		case int:
+			return b >= 0 && a == uint64(b)
+		}
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return a == b
+		case int:
+			return a == int64(b)
+		}
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	}
+	return false
+}
+
+// ─── Encoding ───
+
+func cborEncode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := cborWrite(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func cborWrite(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case uint64:
+		cborHead(buf, cborUint, v)
+	case uint32:
+		cborHead(buf, cborUint, uint64(v))
+	case uint:
+		cborHead(buf, cborUint, uint64(v))
+	case int:
+		return cborWrite(buf, int64(v))
+	case int64:
+		if v >= 0 {
+			cborHead(buf, cborUint, uint64(v))
+		} else {
+			cborHead(buf, cborNegInt, uint64(-(v + 1)))
+		}
+	case []byte:
+		cborHead(buf, cborBytes, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		cborHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []any:
+		cborHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := cborWrite(buf, item); err != nil {
+				return err
+			}
+		}
+	case cborMap:
+		cborHead(buf, cborMapT, uint64(len(v)))
+		for _, p := range v {
+			if err := cborWrite(buf, p.Key); err != nil {
+				return err
+			}
+			if err := cborWrite(buf, p.Value); err != nil {
+				return err
+			}
+		}
+	case cborTag:
+		cborHead(buf, cborTagT, v.Number)
+		return cborWrite(buf, v.Content)
+	case cborRaw:
+		buf.Write(v)
+	default:
+		return fmt.Errorf("%w: cannot encode %T", ErrCBOR, v)
+	}
+	return nil
+}
+
+// cborSortMap orders keys canonically (RFC 7049 section 3.9: shorter encodings
+// first, then bytewise), which is what the ledger and hardware wallets expect.
+func cborSortMap(m cborMap) (cborMap, error) {
+	type keyed struct {
+		key  []byte
+		pair cborPair
+	}
+	items := make([]keyed, len(m))
+	for n, p := range m {
+		k, err := cborEncode(p.Key)
+		if err != nil {
+			return nil, err
+		}
+		items[n] = keyed{k, p}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if len(items[i].key) != len(items[j].key) {
+			return len(items[i].key) < len(items[j].key)
+		}
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+
+	sorted := make(cborMap, len(items))
+	for n, item := range items {
+		sorted[n] = item.pair
+	}
+	return sorted, nil
+}
+
+// ─── Decoding ───
+
+type cborDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func cborDecode(data []byte) (any, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrCBOR, len(data)-d.pos)
+	}
+	return v, nil
+}
+
+// cborSplitArray returns the raw encoding of each element of a top level
+// array, so parts can be hashed exactly as they were received.
+func cborSplitArray(data []byte) ([]cborRaw, error) {
+	d := &cborDecoder{data: data}
+	major, n, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != cborArray {
+		return nil, fmt.Errorf("%w: expected array", ErrCBOR)
+	}
+
+	var items []cborRaw
+	for i := uint64(0); indefinite || i < n; i++ {
+		if indefinite && d.pos < len(d.data) && d.data[d.pos] == 0xff {
+			d.pos++
+			break
+		}
+		start := d.pos
+		if _, err := d.next(); err != nil {
+			return nil, err
+		}
+		items = append(items, cborRaw(d.data[start:d.pos]))
+	}
+	return items, nil
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrCBOR)
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads an item header. indefinite is set for the 0x1f length marker.
+func (d *cborDecoder) head() (major byte, n uint64, indefinite bool, err error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), false, nil
+	case info == 24:
+		b, err = d.read(1)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, uint64(b[0]), false, nil
+	case info == 25:
+		b, err = d.read(2)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(b)), false, nil
+	case info == 26:
+		b, err = d.read(4)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(b)), false, nil
+	case info == 27:
+		b, err = d.read(8)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		return major, binary.BigEndian.Uint64(b), false, nil
+	case info == 31:
+		return major, 0, true, nil
+	}
+	return 0, 0, false, fmt.Errorf("%w: reserved length %d", ErrCBOR, info)
+}
+
+func (d *cborDecoder) breakNext() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == 0xff {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *cborDecoder) next() (any, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > cborMaxDepth {
+		return nil, fmt.Errorf("%w: nested deeper than %d", ErrCBOR, cborMaxDepth)
+	}
+
+	major, n, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: negative int out of range", ErrCBOR)
+		}
+		return -1 - int64(n), nil
+	case cborBytes, cborText:
+		var b []byte
+		if indefinite {
+			for !d.breakNext() {
+				chunk, err := d.next()
+				if err != nil {
+					return nil, err
+				}
+				switch c := chunk.(type) {
+				case []byte:
+					b = append(b, c...)
+				case string:
+					b = append(b, c...)
+				default:
+					return nil, fmt.Errorf("%w: bad chunk in indefinite string", ErrCBOR)
+				}
+			}
+		} else {
+			raw, err := d.read(n)
+			if err != nil {
+				return nil, err
+			}
+			b = append([]byte(nil), raw...)
+		}
+		if major == cborText {
+			return string(b), nil
+		}
+		if b == nil {
+			b = []byte{}
+		}
+		return b, nil
+	case cborArray:
+		items := []any{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && d.breakNext() {
+				break
+			}
+			item, err := d.next()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMapT:
+		m := cborMap{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			if indefinite && d.breakNext() {
+				break
+			}
+			k, err := d.next()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.next()
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, cborPair{k, v})
+		}
+		return m, nil
+	case cborTagT:
+		content, err := d.next()
+		if err != nil {
+			return nil, err
+		}
+		return cborTag{Number: n, Content: content}, nil
+	case cborSimple:
+		switch n {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: unsupported simple value %d", ErrCBOR, n)
+	}
+	return nil, fmt.Errorf("%w: unknown major type %d", ErrCBOR, major)
+}
+
+// cborUntag strips a set tag (258) so sets and plain arrays read the same.
+func cborUntag(v any) any {
+	if t, ok := v.(cborTag); ok && t.Number == cborSetTag {
+		return t.Content
+	}
+	return v
+}
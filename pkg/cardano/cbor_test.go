@@ -0,0 +1,27 @@
+package cardano
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCBORDecodeDepth(t *testing.T) {
+	nested := func(depth int) []byte {
+		// [[[...0...]]]
+		return append(bytes.Repeat([]byte{0x81}, depth-1), 0x00)
+	}
+
+	if _, err := cborDecode(nested(cborMaxDepth)); err != nil {
+		t.Errorf("%d levels: %v", cborMaxDepth, err)
+	}
+	if _, err := cborDecode(nested(cborMaxDepth + 1)); !errors.Is(err, ErrCBOR) {
+		t.Errorf("%d levels: %v, want %v", cborMaxDepth+1, err, ErrCBOR)
+	}
+
+	// indefinite arrays and tags nest too
+	deep := append(bytes.Repeat([]byte{0x9f, 0xc6}, cborMaxDepth), 0x00)
+	if _, err := cborDecode(deep); !errors.Is(err, ErrCBOR) {
+		t.Errorf("nested tags: %v, want %v", err, ErrCBOR)
+	}
+}
@@ -0,0 +1,71 @@
+package cardano
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Certificate is a Conway era certificate ready to go into a tx body. Deposit
+// is the lovelace the certificate locks, which the tx has to pay on top of
+// its outputs and fee.
+type Certificate struct {
+	Description string
+	CBOR        []byte
+	Deposit     uint64
+}
+
+// StakeRegistrationCertificate registers a stake key and pays deposit, the
+// same as cardano-cli conway stake-address registration-certificate
+// --key-reg-deposit-amt. The stake key has to witness the tx.
+func StakeRegistrationCertificate(stake ed25519.PublicKey, deposit uint64) (Certificate, error) {
+	cert, err := cborEncode([]any{uint64(7), []any{uint64(0), KeyHash(stake)}, deposit})
+	if err != nil {
+		return Certificate{}, err
+	}
+	return Certificate{Description: "Stake Address Registration Certificate", CBOR: cert, Deposit: deposit}, nil
+}
+
+// StakeDelegationCertificate delegates a stake key to poolID, the same as
+// cardano-cli conway stake-address stake-delegation-certificate.
+func StakeDelegationCertificate(stake ed25519.PublicKey, poolID string) (Certificate, error) {
+	poolHash, err := PoolKeyHash(poolID)
+	if err != nil {
+		return Certificate{}, err
+	}
+	cert, err := cborEncode([]any{uint64(2), []any{uint64(0), KeyHash(stake)}, poolHash})
+	if err != nil {
+		return Certificate{}, err
+	}
+	return Certificate{Description: "Stake Delegation Certificate", CBOR: cert}, nil
+}
+
+// Envelope renders the certificate the way cardano-cli writes it.
+func (c Certificate) Envelope() string {
+	data, _ := json.MarshalIndent(KeyEnvelope{
+		Type:        "CertificateConway",
+		Description: c.Description,
+		CborHex:     hex.EncodeToString(c.CBOR),
+	}, "", "    ")
+	return string(data)
+}
+
+func deposits(certs []Certificate) uint64 {
+	var total uint64
+	for _, c := range certs {
+		total += c.Deposit
+	}
+	return total
+}
+
+func certificatesCBOR(certs []Certificate) (any, error) {
+	encoded := make([]any, len(certs))
+	for n, c := range certs {
+		if len(c.CBOR) == 0 {
+			return nil, fmt.Errorf("tx build: empty certificate %q", c.Description)
+		}
+		encoded[n] = cborRaw(c.CBOR)
+	}
+	return cborTag{cborSetTag, encoded}, nil
+}
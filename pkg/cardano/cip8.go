@@ -0,0 +1,182 @@
+package cardano
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// SignedData is what a CIP-30 wallet's signData returns: a CIP-8 COSE_Sign1
+// signature and the COSE_Key it was made with, both hex CBOR.
+type SignedData struct {
+	Signature string `json:"signature"`
+	Key       string `json:"key"`
+}
+
+const (
+	coseSign1Tag = 18
+	coseAlgEdDSA = -8
+)
+
+var ErrInvalidSignature = errors.New("invalid data signature")
+
+// ParseSignedData reads a pasted signData result, either the JSON object the
+// wallet returns or the signature and key hex separated by whitespace.
+func ParseSignedData(text string) (SignedData, error) {
+	text = strings.TrimSpace(text)
+	var d SignedData
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &d); err != nil {
+			return d, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+	} else if fields := strings.Fields(text); len(fields) == 2 {
+		d = SignedData{Signature: fields[0], Key: fields[1]}
+	}
+	if d.Signature == "" || d.Key == "" {
+		return d, fmt.Errorf("%w: need both the signature and the key", ErrInvalidSignature)
+	}
+	return d, nil
+}
+
+// Verify checks the signature covers message and was made by the payment or
+// stake key of the address it names, and returns that address.
+func (d SignedData) Verify(message []byte) (*Address, error) {
+	public, err := d.publicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(d.Signature))
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature is not hex", ErrInvalidSignature)
+	}
+	decoded, err := cborDecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if t, ok := decoded.(cborTag); ok && t.Number == coseSign1Tag {
+		decoded = t.Content
+	}
+	sign1, ok := decoded.([]any)
+	if !ok || len(sign1) != 4 {
+		return nil, fmt.Errorf("%w: not a COSE_Sign1", ErrInvalidSignature)
+	}
+	protected, _ := sign1[0].([]byte)
+	unprotected, _ := sign1[1].(cborMap)
+	payload, _ := sign1[2].([]byte)
+	signature, _ := sign1[3].([]byte)
+
+	headers, err := cborDecode(protected)
+	if err != nil {
+		return nil, fmt.Errorf("%w: protected headers: %v", ErrInvalidSignature, err)
+	}
+	protectedMap, _ := headers.(cborMap)
+	if alg, _ := protectedMap.Get(1); !cborKeyEqual(alg, coseAlgEdDSA) {
+		return nil, fmt.Errorf("%w: not an EdDSA signature", ErrInvalidSignature)
+	}
+	addressBytes, _ := protectedMap.Get("address")
+	rawAddress, ok := addressBytes.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: no address in the protected headers", ErrInvalidSignature)
+	}
+
+	// the wallet may sign the hash of a long message instead of the message
+	expected := message
+	if hashed, _ := unprotected.Get("hashed"); hashed == true {
+		h, _ := blake2b.New(credentialSize, nil)
+		h.Write(message)
+		expected = h.Sum(nil)
+	}
+	if sign1[2] == nil {
+		payload = expected // detached payload
+	}
+	if !bytes.Equal(payload, expected) {
+		return nil, fmt.Errorf("%w: it signs a different message", ErrInvalidSignature)
+	}
+
+	sigStructure, err := cborEncode([]any{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(public, sigStructure, signature) {
+		return nil, fmt.Errorf("%w: signature does not match", ErrInvalidSignature)
+	}
+
+	addr, err := AddressFromBytes(rawAddress)
+	if err != nil {
+		return nil, err
+	}
+	keyHash := KeyHash(public)
+	switch {
+	case addr.Payment != nil && !addr.PaymentScript && bytes.Equal(addr.Payment, keyHash):
+	case addr.Stake != nil && !addr.StakeScript && bytes.Equal(addr.Stake, keyHash):
+	default:
+		return nil, fmt.Errorf("%w: the key does not belong to %s", ErrInvalidSignature, addr.Bech32)
+	}
+	return addr, nil
+}
+
+// SignData signs message for address the way a CIP-30 wallet's signData
+// does, with the message attached and unhashed. Offline runs and tests use it
+// in place of a wallet.
+func SignData(key *SigningKey, address string, message []byte) (SignedData, error) {
+	_, raw, err := Bech32Decode(address)
+	if err != nil {
+		return SignedData{}, err
+	}
+	protected, err := cborEncode(cborMap{{1, coseAlgEdDSA}, {"address", raw}})
+	if err != nil {
+		return SignedData{}, err
+	}
+	sigStructure, err := cborEncode([]any{"Signature1", protected, []byte{}, message})
+	if err != nil {
+		return SignedData{}, err
+	}
+	sign1, err := cborEncode([]any{protected, cborMap{{"hashed", false}}, message, key.Sign(sigStructure)})
+	if err != nil {
+		return SignedData{}, err
+	}
+	coseKey, err := cborEncode(cborMap{{1, 1}, {3, coseAlgEdDSA}, {-1, 6}, {-2, []byte(key.Public())}})
+	if err != nil {
+		return SignedData{}, err
+	}
+	return SignedData{Signature: hex.EncodeToString(sign1), Key: hex.EncodeToString(coseKey)}, nil
+}
+
+// KeyHash is the hash of the key that made the signature, to tell whether
+// the payment or the stake key of the address signed.
+func (d SignedData) KeyHash() ([]byte, error) {
+	public, err := d.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	return KeyHash(public), nil
+}
+
+// publicKey reads the Ed25519 key out of the COSE_Key.
+func (d SignedData) publicKey() (ed25519.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(d.Key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: key is not hex", ErrInvalidSignature)
+	}
+	decoded, err := cborDecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key: %v", ErrInvalidSignature, err)
+	}
+	key, ok := decoded.(cborMap)
+	if !ok {
+		return nil, fmt.Errorf("%w: not a COSE_Key", ErrInvalidSignature)
+	}
+	x, _ := key.Get(-2)
+	public, ok := x.([]byte)
+	if !ok || len(public) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: not an Ed25519 key", ErrInvalidSignature)
+	}
+	return public, nil
+}
@@ -0,0 +1,111 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/network"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// signData signs message the way a CIP-30 wallet does, naming address in the
+// protected headers.
+func signData(t *testing.T, key *SigningKey, address string, message []byte, hashed bool) SignedData {
+	t.Helper()
+	_, raw, err := Bech32Decode(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected, err := cborEncode(cborMap{{1, coseAlgEdDSA}, {"address", raw}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := message
+	if hashed {
+		h, _ := blake2b.New(credentialSize, nil)
+		h.Write(message)
+		payload = h.Sum(nil)
+	}
+	sigStructure, err := cborEncode([]any{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign1, err := cborEncode([]any{protected, cborMap{{"hashed", hashed}}, payload, key.Sign(sigStructure)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	coseKey, err := cborEncode(cborMap{{1, 1}, {3, coseAlgEdDSA}, {-1, 6}, {-2, []byte(key.Public())}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SignedData{Signature: hex.EncodeToString(sign1), Key: hex.EncodeToString(coseKey)}
+}
+
+func TestSignedDataVerify(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+	payment, _ := NewSigningKey(PaymentSigningKeyType)
+	stake, _ := NewSigningKey(StakeSigningKeyType)
+	other, _ := NewSigningKey(PaymentSigningKeyType)
+	base, _ := BaseAddress(payment.Public(), stake.Public())
+	reward, _ := StakeAddress(stake.Public())
+	message := []byte("Link this wallet to Discord user 1 on Cardano Valley.")
+
+	tests := []struct {
+		name    string
+		signed  SignedData
+		want    string
+		keyHash []byte
+		err     error
+	}{
+		{"payment key signs its base address", signData(t, payment, base, message, false), base, payment.Hash(), nil},
+		{"stake key signs its base address", signData(t, stake, base, message, false), base, stake.Hash(), nil},
+		{"stake key signs its reward address", signData(t, stake, reward, message, false), reward, stake.Hash(), nil},
+		{"hashed payload", signData(t, payment, base, message, true), base, payment.Hash(), nil},
+		{"key of another wallet", signData(t, other, base, message, false), "", nil, ErrInvalidSignature},
+		{"another message", signData(t, payment, base, []byte("something else"), false), "", nil, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := tt.signed.Verify(message)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Verify = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr.Bech32 != tt.want {
+				t.Errorf("address %s, want %s", addr.Bech32, tt.want)
+			}
+			keyHash, err := tt.signed.KeyHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(keyHash) != hex.EncodeToString(tt.keyHash) {
+				t.Errorf("key hash %x, want %x", keyHash, tt.keyHash)
+			}
+		})
+	}
+}
+
+func TestParseSignedData(t *testing.T) {
+	for _, text := range []string{
+		`{"signature": "84aa", "key": "a401"}`,
+		"84aa\n a401 ",
+	} {
+		d, err := ParseSignedData(text)
+		if err != nil {
+			t.Fatalf("ParseSignedData(%q): %v", text, err)
+		}
+		if d.Signature != "84aa" || d.Key != "a401" {
+			t.Errorf("ParseSignedData(%q) = %+v", text, d)
+		}
+	}
+	if _, err := ParseSignedData(`{"signature": "84aa"}`); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("missing key: %v, want %v", err, ErrInvalidSignature)
+	}
+}
@@ -0,0 +1,382 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/logger"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sort"
+)
+
+// Coin selection over multi-asset values, following CIP-2. Every asset the
+// outputs ask for is selected on its own, then lovelace; the change for
+// whatever is left over is split into outputs that each fit MaxValueSize and
+// carry their min-UTxO.
+
+type (
+	SelectionStrategy string
+
+	SelectionRequest struct {
+		Available     []TxInput
+		Outputs       []TxOut
+		ChangeAddress string
+		Strategy      SelectionStrategy // empty uses CoinSelection
+		FeeAllowance  uint64            // lovelace kept aside for the fee
+		MaxInputs     int               // 0 for no limit
+		Params        *ProtocolParams   // nil uses the package Params
+	}
+
+	// Selection is the inputs to spend and the change they leave once the
+	// outputs and the fee allowance are paid.
+	Selection struct {
+		Inputs []TxInput
+		Change []TxOut
+	}
+)
+
+const (
+	// LargestFirst spends the biggest UTxOs of each asset first. It uses the
+	// fewest inputs but grinds a wallet down into dust over time.
+	LargestFirst SelectionStrategy = "largest-first"
+	// RandomImprove picks at random and then aims for change of about the
+	// size of the payment, which keeps the UTxO set healthy.
+	RandomImprove SelectionStrategy = "random-improve"
+	// Consolidate selects like LargestFirst and then sweeps in the smallest
+	// remaining UTxOs, so farm wallets shed their dust as they pay out.
+	Consolidate SelectionStrategy = "consolidate"
+
+	// inputs Consolidate adds when the request sets no MaxInputs
+	consolidationInputs = 40
+)
+
+var (
+	ErrSelectionLimit = errors.New("coin selection needs more inputs than allowed")
+
+	// CoinSelection is read from CARDANO_VALLEY_COIN_SELECTION and defaults to
+	// random-improve.
+	CoinSelection = coinSelection()
+)
+
+func coinSelection() SelectionStrategy {
+	switch s := SelectionStrategy(os.Getenv("CARDANO_VALLEY_COIN_SELECTION")); s {
+	case "":
+		return RandomImprove
+	case LargestFirst, RandomImprove, Consolidate:
+		return s
+	default:
+		logger.Record.Error("CARDANO", "UNKNOWN COIN SELECTION", s, "USING", RandomImprove)
+		return RandomImprove
+	}
+}
+
+// SelectCoins picks inputs for the outputs in req. Random-improve falls back
+// to largest-first when its random picks need more than MaxInputs inputs.
+func SelectCoins(req SelectionRequest) (*Selection, error) {
+	params := Params
+	if req.Params != nil {
+		params = *req.Params
+	}
+	strategy := req.Strategy
+	if strategy == "" {
+		strategy = CoinSelection
+	}
+
+	var need TxOut
+	for _, o := range req.Outputs {
+		need.Lovelace += o.Lovelace
+		need.Assets = addAssets(need.Assets, o.Assets)
+	}
+	need.Lovelace += req.FeeAllowance
+
+	var total TxOut
+	for _, in := range req.Available {
+		total.Lovelace += in.Output.Lovelace
+		total.Assets = addAssets(total.Assets, in.Output.Assets)
+	}
+	if !covers(total.Assets, need.Assets) || total.Lovelace < need.Lovelace {
+		return nil, fmt.Errorf("coin selection: %w: wallet holds %s, outputs need %s", ErrInsufficientFunds, describeValue(total), describeValue(need))
+	}
+
+	var selected, remaining []TxInput
+	switch strategy {
+	case RandomImprove:
+		var err error
+		selected, remaining, err = randomImprove(req.Available, need, req.MaxInputs)
+		if err != nil {
+			selected, remaining = largestFirst(req.Available, need)
+		}
+	case Consolidate:
+		selected, remaining = largestFirst(req.Available, need)
+		limit := req.MaxInputs
+		if limit == 0 {
+			limit = consolidationInputs
+		}
+		sort.SliceStable(remaining, func(i, j int) bool {
+			return remaining[i].Output.Lovelace < remaining[j].Output.Lovelace
+		})
+		for len(selected) < limit && len(remaining) > 0 {
+			selected = append(selected, remaining[0])
+			remaining = remaining[1:]
+		}
+	default:
+		selected, remaining = largestFirst(req.Available, need)
+	}
+
+	// top up with the largest ada inputs until the change can carry itself
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].Output.Lovelace > remaining[j].Output.Lovelace
+	})
+	for {
+		have := sumInputs(selected)
+		change, err := ChangeOutputs(req.ChangeAddress, have.Lovelace-need.Lovelace, subAssets(have.Assets, need.Assets), params)
+		if err == nil {
+			if req.MaxInputs > 0 && len(selected) > req.MaxInputs {
+				return nil, fmt.Errorf("%w: %d inputs, limit is %d", ErrSelectionLimit, len(selected), req.MaxInputs)
+			}
+			return &Selection{Inputs: selected, Change: change}, nil
+		}
+		if !errors.Is(err, ErrInsufficientFunds) || len(remaining) == 0 {
+			return nil, fmt.Errorf("coin selection: %w", err)
+		}
+		selected = append(selected, remaining[0])
+		remaining = remaining[1:]
+	}
+}
+
+// largestFirst covers each asset, then lovelace, with the inputs holding the
+// most of it.
+func largestFirst(available []TxInput, need TxOut) (selected, remaining []TxInput) {
+	remaining = append([]TxInput(nil), available...)
+	for _, unit := range sortedUnits(need.Assets) {
+		sort.SliceStable(remaining, func(i, j int) bool {
+			return remaining[i].Output.Assets[unit] > remaining[j].Output.Assets[unit]
+		})
+		for quantity(selected, unit) < need.Assets[unit] && len(remaining) > 0 && remaining[0].Output.Assets[unit] > 0 {
+			selected = append(selected, remaining[0])
+			remaining = remaining[1:]
+		}
+	}
+
+	sort.SliceStable(remaining, func(i, j int) bool {
+		return remaining[i].Output.Lovelace > remaining[j].Output.Lovelace
+	})
+	for quantity(selected, "") < need.Lovelace && len(remaining) > 0 {
+		selected = append(selected, remaining[0])
+		remaining = remaining[1:]
+	}
+	return selected, remaining
+}
+
+// randomImprove is CIP-2's random-improve: for each asset, pick random inputs
+// until the target is met, then keep adding random inputs while they bring
+// the selected amount closer to twice the target without passing three times.
+// Picks that go over maxInputs fail with ErrSelectionLimit; improving stops
+// at the limit.
+func randomImprove(available []TxInput, need TxOut, maxInputs int) (selected, remaining []TxInput, err error) {
+	remaining = append([]TxInput(nil), available...)
+	rand.Shuffle(len(remaining), func(i, j int) { remaining[i], remaining[j] = remaining[j], remaining[i] })
+
+	units := append(sortedUnits(need.Assets), "")
+	targets := make(map[string]uint64, len(units))
+	for _, unit := range units {
+		targets[unit] = need.Assets[unit]
+	}
+	targets[""] = need.Lovelace
+
+	// random select
+	for _, unit := range units {
+		for quantity(selected, unit) < targets[unit] {
+			n := pickHolding(remaining, unit)
+			if n < 0 {
+				return nil, nil, fmt.Errorf("%w: random selection ran out of %s", ErrInsufficientFunds, unitName(unit))
+			}
+			if maxInputs > 0 && len(selected) == maxInputs {
+				return nil, nil, fmt.Errorf("%w: random selection of %s", ErrSelectionLimit, unitName(unit))
+			}
+			selected = append(selected, remaining[n])
+			remaining = append(remaining[:n], remaining[n+1:]...)
+		}
+	}
+
+	// improve
+	for _, unit := range units {
+		target := targets[unit]
+		if target == 0 {
+			continue
+		}
+		ideal, max := 2*target, 3*target
+		for maxInputs == 0 || len(selected) < maxInputs {
+			n := pickHolding(remaining, unit)
+			if n < 0 {
+				break
+			}
+			current := quantity(selected, unit)
+			next := current + amountOf(remaining[n].Output, unit)
+			if next > max || distance(next, ideal) >= distance(current, ideal) {
+				break
+			}
+			selected = append(selected, remaining[n])
+			remaining = append(remaining[:n], remaining[n+1:]...)
+		}
+	}
+	return selected, remaining, nil
+}
+
+// ChangeOutputs splits a change value into outputs to address. Tokens are
+// bundled so each output stays within MaxValueSize, every bundle gets its
+// min-UTxO and the rest of the lovelace rides on the first one. Ada-only
+// change too small for an output returns no outputs, for the fee to absorb.
+func ChangeOutputs(address string, lovelace uint64, assets map[string]uint64, params ProtocolParams) ([]TxOut, error) {
+	if len(assets) == 0 {
+		out := TxOut{Address: address, Lovelace: lovelace}
+		min, err := params.MinUTxO(out)
+		if err != nil {
+			return nil, err
+		}
+		if lovelace < min {
+			return nil, nil
+		}
+		return []TxOut{out}, nil
+	}
+
+	var bundles []TxOut
+	current := TxOut{Address: address}
+	for _, unit := range sortedUnits(assets) {
+		if len(current.Assets) > 0 {
+			next := TxOut{Address: address, Assets: addAssets(addAssets(nil, current.Assets), map[string]uint64{unit: assets[unit]})}
+			size, err := valueSize(next)
+			if err != nil {
+				return nil, err
+			}
+			if size > params.MaxValueSize {
+				bundles = append(bundles, current)
+				current = TxOut{Address: address}
+			}
+		}
+		current.Assets = addAssets(current.Assets, map[string]uint64{unit: assets[unit]})
+	}
+	bundles = append(bundles, current)
+
+	var required uint64
+	for n := range bundles {
+		min, err := params.MinUTxO(bundles[n])
+		if err != nil {
+			return nil, err
+		}
+		bundles[n].Lovelace = min
+		required += min
+	}
+	if lovelace < required {
+		return nil, fmt.Errorf("%w: token change needs %d lovelace, %d left", ErrInsufficientFunds, required, lovelace)
+	}
+	bundles[0].Lovelace += lovelace - required
+	return bundles, nil
+}
+
+// ConsolidationRequests groups a wallet's UTxOs, smallest first, into
+// transactions of up to maxInputs inputs that each pay everything back to
+// address as a few large outputs.
+func ConsolidationRequests(available []TxInput, address string, maxInputs int) []TxRequest {
+	if maxInputs < 2 {
+		maxInputs = consolidationInputs
+	}
+	sorted := append([]TxInput(nil), available...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Output.Lovelace < sorted[j].Output.Lovelace
+	})
+
+	var requests []TxRequest
+	for len(sorted) > 1 {
+		n := min(maxInputs, len(sorted))
+		requests = append(requests, TxRequest{Inputs: sorted[:n], ChangeAddress: address, SpendAll: true})
+		sorted = sorted[n:]
+	}
+	return requests
+}
+
+// ─── Helpers ───
+
+func sumInputs(inputs []TxInput) TxOut {
+	var total TxOut
+	for _, in := range inputs {
+		total.Lovelace += in.Output.Lovelace
+		total.Assets = addAssets(total.Assets, in.Output.Assets)
+	}
+	return total
+}
+
+// amountOf returns the quantity of unit in o, with "" meaning lovelace.
+func amountOf(o TxOut, unit string) uint64 {
+	if unit == "" {
+		return o.Lovelace
+	}
+	return o.Assets[unit]
+}
+
+func quantity(inputs []TxInput, unit string) uint64 {
+	var total uint64
+	for _, in := range inputs {
+		total += amountOf(in.Output, unit)
+	}
+	return total
+}
+
+// pickHolding returns the index of a random input holding unit, or -1.
+func pickHolding(inputs []TxInput, unit string) int {
+	var holding []int
+	for n, in := range inputs {
+		if amountOf(in.Output, unit) > 0 {
+			holding = append(holding, n)
+		}
+	}
+	if len(holding) == 0 {
+		return -1
+	}
+	return holding[rand.IntN(len(holding))]
+}
+
+func distance(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func sortedUnits(assets map[string]uint64) []string {
+	units := make([]string, 0, len(assets))
+	for unit := range assets {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+	return units
+}
+
+func unitName(unit string) string {
+	if unit == "" {
+		return "lovelace"
+	}
+	return unit
+}
+
+func describeValue(v TxOut) string {
+	if len(v.Assets) == 0 {
+		return fmt.Sprintf("%d lovelace", v.Lovelace)
+	}
+	return fmt.Sprintf("%d lovelace and %d tokens", v.Lovelace, len(v.Assets))
+}
+
+// valueSize is the serialised size of the output's value, sized like MinUTxO.
+func valueSize(o TxOut) (uint64, error) {
+	o.Lovelace = 1 << 62
+	encoded, err := o.cbor()
+	if err != nil {
+		return 0, err
+	}
+	value, _ := encoded.Get(1)
+	data, err := cborEncode(value)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(data)), nil
+}
@@ -0,0 +1,195 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/network"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func selectionInput(n int, lovelace uint64, assets map[string]uint64) TxInput {
+	return TxInput{
+		TxHash: fmt.Sprintf("%064x", n),
+		Output: TxOut{Address: cip19Mainnet[6].address, Lovelace: lovelace, Assets: assets},
+	}
+}
+
+// tokens returns n units of one policy with 32 byte names.
+func tokens(n int) map[string]uint64 {
+	assets := make(map[string]uint64, n)
+	for i := 0; i < n; i++ {
+		assets[cip19Script+fmt.Sprintf("%064x", i)] = uint64(i + 1)
+	}
+	return assets
+}
+
+func TestSelectCoins(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+	address := cip19Mainnet[6].address
+	smallValues := DefaultProtocolParams
+	smallValues.MaxValueSize = 200
+
+	dust := make([]TxInput, 20)
+	for n := range dust {
+		dust[n] = selectionInput(n, 1_500_000, nil)
+	}
+	whale := selectionInput(100, 50_000_000, nil)
+
+	tests := []struct {
+		name      string
+		available []TxInput
+		pay       uint64
+		strategy  SelectionStrategy
+		maxInputs int
+		params    ProtocolParams
+		inputs    int // expected inputs, 0 to skip the check
+		change    int // expected change outputs
+		err       error
+	}{
+		{
+			name:      "token change carries its min-UTxO",
+			available: []TxInput{selectionInput(1, 10_000_000, tokens(3))},
+			pay:       2_000_000,
+			strategy:  LargestFirst,
+			params:    DefaultProtocolParams,
+			inputs:    1,
+			change:    1,
+		},
+		{
+			name: "token change tops up with ada inputs",
+			available: []TxInput{
+				selectionInput(1, 3_000_000, tokens(3)),
+				selectionInput(2, 1_200_000, nil),
+			},
+			pay:      2_000_000,
+			strategy: LargestFirst,
+			params:   DefaultProtocolParams,
+			inputs:   2,
+			change:   1,
+		},
+		{
+			name:      "token change is split to fit max value size",
+			available: []TxInput{selectionInput(1, 20_000_000, tokens(10))},
+			pay:       2_000_000,
+			strategy:  LargestFirst,
+			params:    smallValues,
+			inputs:    1,
+			change:    3,
+		},
+		{
+			name: "more inputs than allowed",
+			available: []TxInput{
+				selectionInput(1, 2_000_000, nil),
+				selectionInput(2, 2_000_000, nil),
+				selectionInput(3, 2_000_000, nil),
+				selectionInput(4, 2_000_000, nil),
+			},
+			pay:       5_000_000,
+			strategy:  LargestFirst,
+			maxInputs: 2,
+			params:    DefaultProtocolParams,
+			err:       ErrSelectionLimit,
+		},
+		{
+			name:      "not enough ada",
+			available: []TxInput{selectionInput(1, 2_000_000, nil)},
+			pay:       5_000_000,
+			strategy:  RandomImprove,
+			params:    DefaultProtocolParams,
+			err:       ErrInsufficientFunds,
+		},
+		{
+			name:      "random-improve over the input limit falls back to largest-first",
+			available: append(append([]TxInput(nil), dust...), whale),
+			pay:       5_000_000,
+			strategy:  RandomImprove,
+			maxInputs: 2,
+			params:    DefaultProtocolParams,
+			change:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// random-improve is random, give it a few chances to go wrong
+			for round := 0; round < 20; round++ {
+				sel, err := SelectCoins(SelectionRequest{
+					Available:     tt.available,
+					Outputs:       []TxOut{{Address: address, Lovelace: tt.pay}},
+					ChangeAddress: address,
+					Strategy:      tt.strategy,
+					MaxInputs:     tt.maxInputs,
+					Params:        &tt.params,
+				})
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("SelectCoins = %v, want %v", err, tt.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkSelection(t, sel, tt.pay, tt.params)
+
+				if tt.inputs > 0 && len(sel.Inputs) != tt.inputs {
+					t.Errorf("%d inputs, want %d", len(sel.Inputs), tt.inputs)
+				}
+				if tt.maxInputs > 0 && len(sel.Inputs) > tt.maxInputs {
+					t.Fatalf("%d inputs, limit is %d", len(sel.Inputs), tt.maxInputs)
+				}
+				if len(sel.Change) != tt.change {
+					t.Errorf("%d change outputs, want %d", len(sel.Change), tt.change)
+				}
+			}
+		})
+	}
+}
+
+// checkSelection verifies the selection pays the output, returns every
+// other token, and that each change output is valid on its own.
+func checkSelection(t *testing.T, sel *Selection, pay uint64, params ProtocolParams) {
+	t.Helper()
+	in := sumInputs(sel.Inputs)
+
+	var change TxOut
+	for _, o := range sel.Change {
+		min, err := params.MinUTxO(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Lovelace < min {
+			t.Errorf("change holds %d lovelace, min-UTxO is %d", o.Lovelace, min)
+		}
+		size, err := valueSize(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size > params.MaxValueSize {
+			t.Errorf("change value is %d bytes, max is %d", size, params.MaxValueSize)
+		}
+		change.Lovelace += o.Lovelace
+		change.Assets = addAssets(change.Assets, o.Assets)
+	}
+
+	if change.Lovelace+pay > in.Lovelace {
+		t.Errorf("inputs hold %d lovelace, change and payment take %d", in.Lovelace, change.Lovelace+pay)
+	}
+	if len(in.Assets) > 0 && !reflect.DeepEqual(change.Assets, in.Assets) {
+		t.Errorf("change tokens %v, want %v", change.Assets, in.Assets)
+	}
+}
+
+func TestChangeOutputsDust(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+	change, err := ChangeOutputs(cip19Mainnet[6].address, 100_000, nil, DefaultProtocolParams)
+	if err != nil || change != nil {
+		t.Errorf("ChangeOutputs = %v, %v, want no outputs for the fee to absorb", change, err)
+	}
+
+	_, err = ChangeOutputs(cip19Mainnet[6].address, 100_000, tokens(1), DefaultProtocolParams)
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("token change without its min-UTxO: %v, want %v", err, ErrInsufficientFunds)
+	}
+}
@@ -0,0 +1,447 @@
+package cardano
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// Executor runs cardano-cli. stdin may be empty; errors include stderr.
+	Executor interface {
+		Exec(stdin string, args ...string) (string, error)
+	}
+
+	ShellExecutor struct {
+		Path string
+	}
+
+	// RecordingExecutor stands in for cardano-cli in offline runs. It records
+	// every call, writes plausible files for the commands that produce them and
+	// reports submitted transactions through OnSubmit.
+	RecordingExecutor struct {
+		mu    sync.Mutex
+		Calls []ExecCall
+
+		// UTxOs answers `query utxo`, keyed by "txhash#index"
+		UTxOs func(address string) (map[string]TxOut, error)
+
+		// OnSubmit is called with the txid, spent inputs and outputs of every submitted tx
+		OnSubmit func(txID string, txIns []string, txOuts []TxOut) error
+
+		// Handlers override the response for a subcommand, e.g. "transaction build"
+		Handlers map[string]func(args []string) (string, error)
+
+		bodies map[string]recordedTx // cborHex -> inputs and outputs
+	}
+
+	ExecCall struct {
+		Args     []string
+		HasStdin bool
+		Output   string
+		Err      string
+	}
+
+	// TxOut is a parsed --tx-out argument.
+	TxOut struct {
+		Address  string
+		Lovelace uint64
+		Assets   map[string]uint64 // unit (policy ID + hex asset name) -> quantity
+	}
+
+	recordedTx struct {
+		ins  []string
+		outs []TxOut
+	}
+)
+
+// recordedFee is the fee RecordingExecutor charges every tx.
+const recordedFee = 200_000
+
+var errUnknownInputs = errors.New("recorded tx inputs are unknown")
+
+// CLI is the cardano-cli used by Run and the airdrop pipeline.
+var CLI Executor = &ShellExecutor{Path: cliPath()}
+
+func cliPath() string {
+	if p, ok := os.LookupEnv("CARDANO_CLI_PATH"); ok && p != "" {
+		return p
+	}
+	return "cardano-cli"
+}
+
+func (e *ShellExecutor) Exec(stdin string, args ...string) (string, error) {
+	cmd := exec.Command(e.Path, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	out := stdout.String()
+	if err != nil {
+		return out, fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return out, nil
+}
+
+func NewRecordingExecutor() *RecordingExecutor {
+	return &RecordingExecutor{
+		Handlers: make(map[string]func(args []string) (string, error)),
+		bodies:   make(map[string]recordedTx),
+	}
+}
+
+// Recorded returns the calls whose arguments contain the given subcommand,
+// e.g. "transaction submit".
+func (r *RecordingExecutor) Recorded(subcommand string) []ExecCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var calls []ExecCall
+	for _, c := range r.Calls {
+		if strings.Contains(strings.Join(c.Args, " "), subcommand) {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// TxSubcommands lists the `transaction` subcommands run so far, in order,
+// e.g. build, txid, sign, submit.
+func (r *RecordingExecutor) TxSubcommands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var subs []string
+	for _, c := range r.Calls {
+		for i := 0; i < len(c.Args)-1; i++ {
+			if c.Args[i] == "transaction" {
+				subs = append(subs, c.Args[i+1])
+				break
+			}
+		}
+	}
+	return subs
+}
+
+func (r *RecordingExecutor) Exec(stdin string, args ...string) (string, error) {
+	out, err := r.exec(stdin, args)
+
+	r.mu.Lock()
+	call := ExecCall{Args: append([]string(nil), args...), HasStdin: stdin != "", Output: out}
+	if err != nil {
+		call.Err = err.Error()
+	}
+	r.Calls = append(r.Calls, call)
+	r.mu.Unlock()
+
+	return out, err
+}
+
+func (r *RecordingExecutor) exec(stdin string, args []string) (string, error) {
+	joined := strings.Join(args, " ")
+	for sub, handler := range r.Handlers {
+		if strings.Contains(joined, sub) {
+			return handler(args)
+		}
+	}
+
+	flag := func(name string) string {
+		for i := 0; i < len(args)-1; i++ {
+			if args[i] == name {
+				return args[i+1]
+			}
+		}
+		return ""
+	}
+	flags := func(name string) []string {
+		var values []string
+		for i := 0; i < len(args)-1; i++ {
+			if args[i] == name {
+				values = append(values, args[i+1])
+			}
+		}
+		return values
+	}
+
+	switch {
+	case strings.Contains(joined, "key-gen"):
+		vkey, skey := flag("--verification-key-file"), flag("--signing-key-file")
+		seed := sha256.Sum256([]byte(vkey))
+		if err := writeFixtureFile(vkey, fmt.Sprintf(`{"type":"PaymentVerificationKeyShelley_ed25519","cborHex":"5820%x"}`, seed)); err != nil {
+			return "", err
+		}
+		seed = sha256.Sum256([]byte(skey))
+		return "", writeFixtureFile(skey, fmt.Sprintf(`{"type":"PaymentSigningKeyShelley_ed25519","cborHex":"5820%x"}`, seed))
+
+	case strings.Contains(joined, "address build"):
+		vkey := flag("--payment-verification-key-file")
+		hash := sha256.Sum256([]byte(vkey))
+		networkID := byte(1)
+		if flag("--testnet-magic") != "" {
+			networkID = 0
+		}
+		addr, err := Bech32Encode(addressPrefix(AddressEnterprise, networkID), append([]byte{byte(AddressEnterprise)<<4 | networkID}, hash[:credentialSize]...))
+		if err != nil {
+			return "", err
+		}
+		if out := flag("--out-file"); out != "" {
+			return "", writeFixtureFile(out, addr)
+		}
+		return addr, nil
+
+	case strings.Contains(joined, "query utxo"):
+		utxos := make(map[string]any)
+		if r.UTxOs != nil {
+			outs, err := r.UTxOs(flag("--address"))
+			if err != nil {
+				return "", err
+			}
+			for ref, o := range outs {
+				utxos[ref] = o.cliJSON()
+			}
+		}
+		data, _ := json.Marshal(utxos)
+		if out := flag("--out-file"); out != "" && out != "/dev/stdout" {
+			return "", writeFixtureFile(out, string(data))
+		}
+		return string(data), nil
+
+	case strings.Contains(joined, "calculate-min-fee"):
+		return fmt.Sprintf("%d Lovelace", recordedFee), nil
+
+	case strings.Contains(joined, "transaction build"):
+		var tx recordedTx
+		tx.ins = flags("--tx-in")
+		for _, o := range flags("--tx-out") {
+			out, err := ParseTxOut(o)
+			if err != nil {
+				return "", err
+			}
+			tx.outs = append(tx.outs, out)
+		}
+		// a real tx body when the inputs can be looked up, so the tx decodes
+		// and can be submitted through any submitter
+		cbor, err := r.buildTx(tx, flag)
+		if err != nil && !errors.Is(err, errUnknownInputs) {
+			return "", err
+		}
+		if err != nil {
+			cborHex := fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Join(args, " "))))
+			r.mu.Lock()
+			r.bodies[cborHex] = tx
+			r.mu.Unlock()
+			cbor, _ = hex.DecodeString(cborHex)
+		}
+		return fmt.Sprintf("Estimated transaction fee: %d Lovelace", recordedFee), writeFixtureFile(flag("--out-file"), fmt.Sprintf(`{"type":"Unwitnessed Tx ConwayEra","cborHex":"%x"}`, cbor))
+
+	case strings.Contains(joined, "transaction sign"):
+		body, signed := flag("--tx-body-file"), flag("--out-file")
+		cbor, err := ReadTxFile(body)
+		if err != nil {
+			return "", err
+		}
+		if tx, err := DecodeTx(cbor); err == nil {
+			var skeys []string
+			for _, file := range flags("--signing-key-file") {
+				if file == "/dev/stdin" {
+					skeys = append(skeys, stdin)
+					continue
+				}
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return "", err
+				}
+				skeys = append(skeys, string(data))
+			}
+			if err := signNative(tx, skeys...); err != nil {
+				return "", err
+			}
+			cbor = tx.CBOR
+		}
+		return "", writeFixtureFile(signed, fmt.Sprintf(`{"type":"Witnessed Tx ConwayEra","cborHex":"%x"}`, cbor))
+
+	case strings.Contains(joined, "transaction txid"):
+		file := flag("--tx-file")
+		if file == "" {
+			file = flag("--tx-body-file")
+		}
+		return fixtureTxID(file)
+
+	case strings.Contains(joined, "transaction submit"):
+		cbor, err := ReadTxFile(flag("--tx-file"))
+		if err != nil {
+			return "", err
+		}
+		txID := TxID(cbor)
+		r.mu.Lock()
+		tx, ok := r.bodies[hex.EncodeToString(cbor)]
+		r.mu.Unlock()
+		// natively built txs are real CBOR and can be read back
+		if decoded, err := DecodeTx(cbor); !ok && err == nil {
+			txID = decoded.ID
+			for _, in := range decoded.Inputs {
+				tx.ins = append(tx.ins, in.Ref())
+			}
+			tx.outs = decoded.Outputs
+		}
+		if r.OnSubmit != nil {
+			if err := r.OnSubmit(txID, tx.ins, tx.outs); err != nil {
+				return "", err
+			}
+		}
+		return "Transaction successfully submitted.", nil
+	}
+
+	return "", nil
+}
+
+// cliJSON renders the output the way `query utxo --output-json` does.
+func (o TxOut) cliJSON() map[string]any {
+	value := map[string]any{"lovelace": o.Lovelace}
+	for unit, qty := range o.Assets {
+		policyID, name := unit, ""
+		if len(unit) > 56 {
+			policyID, name = unit[:56], unit[56:]
+		}
+		assets, _ := value[policyID].(map[string]uint64)
+		if assets == nil {
+			assets = make(map[string]uint64)
+			value[policyID] = assets
+		}
+		assets[name] += qty
+	}
+	return map[string]any{"address": o.Address, "value": value}
+}
+
+func writeFixtureFile(path, content string) error {
+	if path == "" {
+		return nil
+	}
+	return os.WriteFile(path, []byte(content), 0600)
+}
+
+// fixtureTxID is the ID of a tx the recorder built, which signing does not
+// change.
+func fixtureTxID(file string) (string, error) {
+	cbor, err := ReadTxFile(file)
+	if err != nil {
+		return "", err
+	}
+	if tx, err := DecodeTx(cbor); err == nil {
+		return tx.ID, nil
+	}
+	return TxID(cbor), nil
+}
+
+// buildTx encodes the tx `transaction build` was asked for, with its change
+// and the recorded fee. The inputs are looked up at the change address;
+// errUnknownInputs means they are not there and only a placeholder body can
+// be written.
+func (r *RecordingExecutor) buildTx(tx recordedTx, flag func(string) string) ([]byte, error) {
+	if r.UTxOs == nil {
+		return nil, errUnknownInputs
+	}
+	changeAddress := flag("--change-address")
+	known, err := r.UTxOs(changeAddress)
+	if err != nil {
+		return nil, errUnknownInputs
+	}
+
+	var in, out TxOut
+	inputs := make([]TxInput, 0, len(tx.ins))
+	for _, ref := range tx.ins {
+		o, ok := known[ref]
+		hash, index, found := strings.Cut(ref, "#")
+		n, err := strconv.Atoi(index)
+		if !ok || !found || err != nil {
+			return nil, errUnknownInputs
+		}
+		inputs = append(inputs, TxInput{TxHash: hash, Index: n, Output: o})
+		in.Lovelace += o.Lovelace
+		in.Assets = addAssets(in.Assets, o.Assets)
+	}
+	for _, o := range tx.outs {
+		out.Lovelace += o.Lovelace
+		out.Assets = addAssets(out.Assets, o.Assets)
+	}
+	if !covers(in.Assets, out.Assets) || in.Lovelace < out.Lovelace+recordedFee {
+		return nil, fmt.Errorf("%w: inputs hold %d lovelace, outputs and fee need %d", ErrInsufficientFunds, in.Lovelace, out.Lovelace+recordedFee)
+	}
+
+	outputs := append([]TxOut(nil), tx.outs...)
+	if change := in.Lovelace - out.Lovelace - recordedFee; change > 0 {
+		outputs = append(outputs, TxOut{Address: changeAddress, Lovelace: change, Assets: subAssets(in.Assets, out.Assets)})
+	}
+
+	var ttl uint64
+	if v := flag("--invalid-hereafter"); v != "" {
+		if ttl, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	var aux any
+	if file := flag("--metadata-json-file"); file != "" {
+		md, err := LoadMetadataJSON(file)
+		if err != nil {
+			return nil, err
+		}
+		if aux, err = md.cbor(); err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := txBody(inputs, outputs, recordedFee, ttl, nil, aux)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := cborEncode(body)
+	if err != nil {
+		return nil, err
+	}
+	witnesses, err := witnessSet(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return cborEncode([]any{cborRaw(raw), witnesses, true, aux})
+}
+
+// ParseTxOut parses cardano-cli's `address+lovelace[+"qty policy.name"]` syntax.
+func ParseTxOut(s string) (TxOut, error) {
+	parts := strings.Split(s, "+")
+	if len(parts) < 2 {
+		return TxOut{}, fmt.Errorf("invalid tx-out %q", s)
+	}
+
+	lovelace, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 64)
+	if err != nil {
+		return TxOut{}, fmt.Errorf("invalid lovelace in tx-out %q", s)
+	}
+	out := TxOut{Address: strings.TrimSpace(parts[0]), Lovelace: lovelace}
+
+	for _, p := range parts[2:] {
+		fields := strings.Fields(strings.Trim(strings.TrimSpace(p), `"`))
+		if len(fields) != 2 {
+			return TxOut{}, fmt.Errorf("invalid asset in tx-out %q", s)
+		}
+		qty, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return TxOut{}, fmt.Errorf("invalid asset quantity in tx-out %q", s)
+		}
+		if out.Assets == nil {
+			out.Assets = make(map[string]uint64)
+		}
+		out.Assets[strings.Replace(fields[1], ".", "", 1)] += qty
+	}
+	return out, nil
+}
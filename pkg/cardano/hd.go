@@ -0,0 +1,197 @@
+package cardano
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"filippo.io/edwards25519"
+	bip39 "github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Hierarchical deterministic keys the way Cardano wallets derive them:
+// BIP32-Ed25519 (Khovratovich/Law, "V2" derivation) from an Icarus root, on
+// CIP-1852 paths m/1852'/1815'/account'/role/index.
+
+type (
+	// ExtendedKey is a BIP32-Ed25519 private key: the 64 byte extended
+	// secret (kL || kR) and its chain code.
+	ExtendedKey struct {
+		secret    []byte
+		chainCode []byte
+	}
+)
+
+const (
+	Hardened uint32 = 0x80000000
+
+	PurposeCIP1852 = 1852
+	CoinTypeADA    = 1815
+
+	// CIP-1852 roles
+	RoleExternal = 0
+	RoleInternal = 1
+	RoleStaking  = 2
+
+	// 24 words
+	mnemonicEntropyBits = 256
+	icarusIterations    = 4096
+)
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// NewMnemonic returns a fresh 24 word BIP39 recovery phrase.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// RootKeyFromMnemonic derives the Icarus master key, the same root Daedalus,
+// Yoroi and the other Shelley wallets get from the phrase.
+func RootKeyFromMnemonic(mnemonic, passphrase string) (*ExtendedKey, error) {
+	entropy, err := bip39.EntropyFromMnemonic(strings.Join(strings.Fields(mnemonic), " "))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMnemonic, err)
+	}
+	data := pbkdf2.Key([]byte(passphrase), entropy, icarusIterations, 96, sha512.New)
+	data[0] &= 0xf8
+	data[31] &= 0x1f
+	data[31] |= 0x40
+	return &ExtendedKey{secret: data[:64], chainCode: data[64:]}, nil
+}
+
+// Derive returns the child at index; indexes from Hardened up are hardened.
+func (k *ExtendedKey) Derive(index uint32) *ExtendedKey {
+	var i [4]byte
+	binary.LittleEndian.PutUint32(i[:], index)
+
+	zMac := hmac.New(sha512.New, k.chainCode)
+	ccMac := hmac.New(sha512.New, k.chainCode)
+	if index >= Hardened {
+		zMac.Write([]byte{0x00})
+		zMac.Write(k.secret)
+		ccMac.Write([]byte{0x01})
+		ccMac.Write(k.secret)
+	} else {
+		public := k.Public()
+		zMac.Write([]byte{0x02})
+		zMac.Write(public)
+		ccMac.Write([]byte{0x03})
+		ccMac.Write(public)
+	}
+	zMac.Write(i[:])
+	ccMac.Write(i[:])
+	z := zMac.Sum(nil)
+	cc := ccMac.Sum(nil)
+
+	// kL' = 8 * zL[:28] + kL, kR' = zR + kR mod 2^256
+	secret := make([]byte, 64)
+	var carry uint16
+	for n := 0; n < 32; n++ {
+		var zl uint16
+		if n < 28 {
+			zl = uint16(z[n] << 3)
+		}
+		if n > 0 && n <= 28 {
+			zl |= uint16(z[n-1] >> 5)
+		}
+		sum := uint16(k.secret[n]) + zl + carry
+		secret[n] = byte(sum)
+		carry = sum >> 8
+	}
+	carry = 0
+	for n := 32; n < 64; n++ {
+		sum := uint16(k.secret[n]) + uint16(z[n]) + carry
+		secret[n] = byte(sum)
+		carry = sum >> 8
+	}
+	return &ExtendedKey{secret: secret, chainCode: cc[32:]}
+}
+
+// DerivePath walks a list of indexes from k.
+func (k *ExtendedKey) DerivePath(path ...uint32) *ExtendedKey {
+	key := k
+	for _, index := range path {
+		key = key.Derive(index)
+	}
+	return key
+}
+
+// Public is A = kL·B. kL is not reduced, so it is loaded mod the group order.
+func (k *ExtendedKey) Public() ed25519.PublicKey {
+	s := scalarFromBytes(k.secret[:32])
+	return ed25519.PublicKey(new(edwards25519.Point).ScalarBaseMult(s).Bytes())
+}
+
+// SigningKey wraps the key for signing and for cardano-cli envelopes.
+// keyType is PaymentExtendedSigningKeyType or StakeExtendedSigningKeyType.
+func (k *ExtendedKey) SigningKey(keyType string) *SigningKey {
+	return &SigningKey{
+		Type:      keyType,
+		extended:  append([]byte(nil), k.secret...),
+		chainCode: append([]byte(nil), k.chainCode...),
+	}
+}
+
+// Wipe zeroes the secret once the key is no longer needed.
+func (k *ExtendedKey) Wipe() {
+	for i := range k.secret {
+		k.secret[i] = 0
+	}
+}
+
+// signExtended is Ed25519 with the nonce taken from kR instead of a hashed
+// seed; the signatures verify with plain ed25519.Verify.
+func signExtended(secret []byte, public ed25519.PublicKey, message []byte) []byte {
+	h := sha512.New()
+	h.Write(secret[32:])
+	h.Write(message)
+	r, _ := edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+	R := new(edwards25519.Point).ScalarBaseMult(r).Bytes()
+
+	h.Reset()
+	h.Write(R)
+	h.Write(public)
+	h.Write(message)
+	challenge, _ := edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+
+	S := edwards25519.NewScalar().MultiplyAdd(challenge, scalarFromBytes(secret[:32]), r)
+	return append(R, S.Bytes()...)
+}
+
+func scalarFromBytes(b []byte) *edwards25519.Scalar {
+	wide := make([]byte, 64)
+	copy(wide, b)
+	s, _ := edwards25519.NewScalar().SetUniformBytes(wide)
+	return s
+}
+
+// AccountPath is the CIP-1852 path of an account, m/1852'/1815'/account'.
+func AccountPath(account uint32) []uint32 {
+	return []uint32{PurposeCIP1852 | Hardened, CoinTypeADA | Hardened, account | Hardened}
+}
+
+// FormatPath renders a path the way wallets show it, e.g. m/1852'/1815'/0'/0/0.
+func FormatPath(path []uint32) string {
+	var b strings.Builder
+	b.WriteString("m")
+	for _, index := range path {
+		b.WriteString("/")
+		if index >= Hardened {
+			b.WriteString(strconv.FormatUint(uint64(index-Hardened), 10))
+			b.WriteString("'")
+		} else {
+			b.WriteString(strconv.FormatUint(uint64(index), 10))
+		}
+	}
+	return b.String()
+}
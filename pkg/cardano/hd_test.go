@@ -0,0 +1,132 @@
+package cardano
+
+import (
+	"bytes"
+	"cardano-valley/pkg/network"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// The CIP-19 test vectors are built from keys of this phrase, which makes them
+// CIP-1852 derivation vectors covering the Icarus root, hardened and soft
+// steps. The payment key is the first external key of account 0; the stake
+// key, despite CIP-19 naming 0H/2/0, is the first staking key of account 1.
+const cip19Mnemonic = "test walk nut penalty hip pave soap entry language right filter choice"
+
+// The keys as CIP-19 publishes them, addr_vk and stake_vk.
+const (
+	cip19PaymentVKey = "addr_vk1w0l2sr2zgfm26ztc6nl9xy8ghsk5sh6ldwemlpmp9xylzy4dtf7st80zhd"
+	cip19StakeVKey   = "stake_vk1px4j0r2fk7ux5p23shz8f3y5y2qam7s954rgf3lg5merqcj6aetsft99wu"
+)
+
+func TestDeriveCIP1852(t *testing.T) {
+	root, err := RootKeyFromMnemonic(cip19Mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   []uint32
+		prefix string
+		vkey   string
+		hash   string
+	}{
+		{"payment m/1852'/1815'/0'/0/0", append(AccountPath(0), RoleExternal, 0), "addr_vk", cip19PaymentVKey, cip19PaymentKey},
+		{"stake m/1852'/1815'/1'/2/0", append(AccountPath(1), RoleStaking, 0), "stake_vk", cip19StakeVKey, cip19StakeKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := root.DerivePath(tt.path...)
+			if got, _ := Bech32Encode(tt.prefix, key.Public()); got != tt.vkey {
+				t.Errorf("verification key %s, want %s", got, tt.vkey)
+			}
+			if got := hex.EncodeToString(KeyHash(key.Public())); got != tt.hash {
+				t.Errorf("key hash %s, want %s", got, tt.hash)
+			}
+		})
+	}
+}
+
+func TestDerivedAddressesCIP19(t *testing.T) {
+	root, err := RootKeyFromMnemonic(cip19Mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := root.DerivePath(append(AccountPath(0), RoleExternal, 0)...).Public()
+	stake := root.DerivePath(append(AccountPath(1), RoleStaking, 0)...).Public()
+
+	for _, set := range []struct {
+		network      string
+		base, reward string
+	}{
+		{network.Mainnet, cip19Mainnet[0].address, cip19Mainnet[8].address},
+		{network.Preprod, cip19Testnet[0].address, cip19Testnet[8].address},
+	} {
+		t.Run(set.network, func(t *testing.T) {
+			useNetwork(t, set.network)
+			if got, err := BaseAddress(payment, stake); err != nil || got != set.base {
+				t.Errorf("BaseAddress = %s, %v, want %s", got, err, set.base)
+			}
+			if got, err := StakeAddress(stake); err != nil || got != set.reward {
+				t.Errorf("StakeAddress = %s, %v, want %s", got, err, set.reward)
+			}
+		})
+	}
+}
+
+func TestDeriveDeterministic(t *testing.T) {
+	root, err := RootKeyFromMnemonic(cip19Mnemonic, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := root.DerivePath(append(AccountPath(0), RoleExternal, 7)...)
+	message := []byte("cardano valley")
+	signature := signExtended(key.secret, key.Public(), message)
+	if !ed25519.Verify(key.Public(), message, signature) {
+		t.Error("signature from a derived key does not verify")
+	}
+
+	again := root.DerivePath(append(AccountPath(0), RoleExternal, 7)...)
+	if !bytes.Equal(again.Public(), key.Public()) || !bytes.Equal(again.chainCode, key.chainCode) {
+		t.Error("derivation is not deterministic")
+	}
+	if other := root.DerivePath(append(AccountPath(1), RoleExternal, 7)...); bytes.Equal(other.Public(), key.Public()) {
+		t.Error("accounts 0 and 1 derived the same key")
+	}
+}
+
+func TestRootKeyFromMnemonic(t *testing.T) {
+	if _, err := RootKeyFromMnemonic("test walk nut penalty hip pave soap entry language right filter filter", ""); !errors.Is(err, ErrInvalidMnemonic) {
+		t.Errorf("bad checksum: %v, want %v", err, ErrInvalidMnemonic)
+	}
+
+	// extra whitespace is not part of the phrase
+	spaced, err := RootKeyFromMnemonic("  test walk nut penalty hip pave soap entry\nlanguage right  filter choice ", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, _ := RootKeyFromMnemonic(cip19Mnemonic, "")
+	if !bytes.Equal(spaced.Public(), root.Public()) {
+		t.Error("whitespace changed the root key")
+	}
+
+	// Icarus key clamping
+	if root.secret[0]&0x07 != 0 || root.secret[31]&0xe0 != 0x40 {
+		t.Errorf("root key is not clamped: %x .. %x", root.secret[0], root.secret[31])
+	}
+
+	withPassphrase, _ := RootKeyFromMnemonic(cip19Mnemonic, "secret")
+	if bytes.Equal(withPassphrase.Public(), root.Public()) {
+		t.Error("the passphrase did not change the root key")
+	}
+}
+
+func TestFormatPath(t *testing.T) {
+	if got, want := FormatPath(append(AccountPath(3), RoleStaking, 0)), "m/1852'/1815'/3'/2/0"; got != want {
+		t.Errorf("FormatPath = %s, want %s", got, want)
+	}
+}
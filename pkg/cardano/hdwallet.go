@@ -0,0 +1,297 @@
+package cardano
+
+import (
+	mongo "cardano-valley/pkg/db"
+	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/vault"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The bot keeps a single root phrase, sealed in the key vault, and every farm,
+// treasury and user wallet is a CIP-1852 account under it. The account
+// registry maps wallet IDs (Discord guild and user IDs) to account indexes,
+// so the phrase and the registry are all it takes to restore every wallet.
+// To restore on a fresh database, start with CARDANO_VALLEY_HD_MNEMONIC set.
+
+type (
+	// HDAccount is a wallet's entry in the account registry.
+	HDAccount struct {
+		WalletID  string    `bson:"wallet_id"`
+		Index     uint32    `bson:"index"`
+		Path      string    `bson:"path"` // account path, m/1852'/1815'/index'
+		CreatedAt time.Time `bson:"created_at"`
+	}
+
+	AccountStore interface {
+		Load(ctx context.Context, walletID string) (HDAccount, error)
+		// Create assigns the next free index, or returns the wallet's
+		// account if it already has one.
+		Create(ctx context.Context, walletID string) (HDAccount, error)
+		List(ctx context.Context) ([]HDAccount, error)
+	}
+)
+
+// vault wallet ID holding the root phrase
+const hdRootID = "hd-root"
+
+var (
+	ErrNoAccount = errors.New("wallet has no HD account")
+
+	// Accounts is Mongo, or memory in offline mode.
+	Accounts AccountStore = newAccountStore()
+
+	rootMu sync.Mutex // one root, even when wallets are generated concurrently
+)
+
+func newAccountStore() AccountStore {
+	if os.Getenv("CARDANO_VALLEY_OFFLINE") != "" {
+		return NewMemoryAccountStore()
+	}
+	return MongoAccountStore{}
+}
+
+func newHDAccount(walletID string, index uint32) HDAccount {
+	return HDAccount{
+		WalletID:  walletID,
+		Index:     index,
+		Path:      FormatPath(AccountPath(index)),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// HDMnemonic returns the root phrase for an offline backup.
+func HDMnemonic() (string, error) {
+	phrase, err := vault.Get(hdRootID, vault.RootMnemonic)
+	if err != nil {
+		return "", err
+	}
+	defer vault.Wipe(phrase)
+	return string(phrase), nil
+}
+
+// hdRoot opens the root key, creating the phrase on first use or importing it
+// from CARDANO_VALLEY_HD_MNEMONIC when restoring.
+func hdRoot() (*ExtendedKey, error) {
+	rootMu.Lock()
+	defer rootMu.Unlock()
+
+	phrase, err := vault.Get(hdRootID, vault.RootMnemonic)
+	restore := os.Getenv("CARDANO_VALLEY_HD_MNEMONIC")
+	switch {
+	case err == nil:
+		if restore != "" && strings.Join(strings.Fields(restore), " ") != string(phrase) {
+			logger.Record.Warn("WALLET", "HD ROOT", "ignoring CARDANO_VALLEY_HD_MNEMONIC, a different root already exists")
+		}
+	case errors.Is(err, vault.ErrNotFound):
+		mnemonic := restore
+		if mnemonic != "" {
+			logger.Record.Info("WALLET", "HD ROOT", "restored from CARDANO_VALLEY_HD_MNEMONIC, remove it from the environment")
+		} else if mnemonic, err = NewMnemonic(); err != nil {
+			return nil, err
+		} else {
+			logger.Record.Info("WALLET", "HD ROOT", "created, back it up with `cardano-valley hd-backup`")
+		}
+		phrase = []byte(strings.Join(strings.Fields(mnemonic), " "))
+		if _, err := RootKeyFromMnemonic(string(phrase), ""); err != nil {
+			return nil, err
+		}
+		if err := vault.Put(hdRootID, vault.RootMnemonic, phrase); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	defer vault.Wipe(phrase)
+	return RootKeyFromMnemonic(string(phrase), "")
+}
+
+// deriveWalletKeys returns the payment (role 0) and stake (role 2) keys at
+// index 0 of the wallet's account, registering the account if it is new.
+func deriveWalletKeys(walletID string) (payment, stake *SigningKey, account HDAccount, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	account, err = Accounts.Create(ctx, walletID)
+	if err != nil {
+		return nil, nil, account, fmt.Errorf("hd account %s: %w", walletID, err)
+	}
+	return deriveAccountKeys(account)
+}
+
+func deriveAccountKeys(account HDAccount) (payment, stake *SigningKey, _ HDAccount, err error) {
+	root, err := hdRoot()
+	if err != nil {
+		return nil, nil, account, fmt.Errorf("hd root: %w", err)
+	}
+	defer root.Wipe()
+
+	accountKey := root.DerivePath(AccountPath(account.Index)...)
+	defer accountKey.Wipe()
+	paymentKey := accountKey.DerivePath(RoleExternal, 0)
+	stakeKey := accountKey.DerivePath(RoleStaking, 0)
+	defer paymentKey.Wipe()
+	defer stakeKey.Wipe()
+
+	return paymentKey.SigningKey(PaymentExtendedSigningKeyType), stakeKey.SigningKey(StakeExtendedSigningKeyType), account, nil
+}
+
+// restoreSigningKeys re-derives a wallet's signing keys into the vault, for
+// when the vault record was lost but the account is still registered.
+func restoreSigningKeys(walletID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	account, err := Accounts.Load(ctx, walletID)
+	if err != nil {
+		return err
+	}
+	payment, stake, _, err := deriveAccountKeys(account)
+	if err != nil {
+		return err
+	}
+	for name, key := range map[string]*SigningKey{vault.PaymentSigningKey: payment, vault.StakeSigningKey: stake} {
+		envelope := []byte(key.Envelope())
+		err := vault.Put(walletID, name, envelope)
+		vault.Wipe(envelope)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Record.Info("WALLET", "RESTORED SIGNING KEYS", walletID, "PATH", account.Path)
+	return nil
+}
+
+// ─── Account registry ───────────────────────────────────────────────────────
+
+var errNoDB = errors.New("database is not connected")
+
+// MongoAccountStore keeps accounts in the "hd-accounts" collection and the
+// next free index in "counters".
+type MongoAccountStore struct{}
+
+func (MongoAccountStore) Load(ctx context.Context, walletID string) (HDAccount, error) {
+	var a HDAccount
+	if mongo.DB == nil {
+		return a, errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("hd-accounts")
+	filter := bson.D{{Key: "wallet_id", Value: walletID}}
+
+	err := collection.FindOne(ctx, filter).Decode(&a)
+	if errors.Is(err, mongodriver.ErrNoDocuments) {
+		return a, ErrNoAccount
+	}
+	return a, err
+}
+
+func (s MongoAccountStore) Create(ctx context.Context, walletID string) (HDAccount, error) {
+	if a, err := s.Load(ctx, walletID); !errors.Is(err, ErrNoAccount) {
+		return a, err
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("hd-accounts")
+	// a second wallet with the same ID fails the insert instead
+	_, err := collection.Indexes().CreateOne(ctx, mongodriver.IndexModel{
+		Keys:    bson.D{{Key: "wallet_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return HDAccount{}, err
+	}
+
+	var counter struct {
+		Next uint32 `bson:"next"`
+	}
+	counters := mongo.DB.Database("cardano-valley").Collection("counters")
+	filter := bson.D{{Key: "_id", Value: "hd-account"}}
+	update := bson.D{{Key: "$inc", Value: bson.D{{Key: "next", Value: 1}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := counters.FindOneAndUpdate(ctx, filter, update, opts).Decode(&counter); err != nil {
+		return HDAccount{}, err
+	}
+	index := counter.Next - 1
+	if index >= Hardened {
+		return HDAccount{}, errors.New("hd account indexes are exhausted")
+	}
+
+	a := newHDAccount(walletID, index)
+	if _, err := collection.InsertOne(ctx, a); mongodriver.IsDuplicateKeyError(err) {
+		// lost a race for the same wallet, the index is skipped
+		return s.Load(ctx, walletID)
+	} else if err != nil {
+		return HDAccount{}, err
+	}
+	return a, nil
+}
+
+func (MongoAccountStore) List(ctx context.Context) ([]HDAccount, error) {
+	if mongo.DB == nil {
+		return nil, errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("hd-accounts")
+	opts := options.Find().SetSort(bson.D{{Key: "index", Value: 1}})
+
+	var accounts []HDAccount
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// MemoryAccountStore is used in offline mode, where there is no database.
+type MemoryAccountStore struct {
+	mu       sync.Mutex
+	accounts map[string]HDAccount
+	next     uint32
+}
+
+func NewMemoryAccountStore() *MemoryAccountStore {
+	return &MemoryAccountStore{accounts: make(map[string]HDAccount)}
+}
+
+func (m *MemoryAccountStore) Load(ctx context.Context, walletID string) (HDAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accounts[walletID]
+	if !ok {
+		return a, ErrNoAccount
+	}
+	return a, nil
+}
+
+func (m *MemoryAccountStore) Create(ctx context.Context, walletID string) (HDAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.accounts[walletID]; ok {
+		return a, nil
+	}
+	a := newHDAccount(walletID, m.next)
+	m.accounts[walletID] = a
+	m.next++
+	return a, nil
+}
+
+func (m *MemoryAccountStore) List(ctx context.Context) ([]HDAccount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	accounts := make([]HDAccount, 0, len(m.accounts))
+	for _, a := range m.accounts {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Index < accounts[j].Index })
+	return accounts, nil
+}
@@ -0,0 +1,209 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/network"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// KeyEnvelope is the JSON text envelope cardano-cli reads and writes.
+	KeyEnvelope struct {
+		Type        string `json:"type"`
+		Description string `json:"description"`
+		CborHex     string `json:"cborHex"`
+	}
+
+	// SigningKey is an ed25519 signing key, either a normal one or an
+	// extended (BIP32) one derived from the HD root.
+	SigningKey struct {
+		Type    string
+		private ed25519.PrivateKey
+
+		// extended keys only
+		extended  []byte // kL || kR
+		chainCode []byte
+	}
+)
+
+const (
+	PaymentSigningKeyType      = "PaymentSigningKeyShelley_ed25519"
+	PaymentVerificationKeyType = "PaymentVerificationKeyShelley_ed25519"
+	StakeSigningKeyType        = "StakeSigningKeyShelley_ed25519"
+	StakeVerificationKeyType   = "StakeVerificationKeyShelley_ed25519"
+
+	PaymentExtendedSigningKeyType = "PaymentExtendedSigningKeyShelley_ed25519_bip32"
+	StakeExtendedSigningKeyType   = "StakeExtendedSigningKeyShelley_ed25519_bip32"
+
+	// cardano-cli stores extended signing keys as kL || kR || A || chain code
+	extendedEnvelopeSize = 128
+)
+
+var ErrUnsupportedKey = errors.New("unsupported signing key type")
+
+// NewSigningKey generates a fresh key. keyType is PaymentSigningKeyType or
+// StakeSigningKeyType.
+func NewSigningKey(keyType string) (*SigningKey, error) {
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{Type: keyType, private: private}, nil
+}
+
+// ParseSigningKey reads a cardano-cli signing key envelope, normal or
+// extended (BIP32). Anything else is rejected with ErrUnsupportedKey so
+// callers can fall back to the CLI.
+func ParseSigningKey(envelope string) (*SigningKey, error) {
+	var env KeyEnvelope
+	if err := json.Unmarshal([]byte(envelope), &env); err != nil {
+		return nil, fmt.Errorf("signing key envelope: %w", err)
+	}
+
+	seed, err := envelopeBytes(env)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(env.Type, "Extended") {
+		if len(seed) != extendedEnvelopeSize {
+			return nil, fmt.Errorf("%w: %s with %d byte key", ErrUnsupportedKey, env.Type, len(seed))
+		}
+		key := &SigningKey{Type: env.Type, extended: seed[:64], chainCode: seed[96:]}
+		if !key.Public().Equal(ed25519.PublicKey(seed[64:96])) {
+			return nil, fmt.Errorf("%w: %s public key does not match", ErrUnsupportedKey, env.Type)
+		}
+		return key, nil
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: %s with %d byte key", ErrUnsupportedKey, env.Type, len(seed))
+	}
+	return &SigningKey{Type: env.Type, private: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// envelopeBytes unwraps the CBOR byte string in an envelope.
+func envelopeBytes(env KeyEnvelope) ([]byte, error) {
+	raw, err := hex.DecodeString(env.CborHex)
+	if err != nil {
+		return nil, fmt.Errorf("%s cborHex: %w", env.Type, err)
+	}
+	v, err := cborDecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s cborHex: %w", env.Type, err)
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not a byte string", ErrCBOR, env.Type)
+	}
+	return b, nil
+}
+
+func (k *SigningKey) Public() ed25519.PublicKey {
+	if k.extended != nil {
+		return (&ExtendedKey{secret: k.extended}).Public()
+	}
+	return k.private.Public().(ed25519.PublicKey)
+}
+
+// Hash is the blake2b-224 key hash used in addresses and witnesses.
+func (k *SigningKey) Hash() []byte {
+	return KeyHash(k.Public())
+}
+
+func (k *SigningKey) Sign(message []byte) []byte {
+	if k.extended != nil {
+		return signExtended(k.extended, k.Public(), message)
+	}
+	return ed25519.Sign(k.private, message)
+}
+
+// Envelope renders the signing key the way cardano-cli writes it.
+func (k *SigningKey) Envelope() string {
+	if k.extended != nil {
+		key := append(append([]byte(nil), k.extended...), k.Public()...)
+		return envelopeJSON(k.Type, "", append(key, k.chainCode...))
+	}
+	return envelopeJSON(k.Type, "", k.private.Seed())
+}
+
+// VerificationEnvelope renders the matching verification key. Extended keys
+// keep their chain code, so accounts can derive further public keys.
+func (k *SigningKey) VerificationEnvelope() string {
+	vkeyType := strings.Replace(k.Type, "SigningKey", "VerificationKey", 1)
+	if k.extended != nil {
+		return envelopeJSON(vkeyType, "", append(k.Public(), k.chainCode...))
+	}
+	return envelopeJSON(vkeyType, "", k.Public())
+}
+
+func envelopeJSON(keyType, description string, key []byte) string {
+	cbor, _ := cborEncode(key)
+	data, _ := json.MarshalIndent(KeyEnvelope{
+		Type:        keyType,
+		Description: description,
+		CborHex:     hex.EncodeToString(cbor),
+	}, "", "    ")
+	return string(data)
+}
+
+// ParseVerificationKey reads a cardano-cli verification key envelope.
+func ParseVerificationKey(envelope string) (ed25519.PublicKey, error) {
+	var env KeyEnvelope
+	if err := json.Unmarshal([]byte(envelope), &env); err != nil {
+		return nil, fmt.Errorf("verification key envelope: %w", err)
+	}
+	key, err := envelopeBytes(env)
+	if err != nil {
+		return nil, err
+	}
+	// extended verification keys carry a 32 byte chain code after the key
+	if len(key) != ed25519.PublicKeySize && len(key) != ed25519.PublicKeySize+32 {
+		return nil, fmt.Errorf("%w: %s with %d byte key", ErrUnsupportedKey, env.Type, len(key))
+	}
+	return ed25519.PublicKey(key[:ed25519.PublicKeySize]), nil
+}
+
+func KeyHash(public ed25519.PublicKey) []byte {
+	h, _ := blake2b.New(credentialSize, nil)
+	h.Write(public)
+	return h.Sum(nil)
+}
+
+// EnterpriseAddress builds a payment address without a stake part.
+func EnterpriseAddress(payment ed25519.PublicKey) (string, error) {
+	header := byte(AddressEnterprise)<<4 | network.Current.NetworkID
+	return Bech32Encode(addressPrefix(AddressEnterprise, network.Current.NetworkID), append([]byte{header}, KeyHash(payment)...))
+}
+
+// BaseAddress builds a payment address delegating through the stake key.
+func BaseAddress(payment, stake ed25519.PublicKey) (string, error) {
+	header := byte(AddressBase)<<4 | network.Current.NetworkID
+	data := append([]byte{header}, KeyHash(payment)...)
+	return Bech32Encode(addressPrefix(AddressBase, network.Current.NetworkID), append(data, KeyHash(stake)...))
+}
+
+// StakeAddress builds the reward address for a stake key.
+func StakeAddress(stake ed25519.PublicKey) (string, error) {
+	header := byte(AddressStake)<<4 | network.Current.NetworkID
+	return Bech32Encode(addressPrefix(AddressStake, network.Current.NetworkID), append([]byte{header}, KeyHash(stake)...))
+}
+
+// PoolKeyHash decodes a bech32 pool ID (pool1...) or a hex pool hash.
+func PoolKeyHash(poolID string) ([]byte, error) {
+	if hash, err := hex.DecodeString(poolID); err == nil && len(hash) == credentialSize {
+		return hash, nil
+	}
+	hrp, hash, err := Bech32Decode(poolID)
+	if err != nil {
+		return nil, fmt.Errorf("pool id %q: %w", poolID, err)
+	}
+	if hrp != "pool" || len(hash) != credentialSize {
+		return nil, fmt.Errorf("%w: pool id %q", ErrMalformedAddress, poolID)
+	}
+	return hash, nil
+}
@@ -2,7 +2,6 @@ package cardano
 
 import (
 	"cardano-valley/pkg/logger"
-	"os/exec"
 )
 
 
@@ -17,10 +16,6 @@ type (
 	Assets []Asset
 )
 
-const (
-	NETWORK = "--mainnet"
-)
-
 func init() {
 	// // Check if cardano-cli is installed
 	// if _, err := exec.LookPath("cardano-cli"); err != nil {
@@ -32,11 +27,11 @@ func init() {
 
 func Run(args CommandArgs) ([]byte, error) {
 	logger.Record.Info("CARDANO", "COMMAND", args)
-	output, err := exec.Command("/usr/local/bin/cardano-cli", args...).CombinedOutput()
+	output, err := CLI.Exec("", args...)
 	if err != nil {
-		logger.Record.Error("CARDANO", "ERROR", err, "OUTPUT", string(output))
+		logger.Record.Error("CARDANO", "ERROR", err, "OUTPUT", output)
 	}
-	logger.Record.Info("CARDANO", "OUTPUT", string(output))
+	logger.Record.Info("CARDANO", "OUTPUT", output)
 
-	return output, err
+	return []byte(output), err
 }
\ No newline at end of file
@@ -0,0 +1,197 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/network"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// NativeScript is a timelock-free native script, in the shape of
+	// cardano-cli's script JSON so it can be written out as a script file.
+	NativeScript struct {
+		Type     ScriptType     `json:"type" bson:"type"`
+		KeyHash  string         `json:"keyHash,omitempty" bson:"key_hash,omitempty"`  // sig
+		Required int            `json:"required,omitempty" bson:"required,omitempty"` // atLeast
+		Scripts  []NativeScript `json:"scripts,omitempty" bson:"scripts,omitempty"`   // all, any, atLeast
+	}
+
+	ScriptType string
+)
+
+const (
+	ScriptSig     ScriptType = "sig"
+	ScriptAll     ScriptType = "all"
+	ScriptAny     ScriptType = "any"
+	ScriptAtLeast ScriptType = "atLeast"
+
+	// header nibble for a base address with a script payment part and a key
+	// stake part
+	addressBaseScriptKey AddressType = 1
+)
+
+var ErrInvalidScript = errors.New("invalid native script")
+
+// SigScript requires a signature from the key with this hash.
+func SigScript(keyHash []byte) NativeScript {
+	return NativeScript{Type: ScriptSig, KeyHash: hex.EncodeToString(keyHash)}
+}
+
+// AllOf requires every script.
+func AllOf(scripts ...NativeScript) NativeScript {
+	return NativeScript{Type: ScriptAll, Scripts: scripts}
+}
+
+// AtLeast requires n of the scripts.
+func AtLeast(n int, scripts ...NativeScript) NativeScript {
+	return NativeScript{Type: ScriptAtLeast, Required: n, Scripts: scripts}
+}
+
+func (s NativeScript) cbor() (any, error) {
+	children := func() ([]any, error) {
+		items := make([]any, len(s.Scripts))
+		for n, child := range s.Scripts {
+			c, err := child.cbor()
+			if err != nil {
+				return nil, err
+			}
+			items[n] = c
+		}
+		return items, nil
+	}
+
+	switch s.Type {
+	case ScriptSig:
+		hash, err := hex.DecodeString(s.KeyHash)
+		if err != nil || len(hash) != credentialSize {
+			return nil, fmt.Errorf("%w: key hash %q", ErrInvalidScript, s.KeyHash)
+		}
+		return []any{uint64(0), hash}, nil
+	case ScriptAll, ScriptAny:
+		items, err := children()
+		if err != nil {
+			return nil, err
+		}
+		tag := uint64(1)
+		if s.Type == ScriptAny {
+			tag = 2
+		}
+		return []any{tag, items}, nil
+	case ScriptAtLeast:
+		if s.Required < 1 || s.Required > len(s.Scripts) {
+			return nil, fmt.Errorf("%w: %d of %d", ErrInvalidScript, s.Required, len(s.Scripts))
+		}
+		items, err := children()
+		if err != nil {
+			return nil, err
+		}
+		return []any{uint64(3), uint64(s.Required), items}, nil
+	}
+	return nil, fmt.Errorf("%w: type %q", ErrInvalidScript, s.Type)
+}
+
+// CBOR is the script as it goes in a witness set.
+func (s NativeScript) CBOR() ([]byte, error) {
+	v, err := s.cbor()
+	if err != nil {
+		return nil, err
+	}
+	return cborEncode(v)
+}
+
+// Hash is the blake2b-224 script hash used as the payment credential.
+func (s NativeScript) Hash() ([]byte, error) {
+	data, err := s.CBOR()
+	if err != nil {
+		return nil, err
+	}
+	h, _ := blake2b.New(credentialSize, nil)
+	h.Write([]byte{0x00}) // native script language tag
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// Address is the script's payment address, staked with the given key so the
+// funds keep earning with the farm wallet's delegation.
+func (s NativeScript) Address(stake ed25519.PublicKey) (string, error) {
+	hash, err := s.Hash()
+	if err != nil {
+		return "", err
+	}
+	header := byte(addressBaseScriptKey)<<4 | network.Current.NetworkID
+	data := append([]byte{header}, hash...)
+	return Bech32Encode(addressPrefix(addressBaseScriptKey, network.Current.NetworkID), append(data, KeyHash(stake)...))
+}
+
+// Satisfied reports whether witnesses from these key hashes (hex) are
+// enough to spend from the script.
+func (s NativeScript) Satisfied(signed map[string]bool) bool {
+	switch s.Type {
+	case ScriptSig:
+		return signed[s.KeyHash]
+	case ScriptAll:
+		for _, child := range s.Scripts {
+			if !child.Satisfied(signed) {
+				return false
+			}
+		}
+		return true
+	case ScriptAny:
+		for _, child := range s.Scripts {
+			if child.Satisfied(signed) {
+				return true
+			}
+		}
+		return false
+	case ScriptAtLeast:
+		n := 0
+		for _, child := range s.Scripts {
+			if child.Satisfied(signed) {
+				n++
+			}
+		}
+		return n >= s.Required
+	}
+	return false
+}
+
+// KeyHashes lists every key hash the script mentions.
+func (s NativeScript) KeyHashes() []string {
+	if s.Type == ScriptSig {
+		return []string{s.KeyHash}
+	}
+	var hashes []string
+	for _, child := range s.Scripts {
+		hashes = append(hashes, child.KeyHashes()...)
+	}
+	return hashes
+}
+
+// Witnesses is the most vkey witnesses a spend can need, for fee estimates.
+func (s NativeScript) Witnesses() int {
+	return len(s.KeyHashes())
+}
+
+// JSON renders the script as a cardano-cli script file.
+func (s NativeScript) JSON() string {
+	data, _ := json.MarshalIndent(s, "", "    ")
+	return string(data)
+}
+
+// PaymentKeyHash is the payment credential of a key address, which is the
+// hash of the key a wallet signs with for it.
+func PaymentKeyHash(address string) ([]byte, error) {
+	addr, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if addr.Payment == nil || addr.PaymentScript {
+		return nil, fmt.Errorf("%w: %s has no payment key", ErrNotPaymentAddress, address)
+	}
+	return addr.Payment, nil
+}
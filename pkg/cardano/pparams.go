@@ -0,0 +1,89 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/logger"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// ProtocolParams holds the parameters the native builder needs. The JSON tags
+// match `cardano-cli query protocol-parameters`, so its output loads directly.
+type ProtocolParams struct {
+	TxFeePerByte        uint64 `json:"txFeePerByte"`
+	TxFeeFixed          uint64 `json:"txFeeFixed"`
+	UTxOCostPerByte     uint64 `json:"utxoCostPerByte"`
+	MaxTxSize           uint64 `json:"maxTxSize"`
+	MaxValueSize        uint64 `json:"maxValueSize"`
+	StakeAddressDeposit uint64 `json:"stakeAddressDeposit"`
+	StakePoolDeposit    uint64 `json:"stakePoolDeposit"`
+}
+
+// DefaultProtocolParams are the mainnet values as of the Conway era. They are
+// the same on preprod and preview.
+var DefaultProtocolParams = ProtocolParams{
+	TxFeePerByte:        44,
+	TxFeeFixed:          155381,
+	UTxOCostPerByte:     4310,
+	MaxTxSize:           16384,
+	MaxValueSize:        5000,
+	StakeAddressDeposit: 2_000_000,
+	StakePoolDeposit:    500_000_000,
+}
+
+// Params is read from CARDANO_VALLEY_PPARAMS when it is set, and the bot
+// exits if that file cannot be loaded.
+var Params = loadParams()
+
+func loadParams() ProtocolParams {
+	path, ok := os.LookupEnv("CARDANO_VALLEY_PPARAMS")
+	if !ok || path == "" {
+		return DefaultProtocolParams
+	}
+
+	// fees built from the wrong params are rejected on chain, so a file that
+	// was asked for but cannot be read is not replaced by the defaults
+	params, err := LoadProtocolParams(path)
+	if err != nil {
+		log.Fatalf("Invalid CARDANO_VALLEY_PPARAMS: %v", err)
+	}
+	logger.Record.Info("CARDANO", "PROTOCOL PARAMS", path)
+	return params
+}
+
+// LoadProtocolParams reads a protocol parameters file. Missing fields keep
+// their default value.
+func LoadProtocolParams(path string) (ProtocolParams, error) {
+	params := DefaultProtocolParams
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return params, err
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return params, fmt.Errorf("protocol params %s: %w", path, err)
+	}
+	return params, nil
+}
+
+// MinFee is the linear fee for a transaction of the given size in bytes.
+func (p ProtocolParams) MinFee(size int) uint64 {
+	return p.TxFeeFixed + p.TxFeePerByte*uint64(size)
+}
+
+// MinUTxO is the smallest lovelace amount the output may carry (Babbage
+// rule: 160 bytes of overhead plus the serialised output).
+func (p ProtocolParams) MinUTxO(out TxOut) (uint64, error) {
+	// size it with the largest lovelace encoding so the result holds once
+	// the amount is raised to it
+	out.Lovelace = 1 << 62
+	encoded, err := out.cbor()
+	if err != nil {
+		return 0, err
+	}
+	data, err := cborEncode(encoded)
+	if err != nil {
+		return 0, err
+	}
+	return p.UTxOCostPerByte * uint64(160+len(data)), nil
+}
@@ -0,0 +1,47 @@
+package cardano
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+)
+
+const shredPasses = 3
+
+// ShredFile overwrites the file with random data before removing it so key
+// material does not linger on disk after deletion.
+func ShredFile(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("shred open: %w", err)
+	}
+
+	for pass := 0; pass < shredPasses; pass++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return fmt.Errorf("shred seek: %w", err)
+		}
+		if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+			f.Close()
+			return fmt.Errorf("shred write: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return fmt.Errorf("shred sync: %w", err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("shred close: %w", err)
+	}
+
+	return os.Remove(path)
+}
@@ -2,13 +2,13 @@ package cardano
 
 import (
 	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
-	"sort"
 	"strings"
 )
 
@@ -93,51 +93,6 @@ import (
 // 	return utxoBytes, nil
 // }
 
-type (
-    UTxOValue map[string]map[string]uint64
-
-    UTxOEntry struct {
-		Address           string     `json:"address"`
-		Datum             any        `json:"datum"`
-		DatumHash         any        `json:"datumhash"`
-		InlineDatum       any        `json:"inlineDatum"`
-		InlineDatumRaw    any        `json:"inlineDatumRaw"`
-		ReferenceScript   any        `json:"referenceScript"`
-		Value             UTxOValue  `json:"value"`
-	}
-
- 	UTxOMap map[string]UTxOEntry
-
-	TxOutMap map[string]struct{
-		Asset Asset
-		Amount uint64
-	}
-)
-
-func QueryUTxOJson(addr string) (UTxOMap, error) {
-	tmp := "utxos.json"
-	args := CommandArgs{
-		"query", "utxo",
-		"--address", addr,
-		"--out-file", tmp,
-		"--output-json",
-		NETWORK,
-	}
-	args = append(args, strings.Split(NETWORK, " ")...)
-	output, err := Run(args)
-	if err != nil {
-		logger.Record.Error("CARDANO", "Failed to query UTxO: ", err)
-		return nil, err
-	}
-
-	var utxos UTxOMap
-	if err := json.Unmarshal(output, &utxos); err != nil {
-		logger.Record.Error("CARDANO", "Failed to unmarshal UTxO JSON: ", err)
-		return nil, err
-	}
-	return utxos, nil
-}
-
 func BuildRawTransaction(txIns []string, txOut string, changeAddr string, outFile string) error {
 	args := []string{
 		"conway",
@@ -150,7 +105,7 @@ func BuildRawTransaction(txIns []string, txOut string, changeAddr string, outFil
 		args = append(args, "--tx-in", txIn)
 	}
 	args = append(args, "--tx-out", txOut)
-	args = append(args, strings.Split(NETWORK, " ")...)
+	args = append(args, network.Current.CLIArgs()...)
 	output, err := Run(args)
 	if err != nil {
 		logger.Record.Error("CARDANO", "Failed to build transaction: ", err)
@@ -167,7 +122,7 @@ func SignTransaction(rawFile, skeyFile, outFile string) error {
 		"--signing-key-file", skeyFile,
 		"--out-file", outFile,
 	}
-	args = append(args, strings.Split(NETWORK, " ")...)
+	args = append(args, network.Current.CLIArgs()...)
 	output, err := Run(args)
 	if err != nil {
 		logger.Record.Error("CARDANO", "Failed to sign transaction: ", err)
@@ -182,7 +137,7 @@ func SubmitTransaction(signedFile string) error {
 		"conway", "transaction", "submit",
 		"--tx-file", signedFile,
 	}
-	args = append(args, strings.Split(NETWORK, " ")...)
+	args = append(args, network.Current.CLIArgs()...)
 	output, err := Run(args)
 	if err != nil {
 		logger.Record.Error("CARDANO", "Failed to submit transaction: ", err)
@@ -206,24 +161,19 @@ func FormatAssetString(value UTxOValue) string {
 	return strings.TrimPrefix(sb.String(), " + ")
 }
 
-func SelectUTxOsForMinADA(utxos UTxOMap, minADA uint64) ([]string, uint64) {
-	selected := []string{}
-	total := uint64(0)
-	keys := make([]string, 0, len(utxos))
-	for k := range utxos {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	for _, k := range keys {
-		val := utxos[k].Value
-		lovelace := val["lovelace"][""]
-		total += lovelace
-		selected = append(selected, k)
-		if total >= minADA {
-			break
-		}
+func refs(inputs []TxInput) []string {
+	refs := make([]string, len(inputs))
+	for n, in := range inputs {
+		refs[n] = in.Ref()
 	}
-	return selected, total
+	return refs
+}
+
+// SelectUTxOsForMinADA picks the largest UTxOs until minADA lovelace is
+// covered and returns them with the lovelace they hold.
+func SelectUTxOsForMinADA(utxos UTxOMap, minADA uint64) ([]string, uint64) {
+	selected, _ := largestFirst(utxos.Inputs(), TxOut{Lovelace: minADA})
+	return refs(selected), quantity(selected, "")
 }
 
 func ExampleSendTx(fromAddr, toAddress, changeAddress, skeyPath string, minADA uint64) error {
@@ -265,39 +215,37 @@ func ExampleSendTx(fromAddr, toAddress, changeAddress, skeyPath string, minADA u
 	return nil
 }
 
+// SelectUTxOsWithAssets picks UTxOs holding the required tokens, plus enough
+// lovelace to send them on in one output and pay the fee and any change.
 func SelectUTxOsWithAssets(utxos UTxOMap, requiredAssets map[string]map[string]uint64) ([]string, error) {
-	selected := []string{}
-	accumulated := make(map[string]map[string]uint64)
-
-	for k, utxo := range utxos {
-		selected = append(selected, k)
-		for policy, assets := range utxo.Value {
-			if policy == "lovelace" {
-				continue
-			}
-			if _, ok := accumulated[policy]; !ok {
-				accumulated[policy] = make(map[string]uint64)
-			}
-			for name, qty := range assets {
-				accumulated[policy][name] += qty
-			}
-		}
-		if containsAllAssets(accumulated, requiredAssets) {
-			return selected, nil
-		}
+	inputs := utxos.Inputs()
+	if len(inputs) == 0 {
+		return nil, errors.New("no UTxOs to select from")
 	}
-	return nil, errors.New("could not find enough tokens to satisfy requirements")
-}
 
-func containsAllAssets(acc, required map[string]map[string]uint64) bool {
-	for policy, assets := range required {
-		for name, reqQty := range assets {
-			if acc[policy][name] < reqQty {
-				return false
-			}
+	out := TxOut{Address: inputs[0].Output.Address}
+	for policyID, assets := range requiredAssets {
+		for name, qty := range assets {
+			out.Assets = addAssets(out.Assets, map[string]uint64{policyID + name: qty})
 		}
 	}
-	return true
+	min, err := Params.MinUTxO(out)
+	if err != nil {
+		return nil, err
+	}
+	out.Lovelace = min
+
+	sel, err := SelectCoins(SelectionRequest{
+		Available:     inputs,
+		Outputs:       []TxOut{out},
+		ChangeAddress: out.Address,
+		Strategy:      LargestFirst,
+		FeeAllowance:  Params.MinFee(1000),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs(sel.Inputs), nil
 }
 
 func ExampleSendTxWithAssets(fromAddr, toAddress, changeAddress, skeyPath string, minADA uint64) error {
@@ -355,12 +303,12 @@ func BuildTxAdaOnly(changeAddr string, holders []Holder, adaPerNFT uint64) error
 	}
 
 	// 1. Query UTXOs in JSON format
-	utxoCmd := exec.Command("cardano-cli", "query", "utxo",
+	utxoArgs := append([]string{"query", "utxo",
 		"--address", changeAddr,
-		"--mainnet",
 		"--out-file", "/dev/stdout",
 		"--output-json",
-	)
+	}, network.Current.CLIArgs()...)
+	utxoCmd := exec.Command("cardano-cli", utxoArgs...)
 
 	utxoOutput, err := utxoCmd.Output()
 	if err != nil {
@@ -393,10 +341,10 @@ func BuildTxAdaOnly(changeAddr string, holders []Holder, adaPerNFT uint64) error
 	// 4. Build full CLI command
 	args := append([]string{
 		"conway", "transaction", "build",
-		"--mainnet",
 		"--change-address", changeAddr,
 		"--out-file", "airdrop-tx.raw",
-	}, append(txIns, txOuts...)...)
+	}, network.Current.CLIArgs()...)
+	args = append(args, append(txIns, txOuts...)...)
 
 	slog.Default().Info("Executing cardano-cli command", "args", args)
 
@@ -406,68 +354,3 @@ func BuildTxAdaOnly(changeAddr string, holders []Holder, adaPerNFT uint64) error
 
 	return cmd.Run()
 }
-
-
-func BuildTxFromBalance(to string, assets TxOutMap) error {
-	txOuts := []string{}
-	for asset, amount := range assets {
-		slog.Default().Info("Calculating transaction output",
-			"asset", asset,
-			"amount", amount,
-		)
-		//--tx-out addr_test1vp6jz+"1000 11375f8ee31c280e1f2ec6fe11a73bca79d7a6a64f18e1e6980f0c74.637573746f6d636f696e"
-		txOuts = append(txOuts, "--tx-out", fmt.Sprintf("%s+\"%d %s\"", to, amount, asset))
-	}
-
-	// 1. Query UTXOs in JSON format
-	utxoCmd := exec.Command("cardano-cli", "query", "utxo",
-		"--address", to,
-		"--mainnet",
-		"--out-file", "/dev/stdout",
-		"--output-json",
-	)
-
-	utxoOutput, err := utxoCmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to query UTXOs: %w", err)
-	}
-
-	// 2. Parse JSON into map
-	var utxos UTxOMap
-	if err := json.Unmarshal(utxoOutput, &utxos); err != nil {
-		return fmt.Errorf("failed to parse UTXO JSON: %w", err)
-	}
-
-	// 3. Construct --tx-in arguments
-	txIns := []string{}
-	for utxo := range utxos {
-		// key is like "txhash#txix"
-		txIns = append(txIns, "--tx-in", utxo)
-	}
-	if len(txIns) == 0 {
-		return fmt.Errorf("no UTXOs found at address %s", to)
-	}
-
-	// slog.Default().Info("Building transaction",
-	// 	"wallet_address", to,
-	// 	"holders_count", len(holders),
-	// 	"ada_per_nft", adaPerNFT,
-	// 	"txOuts", txOuts,
-	// )
-
-	// 4. Build full CLI command
-	args := append([]string{
-		"conway", "transaction", "build",
-		"--mainnet",
-		"--change-address", to,
-		"--out-file", "airdrop-tx.raw",
-	}, append(txIns, txOuts...)...)
-
-	slog.Default().Info("Executing cardano-cli command", "args", args)
-
-	cmd := exec.Command("cardano-cli", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
-}
\ No newline at end of file
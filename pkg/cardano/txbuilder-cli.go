@@ -0,0 +1,225 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/network"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CLIBuilder is the cardano-cli fallback. `transaction build` balances the tx
+// against a local node, so CARDANO_NODE_SOCKET_PATH must be set. Every input
+// in the request is spent.
+type CLIBuilder struct{}
+
+func (b *CLIBuilder) Name() string { return "cardano-cli" }
+
+func (b *CLIBuilder) Build(req TxRequest) (*Tx, error) {
+	if len(req.Inputs) == 0 {
+		return nil, fmt.Errorf("tx build: %w: no inputs", ErrInsufficientFunds)
+	}
+
+	dir, err := os.MkdirTemp("", "cardano-valley-tx-*")
+	if err != nil {
+		return nil, err
+	}
+	body := filepath.Join(dir, "tx.raw")
+
+	args := []string{"conway", "transaction", "build",
+		"--change-address", req.ChangeAddress,
+		"--out-file", body,
+	}
+	args = append(args, cliNodeArgs()...)
+	scriptFiles, err := writeScriptFiles(dir, req.Scripts)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	for _, in := range req.Inputs {
+		args = append(args, "--tx-in", in.Ref())
+		if file, ok := scriptFiles[scriptHashOf(in.Output.Address)]; ok {
+			args = append(args, "--tx-in-script-file", file)
+		}
+	}
+	for _, o := range req.Outputs {
+		args = append(args, "--tx-out", o.String())
+	}
+	if req.TTL > 0 {
+		args = append(args, "--invalid-hereafter", strconv.FormatUint(req.TTL, 10))
+	}
+	if req.Signers > 1 {
+		args = append(args, "--witness-override", strconv.Itoa(req.Signers))
+	}
+	if len(req.Metadata) > 0 {
+		file := filepath.Join(dir, "metadata.json")
+		data, err := json.Marshal(req.Metadata)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		if err := os.WriteFile(file, data, 0600); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		args = append(args, "--metadata-json-file", file)
+	}
+	for n, cert := range req.Certificates {
+		file := filepath.Join(dir, fmt.Sprintf("cert%d.cert", n))
+		if err := os.WriteFile(file, []byte(cert.Envelope()), 0600); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		args = append(args, "--certificate-file", file)
+	}
+
+	out, err := CLI.Exec("", args...)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("tx build: %w (%s)", err, out)
+	}
+	fee := parseLovelace(out)
+
+	id, err := cliTxID("--tx-body-file", body)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cbor, err := ReadTxFile(body)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	tx := &Tx{ID: id, CBOR: cbor, Fee: fee, Inputs: req.Inputs, Outputs: req.Outputs, TTL: req.TTL, dir: dir}
+
+	// cardano-cli puts whatever is left into the change output
+	var in, spent TxOut
+	for _, i := range req.Inputs {
+		in.Lovelace += i.Output.Lovelace
+		in.Assets = addAssets(in.Assets, i.Output.Assets)
+	}
+	for _, o := range req.Outputs {
+		spent.Lovelace += o.Lovelace
+		spent.Assets = addAssets(spent.Assets, o.Assets)
+	}
+	spent.Lovelace += deposits(req.Certificates)
+	if in.Lovelace > spent.Lovelace+fee {
+		tx.Outputs = append(append([]TxOut(nil), req.Outputs...), TxOut{
+			Address:  req.ChangeAddress,
+			Lovelace: in.Lovelace - spent.Lovelace - fee,
+			Assets:   subAssets(in.Assets, spent.Assets),
+		})
+	}
+	return tx, nil
+}
+
+// Sign hands the first key to cardano-cli over stdin. Any further keys are
+// written next to the tx body and shredded once the tx is signed.
+func (b *CLIBuilder) Sign(tx *Tx, skeys ...string) error {
+	if tx.dir == "" {
+		return fmt.Errorf("tx sign: %s was not built by cardano-cli", tx.ID)
+	}
+	defer os.RemoveAll(tx.dir)
+
+	body := filepath.Join(tx.dir, "tx.raw")
+	signed := filepath.Join(tx.dir, "tx.signed")
+	args := append([]string{"conway", "transaction", "sign",
+		"--tx-body-file", body,
+		"--out-file", signed,
+	}, network.Current.CLIArgs()...)
+
+	var stdin string
+	for n, skey := range skeys {
+		if n == 0 {
+			stdin = skey
+			args = append(args, "--signing-key-file", "/dev/stdin")
+			continue
+		}
+		file := filepath.Join(tx.dir, fmt.Sprintf("key%d.skey", n))
+		if err := os.WriteFile(file, []byte(skey), 0600); err != nil {
+			return err
+		}
+		defer ShredFile(file)
+		args = append(args, "--signing-key-file", file)
+	}
+
+	if out, err := CLI.Exec(stdin, args...); err != nil {
+		return fmt.Errorf("tx sign: %w (%s)", err, out)
+	}
+
+	cbor, err := ReadTxFile(signed)
+	if err != nil {
+		return err
+	}
+	tx.CBOR = cbor
+	tx.dir = ""
+	return nil
+}
+
+// writeScriptFiles writes each script as a cardano-cli script file, keyed by
+// its hash.
+func writeScriptFiles(dir string, scripts []NativeScript) (map[string]string, error) {
+	files := make(map[string]string, len(scripts))
+	for n, script := range scripts {
+		hash, err := script.Hash()
+		if err != nil {
+			return nil, err
+		}
+		file := filepath.Join(dir, fmt.Sprintf("script%d.json", n))
+		if err := os.WriteFile(file, []byte(script.JSON()), 0600); err != nil {
+			return nil, err
+		}
+		files[hex.EncodeToString(hash)] = file
+	}
+	return files, nil
+}
+
+// scriptHashOf is the payment script hash of an address, "" for key addresses.
+func scriptHashOf(address string) string {
+	addr, err := ParseAddress(address)
+	if err != nil || !addr.PaymentScript {
+		return ""
+	}
+	return hex.EncodeToString(addr.Payment)
+}
+
+func cliNodeArgs() []string {
+	args := network.Current.CLIArgs()
+	if socket := os.Getenv("CARDANO_NODE_SOCKET_PATH"); socket != "" {
+		args = append(args, "--socket-path", socket)
+	}
+	return args
+}
+
+// cliTxID handles both the plain and the JSON output of `transaction txid`.
+func cliTxID(flag, file string) (string, error) {
+	out, err := CLI.Exec("", "conway", "transaction", "txid", flag, file)
+	if err != nil {
+		return "", fmt.Errorf("txid: %w (%s)", err, out)
+	}
+	out = strings.TrimSpace(out)
+	if strings.HasPrefix(out, "{") {
+		var id struct {
+			TxHash string `json:"txhash"`
+		}
+		if err := json.Unmarshal([]byte(out), &id); err != nil {
+			return "", fmt.Errorf("txid: %w", err)
+		}
+		return id.TxHash, nil
+	}
+	return out, nil
+}
+
+// parseLovelace finds the amount in "Estimated transaction fee: 171793 Lovelace".
+func parseLovelace(out string) uint64 {
+	for _, field := range strings.Fields(out) {
+		if n, err := strconv.ParseUint(field, 10, 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,357 @@
+package cardano
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// NativeBuilder builds, balances and signs Conway era transactions without
+// cardano-cli or a node. Fees and min-UTxO come from Params.
+type NativeBuilder struct {
+	Params   *ProtocolParams   // nil uses the package Params
+	Strategy SelectionStrategy // empty uses CoinSelection
+}
+
+func (b *NativeBuilder) Name() string { return "native" }
+
+func (b *NativeBuilder) params() ProtocolParams {
+	if b.Params != nil {
+		return *b.Params
+	}
+	return Params
+}
+
+func (b *NativeBuilder) Build(req TxRequest) (*Tx, error) {
+	if req.ChangeAddress == "" {
+		return nil, errors.New("tx build: change address is required")
+	}
+	if len(req.Inputs) == 0 {
+		return nil, fmt.Errorf("tx build: %w: no inputs", ErrInsufficientFunds)
+	}
+	params := b.params()
+
+	for _, o := range req.Outputs {
+		min, err := params.MinUTxO(o)
+		if err != nil {
+			return nil, err
+		}
+		if o.Lovelace < min {
+			return nil, fmt.Errorf("%w: %d lovelace to %s, minimum is %d", ErrOutputTooSmall, o.Lovelace, o.Address, min)
+		}
+	}
+
+	if req.SpendAll {
+		return b.balance(req, req.Inputs, params)
+	}
+
+	// Select for the outputs plus a rough fee. If the real fee turns out
+	// larger, select again with a bigger allowance.
+	allowance := params.MinFee(1000)
+	for attempt := 0; ; attempt++ {
+		sel, err := SelectCoins(SelectionRequest{
+			Available:     req.Inputs,
+			Outputs:       req.Outputs,
+			ChangeAddress: req.ChangeAddress,
+			Strategy:      b.Strategy,
+			FeeAllowance:  allowance + deposits(req.Certificates),
+			Params:        &params,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("tx build: %w", err)
+		}
+		tx, err := b.balance(req, sel.Inputs, params)
+		if !errors.Is(err, ErrInsufficientFunds) || attempt >= 4 {
+			return tx, err
+		}
+		allowance *= 2
+	}
+}
+
+// balance adds change and the fee for exactly these inputs.
+func (b *NativeBuilder) balance(req TxRequest, inputs []TxInput, params ProtocolParams) (*Tx, error) {
+	var in, out TxOut
+	for _, i := range inputs {
+		in.Lovelace += i.Output.Lovelace
+		in.Assets = addAssets(in.Assets, i.Output.Assets)
+	}
+	for _, o := range req.Outputs {
+		out.Lovelace += o.Lovelace
+		out.Assets = addAssets(out.Assets, o.Assets)
+	}
+	// deposits leave the tx like an output
+	out.Lovelace += deposits(req.Certificates)
+	if !covers(in.Assets, out.Assets) || in.Lovelace < out.Lovelace {
+		return nil, fmt.Errorf("tx build: %w: inputs hold %d lovelace, outputs and deposits need %d", ErrInsufficientFunds, in.Lovelace, out.Lovelace)
+	}
+
+	signers := req.Signers
+	if signers < 1 {
+		signers = 1
+	}
+
+	var aux any
+	if len(req.Metadata) > 0 {
+		md, err := req.Metadata.cbor()
+		if err != nil {
+			return nil, err
+		}
+		aux = md
+	}
+
+	changeAssets := subAssets(in.Assets, out.Assets)
+
+	var fee uint64
+	for round := 0; ; round++ {
+		if in.Lovelace < out.Lovelace+fee {
+			return nil, fmt.Errorf("tx build: %w: %d lovelace short of the fee", ErrInsufficientFunds, out.Lovelace+fee-in.Lovelace)
+		}
+		change, err := ChangeOutputs(req.ChangeAddress, in.Lovelace-out.Lovelace-fee, changeAssets, params)
+		if err != nil {
+			return nil, fmt.Errorf("tx build: %w", err)
+		}
+		outputs := append(append([]TxOut(nil), req.Outputs...), change...)
+		if len(outputs) == 0 {
+			return nil, fmt.Errorf("tx build: %w: nothing left after the fee", ErrInsufficientFunds)
+		}
+
+		// ada-only change below the minimum is left to the fee
+		txFee := fee
+		if len(change) == 0 {
+			txFee = in.Lovelace - out.Lovelace
+		}
+
+		body, err := txBody(inputs, outputs, txFee, req.TTL, req.Certificates, aux)
+		if err != nil {
+			return nil, err
+		}
+		witnesses, err := witnessSet(dummyVKeys(signers), req.Scripts)
+		if err != nil {
+			return nil, err
+		}
+		data, err := cborEncode([]any{body, witnesses, true, aux})
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(data)) > params.MaxTxSize {
+			return nil, fmt.Errorf("%w: %d bytes", ErrTxTooLarge, len(data))
+		}
+
+		minFee := params.MinFee(len(data))
+		if minFee <= txFee || round >= 10 {
+			if minFee > txFee {
+				return nil, fmt.Errorf("tx build: fee did not settle at %d lovelace", txFee)
+			}
+			raw, err := cborEncode(body)
+			if err != nil {
+				return nil, err
+			}
+			witnesses, err := witnessSet(nil, req.Scripts)
+			if err != nil {
+				return nil, err
+			}
+			data, err := cborEncode([]any{cborRaw(raw), witnesses, true, aux})
+			if err != nil {
+				return nil, err
+			}
+			return &Tx{ID: TxID(raw), CBOR: data, Fee: txFee, Inputs: inputs, Outputs: outputs, TTL: req.TTL}, nil
+		}
+		fee = minFee
+	}
+}
+
+func txBody(inputs []TxInput, outputs []TxOut, fee, ttl uint64, certs []Certificate, aux any) (cborMap, error) {
+	ins := make([]TxInput, len(inputs))
+	copy(ins, inputs)
+	sort.Slice(ins, func(i, j int) bool {
+		if ins[i].TxHash != ins[j].TxHash {
+			return ins[i].TxHash < ins[j].TxHash
+		}
+		return ins[i].Index < ins[j].Index
+	})
+
+	encodedIns := make([]any, len(ins))
+	for n, in := range ins {
+		hash, err := hex.DecodeString(in.TxHash)
+		if err != nil || len(hash) != 32 {
+			return nil, fmt.Errorf("tx build: invalid input %s", in.Ref())
+		}
+		encodedIns[n] = []any{hash, uint64(in.Index)}
+	}
+
+	encodedOuts := make([]any, len(outputs))
+	for n, o := range outputs {
+		encoded, err := o.cbor()
+		if err != nil {
+			return nil, err
+		}
+		encodedOuts[n] = encoded
+	}
+
+	body := cborMap{
+		{uint64(0), cborTag{cborSetTag, encodedIns}},
+		{uint64(1), encodedOuts},
+		{uint64(2), fee},
+	}
+	if ttl > 0 {
+		body = append(body, cborPair{uint64(3), ttl})
+	}
+	if len(certs) > 0 {
+		encoded, err := certificatesCBOR(certs)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, cborPair{uint64(4), encoded})
+	}
+	if aux != nil {
+		data, err := cborEncode(aux)
+		if err != nil {
+			return nil, err
+		}
+		hash := blake2b.Sum256(data)
+		body = append(body, cborPair{uint64(7), hash[:]})
+	}
+	return body, nil
+}
+
+// cbor encodes the output in the post-Alonzo map format.
+func (o TxOut) cbor() (cborMap, error) {
+	addr, err := ParseAddress(o.Address)
+	if err != nil {
+		return nil, fmt.Errorf("output %s: %w", o.Address, err)
+	}
+	if !addr.IsPayment() {
+		return nil, fmt.Errorf("output %s: %w", o.Address, ErrNotPaymentAddress)
+	}
+	_, raw, err := Bech32Decode(o.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any = o.Lovelace
+	if len(o.Assets) > 0 {
+		policies := map[string]cborMap{}
+		for unit, qty := range o.Assets {
+			policyID, name := unit, ""
+			if len(unit) > 56 {
+				policyID, name = unit[:56], unit[56:]
+			}
+			nameBytes, err := hex.DecodeString(name)
+			if err != nil {
+				return nil, fmt.Errorf("output %s: invalid asset %s", o.Address, unit)
+			}
+			policies[policyID] = append(policies[policyID], cborPair{nameBytes, qty})
+		}
+
+		multiasset := make(cborMap, 0, len(policies))
+		for policyID, names := range policies {
+			policy, err := hex.DecodeString(policyID)
+			if err != nil || len(policy) != credentialSize {
+				return nil, fmt.Errorf("output %s: invalid policy %s", o.Address, policyID)
+			}
+			sorted, err := cborSortMap(names)
+			if err != nil {
+				return nil, err
+			}
+			multiasset = append(multiasset, cborPair{policy, sorted})
+		}
+		sorted, err := cborSortMap(multiasset)
+		if err != nil {
+			return nil, err
+		}
+		value = []any{o.Lovelace, sorted}
+	}
+
+	return cborMap{{uint64(0), raw}, {uint64(1), value}}, nil
+}
+
+// dummyVKeys stand in for the real vkey witnesses when sizing the fee.
+func dummyVKeys(n int) []any {
+	witnesses := make([]any, n)
+	for i := range witnesses {
+		witnesses[i] = []any{make([]byte, 32), make([]byte, 64)}
+	}
+	return witnesses
+}
+
+// witnessSet holds the vkey witnesses and the native scripts that lock the
+// inputs, which travel with the tx.
+func witnessSet(vkeys []any, scripts []NativeScript) (cborMap, error) {
+	set := cborMap{}
+	if len(vkeys) > 0 {
+		set = append(set, cborPair{uint64(0), cborTag{cborSetTag, vkeys}})
+	}
+	if len(scripts) > 0 {
+		encoded := make([]any, len(scripts))
+		for n, script := range scripts {
+			data, err := script.CBOR()
+			if err != nil {
+				return nil, err
+			}
+			encoded[n] = cborRaw(data)
+		}
+		set = append(set, cborPair{uint64(1), cborTag{cborSetTag, encoded}})
+	}
+	return set, nil
+}
+
+// Sign adds vkey witnesses, keeping any already on the tx so several parties
+// can sign in turn.
+func (b *NativeBuilder) Sign(tx *Tx, skeys ...string) error {
+	return signNative(tx, skeys...)
+}
+
+func signNative(tx *Tx, skeys ...string) error {
+	id, err := hex.DecodeString(tx.ID)
+	if err != nil {
+		return fmt.Errorf("tx sign: invalid tx id %q", tx.ID)
+	}
+
+	witnesses := make([]VKeyWitness, 0, len(skeys))
+	for _, envelope := range skeys {
+		key, err := ParseSigningKey(envelope)
+		if err != nil {
+			return fmt.Errorf("tx sign: %w", err)
+		}
+		witnesses = append(witnesses, VKeyWitness{VKey: key.Public(), Signature: key.Sign(id)})
+	}
+	return addVKeyWitnesses(tx, witnesses)
+}
+
+// ─── Values ───
+
+func addAssets(dst, src map[string]uint64) map[string]uint64 {
+	for unit, qty := range src {
+		if dst == nil {
+			dst = make(map[string]uint64)
+		}
+		dst[unit] += qty
+	}
+	return dst
+}
+
+// subAssets returns have minus spent, dropping units that reach zero.
+func subAssets(have, spent map[string]uint64) map[string]uint64 {
+	var left map[string]uint64
+	for unit, qty := range have {
+		if qty > spent[unit] {
+			if left == nil {
+				left = make(map[string]uint64)
+			}
+			left[unit] = qty - spent[unit]
+		}
+	}
+	return left
+}
+
+func covers(have, need map[string]uint64) bool {
+	for unit, qty := range need {
+		if have[unit] < qty {
+			return false
+		}
+	}
+	return true
+}
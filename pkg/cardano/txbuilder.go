@@ -0,0 +1,409 @@
+package cardano
+
+import (
+	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+type (
+	// TxBuilder turns a TxRequest into a balanced transaction and signs it.
+	// NativeBuilder does this in process; CLIBuilder shells out to cardano-cli
+	// and needs a node socket.
+	TxBuilder interface {
+		Name() string
+		Build(req TxRequest) (*Tx, error)
+		// Sign adds a witness for each signing key envelope (cardano-cli JSON).
+		Sign(tx *Tx, skeys ...string) error
+	}
+
+	TxInput struct {
+		TxHash string
+		Index  int
+		Output TxOut
+	}
+
+	TxRequest struct {
+		Inputs        []TxInput // UTxOs the builder may spend
+		Outputs       []TxOut
+		ChangeAddress string
+		SpendAll      bool // spend every input, e.g. to drain a wallet into ChangeAddress
+		Metadata      Metadata
+		TTL           uint64 // last valid slot, 0 for none
+		Signers       int    // witnesses to budget the fee for, defaults to 1
+		Certificates  []Certificate // deposits are paid from the inputs
+		Scripts       []NativeScript // native scripts locking script inputs
+	}
+
+	// Tx is a built transaction. CBOR is the full transaction and gains
+	// witnesses as it is signed.
+	Tx struct {
+		ID      string
+		CBOR    []byte
+		Fee     uint64
+		Inputs  []TxInput
+		Outputs []TxOut // requested outputs followed by change, if any
+		TTL     uint64  // last valid slot, 0 for none
+
+		dir string // CLIBuilder working files
+	}
+
+	// Metadata is transaction metadata keyed by label, e.g. 674 for CIP-20 messages.
+	Metadata map[uint64]any
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrOutputTooSmall    = errors.New("output is below the minimum UTxO value")
+	ErrTxTooLarge        = errors.New("transaction exceeds the maximum size")
+)
+
+// Builder is picked by CARDANO_VALLEY_TX_BUILDER ("native" or "cli") and
+// defaults to the native builder.
+var Builder TxBuilder = newBuilder()
+
+func newBuilder() TxBuilder {
+	switch name := os.Getenv("CARDANO_VALLEY_TX_BUILDER"); name {
+	case "", "native":
+		return &NativeBuilder{}
+	case "cli":
+		return &CLIBuilder{}
+	default:
+		logger.Record.Error("CARDANO", "UNKNOWN TX BUILDER", name, "USING", "native")
+		return &NativeBuilder{}
+	}
+}
+
+func (in TxInput) Ref() string {
+	return in.TxHash + "#" + strconv.Itoa(in.Index)
+}
+
+// String renders the output in cardano-cli --tx-out syntax.
+func (o TxOut) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s+%d", o.Address, o.Lovelace)
+	units := make([]string, 0, len(o.Assets))
+	for unit := range o.Assets {
+		units = append(units, unit)
+	}
+	sort.Strings(units)
+	for _, unit := range units {
+		policyID, name := unit, ""
+		if len(unit) > 56 {
+			policyID, name = unit[:56], unit[56:]
+		}
+		fmt.Fprintf(&sb, "+%d %s.%s", o.Assets[unit], policyID, name)
+	}
+	return sb.String()
+}
+
+// Remaining returns the UTxOs left to spend after tx: the unspent inputs plus
+// the tx's outputs to address. Batches can then be chained without waiting
+// for a provider to see the first one.
+func (tx *Tx) Remaining(available []TxInput, address string) []TxInput {
+	spent := make(map[string]bool, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		spent[in.Ref()] = true
+	}
+
+	var remaining []TxInput
+	for _, in := range available {
+		if !spent[in.Ref()] {
+			remaining = append(remaining, in)
+		}
+	}
+	for n, o := range tx.Outputs {
+		if o.Address == address {
+			remaining = append(remaining, TxInput{TxHash: tx.ID, Index: n, Output: o})
+		}
+	}
+	return remaining
+}
+
+// Envelope renders the signed tx the way cardano-cli writes it.
+func (tx *Tx) Envelope() string {
+	data, _ := json.MarshalIndent(KeyEnvelope{
+		Type:    "Witnessed Tx ConwayEra",
+		CborHex: hex.EncodeToString(tx.CBOR),
+	}, "", "    ")
+	return string(data)
+}
+
+// Submit sends a signed transaction through cardano-cli and returns its ID.
+func Submit(tx *Tx) (string, error) {
+	f, err := os.CreateTemp("", "cardano-valley-*.signed")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(tx.Envelope()); err != nil {
+		f.Close()
+		return "", err
+	}
+	f.Close()
+
+	args := append([]string{"conway", "transaction", "submit", "--tx-file", f.Name()}, network.Current.CLIArgs()...)
+	if socket := os.Getenv("CARDANO_NODE_SOCKET_PATH"); socket != "" {
+		args = append(args, "--socket-path", socket)
+	}
+	if out, err := CLI.Exec("", args...); err != nil {
+		return "", fmt.Errorf("tx submit: %w (%s)", err, out)
+	}
+	logger.Record.Info("CARDANO", "SUBMITTED", tx.ID)
+	return tx.ID, nil
+}
+
+// ReadTxFile returns the CBOR of a cardano-cli tx or tx body envelope.
+func ReadTxFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var env KeyEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("tx envelope %s: %w", path, err)
+	}
+	return hex.DecodeString(env.CborHex)
+}
+
+// TxID hashes a transaction body.
+func TxID(body []byte) string {
+	sum := blake2b.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// DecodeTx reads the ID, fee, inputs and outputs back out of a transaction.
+// Input values are not part of a transaction and are left empty.
+func DecodeTx(data []byte) (*Tx, error) {
+	parts, err := cborSplitArray(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("%w: transaction has %d parts", ErrCBOR, len(parts))
+	}
+
+	decoded, err := cborDecode(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	body, ok := decoded.(cborMap)
+	if !ok {
+		return nil, fmt.Errorf("%w: transaction body is not a map", ErrCBOR)
+	}
+
+	tx := &Tx{ID: TxID(parts[0]), CBOR: data}
+	if fee, ok := body.Get(2); ok {
+		tx.Fee, _ = fee.(uint64)
+	}
+
+	ins, _ := body.Get(0)
+	inList, _ := cborUntag(ins).([]any)
+	for _, in := range inList {
+		pair, ok := in.([]any)
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("%w: malformed input", ErrCBOR)
+		}
+		hash, _ := pair[0].([]byte)
+		index, _ := pair[1].(uint64)
+		tx.Inputs = append(tx.Inputs, TxInput{TxHash: hex.EncodeToString(hash), Index: int(index)})
+	}
+
+	outs, _ := body.Get(1)
+	outList, _ := outs.([]any)
+	for _, o := range outList {
+		out, err := decodeTxOut(o)
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, out)
+	}
+	return tx, nil
+}
+
+// decodeTxOut reads both the legacy array and the post-Alonzo map formats.
+func decodeTxOut(v any) (TxOut, error) {
+	var addr, value any
+	switch o := v.(type) {
+	case []any:
+		if len(o) < 2 {
+			return TxOut{}, fmt.Errorf("%w: malformed output", ErrCBOR)
+		}
+		addr, value = o[0], o[1]
+	case cborMap:
+		addr, _ = o.Get(0)
+		value, _ = o.Get(1)
+	default:
+		return TxOut{}, fmt.Errorf("%w: malformed output", ErrCBOR)
+	}
+
+	raw, ok := addr.([]byte)
+	if !ok || len(raw) == 0 {
+		return TxOut{}, fmt.Errorf("%w: malformed output address", ErrCBOR)
+	}
+	header := raw[0]
+	bech, err := Bech32Encode(addressPrefix(AddressType(header>>4), header&0x0f), raw)
+	if err != nil {
+		return TxOut{}, err
+	}
+	out := TxOut{Address: bech}
+
+	switch val := value.(type) {
+	case uint64:
+		out.Lovelace = val
+	case []any:
+		if len(val) != 2 {
+			return TxOut{}, fmt.Errorf("%w: malformed output value", ErrCBOR)
+		}
+		out.Lovelace, _ = val[0].(uint64)
+		policies, _ := val[1].(cborMap)
+		for _, p := range policies {
+			policyID, _ := p.Key.([]byte)
+			names, _ := p.Value.(cborMap)
+			for _, n := range names {
+				name, _ := n.Key.([]byte)
+				qty, _ := n.Value.(uint64)
+				if out.Assets == nil {
+					out.Assets = make(map[string]uint64)
+				}
+				out.Assets[hex.EncodeToString(policyID)+hex.EncodeToString(name)] += qty
+			}
+		}
+	default:
+		return TxOut{}, fmt.Errorf("%w: malformed output value", ErrCBOR)
+	}
+	return out, nil
+}
+
+// ─── Metadata ───
+
+// LoadMetadataJSON reads a metadata file in cardano-cli's "no schema" JSON
+// format: top level keys are labels, strings starting with "0x" are hex byte
+// strings, and strings and byte strings are limited to 64 bytes.
+func LoadMetadataJSON(path string) (Metadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var raw map[string]any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("metadata %s: %w", path, err)
+	}
+
+	md := make(Metadata, len(raw))
+	for label, v := range raw {
+		n, err := strconv.ParseUint(label, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s: label %q is not a number", path, label)
+		}
+		md[n] = noSchemaBytes(v)
+	}
+	return md, nil
+}
+
+// noSchemaBytes turns "0x" strings into byte strings, as cardano-cli does.
+// Strings that are not valid hex after the prefix stay text.
+func noSchemaBytes(v any) any {
+	switch v := v.(type) {
+	case string:
+		if hexStr, ok := strings.CutPrefix(v, "0x"); ok {
+			if b, err := hex.DecodeString(hexStr); err == nil {
+				return b
+			}
+		}
+		return v
+	case []any:
+		for n, item := range v {
+			v[n] = noSchemaBytes(item)
+		}
+		return v
+	case map[string]any:
+		for key, item := range v {
+			v[key] = noSchemaBytes(item)
+		}
+		return v
+	}
+	return v
+}
+
+func (md Metadata) cbor() (cborMap, error) {
+	labels := make([]uint64, 0, len(md))
+	for label := range md {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i] < labels[j] })
+
+	m := make(cborMap, 0, len(md))
+	for _, label := range labels {
+		v, err := metadatum(md[label])
+		if err != nil {
+			return nil, fmt.Errorf("metadata label %d: %w", label, err)
+		}
+		m = append(m, cborPair{label, v})
+	}
+	return m, nil
+}
+
+func metadatum(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if len(v) > 64 {
+			return nil, fmt.Errorf("string %q is longer than 64 bytes", v)
+		}
+		return v, nil
+	case []byte:
+		if len(v) > 64 {
+			return nil, fmt.Errorf("byte string is longer than 64 bytes")
+		}
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int64, uint64:
+		return v, nil
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("metadata numbers must be integers: %s", v)
+		}
+		return n, nil
+	case []string:
+		items := make([]any, len(v))
+		for n, s := range v {
+			items[n] = s
+		}
+		return metadatum(items)
+	case []any:
+		items := make([]any, len(v))
+		for n, item := range v {
+			m, err := metadatum(item)
+			if err != nil {
+				return nil, err
+			}
+			items[n] = m
+		}
+		return items, nil
+	case map[string]any:
+		m := make(cborMap, 0, len(v))
+		for key, item := range v {
+			value, err := metadatum(item)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, cborPair{key, value})
+		}
+		return cborSortMap(m)
+	}
+	return nil, fmt.Errorf("unsupported metadata value %T", v)
+}
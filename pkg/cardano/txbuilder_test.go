@@ -0,0 +1,118 @@
+package cardano
+
+import (
+	"bytes"
+	"cardano-valley/pkg/network"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Golden transactions. The body bytes were encoded by hand from the Conway
+// CDDL and the ID is their blake2b-256, so the builder is checked against an
+// encoding it did not produce. The fee is 155381 + 44 * the size of the tx
+// with one vkey witness, which is what `cardano-cli transaction
+// calculate-min-fee` gives for the same tx.
+var goldenTxs = []struct {
+	name     string
+	ttl      uint64
+	metadata Metadata
+	body     string
+	id       string
+	fee      uint64
+}{
+	{
+		name: "ada only with ttl",
+		ttl:  1000,
+		body: "a400d9010281825820abababababababababababababababababababababababababababababababab000182a200581d619493315cd92eb5d8c4304e67b7e16ae36d61d34502694657811a2c8e011a001e8480a200581d619493315cd92eb5d8c4304e67b7e16ae36d61d34502694657811a2c8e011a00778973021a0002888d031903e8",
+		id:   "5bbff76b7a616036816af8e3a48adba990a1a40b2fd858433b87fe04247d5972",
+		fee:  166029,
+	},
+	{
+		name:     "cip-20 message",
+		metadata: Metadata{674: map[string]any{"msg": []string{"hi"}}},
+		body:     "a400d9010281825820abababababababababababababababababababababababababababababababab000182a200581d619493315cd92eb5d8c4304e67b7e16ae36d61d34502694657811a2c8e011a001e8480a200581d619493315cd92eb5d8c4304e67b7e16ae36d61d34502694657811a2c8e011a0077820f021a00028ff1075820d172f34e1de3cd36e24bfab2fe3ee479f01225c8751789411b3c38b944941ee6",
+		id:       "6930e5d7cfa763ea30f839f708fe99fe1aec57978b136a5a305527ad1ba3ae37",
+		fee:      167921,
+	},
+}
+
+func TestNativeBuilderGolden(t *testing.T) {
+	useNetwork(t, network.Mainnet)
+	address := cip19Mainnet[6].address
+
+	for _, g := range goldenTxs {
+		t.Run(g.name, func(t *testing.T) {
+			tx, err := (&NativeBuilder{Params: &DefaultProtocolParams}).Build(TxRequest{
+				Inputs: []TxInput{{
+					TxHash: strings.Repeat("ab", 32),
+					Output: TxOut{Address: address, Lovelace: 10_000_000},
+				}},
+				Outputs:       []TxOut{{Address: address, Lovelace: 2_000_000}},
+				ChangeAddress: address,
+				TTL:           g.ttl,
+				Metadata:      g.metadata,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parts, err := cborSplitArray(tx.CBOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(parts[0]); got != g.body {
+				t.Errorf("body\n got %s\nwant %s", got, g.body)
+			}
+			if tx.ID != g.id {
+				t.Errorf("id %s, want %s", tx.ID, g.id)
+			}
+			if tx.Fee != g.fee {
+				t.Errorf("fee %d, want %d", tx.Fee, g.fee)
+			}
+
+			decoded, err := DecodeTx(tx.CBOR)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.ID != g.id || decoded.Fee != g.fee {
+				t.Errorf("decoded id %s fee %d, want %s %d", decoded.ID, decoded.Fee, g.id, g.fee)
+			}
+		})
+	}
+}
+
+func TestLoadMetadataJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.json")
+	data := `{"674": {"msg": ["0xdeadbeef", "0xnothex", "plain"]}, "1": "0x", "2": 42}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := LoadMetadataJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := md[674].(map[string]any)["msg"].([]any)
+	if b, ok := msg[0].([]byte); !ok || !bytes.Equal(b, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("0x string decoded to %#v, want bytes", msg[0])
+	}
+	if !reflect.DeepEqual(msg[1:], []any{"0xnothex", "plain"}) {
+		t.Errorf("text strings changed to %#v", msg[1:])
+	}
+	if b, ok := md[1].([]byte); !ok || len(b) != 0 {
+		t.Errorf(`"0x" decoded to %#v, want empty bytes`, md[1])
+	}
+
+	encoded, err := md.cbor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cborEncode(encoded); err != nil {
+		t.Fatal(err)
+	}
+}
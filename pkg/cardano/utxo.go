@@ -0,0 +1,225 @@
+package cardano
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type (
+	// UTxOValue is policy ID -> hex asset name -> quantity, with lovelace
+	// kept under "lovelace" and an empty name. cardano-cli writes lovelace as
+	// a plain number; the JSON methods translate.
+	UTxOValue map[string]map[string]uint64
+
+	// UTxOEntry is one output in `query utxo --output-json` format.
+	UTxOEntry struct {
+		Address         string           `json:"address"`
+		Datum           json.RawMessage  `json:"datum,omitempty"`
+		DatumHash       string           `json:"datumhash,omitempty"`
+		InlineDatum     json.RawMessage  `json:"inlineDatum,omitempty"`    // ScriptData JSON
+		InlineDatumRaw  string           `json:"inlineDatumRaw,omitempty"` // CBOR hex
+		ReferenceScript *ReferenceScript `json:"referenceScript,omitempty"`
+		Value           UTxOValue        `json:"value"`
+	}
+
+	ReferenceScript struct {
+		ScriptLanguage string `json:"scriptLanguage,omitempty"`
+		Script         struct {
+			CborHex     string `json:"cborHex,omitempty"`
+			Description string `json:"description,omitempty"`
+			Type        string `json:"type,omitempty"`
+		} `json:"script"`
+		Hash string `json:"hash,omitempty"` // providers may only report the hash
+	}
+
+	// UTxOMap is keyed by "txhash#index".
+	UTxOMap map[string]UTxOEntry
+
+	// UTxOSource answers UTxO queries for an address.
+	UTxOSource interface {
+		Name() string
+		QueryUTxOs(ctx context.Context, address string) (UTxOMap, error)
+	}
+
+	// NodeUTxOs queries the local node through cardano-cli. Every call gets
+	// its own temp directory for the JSON file.
+	NodeUTxOs struct{}
+)
+
+// UTxOs is the source used by QueryUTxOs. The chain package swaps in a
+// provider backed source when CARDANO_VALLEY_UTXO_SOURCE asks for one or no
+// node socket is configured.
+var UTxOs UTxOSource = NodeUTxOs{}
+
+// QueryUTxOs returns the UTxOs at address from the configured source.
+func QueryUTxOs(ctx context.Context, address string) (UTxOMap, error) {
+	return UTxOs.QueryUTxOs(ctx, address)
+}
+
+// QueryUTxOJson is QueryUTxOs without a context.
+func QueryUTxOJson(addr string) (UTxOMap, error) {
+	return QueryUTxOs(context.Background(), addr)
+}
+
+func (NodeUTxOs) Name() string { return "node" }
+
+func (NodeUTxOs) QueryUTxOs(ctx context.Context, address string) (UTxOMap, error) {
+	dir, err := os.MkdirTemp("", "cardano-valley-utxo-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "utxos.json")
+
+	args := append([]string{"query", "utxo",
+		"--address", address,
+		"--output-json",
+		"--out-file", file,
+	}, cliNodeArgs()...)
+	if out, err := CLI.Exec("", args...); err != nil {
+		return nil, fmt.Errorf("utxo query %s: %w (%s)", address, err, out)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("utxo query %s: %w", address, err)
+	}
+	var utxos UTxOMap
+	if err := json.Unmarshal(data, &utxos); err != nil {
+		return nil, fmt.Errorf("utxo query %s: %w", address, err)
+	}
+	return utxos, nil
+}
+
+// UnmarshalJSON reads cardano-cli's value, where lovelace is a plain number
+// next to the policy maps.
+func (v *UTxOValue) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	value := make(UTxOValue, len(raw))
+	for policyID, entry := range raw {
+		if policyID == "lovelace" {
+			var lovelace uint64
+			if err := json.Unmarshal(entry, &lovelace); err != nil {
+				return fmt.Errorf("lovelace: %w", err)
+			}
+			value["lovelace"] = map[string]uint64{"": lovelace}
+			continue
+		}
+		var names map[string]uint64
+		if err := json.Unmarshal(entry, &names); err != nil {
+			return fmt.Errorf("policy %s: %w", policyID, err)
+		}
+		value[policyID] = names
+	}
+	*v = value
+	return nil
+}
+
+func (v UTxOValue) MarshalJSON() ([]byte, error) {
+	raw := make(map[string]any, len(v))
+	for policyID, names := range v {
+		if policyID == "lovelace" {
+			raw[policyID] = names[""]
+			continue
+		}
+		raw[policyID] = names
+	}
+	return json.Marshal(raw)
+}
+
+// NewUTxOValue builds a value from lovelace and units (policy ID + hex name).
+func NewUTxOValue(lovelace uint64, assets map[string]uint64) UTxOValue {
+	value := UTxOValue{"lovelace": {"": lovelace}}
+	for unit, qty := range assets {
+		policyID, name := unit, ""
+		if len(unit) > 56 {
+			policyID, name = unit[:56], unit[56:]
+		}
+		if value[policyID] == nil {
+			value[policyID] = make(map[string]uint64)
+		}
+		value[policyID][name] += qty
+	}
+	return value
+}
+
+func (e UTxOEntry) Lovelace() uint64 {
+	return e.Value["lovelace"][""]
+}
+
+// Assets returns the native tokens keyed by unit.
+func (e UTxOEntry) Assets() map[string]uint64 {
+	var assets map[string]uint64
+	for policyID, names := range e.Value {
+		if policyID == "lovelace" {
+			continue
+		}
+		for name, qty := range names {
+			assets = addAssets(assets, map[string]uint64{policyID + name: qty})
+		}
+	}
+	return assets
+}
+
+func (e UTxOEntry) TxOut() TxOut {
+	return TxOut{Address: e.Address, Lovelace: e.Lovelace(), Assets: e.Assets()}
+}
+
+// HasDatum reports whether the output is locked with a datum, in which case
+// spending it needs a script and it is left out of Inputs.
+func (e UTxOEntry) HasDatum() bool {
+	return e.DatumHash != "" || e.InlineDatumRaw != "" || (len(e.InlineDatum) > 0 && string(e.InlineDatum) != "null")
+}
+
+// Lovelace sums the lovelace of every entry.
+func (m UTxOMap) Lovelace() uint64 {
+	var total uint64
+	for _, e := range m {
+		total += e.Lovelace()
+	}
+	return total
+}
+
+// Assets sums the native tokens of every entry, keyed by unit.
+func (m UTxOMap) Assets() map[string]uint64 {
+	var assets map[string]uint64
+	for _, e := range m {
+		assets = addAssets(assets, e.Assets())
+	}
+	return assets
+}
+
+// Inputs converts the entries the tx builder can spend with a key witness,
+// sorted by reference. Outputs carrying a datum or reference script are
+// skipped so a plain payment never consumes them.
+func (m UTxOMap) Inputs() []TxInput {
+	refs := make([]string, 0, len(m))
+	for ref := range m {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	inputs := make([]TxInput, 0, len(m))
+	for _, ref := range refs {
+		entry := m[ref]
+		if entry.HasDatum() || entry.ReferenceScript != nil {
+			continue
+		}
+		hash, ix, _ := strings.Cut(ref, "#")
+		index, err := strconv.Atoi(ix)
+		if err != nil {
+			continue
+		}
+		inputs = append(inputs, TxInput{TxHash: hash, Index: index, Output: entry.TxOut()})
+	}
+	return inputs
+}
@@ -2,7 +2,11 @@ package cardano
 
 import (
 	"cardano-valley/pkg/db"
+	"context"
+	"crypto/ed25519"
 	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
+	"cardano-valley/pkg/vault"
 	"errors"
 	"fmt"
 	"os"
@@ -17,6 +21,8 @@ type (
 		StakeKey string `bson:"stake_key,omitempty"`
 		SigningStakeKey string `bson:"signing_stake_key,omitempty"`
 		DelegationCertificate string `bson:"delegation_certificate,omitempty"`
+		Vault string `bson:"vault,omitempty"` // wallet ID of the signing keys in the key vault
+		Derivation string `bson:"derivation,omitempty"` // HD account path, empty for wallets generated before HD
 	}
 )
 
@@ -45,7 +51,7 @@ func getFileName(userID, suffix string) string {
 
 /**
  * GenerateWallet generates a new wallet with the given ID.
- * It creates the necessary payment and stake keys, and generates a payment address.
+ * It derives the payment and stake keys from the wallet's HD account, and generates a payment address.
  * If the wallet already exists, it skips the generation.
  * @param ID The Discord GuildID or the UserID of the wallet to generate.
  * @return error If there was an error during the generation process.
@@ -59,21 +65,15 @@ func getFileName(userID, suffix string) string {
 	}
 
 	logger.Record.Info("WALLET", "Wallet does not exist", "Generating new wallet...")
-	err = os.MkdirAll(getFileName(ID, ""), 0755)
+	err = os.MkdirAll(getFileName(ID, ""), 0700)
 	if err != nil {
 		logger.Record.Error("WALLET", "Failed to create wallet directory: ", err)
 		return nil, fmt.Errorf("failed to create wallet directory: %w", err)
 	}
 	// If the wallet does not exist, proceed with generation
-	err = generatePaymentKey(ID)
+	err = generateKeys(ID)
 	if err != nil {
-		logger.Record.Error("WALLET", "Failed to generate payment key: ", err)
-		return nil, err
-	}
-
-	err = generateStakeKey(ID)
-	if err != nil {
-		logger.Record.Error("WALLET", "Failed to generate stake key: ", err)
+		logger.Record.Error("WALLET", "Failed to generate keys: ", err)
 		return nil, err
 	}
 
@@ -100,87 +100,73 @@ func getFileName(userID, suffix string) string {
 	return wallet, nil
 }
 
-func generatePaymentKey(ID string) error {
+// generateKeys derives the payment and stake keys from the wallet's HD
+// account. A wallet ID that already has an account gets the same keys back,
+// which is how a wallet is restored after its files were lost.
+func generateKeys(ID string) error {
 	paymentKey := getFileName(ID, PaymentKeySuffix)
-	logger.Record.Info("WALLET", "Trying to generate payment key:", paymentKey)
-	if _, err := os.Stat(paymentKey); os.IsNotExist(err) {
-		// Generate the payment keys
-		signingKey := getFileName(ID, SigningKeySuffix)
-		paymentArgs := CommandArgs{
-			"address",
-			"key-gen",
-			"--verification-key-file",
-			paymentKey,
-			"--signing-key-file",
-			signingKey,
-		}
-
-		// cardano-cli address key-gen --verification-key-file payment.vkey --signing-key-file payment.skey
-		_, err := Run(paymentArgs)
-		if err != nil {
-			logger.Record.Error("WALLET", "Failed to generate payment key: ", err)
-			return err
-		}
-	} else {
-		// File exists, skip generation
+	stakeKey := getFileName(ID, StakeKeySuffix)
+	logger.Record.Info("WALLET", "Trying to generate keys:", paymentKey)
+	if _, err := os.Stat(paymentKey); !os.IsNotExist(err) {
 		return errors.New("Payment key file already exists, skipping generation.")
 	}
+	if _, err := os.Stat(stakeKey); !os.IsNotExist(err) {
+		return errors.New("stake key file already exists, skipping generation")
+	}
 
-	return nil
-}
+	payment, stake, account, err := deriveWalletKeys(ID)
+	if err != nil {
+		return err
+	}
+	logger.Record.Info("WALLET", "HD ACCOUNT", account.Path, "ID", ID)
 
-func generateStakeKey(ID string) error {
-	stakeKey := getFileName(ID, StakeKeySuffix)
-	if _, err := os.Stat(stakeKey); os.IsNotExist(err) {
-		// Generate the stake keys
-		signingStakeKey := getFileName(ID, StakeSigningKeySuffix)
-		stakeArgs := []string{
-			"conway",
-			"stake-address",
-			"key-gen",
-			"--verification-key-file",
-			stakeKey,
-			"--signing-key-file",
-			signingStakeKey,
-		}
+	if err := writeKeyPair(payment, ID, vault.PaymentSigningKey, paymentKey); err != nil {
+		return err
+	}
+	return writeKeyPair(stake, ID, vault.StakeSigningKey, stakeKey)
+}
 
-		// cardano-cli conway stake-address key-gen --verification-key-file stake.vkey --signing-key-file stake.skey
-		_, err = Run(stakeArgs)
-		if err != nil {
-			logger.Record.Error("WALLET", "Failed to generate stake key: ", err)
-			return err
-		}
-	} else {
-		return errors.New("stake key file already exists, skipping generation")
+// writeKeyPair writes a key pair in cardano-cli's envelope format. The
+// signing key goes straight into the vault, only the verification key is
+// written to disk.
+func writeKeyPair(key *SigningKey, ID, name, vkeyFile string) error {
+	envelope := []byte(key.Envelope())
+	defer vault.Wipe(envelope)
+	if err := vault.Put(ID, name, envelope); err != nil {
+		return err
 	}
+	return os.WriteFile(vkeyFile, []byte(key.VerificationEnvelope()), 0644)
+}
 
-	return nil
+func readVerificationKey(file string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVerificationKey(string(data))
 }
 
 func generatePaymentAddress(ID string) (error) {
 	address := getFileName(ID, AddressSuffix)
 	if _, err := os.Stat(address); os.IsNotExist(err) {
-		paymentKey := getFileName(ID, PaymentKeySuffix)
-		stakeKey := getFileName(ID, StakeKeySuffix)
-		// Generate the payment address
-		addressArgs := []string{
-			"address",
-			"build",
-			"--payment-verification-key-file",
-			paymentKey,
-			"--stake-verification-key-file",
-			stakeKey,
-			"--mainnet",
-			"--out-file",
-			address,
+		paymentKey, err := readVerificationKey(getFileName(ID, PaymentKeySuffix))
+		if err != nil {
+			return err
+		}
+		stakeKey, err := readVerificationKey(getFileName(ID, StakeKeySuffix))
+		if err != nil {
+			return err
 		}
 
-		// cardano-cli address build --payment-verification-key-file payment.vkey --stake-verification-key-file stake.vkey --mainnet --out-file payment.addr
-		_, err := Run(addressArgs)
+		// Same as cardano-cli address build with both verification keys
+		addr, err := BaseAddress(paymentKey, stakeKey)
 		if err != nil {
 			logger.Record.Error("WALLET", "Failed to generate payment address: ", err)
 			return err
 		}
+		if err := os.WriteFile(address, []byte(addr), 0644); err != nil {
+			return err
+		}
 	} else {
 		return errors.New("payment address file already exists, skipping generation")
 	}
@@ -189,25 +175,22 @@ func generatePaymentAddress(ID string) (error) {
 }
 
 func generateDelegationCertificate(ID string) error {
-	stakeKey := getFileName(ID, StakeKeySuffix)
 	delegationCert := getFileName(ID, DelegationCertificateSuffix)
+	if network.Current.PoolID == "" {
+		logger.Record.Warn("WALLET", "NO POOL CONFIGURED, SKIPPING DELEGATION CERTIFICATE", ID)
+		return nil
+	}
 	if _, err := os.Stat(delegationCert); os.IsNotExist(err) {
-		// Generate the delegation certificate
-		delegationArgs := []string{
-			"conway",
-			"stake-address",
-			"stake-delegation-certificate",
-			"--stake-verification-key-file",
-			stakeKey,
-			"--stake-pool-id",
-			"pool19peeq2czwunkwe3s70yuvwpsrqcyndlqnxvt67usz98px57z7fk", // PREEB
-			"--out-file",
-			delegationCert,
+		stakeKey, err := readVerificationKey(getFileName(ID, StakeKeySuffix))
+		if err != nil {
+			return err
 		}
-
-		// cardano-cli conway stake-address stake-delegation-certificate --stake-verification-key-file stake.vkey --stake-pool-id pool17navl486tuwjg4t95vwtlqslx9225x5lguwuy6ahc58x5dnm9ma --out-file delegation.cert
-		_, err := Run(delegationArgs)
+		cert, err := StakeDelegationCertificate(stakeKey, network.Current.PoolID)
 		if err != nil {
+			return err
+		}
+		data := []byte(cert.Envelope())
+		if err := os.WriteFile(delegationCert, data, 0644); err != nil {
 			logger.Record.Error("WALLET", "Failed to generate delegation certificate: ", err)
 			return err
 		}
@@ -229,26 +212,19 @@ func LoadWallet(ID string) (*Keys, error) {
 		return nil, err
 	}
 
-	safeSigningPaymentKey, err := readAndEncryptKey(signingPaymentKey)
-	if err != nil {
-		logger.Record.Error("WALLET", "Failed to read and encrypt signing payment key file: ", err)
-		return nil, err
-	}
 	safeStakeKey, err := readAndEncryptKey(stakeKey)
 	if err != nil {
 		logger.Record.Error("WALLET", "Failed to read and encrypt stake key file: ", err)
 		return nil, err
 	}
-	safeSigningStakeKey, err := readAndEncryptKey(signingStakeKey)
-	if err != nil {
-		logger.Record.Error("WALLET", "Failed to read and encrypt signing stake key file: ", err)
-		return nil, err
-	}
-
-	safeDelegationCert, err := readAndEncryptKey(delegationCert)
-	if err != nil {
-		logger.Record.Error("WALLET", "Failed to read and encrypt delegation certificate file: ", err)
-		return nil, err
+	// No certificate is generated when the network has no pool configured
+	var safeDelegationCert string
+	if network.Current.PoolID != "" {
+		safeDelegationCert, err = readAndEncryptKey(delegationCert)
+		if err != nil {
+			logger.Record.Error("WALLET", "Failed to read and encrypt delegation certificate file: ", err)
+			return nil, err
+		}
 	}
 
 	addressData, err := os.ReadFile(address)
@@ -259,15 +235,203 @@ func LoadWallet(ID string) (*Keys, error) {
 	wallet := &Keys{
 		Address:         		string(addressData),
 		PaymentKey:      		string(safePaymentKey),
-		SigningPaymentKey: 		string(safeSigningPaymentKey),
 		StakeKey:        		string(safeStakeKey),
-		SigningStakeKey: 		string(safeSigningStakeKey),
 		DelegationCertificate:  string(safeDelegationCert),
 	}
 
+	if vault.Has(ID, vault.PaymentSigningKey) {
+		wallet.Vault = ID
+		if account, err := Accounts.Load(context.Background(), ID); err == nil {
+			wallet.Derivation = account.Path
+		}
+		return wallet, nil
+	}
+
+	// Wallets generated before the key vault still have plaintext signing
+	// keys on disk until MigrateToVault moves them
+	wallet.SigningPaymentKey, err = readAndEncryptKey(signingPaymentKey)
+	if err != nil {
+		logger.Record.Error("WALLET", "Failed to read and encrypt signing payment key file: ", err)
+		return nil, err
+	}
+	wallet.SigningStakeKey, err = readAndEncryptKey(signingStakeKey)
+	if err != nil {
+		logger.Record.Error("WALLET", "Failed to read and encrypt signing stake key file: ", err)
+		return nil, err
+	}
+
 	return wallet, nil
 }
 
+// MigrateToVault moves the wallet's signing keys into the key vault under ID,
+// taking them from the plaintext files under wallets/ or, when those are
+// gone, from the legacy encrypted fields. The plaintext files are shredded
+// and the legacy fields cleared; the caller saves the updated keys. It
+// reports whether anything changed.
+func (k *Keys) MigrateToVault(ID string) (bool, error) {
+	files := map[string]string{
+		vault.PaymentSigningKey: getFileName(ID, SigningKeySuffix),
+		vault.StakeSigningKey:   getFileName(ID, StakeSigningKeySuffix),
+	}
+	legacy := map[string]string{
+		vault.PaymentSigningKey: k.SigningPaymentKey,
+		vault.StakeSigningKey:   k.SigningStakeKey,
+	}
+
+	changed := false
+	for _, name := range []string{vault.PaymentSigningKey, vault.StakeSigningKey} {
+		if vault.Has(ID, name) {
+			continue
+		}
+
+		var envelope []byte
+		if data, err := os.ReadFile(files[name]); err == nil {
+			envelope = data
+		} else if legacy[name] != "" {
+			decrypted, err := db.Decrypt(legacy[name])
+			if err != nil {
+				return changed, fmt.Errorf("wallet %s: decrypt %s: %w", ID, name, err)
+			}
+			envelope = []byte(decrypted)
+		} else {
+			return changed, fmt.Errorf("wallet %s: no %s to migrate", ID, name)
+		}
+
+		// never store something that will not sign
+		if _, err := ParseSigningKey(string(envelope)); err != nil {
+			vault.Wipe(envelope)
+			return changed, fmt.Errorf("wallet %s: %s: %w", ID, name, err)
+		}
+		err := vault.Put(ID, name, envelope)
+		vault.Wipe(envelope)
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if err := ShredFile(file); err != nil {
+			return changed, fmt.Errorf("wallet %s: %w", ID, err)
+		}
+		logger.Record.Info("WALLET", "SHREDDED", file)
+		changed = true
+	}
+	_ = os.Chmod(getFileName(ID, ""), 0700)
+
+	if k.Vault != ID || k.SigningPaymentKey != "" || k.SigningStakeKey != "" {
+		k.Vault = ID
+		k.SigningPaymentKey = ""
+		k.SigningStakeKey = ""
+		changed = true
+	}
+	return changed, nil
+}
+
+// ReencryptedField is a Keys field sealed again under the current master key,
+// by its bson name.
+type ReencryptedField struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Reencrypt re-seals every encrypted field under the current master key and
+// returns the fields that changed, so they can be written on their own.
+func (k *Keys) Reencrypt() ([]ReencryptedField, error) {
+	var changed []ReencryptedField
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{
+		{"payment_key", &k.PaymentKey},
+		{"signing_payment_key", &k.SigningPaymentKey},
+		{"stake_key", &k.StakeKey},
+		{"signing_stake_key", &k.SigningStakeKey},
+		{"delegation_certificate", &k.DelegationCertificate},
+	} {
+		if db.Keys.IsCurrent(*f.value) {
+			continue
+		}
+		resealed, err := db.Keys.Reseal(*f.value, nil)
+		if err != nil {
+			return changed, err
+		}
+		changed = append(changed, ReencryptedField{Field: f.name, Old: *f.value, New: resealed})
+		*f.value = resealed
+	}
+	return changed, nil
+}
+
+// StakeVerificationKey decrypts and parses the wallet's stake key.
+func (k Keys) StakeVerificationKey() (ed25519.PublicKey, error) {
+	if k.StakeKey == "" {
+		return nil, errors.New("wallet has no stake key")
+	}
+	envelope, err := db.Decrypt(k.StakeKey)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVerificationKey(envelope)
+}
+
+// PaymentVerificationKey decrypts and parses the wallet's payment key.
+func (k Keys) PaymentVerificationKey() (ed25519.PublicKey, error) {
+	if k.PaymentKey == "" {
+		return nil, errors.New("wallet has no payment key")
+	}
+	envelope, err := db.Decrypt(k.PaymentKey)
+	if err != nil {
+		return nil, err
+	}
+	return ParseVerificationKey(envelope)
+}
+
+// StakeAddress is the reward address of the wallet's stake key.
+func (k Keys) StakeAddress() (string, error) {
+	stake, err := k.StakeVerificationKey()
+	if err != nil {
+		return "", err
+	}
+	return StakeAddress(stake)
+}
+
+// StakeSigningKey decrypts the wallet's stake signing key envelope.
+func (k Keys) StakeSigningKey() (string, error) {
+	return k.signingKey(vault.StakeSigningKey, k.SigningStakeKey)
+}
+
+// PaymentSigningKey decrypts the wallet's payment signing key envelope.
+func (k Keys) PaymentSigningKey() (string, error) {
+	return k.signingKey(vault.PaymentSigningKey, k.SigningPaymentKey)
+}
+
+// signingKey reads a key from the vault, falling back to the legacy field
+// for wallets that have not been migrated yet.
+func (k Keys) signingKey(name, legacy string) (string, error) {
+	if k.Vault != "" {
+		envelope, err := vault.Get(k.Vault, name)
+		if errors.Is(err, vault.ErrNotFound) && k.Derivation != "" {
+			// the vault record is gone but the key can be derived again
+			if err = restoreSigningKeys(k.Vault); err == nil {
+				envelope, err = vault.Get(k.Vault, name)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		defer vault.Wipe(envelope)
+		return string(envelope), nil
+	}
+	if legacy == "" {
+		return "", fmt.Errorf("wallet has no %s", name)
+	}
+	return db.Decrypt(legacy)
+}
+
 func readAndEncryptKey(keyPath string) (string, error) {
 	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
 		return "", fmt.Errorf("key file does not exist: %s", keyPath)
@@ -0,0 +1,183 @@
+package cardano
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// VKeyWitness is a signature over a tx ID by one key.
+type VKeyWitness struct {
+	VKey      ed25519.PublicKey
+	Signature []byte
+}
+
+var ErrInvalidWitness = errors.New("invalid witness")
+
+// KeyHash is the hash a native script names the signer by.
+func (w VKeyWitness) KeyHash() string {
+	return hex.EncodeToString(KeyHash(w.VKey))
+}
+
+// ParseWitnesses reads witnesses made elsewhere: a cardano-cli witness file
+// (`transaction witness`), the hex witness set a CIP-30 wallet returns from
+// signTx, or the bare CBOR of either.
+func ParseWitnesses(text string) ([]VKeyWitness, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		var env KeyEnvelope
+		if err := json.Unmarshal([]byte(text), &env); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidWitness, err)
+		}
+		text = env.CborHex
+	}
+	raw, err := hex.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: not hex", ErrInvalidWitness)
+	}
+	decoded, err := cborDecode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWitness, err)
+	}
+
+	var items []any
+	switch v := decoded.(type) {
+	case cborMap:
+		// a witness set, only the vkey witnesses matter
+		vkeys, _ := v.Get(0)
+		items, _ = cborUntag(vkeys).([]any)
+	case []any:
+		// cardano-cli wraps a key witness as [0, [vkey, signature]]
+		if len(v) == 2 && cborKeyEqual(v[0], 0) {
+			items = []any{v[1]}
+		} else {
+			items = []any{v}
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: no key witnesses", ErrInvalidWitness)
+	}
+
+	witnesses := make([]VKeyWitness, 0, len(items))
+	for _, item := range items {
+		pair, ok := item.([]any)
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("%w: malformed key witness", ErrInvalidWitness)
+		}
+		vkey, _ := pair[0].([]byte)
+		sig, _ := pair[1].([]byte)
+		if len(vkey) != ed25519.PublicKeySize || len(sig) != ed25519.SignatureSize {
+			return nil, fmt.Errorf("%w: malformed key witness", ErrInvalidWitness)
+		}
+		witnesses = append(witnesses, VKeyWitness{VKey: vkey, Signature: sig})
+	}
+	return witnesses, nil
+}
+
+// Witnesses lists the vkey witnesses already on the tx.
+func (tx *Tx) Witnesses() ([]VKeyWitness, error) {
+	parts, err := cborSplitArray(tx.CBOR)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("%w: transaction has %d parts", ErrCBOR, len(parts))
+	}
+	decoded, err := cborDecode(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	witnessSet, ok := decoded.(cborMap)
+	if !ok {
+		return nil, fmt.Errorf("%w: witness set is not a map", ErrCBOR)
+	}
+	existing, _ := witnessSet.Get(0)
+	vkeys, _ := cborUntag(existing).([]any)
+
+	witnesses := make([]VKeyWitness, 0, len(vkeys))
+	for _, w := range vkeys {
+		pair, ok := w.([]any)
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		vkey, _ := pair[0].([]byte)
+		sig, _ := pair[1].([]byte)
+		witnesses = append(witnesses, VKeyWitness{VKey: vkey, Signature: sig})
+	}
+	return witnesses, nil
+}
+
+// AddWitnesses checks each witness signs this tx and adds the ones it does
+// not have yet. Co-signers can hand theirs over in any order.
+func (tx *Tx) AddWitnesses(witnesses ...VKeyWitness) error {
+	id, err := hex.DecodeString(tx.ID)
+	if err != nil {
+		return fmt.Errorf("tx sign: invalid tx id %q", tx.ID)
+	}
+	for _, w := range witnesses {
+		if !ed25519.Verify(w.VKey, id, w.Signature) {
+			return fmt.Errorf("%w: key %s did not sign tx %s", ErrInvalidWitness, w.KeyHash(), tx.ID)
+		}
+	}
+	return addVKeyWitnesses(tx, witnesses)
+}
+
+func addVKeyWitnesses(tx *Tx, witnesses []VKeyWitness) error {
+	parts, err := cborSplitArray(tx.CBOR)
+	if err != nil {
+		return err
+	}
+	if len(parts) != 4 {
+		return fmt.Errorf("%w: transaction has %d parts", ErrCBOR, len(parts))
+	}
+
+	decoded, err := cborDecode(parts[1])
+	if err != nil {
+		return err
+	}
+	witnessSet, ok := decoded.(cborMap)
+	if !ok {
+		return fmt.Errorf("%w: witness set is not a map", ErrCBOR)
+	}
+	existing, _ := witnessSet.Get(0)
+	vkeys, _ := cborUntag(existing).([]any)
+
+	for _, w := range witnesses {
+		if hasWitness(vkeys, w.VKey) {
+			continue
+		}
+		vkeys = append(vkeys, []any{[]byte(w.VKey), w.Signature})
+	}
+
+	rest := make(cborMap, 0, len(witnessSet)+1)
+	rest = append(rest, cborPair{uint64(0), cborTag{cborSetTag, vkeys}})
+	for _, p := range witnessSet {
+		if !cborKeyEqual(p.Key, 0) {
+			rest = append(rest, p)
+		}
+	}
+
+	data, err := cborEncode([]any{parts[0], rest, parts[2], parts[3]})
+	if err != nil {
+		return err
+	}
+	tx.CBOR = data
+	return nil
+}
+
+func hasWitness(vkeys []any, public []byte) bool {
+	for _, w := range vkeys {
+		pair, ok := w.([]any)
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		if vkey, ok := pair[0].([]byte); ok && bytes.Equal(vkey, public) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+package chain
+
+import (
+	"cardano-valley/pkg/network"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	bfg "github.com/blockfrost/blockfrost-go"
+)
+
+type Blockfrost struct {
+	client    bfg.APIClient
+	projectID string
+	server    string
+}
+
+func NewBlockfrost() (*Blockfrost, error) {
+	projectID, ok := os.LookupEnv("BLOCKFROST_PROJECT_ID")
+	if !ok || projectID == "" {
+		return nil, errors.New("BLOCKFROST_PROJECT_ID is not set")
+	}
+
+	return &Blockfrost{
+		client:    bfg.NewAPIClient(bfg.APIClientOptions{ProjectID: projectID, Server: network.Current.BlockfrostURL}),
+		projectID: projectID,
+		server:    network.Current.BlockfrostURL,
+	}, nil
+}
+
+func (b *Blockfrost) Name() string { return "blockfrost" }
+
+// blockfrostErr maps the SDK's error responses onto the chain errors.
+func blockfrostErr(err error) error {
+	var apiErr *bfg.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.Response.(type) {
+	case bfg.NotFound:
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case bfg.OverusageLimit, bfg.AutoBanned:
+		return fmt.Errorf("%w: %v", ErrRateLimited, err)
+	}
+	return err
+}
+
+func parseQuantity(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 10, 64)
+	return v
+}
+
+func (b *Blockfrost) AddressBalance(ctx context.Context, address string) (uint64, error) {
+	addr, err := b.client.Address(ctx, address)
+	if err != nil {
+		err = blockfrostErr(err)
+		// Addresses that never received anything are unknown to the indexer
+		if errors.Is(err, ErrNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var lovelace uint64
+	for _, a := range addr.Amount {
+		if a.Unit == "lovelace" {
+			lovelace += parseQuantity(a.Quantity)
+		}
+	}
+	return lovelace, nil
+}
+
+func (b *Blockfrost) AddressUTxOs(ctx context.Context, address string) ([]UTxO, error) {
+	var utxos []UTxO
+	for page := range b.client.AddressUTXOsAll(ctx, address) {
+		if page.Err != nil {
+			err := blockfrostErr(page.Err)
+			if errors.Is(err, ErrNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, u := range page.Res {
+			utxo := UTxO{TxHash: u.TxHash, Index: u.OutputIndex, Address: u.Address}
+			for _, a := range u.Amount {
+				if a.Unit == "lovelace" {
+					utxo.Lovelace += parseQuantity(a.Quantity)
+				} else {
+					utxo.Assets = addAsset(utxo.Assets, a.Unit, parseQuantity(a.Quantity))
+				}
+			}
+			if u.DataHash != nil {
+				utxo.DatumHash = *u.DataHash
+			}
+			if u.InlineDatum != nil {
+				utxo.InlineDatum = *u.InlineDatum
+			}
+			if u.ReferenceScriptHash != nil {
+				utxo.ReferenceScriptHash = *u.ReferenceScriptHash
+			}
+			utxos = append(utxos, utxo)
+		}
+	}
+	return utxos, nil
+}
+
+func (b *Blockfrost) Transaction(ctx context.Context, hash string) (*Transaction, error) {
+	content, err := b.client.Transaction(ctx, hash)
+	if err != nil {
+		return nil, blockfrostErr(err)
+	}
+	ios, err := b.client.TransactionUTXOs(ctx, hash)
+	if err != nil {
+		return nil, blockfrostErr(err)
+	}
+
+	tx := &Transaction{
+		Hash:        content.Hash,
+		BlockHash:   content.Block,
+		BlockHeight: uint64(content.BlockHeight),
+		Slot:        uint64(content.Slot),
+		BlockTime:   time.Unix(int64(content.BlockTime), 0),
+		Fee:         parseQuantity(content.Fees),
+	}
+	toUTxO := func(address, txHash string, index int, amounts []bfg.TxAmount) UTxO {
+		utxo := UTxO{TxHash: txHash, Index: index, Address: address}
+		for _, a := range amounts {
+			if a.Unit == "lovelace" {
+				utxo.Lovelace += parseQuantity(a.Quantity)
+			} else {
+				utxo.Assets = addAsset(utxo.Assets, a.Unit, parseQuantity(a.Quantity))
+			}
+		}
+		return utxo
+	}
+	for _, in := range ios.Inputs {
+		if in.Collateral || (in.Reference != nil && *in.Reference) {
+			continue
+		}
+		tx.Inputs = append(tx.Inputs, toUTxO(in.Address, in.TxHash, int(in.OutputIndex), in.Amount))
+	}
+	for _, out := range ios.Outputs {
+		tx.Outputs = append(tx.Outputs, toUTxO(out.Address, hash, out.OutputIndex, out.Amount))
+	}
+	return tx, nil
+}
+
+// get performs a raw GET for endpoints the SDK does not paginate.
+func (b *Blockfrost) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.server+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("project_id", b.projectID)
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	switch {
+	case rsp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode == 418:
+		return ErrRateLimited
+	case rsp.StatusCode >= 300:
+		body, _ := io.ReadAll(rsp.Body)
+		return fmt.Errorf("blockfrost %s: %s", path, string(body))
+	}
+	return json.NewDecoder(rsp.Body).Decode(v)
+}
+
+func (b *Blockfrost) PolicyHolders(ctx context.Context, policyID string) (map[string]uint64, error) {
+	var units []string
+	for page := 1; ; page++ {
+		var assets []bfg.AssetByPolicy
+		err := b.get(ctx, fmt.Sprintf("/assets/policy/%s?page=%d", policyID, page), &assets)
+		if errors.Is(err, ErrNotFound) || (err == nil && len(assets) == 0) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range assets {
+			units = append(units, a.Asset)
+		}
+	}
+
+	holders := make(map[string]uint64)
+	for _, unit := range units {
+		for page := range b.client.AssetAddressesAll(ctx, unit) {
+			if page.Err != nil {
+				return nil, blockfrostErr(page.Err)
+			}
+			for _, a := range page.Res {
+				holders[a.Address] += parseQuantity(a.Quantity)
+			}
+		}
+	}
+	return holders, nil
+}
+
+func (b *Blockfrost) AssetInfo(ctx context.Context, unit string) (*Asset, error) {
+	a, err := b.client.Asset(ctx, unit)
+	if err != nil {
+		return nil, blockfrostErr(err)
+	}
+
+	asset := &Asset{
+		Unit:        a.Asset,
+		PolicyID:    a.PolicyId,
+		AssetName:   a.AssetName,
+		Fingerprint: a.Fingerprint,
+		Quantity:    parseQuantity(a.Quantity),
+	}
+	if a.OnchainMetadata != nil {
+		asset.Metadata, _ = json.Marshal(*a.OnchainMetadata)
+	}
+	return asset, nil
+}
+
+func (b *Blockfrost) StakeAccount(ctx context.Context, stakeAddress string) (*StakeAccount, error) {
+	acc, err := b.client.Account(ctx, stakeAddress)
+	if err != nil {
+		return nil, blockfrostErr(err)
+	}
+
+	account := &StakeAccount{
+		StakeAddress:       acc.StakeAddress,
+		Active:             acc.Active,
+		ControlledLovelace: parseQuantity(acc.ControlledAmount),
+		RewardsAvailable:   parseQuantity(acc.WithdrawableAmount),
+	}
+	if acc.PoolID != nil {
+		account.PoolID = *acc.PoolID
+	}
+	return account, nil
+}
+
+func (b *Blockfrost) Tip(ctx context.Context) (*Tip, error) {
+	block, err := b.client.BlockLatest(ctx)
+	if err != nil {
+		return nil, blockfrostErr(err)
+	}
+
+	return &Tip{
+		Epoch:  block.Epoch,
+		Slot:   uint64(block.Slot),
+		Height: uint64(block.Height),
+		Hash:   block.Hash,
+		Time:   time.Unix(int64(block.Time), 0),
+	}, nil
+}
+
+func (b *Blockfrost) Submit(ctx context.Context, cbor []byte) (string, error) {
+	hash, err := b.client.TransactionSubmit(ctx, cbor)
+	if err != nil {
+		var apiErr *bfg.APIError
+		if errors.As(err, &apiErr) {
+			if bad, ok := apiErr.Response.(bfg.BadRequest); ok {
+				return "", badRequest(bad.Message)
+			}
+		}
+		return "", blockfrostErr(err)
+	}
+	return hash, nil
+}
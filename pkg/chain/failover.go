@@ -0,0 +1,142 @@
+package chain
+
+import (
+	"cardano-valley/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a provider is skipped after it rate-limits us
+	rateLimitCooldown = 1 * time.Minute
+
+	// How long a provider is skipped after any other failure
+	errorCooldown = 30 * time.Second
+)
+
+// Failover tries each provider in order, skipping providers that recently
+// failed. A not-found answer may only mean that indexer lags behind, so the
+// next provider is asked too; ErrNotFound is returned only when every
+// provider that answered agrees.
+type Failover struct {
+	providers []Provider
+
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func NewFailover(providers ...Provider) *Failover {
+	return &Failover{
+		providers: providers,
+		until:     make(map[string]time.Time),
+	}
+}
+
+func (f *Failover) Name() string {
+	names := make([]string, len(f.providers))
+	for i, p := range f.providers {
+		names[i] = p.Name()
+	}
+	return "failover(" + strings.Join(names, ",") + ")"
+}
+
+func (f *Failover) available(p Provider) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Now().After(f.until[p.Name()])
+}
+
+func (f *Failover) penalize(p Provider, err error) {
+	cooldown := errorCooldown
+	if errors.Is(err, ErrRateLimited) {
+		cooldown = rateLimitCooldown
+	}
+
+	f.mu.Lock()
+	f.until[p.Name()] = time.Now().Add(cooldown)
+	f.mu.Unlock()
+
+	logger.Record.Warn("CHAIN", "PROVIDER FAILED", p.Name(), "COOLDOWN", cooldown.String(), "ERROR", err)
+}
+
+func failover[T any](ctx context.Context, f *Failover, call func(Provider) (T, error)) (T, error) {
+	var (
+		zero     T
+		errs     []error
+		notFound []error
+		failed   bool
+	)
+
+	// Providers in cooldown are only tried when nothing else is left
+	var ordered, cooling []Provider
+	for _, p := range f.providers {
+		if f.available(p) {
+			ordered = append(ordered, p)
+		} else {
+			cooling = append(cooling, p)
+		}
+	}
+	ordered = append(ordered, cooling...)
+
+	for _, p := range ordered {
+		if ctx.Err() != nil {
+			return zero, ctx.Err()
+		}
+
+		res, err := call(p)
+		switch {
+		case err == nil:
+			return res, nil
+		case errors.Is(err, ErrNotFound):
+			notFound = append(notFound, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		case errors.Is(err, ErrUnsupported):
+			// try the next one without penalty
+		default:
+			f.penalize(p, err)
+			failed = true
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+
+	switch {
+	case len(notFound) > 0 && !failed:
+		return zero, errors.Join(notFound...)
+	case len(errs) == 0:
+		return zero, errors.New("no chain providers configured")
+	}
+	// a provider that failed might have known it, so this is not a not-found
+	return zero, errors.Join(errs...)
+}
+
+func (f *Failover) AddressBalance(ctx context.Context, address string) (uint64, error) {
+	return failover(ctx, f, func(p Provider) (uint64, error) { return p.AddressBalance(ctx, address) })
+}
+
+func (f *Failover) AddressUTxOs(ctx context.Context, address string) ([]UTxO, error) {
+	return failover(ctx, f, func(p Provider) ([]UTxO, error) { return p.AddressUTxOs(ctx, address) })
+}
+
+func (f *Failover) Transaction(ctx context.Context, hash string) (*Transaction, error) {
+	return failover(ctx, f, func(p Provider) (*Transaction, error) { return p.Transaction(ctx, hash) })
+}
+
+func (f *Failover) PolicyHolders(ctx context.Context, policyID string) (map[string]uint64, error) {
+	return failover(ctx, f, func(p Provider) (map[string]uint64, error) { return p.PolicyHolders(ctx, policyID) })
+}
+
+func (f *Failover) AssetInfo(ctx context.Context, unit string) (*Asset, error) {
+	return failover(ctx, f, func(p Provider) (*Asset, error) { return p.AssetInfo(ctx, unit) })
+}
+
+func (f *Failover) StakeAccount(ctx context.Context, stakeAddress string) (*StakeAccount, error) {
+	return failover(ctx, f, func(p Provider) (*StakeAccount, error) { return p.StakeAccount(ctx, stakeAddress) })
+}
+
+func (f *Failover) Tip(ctx context.Context) (*Tip, error) {
+	return failover(ctx, f, func(p Provider) (*Tip, error) { return p.Tip(ctx) })
+}
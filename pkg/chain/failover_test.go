@@ -0,0 +1,40 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFailoverNotFound(t *testing.T) {
+	ctx := context.Background()
+	const hash = "aa"
+
+	// lagging has not indexed the tx yet, synced has
+	lagging, synced := NewFixture(), NewFixture()
+	synced.Transactions[hash] = &Transaction{Hash: hash, BlockHash: "bb"}
+
+	tx, err := NewFailover(lagging, synced).Transaction(ctx, hash)
+	if err != nil || tx.BlockHash != "bb" {
+		t.Fatalf("lagging provider first: %+v, %v, want the synced answer", tx, err)
+	}
+
+	_, err = NewFailover(lagging, NewFixture()).Transaction(ctx, hash)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("every provider agrees: %v, want %v", err, ErrNotFound)
+	}
+
+	failing := NewFixture()
+	failing.FailNext("Transaction", errors.New("timeout"))
+	_, err = NewFailover(lagging, failing).Transaction(ctx, hash)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("one provider failed: %v, want an error that is not %v", err, ErrNotFound)
+	}
+
+	unsupported := NewFixture()
+	unsupported.FailNext("Transaction", ErrUnsupported)
+	_, err = NewFailover(unsupported, lagging).Transaction(ctx, hash)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("unsupported and not found: %v, want %v", err, ErrNotFound)
+	}
+}
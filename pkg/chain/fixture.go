@@ -0,0 +1,371 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/db"
+)
+
+// Fixture is an in-memory ledger implementing Provider. It can be loaded from
+// and saved to a JSON file, and scripted from code: add UTxOs, apply txs,
+// advance the tip and make the next call to a method fail.
+type Fixture struct {
+	mu sync.Mutex
+
+	UTxOs        []UTxO                   `json:"utxos"`
+	Transactions map[string]*Transaction  `json:"transactions"`
+	Assets       map[string]*Asset        `json:"assets"`
+	Accounts     map[string]*StakeAccount `json:"accounts"`
+	Head         Tip                      `json:"tip"`
+
+	// SlotsPerBlock is how far each applied tx moves the tip
+	SlotsPerBlock uint64 `json:"slots_per_block"`
+
+	failures map[string][]error
+}
+
+func NewFixture() *Fixture {
+	return &Fixture{
+		Transactions:  make(map[string]*Transaction),
+		Assets:        make(map[string]*Asset),
+		Accounts:      make(map[string]*StakeAccount),
+		SlotsPerBlock: 20,
+		Head:          Tip{Epoch: 500, Slot: 100_000_000, Height: 10_000_000, Time: time.Unix(1_700_000_000, 0).UTC()},
+	}
+}
+
+// LoadFixture reads a ledger saved with Save. A missing file gives an empty ledger.
+func LoadFixture(path string) (*Fixture, error) {
+	f := NewFixture()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f *Fixture) Save(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+func (f *Fixture) Name() string { return "fixture" }
+
+// FailNext makes the next call to method (e.g. "AddressBalance") return err.
+func (f *Fixture) FailNext(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures == nil {
+		f.failures = make(map[string][]error)
+	}
+	f.failures[method] = append(f.failures[method], err)
+}
+
+// scripted returns a queued failure; the caller must hold f.mu.
+func (f *Fixture) scripted(method string) error {
+	queue := f.failures[method]
+	if len(queue) == 0 {
+		return nil
+	}
+	f.failures[method] = queue[1:]
+	return queue[0]
+}
+
+func (f *Fixture) AddUTxO(u UTxO) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.UTxOs = append(f.UTxOs, u)
+}
+
+func (f *Fixture) AddAsset(a Asset) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if a.Unit == "" {
+		a.Unit = Unit(a.PolicyID, a.AssetName)
+	}
+	f.Assets[a.Unit] = &a
+}
+
+func (f *Fixture) SetAccount(a StakeAccount) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Accounts[a.StakeAddress] = &a
+}
+
+// AdvanceTip moves the tip forward by the given number of blocks.
+func (f *Fixture) AdvanceTip(blocks int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advance(blocks)
+}
+
+func (f *Fixture) advance(blocks int) {
+	f.Head.Height += uint64(blocks)
+	f.Head.Slot += uint64(blocks) * f.SlotsPerBlock
+	f.Head.Time = f.Head.Time.Add(time.Duration(uint64(blocks)*f.SlotsPerBlock) * time.Second)
+	f.Head.Hash = fmt.Sprintf("%064x", f.Head.Height)
+}
+
+// Apply spends the inputs ("txhash#index"), creates the outputs and includes
+// the tx in a new block.
+func (f *Fixture) Apply(txID string, txIns []string, txOuts []cardano.TxOut) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	spent := make(map[string]bool, len(txIns))
+	for _, in := range txIns {
+		spent[in] = true
+	}
+
+	tx := &Transaction{Hash: txID}
+	remaining := f.UTxOs[:0]
+	for _, u := range f.UTxOs {
+		if spent[u.TxHash+"#"+strconv.Itoa(u.Index)] {
+			tx.Inputs = append(tx.Inputs, u)
+			delete(spent, u.TxHash+"#"+strconv.Itoa(u.Index))
+			continue
+		}
+		remaining = append(remaining, u)
+	}
+	if len(spent) > 0 {
+		// put everything back, nothing was applied
+		f.UTxOs = append(remaining, tx.Inputs...)
+		var missing []string
+		for in := range spent {
+			missing = append(missing, in)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("BadInputsUTxO: %s", strings.Join(missing, ", "))
+	}
+	f.UTxOs = remaining
+
+	var in, out uint64
+	for _, u := range tx.Inputs {
+		in += u.Lovelace
+	}
+	for n, o := range txOuts {
+		utxo := UTxO{TxHash: txID, Index: n, Address: o.Address, Lovelace: o.Lovelace, Assets: o.Assets}
+		f.UTxOs = append(f.UTxOs, utxo)
+		tx.Outputs = append(tx.Outputs, utxo)
+		out += o.Lovelace
+	}
+	if in > out {
+		tx.Fee = in - out
+	}
+
+	f.advance(1)
+	tx.BlockHash = f.Head.Hash
+	tx.BlockHeight = f.Head.Height
+	tx.Slot = f.Head.Slot
+	tx.BlockTime = f.Head.Time
+	f.Transactions[txID] = tx
+	return nil
+}
+
+// UseFixture makes f the chain provider and submitter and replaces
+// cardano-cli with a RecordingExecutor that queries and updates f.
+func UseFixture(f *Fixture) *cardano.RecordingExecutor {
+	rec := cardano.NewRecordingExecutor()
+	rec.UTxOs = f.CLIUTxOs
+	rec.OnSubmit = f.Apply
+
+	Current = f
+	Submitters = NewSubmitFailover(f)
+	cardano.CLI = rec
+	cardano.UTxOs = UTxOSource{Provider: f}
+	return rec
+}
+
+// UseFixtureT gives a test an empty ledger through UseFixture and an empty
+// database in memory, and puts back the chain, cardano-cli and database it
+// replaced when the test ends.
+func UseFixtureT(t testing.TB) (*Fixture, *cardano.RecordingExecutor) {
+	t.Helper()
+	current, submitters, cli, utxos, builder, database := Current, Submitters, cardano.CLI, cardano.UTxOs, cardano.Builder, db.DB
+	t.Cleanup(func() {
+		Current, Submitters, cardano.CLI, cardano.UTxOs, cardano.Builder, db.DB = current, submitters, cli, utxos, builder, database
+	})
+
+	client, err := db.NewMemoryClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB = client
+
+	f := NewFixture()
+	return f, UseFixture(f)
+}
+
+// Submit applies a natively built transaction to the ledger. Missing inputs
+// are rejected the way a node would.
+func (f *Fixture) Submit(ctx context.Context, cbor []byte) (string, error) {
+	f.mu.Lock()
+	err := f.scripted("Submit")
+	f.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := cardano.DecodeTx(cbor)
+	if err != nil {
+		return "", &SubmitError{Status: SubmitRejected, Reason: err.Error()}
+	}
+
+	f.mu.Lock()
+	_, known := f.Transactions[tx.ID]
+	f.mu.Unlock()
+	if known {
+		return "", &SubmitError{Status: SubmitInMempool, Reason: "transaction already exists"}
+	}
+
+	ins := make([]string, len(tx.Inputs))
+	for n, in := range tx.Inputs {
+		ins[n] = in.Ref()
+	}
+	if err := f.Apply(tx.ID, ins, tx.Outputs); err != nil {
+		return "", classifySubmit(err.Error())
+	}
+	return tx.ID, nil
+}
+
+// CLIUTxOs answers cardano-cli `query utxo` for the RecordingExecutor.
+func (f *Fixture) CLIUTxOs(address string) (map[string]cardano.TxOut, error) {
+	utxos, err := f.AddressUTxOs(context.Background(), address)
+	if err != nil {
+		return nil, err
+	}
+
+	outs := make(map[string]cardano.TxOut, len(utxos))
+	for _, u := range utxos {
+		outs[u.TxHash+"#"+strconv.Itoa(u.Index)] = cardano.TxOut{Address: u.Address, Lovelace: u.Lovelace, Assets: u.Assets}
+	}
+	return outs, nil
+}
+
+func (f *Fixture) AddressBalance(ctx context.Context, address string) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.scripted("AddressBalance"); err != nil {
+		return 0, err
+	}
+
+	var lovelace uint64
+	for _, u := range f.UTxOs {
+		if u.Address == address {
+			lovelace += u.Lovelace
+		}
+	}
+	return lovelace, nil
+}
+
+func (f *Fixture) AddressUTxOs(ctx context.Context, address string) ([]UTxO, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.scripted("AddressUTxOs"); err != nil {
+		return nil, err
+	}
+
+	var utxos []UTxO
+	for _, u := range f.UTxOs {
+		if u.Address == address {
+			utxos = append(utxos, u)
+		}
+	}
+	return utxos, nil
+}
+
+func (f *Fixture) Transaction(ctx context.Context, hash string) (*Transaction, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.scripted("Transaction"); err != nil {
+		return nil, err
+	}
+
+	tx, ok := f.Transactions[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: tx %s", ErrNotFound, hash)
+	}
+	copied := *tx
+	return &copied, nil
+}
+
+func (f *Fixture) PolicyHolders(ctx context.Context, policyID string) (map[string]uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.scripted("PolicyHolders"); err != nil {
+		return nil, err
+	}
+
+	holders := make(map[string]uint64)
+	for _, u := range f.UTxOs {
+		for unit, qty := range u.Assets {
+			if strings.HasPrefix(unit, policyID) {
+				holders[u.Address] += qty
+			}
+		}
+	}
+	return holders, nil
+}
+
+func (f *Fixture) AssetInfo(ctx context.Context, unit string) (*Asset, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.scripted("AssetInfo"); err != nil {
+		return nil, err
+	}
+
+	a, ok := f.Assets[unit]
+	if !ok {
+		return nil, fmt.Errorf("%w: asset %s", ErrNotFound, unit)
+	}
+	copied := *a
+	return &copied, nil
+}
+
+func (f *Fixture) StakeAccount(ctx context.Context, stakeAddress string) (*StakeAccount, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.scripted("StakeAccount"); err != nil {
+		return nil, err
+	}
+
+	a, ok := f.Accounts[stakeAddress]
+	if !ok {
+		return nil, fmt.Errorf("%w: account %s", ErrNotFound, stakeAddress)
+	}
+	copied := *a
+	return &copied, nil
+}
+
+func (f *Fixture) Tip(ctx context.Context) (*Tip, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.scripted("Tip"); err != nil {
+		return nil, err
+	}
+
+	tip := f.Head
+	return &tip, nil
+}
@@ -0,0 +1,211 @@
+package chain
+
+import (
+	"cardano-valley/pkg/network"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+
+	koioslib "cardano-valley/pkg/koios"
+
+	"github.com/cardano-community/koios-go-client/v4"
+)
+
+type Koios struct {
+	client *koios.Client
+}
+
+// NewKoios works without a token, a KOIOS_TOKEN only raises the rate limits.
+func NewKoios() (*Koios, error) {
+	client, err := koios.New(koios.Host(network.Current.KoiosHost))
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := os.LookupEnv("KOIOS_TOKEN"); ok && token != "" {
+		if err := client.SetAuth(token); err != nil {
+			return nil, err
+		}
+	}
+	return &Koios{client: client}, nil
+}
+
+func (k *Koios) Name() string { return "koios" }
+
+// koiosErr maps a response status onto the chain errors.
+func koiosErr(rsp koios.Response, err error) error {
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: koios", ErrRateLimited)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: koios", ErrNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if rsp.StatusCode >= 300 {
+		if rsp.Error != nil {
+			return fmt.Errorf("koios %d: %s", rsp.StatusCode, rsp.Error.Message)
+		}
+		return fmt.Errorf("koios %d", rsp.StatusCode)
+	}
+	return nil
+}
+
+func koiosUTxO(u koios.UTxO) UTxO {
+	utxo := UTxO{
+		TxHash:   string(u.TxHash),
+		Index:    u.TxIndex,
+		Lovelace: uint64(u.Value.IntPart()),
+	}
+	if u.Address != nil {
+		utxo.Address = string(*u.Address)
+	}
+	for _, a := range u.AssetList {
+		utxo.Assets = addAsset(utxo.Assets, Unit(string(a.PolicyID), string(a.AssetName)), uint64(a.Quantity.IntPart()))
+	}
+	utxo.DatumHash = string(u.DatumHash)
+	// inline datums and reference scripts come back as {"bytes": ..., "hash": ...}
+	if datum, ok := u.InlineDatum.(map[string]any); ok {
+		utxo.InlineDatum, _ = datum["bytes"].(string)
+	}
+	if script, ok := u.ReferenceScript.(map[string]any); ok {
+		utxo.ReferenceScriptHash, _ = script["hash"].(string)
+	}
+	return utxo
+}
+
+func (k *Koios) AddressBalance(ctx context.Context, address string) (uint64, error) {
+	res, err := k.client.GetAddressesInfo(ctx, []koios.Address{koios.Address(address)}, nil)
+	if err := koiosErr(res.Response, err); err != nil {
+		return 0, err
+	}
+	// Addresses that never received anything are not returned at all
+	if len(res.Data) == 0 {
+		return 0, nil
+	}
+	return uint64(res.Data[0].Balance.IntPart()), nil
+}
+
+func (k *Koios) AddressUTxOs(ctx context.Context, address string) ([]UTxO, error) {
+	res, err := k.client.GetAddressUTxOs(ctx, []koios.Address{koios.Address(address)}, true, nil)
+	if err := koiosErr(res.Response, err); err != nil {
+		return nil, err
+	}
+
+	utxos := make([]UTxO, 0, len(res.Data))
+	for _, u := range res.Data {
+		if u.IsSpent {
+			continue
+		}
+		utxos = append(utxos, koiosUTxO(u))
+	}
+	return utxos, nil
+}
+
+func (k *Koios) Transaction(ctx context.Context, hash string) (*Transaction, error) {
+	res, err := k.client.GetTxInfo(ctx, []koios.TxHash{koios.TxHash(hash)}, nil)
+	if err := koiosErr(res.Response, err); err != nil {
+		return nil, err
+	}
+	if len(res.Data) == 0 {
+		return nil, fmt.Errorf("%w: tx %s", ErrNotFound, hash)
+	}
+
+	info := res.Data[0]
+	tx := &Transaction{
+		Hash:        hash,
+		BlockHash:   string(info.BlockHash),
+		BlockHeight: uint64(info.BlockHeight),
+		Slot:        uint64(info.AbsoluteSlot),
+		BlockTime:   info.TxTimestamp.Time,
+		Fee:         uint64(info.Fee.IntPart()),
+	}
+	for _, in := range info.Inputs {
+		tx.Inputs = append(tx.Inputs, koiosUTxO(in))
+	}
+	for _, out := range info.Outputs {
+		tx.Outputs = append(tx.Outputs, koiosUTxO(out))
+	}
+	return tx, nil
+}
+
+func (k *Koios) PolicyHolders(ctx context.Context, policyID string) (map[string]uint64, error) {
+	return koioslib.GetPolicyHolders(policyID)
+}
+
+func (k *Koios) AssetInfo(ctx context.Context, unit string) (*Asset, error) {
+	policyID, name := SplitUnit(unit)
+	res, err := k.client.GetAssetInfo(ctx, []koios.Asset{{PolicyID: koios.PolicyID(policyID), AssetName: koios.AssetName(name)}}, nil)
+	if err := koiosErr(res.Response, err); err != nil {
+		return nil, err
+	}
+	if len(res.Data) == 0 {
+		return nil, fmt.Errorf("%w: asset %s", ErrNotFound, unit)
+	}
+
+	a := res.Data[0]
+	asset := &Asset{
+		Unit:        unit,
+		PolicyID:    string(a.PolicyID),
+		AssetName:   string(a.AssetName),
+		Fingerprint: string(a.Fingerprint),
+		Quantity:    uint64(a.TotalSupply.IntPart()),
+	}
+	switch {
+	case a.CIP68Metadata != nil:
+		asset.Metadata = *a.CIP68Metadata
+	case a.MintingTxMetadata != nil:
+		asset.Metadata = *a.MintingTxMetadata
+	}
+	return asset, nil
+}
+
+func (k *Koios) StakeAccount(ctx context.Context, stakeAddress string) (*StakeAccount, error) {
+	res, err := k.client.GetAccountInfo(ctx, []koios.Address{koios.Address(stakeAddress)}, nil)
+	if err := koiosErr(res.Response, err); err != nil {
+		return nil, err
+	}
+	if len(res.Data) == 0 {
+		return nil, fmt.Errorf("%w: account %s", ErrNotFound, stakeAddress)
+	}
+
+	acc := res.Data[0]
+	account := &StakeAccount{
+		StakeAddress:       string(acc.StakeAddress),
+		Active:             acc.Status == "registered",
+		ControlledLovelace: uint64(acc.TotalBalance.IntPart()),
+		RewardsAvailable:   uint64(acc.RewardsAvailable.IntPart()),
+	}
+	if acc.DelegatedPool != nil {
+		account.PoolID = string(*acc.DelegatedPool)
+	}
+	return account, nil
+}
+
+func (k *Koios) Tip(ctx context.Context) (*Tip, error) {
+	res, err := k.client.GetTip(ctx, nil)
+	if err := koiosErr(res.Response, err); err != nil {
+		return nil, err
+	}
+
+	return &Tip{
+		Epoch:  int(res.Data.EpochNo),
+		Slot:   uint64(res.Data.AbsSlot),
+		Height: uint64(res.Data.BlockNo),
+		Hash:   string(res.Data.Hash),
+		Time:   res.Data.BlockTime.Time,
+	}, nil
+}
+
+func (k *Koios) Submit(ctx context.Context, cbor []byte) (string, error) {
+	res, err := k.client.SubmitSignedTx(ctx, koios.TxBodyJSON{CborHex: hex.EncodeToString(cbor)}, nil)
+	if res.StatusCode == http.StatusBadRequest && res.Error != nil {
+		return "", badRequest(res.Error.Message)
+	}
+	if err := koiosErr(res.Response, err); err != nil {
+		return "", err
+	}
+	return string(res.Data), nil
+}
@@ -0,0 +1,351 @@
+package chain
+
+import (
+	"bytes"
+	"cardano-valley/pkg/network"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maestro talks to the REST API directly rather than through pkg/maestro so
+// the chain package does not depend on the Maestro SDK.
+type Maestro struct {
+	token   string
+	baseURL string
+	http    *http.Client
+}
+
+func NewMaestro() (*Maestro, error) {
+	token, ok := os.LookupEnv("MAESTRO_TOKEN")
+	if !ok || token == "" {
+		return nil, errors.New("MAESTRO_TOKEN is not set")
+	}
+
+	return &Maestro{
+		token:   token,
+		baseURL: network.Current.MaestroURL(),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (m *Maestro) Name() string { return "maestro" }
+
+// maestroUint accepts quantities encoded either as JSON numbers or strings.
+type maestroUint uint64
+
+func (u *maestroUint) UnmarshalJSON(b []byte) error {
+	b = bytes.Trim(b, `"`)
+	if len(b) == 0 || string(b) == "null" {
+		return nil
+	}
+	v, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return err
+	}
+	*u = maestroUint(v)
+	return nil
+}
+
+type (
+	maestroAsset struct {
+		Unit   string      `json:"unit"`
+		Amount maestroUint `json:"amount"`
+	}
+
+	maestroUTxO struct {
+		TxHash  string         `json:"tx_hash"`
+		Index   int            `json:"index"`
+		Address string         `json:"address"`
+		Assets  []maestroAsset `json:"assets"`
+		Datum   *struct {
+			Type  string `json:"type"` // "hash" or "inline"
+			Hash  string `json:"hash"`
+			Bytes string `json:"bytes"`
+		} `json:"datum"`
+		ReferenceScript *struct {
+			Hash string `json:"hash"`
+		} `json:"reference_script"`
+	}
+)
+
+func (u maestroUTxO) utxo() UTxO {
+	utxo := UTxO{TxHash: u.TxHash, Index: u.Index, Address: u.Address}
+	for _, a := range u.Assets {
+		if a.Unit == "lovelace" {
+			utxo.Lovelace += uint64(a.Amount)
+		} else {
+			utxo.Assets = addAsset(utxo.Assets, a.Unit, uint64(a.Amount))
+		}
+	}
+	if u.Datum != nil {
+		utxo.DatumHash = u.Datum.Hash
+		if u.Datum.Type == "inline" {
+			utxo.InlineDatum = u.Datum.Bytes
+		}
+	}
+	if u.ReferenceScript != nil {
+		utxo.ReferenceScriptHash = u.ReferenceScript.Hash
+	}
+	return utxo
+}
+
+// get fetches path and decodes the "data" envelope into v, returning the
+// pagination cursor if there is one.
+func (m *Maestro) get(ctx context.Context, path string, query url.Values, v any) (string, error) {
+	endpoint := m.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("api-key", m.token)
+	req.Header.Set("Accept", "application/json")
+
+	rsp, err := m.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	switch {
+	case rsp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: maestro %s", ErrNotFound, path)
+	case rsp.StatusCode == http.StatusTooManyRequests:
+		return "", fmt.Errorf("%w: maestro", ErrRateLimited)
+	case rsp.StatusCode >= 300:
+		body, _ := io.ReadAll(rsp.Body)
+		return "", fmt.Errorf("maestro %s: %d %s", path, rsp.StatusCode, string(body))
+	}
+
+	var envelope struct {
+		Data       json.RawMessage `json:"data"`
+		NextCursor *string         `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(&envelope); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		return "", err
+	}
+	if envelope.NextCursor != nil {
+		return *envelope.NextCursor, nil
+	}
+	return "", nil
+}
+
+func (m *Maestro) AddressBalance(ctx context.Context, address string) (uint64, error) {
+	utxos, err := m.AddressUTxOs(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+
+	var lovelace uint64
+	for _, u := range utxos {
+		lovelace += u.Lovelace
+	}
+	return lovelace, nil
+}
+
+func (m *Maestro) AddressUTxOs(ctx context.Context, address string) ([]UTxO, error) {
+	var (
+		utxos  []UTxO
+		cursor string
+	)
+	for {
+		query := url.Values{"count": {"100"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var page []maestroUTxO
+		next, err := m.get(ctx, "addresses/"+address+"/utxos", query, &page)
+		if errors.Is(err, ErrNotFound) {
+			return utxos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range page {
+			utxos = append(utxos, u.utxo())
+		}
+
+		if next == "" {
+			return utxos, nil
+		}
+		cursor = next
+	}
+}
+
+func (m *Maestro) Transaction(ctx context.Context, hash string) (*Transaction, error) {
+	var data struct {
+		TxHash         string        `json:"tx_hash"`
+		BlockHash      string        `json:"block_hash"`
+		BlockHeight    uint64        `json:"block_height"`
+		BlockSlot      uint64        `json:"block_absolute_slot"`
+		BlockTimestamp int64         `json:"block_timestamp"`
+		Fee            maestroUint   `json:"fee"`
+		Inputs         []maestroUTxO `json:"inputs"`
+		Outputs        []maestroUTxO `json:"outputs"`
+	}
+	if _, err := m.get(ctx, "transactions/"+hash, nil, &data); err != nil {
+		return nil, err
+	}
+
+	tx := &Transaction{
+		Hash:        data.TxHash,
+		BlockHash:   data.BlockHash,
+		BlockHeight: data.BlockHeight,
+		Slot:        data.BlockSlot,
+		BlockTime:   time.Unix(data.BlockTimestamp, 0),
+		Fee:         uint64(data.Fee),
+	}
+	for _, in := range data.Inputs {
+		tx.Inputs = append(tx.Inputs, in.utxo())
+	}
+	for _, out := range data.Outputs {
+		tx.Outputs = append(tx.Outputs, out.utxo())
+	}
+	return tx, nil
+}
+
+func (m *Maestro) PolicyHolders(ctx context.Context, policyID string) (map[string]uint64, error) {
+	holders := make(map[string]uint64)
+	var cursor string
+	for {
+		query := url.Values{"count": {"100"}}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+
+		var page []struct {
+			Address string `json:"address"`
+			Assets  []struct {
+				Name   string      `json:"name"`
+				Amount maestroUint `json:"amount"`
+			} `json:"assets"`
+		}
+		next, err := m.get(ctx, "policy/"+policyID+"/addresses", query, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range page {
+			for _, a := range h.Assets {
+				holders[h.Address] += uint64(a.Amount)
+			}
+		}
+
+		if next == "" {
+			return holders, nil
+		}
+		cursor = next
+	}
+}
+
+func (m *Maestro) AssetInfo(ctx context.Context, unit string) (*Asset, error) {
+	var data struct {
+		Fingerprint string          `json:"fingerprint"`
+		TotalSupply maestroUint     `json:"total_supply"`
+		AssetStd    json.RawMessage `json:"asset_standards"`
+	}
+	if _, err := m.get(ctx, "assets/"+unit, nil, &data); err != nil {
+		return nil, err
+	}
+
+	policyID, name := SplitUnit(unit)
+	return &Asset{
+		Unit:        unit,
+		PolicyID:    policyID,
+		AssetName:   name,
+		Fingerprint: data.Fingerprint,
+		Quantity:    uint64(data.TotalSupply),
+		Metadata:    data.AssetStd,
+	}, nil
+}
+
+func (m *Maestro) StakeAccount(ctx context.Context, stakeAddress string) (*StakeAccount, error) {
+	var data struct {
+		StakeAddress     string      `json:"stake_address"`
+		Registered       bool        `json:"registered"`
+		DelegatedPool    *string     `json:"delegated_pool"`
+		TotalBalance     maestroUint `json:"total_balance"`
+		RewardsAvailable maestroUint `json:"rewards_available"`
+	}
+	if _, err := m.get(ctx, "accounts/"+stakeAddress, nil, &data); err != nil {
+		return nil, err
+	}
+
+	account := &StakeAccount{
+		StakeAddress:       data.StakeAddress,
+		Active:             data.Registered,
+		ControlledLovelace: uint64(data.TotalBalance),
+		RewardsAvailable:   uint64(data.RewardsAvailable),
+	}
+	if data.DelegatedPool != nil {
+		account.PoolID = *data.DelegatedPool
+	}
+	return account, nil
+}
+
+func (m *Maestro) Tip(ctx context.Context) (*Tip, error) {
+	var tip struct {
+		BlockHash string `json:"block_hash"`
+		Slot      uint64 `json:"slot"`
+		Height    uint64 `json:"height"`
+	}
+	if _, err := m.get(ctx, "chain-tip", nil, &tip); err != nil {
+		return nil, err
+	}
+
+	var epoch struct {
+		EpochNo int `json:"epoch_no"`
+	}
+	if _, err := m.get(ctx, "epochs/current", nil, &epoch); err != nil {
+		return nil, err
+	}
+
+	return &Tip{
+		Epoch:  epoch.EpochNo,
+		Slot:   tip.Slot,
+		Height: tip.Height,
+		Hash:   tip.BlockHash,
+		Time:   time.Now(),
+	}, nil
+}
+
+func (m *Maestro) Submit(ctx context.Context, cbor []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"txmanager", bytes.NewReader(cbor))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("api-key", m.token)
+	req.Header.Set("Content-Type", "application/cbor")
+
+	rsp, err := m.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+
+	body, _ := io.ReadAll(rsp.Body)
+	switch {
+	case rsp.StatusCode == http.StatusTooManyRequests:
+		return "", fmt.Errorf("%w: maestro", ErrRateLimited)
+	case rsp.StatusCode == http.StatusBadRequest:
+		return "", badRequest(string(body))
+	case rsp.StatusCode >= 300:
+		return "", fmt.Errorf("maestro txmanager: %d %s", rsp.StatusCode, string(body))
+	}
+	return strings.Trim(strings.TrimSpace(string(body)), `"`), nil
+}
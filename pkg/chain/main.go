@@ -0,0 +1,170 @@
+// Package chain puts Blockfrost, Koios and Maestro behind a single Provider
+// interface so callers are not tied to one indexer. Current is configured by
+// CARDANO_VALLEY_PROVIDERS and fails over between the listed providers;
+// Submitters does the same for sending transactions.
+package chain
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	Provider interface {
+		Name() string
+		AddressBalance(ctx context.Context, address string) (uint64, error)
+		AddressUTxOs(ctx context.Context, address string) ([]UTxO, error)
+		Transaction(ctx context.Context, hash string) (*Transaction, error)
+		PolicyHolders(ctx context.Context, policyID string) (map[string]uint64, error)
+		AssetInfo(ctx context.Context, unit string) (*Asset, error)
+		StakeAccount(ctx context.Context, stakeAddress string) (*StakeAccount, error)
+		Tip(ctx context.Context) (*Tip, error)
+	}
+
+	UTxO struct {
+		TxHash   string            `json:"tx_hash"`
+		Index    int               `json:"index"`
+		Address  string            `json:"address"`
+		Lovelace uint64            `json:"lovelace"`
+		Assets   map[string]uint64 `json:"assets,omitempty"` // unit (policy ID + hex asset name) -> quantity
+
+		DatumHash           string `json:"datum_hash,omitempty"`
+		InlineDatum         string `json:"inline_datum,omitempty"` // CBOR hex
+		ReferenceScriptHash string `json:"reference_script_hash,omitempty"`
+	}
+
+	Transaction struct {
+		Hash        string    `json:"hash"`
+		BlockHash   string    `json:"block_hash"`
+		BlockHeight uint64    `json:"block_height"`
+		Slot        uint64    `json:"slot"`
+		BlockTime   time.Time `json:"block_time"`
+		Fee         uint64    `json:"fee"`
+		Inputs      []UTxO    `json:"inputs"`
+		Outputs     []UTxO    `json:"outputs"`
+	}
+
+	Asset struct {
+		Unit        string          `json:"unit"`
+		PolicyID    string          `json:"policy_id"`
+		AssetName   string          `json:"asset_name"` // hex
+		Fingerprint string          `json:"fingerprint"`
+		Quantity    uint64          `json:"quantity"`
+		Metadata    json.RawMessage `json:"metadata,omitempty"` // on-chain (CIP-25/68) metadata as returned by the provider
+	}
+
+	StakeAccount struct {
+		StakeAddress       string `json:"stake_address"`
+		Active             bool   `json:"active"`
+		PoolID             string `json:"pool_id,omitempty"`
+		ControlledLovelace uint64 `json:"controlled_lovelace"`
+		RewardsAvailable   uint64 `json:"rewards_available"`
+	}
+
+	Tip struct {
+		Epoch  int       `json:"epoch"`
+		Slot   uint64    `json:"slot"`
+		Height uint64    `json:"height"`
+		Hash   string    `json:"hash"`
+		Time   time.Time `json:"time"`
+	}
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrRateLimited = errors.New("rate limited")
+	ErrUnsupported = errors.New("not supported by provider")
+
+	// Current is the provider used by the rest of the bot
+	Current Provider
+)
+
+func init() {
+	// Offline runs use a fixture ledger and never touch a provider or node
+	if path, ok := os.LookupEnv("CARDANO_VALLEY_OFFLINE"); ok && path != "" {
+		fixture, err := LoadFixture(path)
+		if err != nil {
+			logger.Record.Error("CHAIN", "FIXTURE", path, "ERROR", err)
+			fixture = NewFixture()
+		}
+		UseFixture(fixture)
+		logger.Record.Info("CHAIN", "OFFLINE FIXTURE", path)
+		return
+	}
+
+	names, ok := os.LookupEnv("CARDANO_VALLEY_PROVIDERS")
+	if !ok || names == "" {
+		names = "blockfrost,koios,maestro"
+	}
+
+	var providers []Provider
+	for _, name := range strings.Split(names, ",") {
+		p, err := New(strings.TrimSpace(name))
+		if err != nil {
+			logger.Record.Warn("CHAIN", "PROVIDER SKIPPED", name, "ERROR", err)
+			continue
+		}
+		providers = append(providers, p)
+	}
+
+	Current = NewFailover(providers...)
+	logger.Record.Info("CHAIN", "PROVIDERS", Current.Name())
+
+	Submitters = newSubmitters()
+	cardano.UTxOs = newUTxOSource()
+}
+
+// New returns the named provider, configured from the environment.
+func New(name string) (Provider, error) {
+	switch strings.ToLower(name) {
+	case "blockfrost":
+		return NewBlockfrost()
+	case "koios":
+		return NewKoios()
+	case "maestro":
+		return NewMaestro()
+	default:
+		return nil, fmt.Errorf("unknown chain provider %q", name)
+	}
+}
+
+// Unit joins a policy ID and hex asset name.
+func Unit(policyID, assetName string) string {
+	return policyID + assetName
+}
+
+// SplitUnit separates a unit into policy ID and hex asset name.
+func SplitUnit(unit string) (string, string) {
+	if len(unit) < 56 {
+		return unit, ""
+	}
+	return unit[:56], unit[56:]
+}
+
+// TxInputs converts UTxOs for cardano.Builder.
+func TxInputs(utxos []UTxO) []cardano.TxInput {
+	inputs := make([]cardano.TxInput, len(utxos))
+	for n, u := range utxos {
+		inputs[n] = cardano.TxInput{
+			TxHash: u.TxHash,
+			Index:  u.Index,
+			Output: cardano.TxOut{Address: u.Address, Lovelace: u.Lovelace, Assets: u.Assets},
+		}
+	}
+	return inputs
+}
+
+func addAsset(assets map[string]uint64, unit string, qty uint64) map[string]uint64 {
+	if assets == nil {
+		assets = make(map[string]uint64)
+	}
+	assets[unit] += qty
+	return assets
+}
@@ -0,0 +1,268 @@
+package chain
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// Submitter sends a signed transaction (raw CBOR) to the network and
+	// returns its ID. A rejection by the ledger is reported as *SubmitError.
+	Submitter interface {
+		Name() string
+		Submit(ctx context.Context, cbor []byte) (string, error)
+	}
+
+	SubmitStatus string
+
+	SubmitResult struct {
+		TxID      string
+		Status    SubmitStatus
+		Reason    string // why the tx was rejected, or the last transient error
+		Submitter string
+	}
+
+	// SubmitError is returned by submitters when the node or API gave a
+	// definite answer about the transaction.
+	SubmitError struct {
+		Status SubmitStatus
+		Reason string
+	}
+
+	// SubmitFailover retries transient failures on each submitter before
+	// moving on to the next. Definite answers stop it straight away, except
+	// spent inputs after a failed attempt, which may be the tx itself.
+	SubmitFailover struct {
+		submitters []Submitter
+		attempts   int
+		backoff    time.Duration
+	}
+
+	// Node submits through cardano-cli and the local node socket.
+	Node struct{}
+)
+
+const (
+	SubmitAccepted  SubmitStatus = "accepted"
+	SubmitInMempool SubmitStatus = "already_in_mempool" // submitted before, nothing to do
+	SubmitRejected  SubmitStatus = "rejected"           // the ledger refused it, retrying will not help
+	SubmitFailed    SubmitStatus = "failed"             // no submitter could be reached
+)
+
+var (
+	ErrTxRejected = errors.New("transaction rejected")
+
+	// Submitters is configured by CARDANO_VALLEY_SUBMITTERS
+	Submitters *SubmitFailover
+)
+
+func (e *SubmitError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Status, e.Reason)
+}
+
+// classifySubmit turns a submitter's error message into a result. APIs wrap
+// the node's ApplyTxError in different envelopes, so the reason text is what
+// they have in common.
+func classifySubmit(reason string) error {
+	lower := strings.ToLower(reason)
+	switch {
+	case strings.Contains(lower, "alreadyinmempool"),
+		strings.Contains(lower, "already in mempool"),
+		strings.Contains(lower, "already been included"),
+		strings.Contains(lower, "transaction already exists"):
+		return &SubmitError{Status: SubmitInMempool, Reason: reason}
+	case strings.Contains(lower, "applytxerror"),
+		strings.Contains(lower, "validationerror"),
+		strings.Contains(lower, "utxowfailure"),
+		strings.Contains(lower, "utxofailure"),
+		strings.Contains(lower, "badinputsutxo"),
+		strings.Contains(lower, "valuenotconserved"),
+		strings.Contains(lower, "feetoosmall"),
+		strings.Contains(lower, "outsidevalidityinterval"),
+		strings.Contains(lower, "deserialise"),
+		strings.Contains(lower, "missingvkeywitnesses"):
+		return &SubmitError{Status: SubmitRejected, Reason: reason}
+	}
+	return errors.New(reason)
+}
+
+// badRequest classifies an API's 400 response, which is always a definite
+// answer even when the reason is not one classifySubmit knows.
+func badRequest(reason string) error {
+	var subErr *SubmitError
+	if err := classifySubmit(reason); errors.As(err, &subErr) {
+		return err
+	}
+	return &SubmitError{Status: SubmitRejected, Reason: reason}
+}
+
+// newSubmitters builds the failover from CARDANO_VALLEY_SUBMITTERS. The local
+// node is skipped when there is no socket, so API submission takes over.
+func newSubmitters() *SubmitFailover {
+	names, ok := os.LookupEnv("CARDANO_VALLEY_SUBMITTERS")
+	if !ok || names == "" {
+		names = "node,blockfrost,koios,maestro"
+	}
+
+	var submitters []Submitter
+	for _, name := range strings.Split(names, ",") {
+		s, err := NewSubmitter(strings.TrimSpace(name))
+		if err != nil {
+			logger.Record.Warn("CHAIN", "SUBMITTER SKIPPED", name, "ERROR", err)
+			continue
+		}
+		submitters = append(submitters, s)
+	}
+
+	f := NewSubmitFailover(submitters...)
+	logger.Record.Info("CHAIN", "SUBMITTERS", f.Name())
+	return f
+}
+
+// NewSubmitter returns the named submitter, configured from the environment.
+func NewSubmitter(name string) (Submitter, error) {
+	switch strings.ToLower(name) {
+	case "node":
+		return NewNode()
+	case "blockfrost":
+		return NewBlockfrost()
+	case "koios":
+		return NewKoios()
+	case "maestro":
+		return NewMaestro()
+	default:
+		return nil, fmt.Errorf("unknown submitter %q", name)
+	}
+}
+
+func NewSubmitFailover(submitters ...Submitter) *SubmitFailover {
+	return &SubmitFailover{submitters: submitters, attempts: 3, backoff: 2 * time.Second}
+}
+
+func (f *SubmitFailover) Name() string {
+	names := make([]string, len(f.submitters))
+	for i, s := range f.submitters {
+		names[i] = s.Name()
+	}
+	return "submit(" + strings.Join(names, ",") + ")"
+}
+
+// Submit sends a signed transaction with the configured submitters.
+func Submit(ctx context.Context, cbor []byte) (*SubmitResult, error) {
+	return Submitters.Submit(ctx, cbor)
+}
+
+func (f *SubmitFailover) Submit(ctx context.Context, cbor []byte) (*SubmitResult, error) {
+	tx, err := cardano.DecodeTx(cbor)
+	if err != nil {
+		return nil, fmt.Errorf("submit: %w", err)
+	}
+	result := &SubmitResult{TxID: tx.ID, Status: SubmitFailed}
+
+	var (
+		errs      []error
+		transient bool
+	)
+	for _, s := range f.submitters {
+		result.Submitter = s.Name()
+		for attempt := 1; attempt <= f.attempts; attempt++ {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+
+			_, err := s.Submit(ctx, cbor)
+			if err == nil {
+				result.Status = SubmitAccepted
+				logger.Record.Info("CHAIN", "SUBMITTED", tx.ID, "SUBMITTER", s.Name())
+				return result, nil
+			}
+
+			var subErr *SubmitError
+			if errors.As(err, &subErr) {
+				result.Status, result.Reason = subErr.Status, subErr.Reason
+				if subErr.Status == SubmitInMempool {
+					logger.Record.Info("CHAIN", "ALREADY SUBMITTED", tx.ID, "SUBMITTER", s.Name())
+					return result, nil
+				}
+				if transient && badInputs(subErr.Reason) {
+					spentByEarlierAttempt(ctx, result)
+					return result, nil
+				}
+				logger.Record.Error("CHAIN", "REJECTED", tx.ID, "SUBMITTER", s.Name(), "REASON", subErr.Reason)
+				return result, fmt.Errorf("%w by %s: %s", ErrTxRejected, s.Name(), subErr.Reason)
+			}
+
+			transient = true
+			result.Reason = err.Error()
+			errs = append(errs, fmt.Errorf("%s attempt %d: %w", s.Name(), attempt, err))
+			logger.Record.Warn("CHAIN", "SUBMIT FAILED", tx.ID, "SUBMITTER", s.Name(), "ATTEMPT", attempt, "ERROR", err)
+
+			// rate limits will not clear within a few seconds, try the next one
+			if errors.Is(err, ErrRateLimited) || attempt == f.attempts {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-time.After(f.backoff * time.Duration(attempt)):
+			}
+		}
+	}
+
+	result.Status = SubmitFailed
+	if len(errs) == 0 {
+		return result, errors.New("no submitters configured")
+	}
+	return result, errors.Join(errs...)
+}
+
+// badInputs reports whether the ledger refused a tx because its inputs are
+// already spent.
+func badInputs(reason string) bool {
+	return strings.Contains(strings.ToLower(reason), "badinputsutxo")
+}
+
+// spentByEarlierAttempt settles a BadInputsUTxO answer that follows a failed
+// attempt. A submitter that timed out may still have passed the tx on, and
+// then its own inputs are what is spent, so it is reported as in the mempool
+// and left to the confirm tracker, which drops it at its TTL if it never
+// lands. Reporting it rejected would let the caller build a second payment.
+func spentByEarlierAttempt(ctx context.Context, result *SubmitResult) {
+	result.Status = SubmitInMempool
+	if Current != nil {
+		if _, err := Current.Transaction(ctx, result.TxID); err == nil {
+			logger.Record.Info("CHAIN", "ALREADY ON CHAIN", result.TxID, "SUBMITTER", result.Submitter)
+			return
+		}
+	}
+	logger.Record.Warn("CHAIN", "INPUTS SPENT AFTER FAILED ATTEMPT", result.TxID, "SUBMITTER", result.Submitter, "REASON", result.Reason)
+}
+
+// ─── Local node ───
+
+func NewNode() (*Node, error) {
+	if socket, ok := os.LookupEnv("CARDANO_NODE_SOCKET_PATH"); !ok || socket == "" {
+		return nil, errors.New("CARDANO_NODE_SOCKET_PATH is not set")
+	}
+	return &Node{}, nil
+}
+
+func (n *Node) Name() string { return "node" }
+
+func (n *Node) Submit(ctx context.Context, cbor []byte) (string, error) {
+	tx, err := cardano.DecodeTx(cbor)
+	if err != nil {
+		return "", err
+	}
+	txID, err := cardano.Submit(tx)
+	if err != nil {
+		return "", classifySubmit(err.Error())
+	}
+	return txID, nil
+}
@@ -0,0 +1,80 @@
+package chain
+
+import (
+	"cardano-valley/pkg/cardano"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// scriptedSubmitter answers each Submit with the next queued error.
+type scriptedSubmitter struct {
+	answers []error
+}
+
+func (s *scriptedSubmitter) Name() string { return "scripted" }
+
+func (s *scriptedSubmitter) Submit(ctx context.Context, cbor []byte) (string, error) {
+	err := s.answers[0]
+	s.answers = s.answers[1:]
+	return "", err
+}
+
+func testTx(t *testing.T) *cardano.Tx {
+	t.Helper()
+	const address = "addr1vx2fxv2umyhttkxyxp8x0dlpdt3k6cwng5pxj3jhsydzers66hrl8"
+	tx, err := (&cardano.NativeBuilder{}).Build(cardano.TxRequest{
+		Inputs: []cardano.TxInput{{
+			TxHash: strings.Repeat("ab", 32),
+			Output: cardano.TxOut{Address: address, Lovelace: 10_000_000},
+		}},
+		Outputs:       []cardano.TxOut{{Address: address, Lovelace: 2_000_000}},
+		ChangeAddress: address,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestSubmitBadInputsAfterTransientFailure(t *testing.T) {
+	tx := testTx(t)
+	badInputs := &SubmitError{Status: SubmitRejected, Reason: "ApplyTxError: BadInputsUTxO"}
+	timeout := errors.New("timeout")
+
+	previous := Current
+	t.Cleanup(func() { Current = previous })
+
+	tests := []struct {
+		name    string
+		answers []error
+		onChain bool
+		status  SubmitStatus
+		err     error
+	}{
+		{"rejected straight away", []error{badInputs}, false, SubmitRejected, ErrTxRejected},
+		{"earlier attempt landed", []error{timeout, badInputs}, true, SubmitInMempool, nil},
+		{"earlier attempt may be in a mempool", []error{timeout, badInputs}, false, SubmitInMempool, nil},
+		{"other rejection after a timeout", []error{timeout, &SubmitError{Status: SubmitRejected, Reason: "FeeTooSmallUTxO"}}, false, SubmitRejected, ErrTxRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture := NewFixture()
+			if tt.onChain {
+				fixture.Transactions[tx.ID] = &Transaction{Hash: tx.ID, BlockHash: "bb"}
+			}
+			Current = fixture
+
+			f := NewSubmitFailover(&scriptedSubmitter{answers: tt.answers})
+			f.backoff = 0
+			result, err := f.Submit(context.Background(), tx.CBOR)
+			if result.Status != tt.status || !errors.Is(err, tt.err) {
+				t.Errorf("Submit = %s, %v, want %s, %v", result.Status, err, tt.status, tt.err)
+			}
+			if result.TxID != tx.ID {
+				t.Errorf("tx ID %s, want %s", result.TxID, tx.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package chain
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/logger"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// UTxOSource answers cardano.QueryUTxOs from a provider instead of the node.
+type UTxOSource struct {
+	Provider Provider // nil uses Current
+}
+
+func (s UTxOSource) provider() Provider {
+	if s.Provider != nil {
+		return s.Provider
+	}
+	return Current
+}
+
+func (s UTxOSource) Name() string { return "provider:" + s.provider().Name() }
+
+func (s UTxOSource) QueryUTxOs(ctx context.Context, address string) (cardano.UTxOMap, error) {
+	utxos, err := s.provider().AddressUTxOs(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("utxo query %s: %w", address, err)
+	}
+	entries := make(cardano.UTxOMap, len(utxos))
+	for _, u := range utxos {
+		entries[u.TxHash+"#"+strconv.Itoa(u.Index)] = u.Entry()
+	}
+	return entries, nil
+}
+
+// Entry converts the UTxO to cardano-cli's JSON model.
+func (u UTxO) Entry() cardano.UTxOEntry {
+	entry := cardano.UTxOEntry{
+		Address:        u.Address,
+		DatumHash:      u.DatumHash,
+		InlineDatumRaw: u.InlineDatum,
+		Value:          cardano.NewUTxOValue(u.Lovelace, u.Assets),
+	}
+	if u.ReferenceScriptHash != "" {
+		entry.ReferenceScript = &cardano.ReferenceScript{Hash: u.ReferenceScriptHash}
+	}
+	return entry
+}
+
+// newUTxOSource reads CARDANO_VALLEY_UTXO_SOURCE ("node" or "provider").
+// Without it the node is used when there is a socket to reach it.
+func newUTxOSource() cardano.UTxOSource {
+	source := os.Getenv("CARDANO_VALLEY_UTXO_SOURCE")
+	if source == "" {
+		source = "provider"
+		if os.Getenv("CARDANO_NODE_SOCKET_PATH") != "" {
+			source = "node"
+		}
+	}
+
+	switch source {
+	case "node":
+		logger.Record.Info("CHAIN", "UTXO SOURCE", "node")
+		return cardano.NodeUTxOs{}
+	case "provider":
+		logger.Record.Info("CHAIN", "UTXO SOURCE", "provider")
+		return UTxOSource{}
+	default:
+		logger.Record.Error("CHAIN", "UNKNOWN UTXO SOURCE", source, "USING", "provider")
+		return UTxOSource{}
+	}
+}
@@ -0,0 +1,352 @@
+// Package confirm follows submitted transactions until they are buried deep
+// enough to be final. The airdrop and harvest flows Track the txs they submit
+// and either Wait on them or Subscribe to the events the poller emits when a
+// tx is confirmed, dropped after its TTL, or rolled back out of a block.
+package confirm
+
+import (
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Purpose says which flow submitted the tx.
+	Purpose string
+
+	Status string
+
+	// TrackedTx is the persisted state of a submitted transaction.
+	TrackedTx struct {
+		TxHash      string    `bson:"tx_hash"`
+		Purpose     Purpose   `bson:"purpose"`
+		Reference   string    `bson:"reference,omitempty"` // airdrop session ID, discord user ID, ...
+		Status      Status    `bson:"status"`
+		SubmittedAt time.Time `bson:"submitted_at"`
+		TTL         uint64    `bson:"ttl,omitempty"` // last valid slot, 0 for none
+		BlockHash   string    `bson:"block_hash,omitempty"`
+		BlockHeight uint64    `bson:"block_height,omitempty"`
+		Slot        uint64    `bson:"slot,omitempty"`
+		Depth       uint64    `bson:"depth"`
+		Rollbacks   int       `bson:"rollbacks,omitempty"`
+		Misses      int       `bson:"misses,omitempty"` // polls in a row an included tx was not found
+		UpdatedAt   time.Time `bson:"updated_at"`
+		ConfirmedAt time.Time `bson:"confirmed_at,omitempty"`
+	}
+
+	// Event is emitted when a tracked tx is confirmed, dropped or rolled back.
+	Event struct {
+		Status Status
+		Tx     TrackedTx
+	}
+
+	Store interface {
+		Save(ctx context.Context, tx TrackedTx) error
+		Load(ctx context.Context, hash string) (TrackedTx, error)
+		// Open returns every tx that has not reached a final status.
+		Open(ctx context.Context) ([]TrackedTx, error)
+	}
+
+	Tracker struct {
+		Store    Store
+		Provider chain.Provider // nil uses chain.Current
+		Depth    uint64         // blocks on top of the tx's block, including it
+		Interval time.Duration
+		// DropAfter gives up on txs without a TTL that never show up
+		DropAfter time.Duration
+
+		mu       sync.Mutex
+		handlers []func(Event)
+		waiters  map[string][]chan Event
+	}
+)
+
+const (
+	PurposeAirdrop    Purpose = "airdrop_batch"
+	PurposeServiceFee Purpose = "service_fee"
+	PurposeHarvest    Purpose = "harvest"
+	PurposeDelegation Purpose = "delegation"
+	PurposeClaimFee   Purpose = "claim_fee" // paid by the user from their own wallet
+
+	StatusPending    Status = "pending"     // submitted, not seen in a block yet
+	StatusIncluded   Status = "included"    // in a block, not deep enough yet
+	StatusConfirmed  Status = "confirmed"   // final
+	StatusRolledBack Status = "rolled_back" // its block was rolled back, waiting to be included again
+	StatusDropped    Status = "dropped"     // final, expired or never seen
+)
+
+const (
+	// A tx is only dropped once the tip is this many blocks past its TTL,
+	// so providers that lag behind have caught up by then
+	slotsPerBlock = 20
+
+	// polls in a row an included tx has to be missing before it counts as
+	// rolled back, rather than a provider answering from a stale index
+	rollbackMisses = 3
+)
+
+var (
+	ErrDropped = errors.New("transaction dropped")
+
+	// Default is configured by CARDANO_VALLEY_CONFIRMATIONS and persists to
+	// Mongo, or to memory in offline mode.
+	Default = newDefault()
+)
+
+func newDefault() *Tracker {
+	depth := uint64(10)
+	if v, ok := os.LookupEnv("CARDANO_VALLEY_CONFIRMATIONS"); ok && v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 {
+			logger.Record.Error("CONFIRM", "INVALID CARDANO_VALLEY_CONFIRMATIONS", v, "USING", depth)
+		} else {
+			depth = n
+		}
+	}
+
+	var store Store = MongoStore{}
+	if os.Getenv("CARDANO_VALLEY_OFFLINE") != "" {
+		store = NewMemoryStore()
+	}
+	return New(store, depth)
+}
+
+func New(store Store, depth uint64) *Tracker {
+	return &Tracker{
+		Store:     store,
+		Depth:     depth,
+		Interval:  30 * time.Second,
+		DropAfter: 2 * time.Hour,
+		waiters:   make(map[string][]chan Event),
+	}
+}
+
+func (s Status) Final() bool {
+	return s == StatusConfirmed || s == StatusDropped
+}
+
+// Track starts following a submitted tx with the default tracker.
+func Track(ctx context.Context, tx TrackedTx) error { return Default.Track(ctx, tx) }
+
+// Subscribe registers fn for every event of the default tracker.
+func Subscribe(fn func(Event)) { Default.Subscribe(fn) }
+
+// Wait blocks until the tx is confirmed or dropped on the default tracker.
+func Wait(ctx context.Context, hash string) (TrackedTx, error) { return Default.Wait(ctx, hash) }
+
+// Run polls the default tracker until ctx is done.
+func Run(ctx context.Context) { Default.Run(ctx) }
+
+func (t *Tracker) provider() chain.Provider {
+	if t.Provider != nil {
+		return t.Provider
+	}
+	return chain.Current
+}
+
+func (t *Tracker) Track(ctx context.Context, tx TrackedTx) error {
+	if tx.TxHash == "" {
+		return errors.New("track: tx hash is required")
+	}
+	now := time.Now().UTC()
+	if tx.SubmittedAt.IsZero() {
+		tx.SubmittedAt = now
+	}
+	tx.Status = StatusPending
+	tx.UpdatedAt = now
+	if err := t.Store.Save(ctx, tx); err != nil {
+		return fmt.Errorf("track %s: %w", tx.TxHash, err)
+	}
+	logger.Record.Info("CONFIRM", "TRACKING", tx.TxHash, "PURPOSE", tx.Purpose, "REFERENCE", tx.Reference, "TTL", tx.TTL)
+	return nil
+}
+
+func (t *Tracker) Subscribe(fn func(Event)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers = append(t.handlers, fn)
+}
+
+// Wait returns once the tx reaches a final status. A dropped tx returns
+// ErrDropped; rollbacks are waited out.
+func (t *Tracker) Wait(ctx context.Context, hash string) (TrackedTx, error) {
+	ch := make(chan Event, 1)
+	t.mu.Lock()
+	t.waiters[hash] = append(t.waiters[hash], ch)
+	t.mu.Unlock()
+	defer t.removeWaiter(hash, ch)
+
+	// it may have settled before we started waiting
+	if tx, err := t.Store.Load(ctx, hash); err == nil && tx.Status.Final() {
+		return tx, finalErr(tx)
+	}
+
+	select {
+	case <-ctx.Done():
+		return TrackedTx{TxHash: hash}, ctx.Err()
+	case e := <-ch:
+		return e.Tx, finalErr(e.Tx)
+	}
+}
+
+func finalErr(tx TrackedTx) error {
+	if tx.Status == StatusDropped {
+		return fmt.Errorf("%w: %s", ErrDropped, tx.TxHash)
+	}
+	return nil
+}
+
+func (t *Tracker) removeWaiter(hash string, ch chan Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	waiters := t.waiters[hash]
+	for n, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:n], waiters[n+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(t.waiters, hash)
+	} else {
+		t.waiters[hash] = waiters
+	}
+}
+
+func (t *Tracker) emit(e Event) {
+	logger.Record.Info("CONFIRM", string(e.Status), e.Tx.TxHash, "PURPOSE", e.Tx.Purpose, "REFERENCE", e.Tx.Reference, "DEPTH", e.Tx.Depth)
+
+	t.mu.Lock()
+	handlers := append([]func(Event){}, t.handlers...)
+	var waiters []chan Event
+	if e.Status.Final() {
+		waiters = t.waiters[e.Tx.TxHash]
+	}
+	t.mu.Unlock()
+
+	for _, ch := range waiters {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	for _, fn := range handlers {
+		fn(e)
+	}
+}
+
+// Run polls the open txs every Interval until ctx is done.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.Poll(ctx); err != nil {
+			logger.Record.Warn("CONFIRM", "POLL FAILED", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks every open tx once against the chain tip.
+func (t *Tracker) Poll(ctx context.Context) error {
+	open, err := t.Store.Open(ctx)
+	if err != nil {
+		return err
+	}
+	if len(open) == 0 {
+		return nil
+	}
+
+	tip, err := t.provider().Tip(ctx)
+	if err != nil {
+		return fmt.Errorf("tip: %w", err)
+	}
+
+	for _, tx := range open {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := t.check(ctx, tx, tip); err != nil {
+			logger.Record.Warn("CONFIRM", "CHECK FAILED", tx.TxHash, "ERROR", err)
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) check(ctx context.Context, tx TrackedTx, tip *chain.Tip) error {
+	now := time.Now().UTC()
+	onChain, err := t.provider().Transaction(ctx, tx.TxHash)
+	if err != nil && !errors.Is(err, chain.ErrNotFound) {
+		return err
+	}
+	if onChain != nil && onChain.BlockHash == "" {
+		onChain = nil // seen in a mempool only
+	}
+
+	var event Status
+	switch {
+	case onChain == nil && tx.Status == StatusIncluded:
+		// a tip below the tx's block is an index that has not caught up
+		if tip.Height < tx.BlockHeight {
+			return nil
+		}
+		tx.Misses++
+		if tx.Misses < rollbackMisses {
+			break
+		}
+		// it was in a block and no provider has known it for a while
+		tx.Status = StatusRolledBack
+		tx.Rollbacks++
+		tx.Misses = 0
+		tx.BlockHash, tx.BlockHeight, tx.Slot, tx.Depth = "", 0, 0, 0
+		event = StatusRolledBack
+
+	case onChain == nil:
+		expired := tx.TTL > 0 && tip.Slot > tx.TTL+t.Depth*slotsPerBlock
+		stale := tx.TTL == 0 && now.Sub(tx.SubmittedAt) > t.DropAfter
+		if !expired && !stale {
+			return nil
+		}
+		tx.Status = StatusDropped
+		event = StatusDropped
+
+	default:
+		tx.Misses = 0
+		if tx.BlockHash != "" && onChain.BlockHash != tx.BlockHash {
+			// re-included in a different block after a fork
+			tx.Rollbacks++
+			t.emit(Event{Status: StatusRolledBack, Tx: tx})
+		}
+		tx.BlockHash, tx.BlockHeight, tx.Slot = onChain.BlockHash, onChain.BlockHeight, onChain.Slot
+		tx.Depth = 0
+		if tip.Height >= onChain.BlockHeight {
+			tx.Depth = tip.Height - onChain.BlockHeight + 1
+		}
+		tx.Status = StatusIncluded
+		if tx.Depth >= t.Depth {
+			tx.Status = StatusConfirmed
+			tx.ConfirmedAt = now
+			event = StatusConfirmed
+		}
+	}
+
+	tx.UpdatedAt = now
+	if err := t.Store.Save(ctx, tx); err != nil {
+		return err
+	}
+	if event != "" {
+		t.emit(Event{Status: event, Tx: tx})
+	}
+	return nil
+}
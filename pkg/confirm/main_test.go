@@ -0,0 +1,104 @@
+package confirm
+
+import (
+	"cardano-valley/pkg/chain"
+	"context"
+	"testing"
+)
+
+func newTestTracker(depth uint64) (*Tracker, *chain.Fixture) {
+	fixture := chain.NewFixture()
+	t := New(NewMemoryStore(), depth)
+	t.Provider = fixture
+	return t, fixture
+}
+
+func status(t *testing.T, tracker *Tracker, hash string) TrackedTx {
+	t.Helper()
+	if err := tracker.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := tracker.Store.Load(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestTrackerConfirms(t *testing.T) {
+	tracker, fixture := newTestTracker(3)
+	ctx := context.Background()
+	if err := fixture.Apply("tx1", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Track(ctx, TrackedTx{TxHash: "tx1", Purpose: PurposeHarvest}); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx := status(t, tracker, "tx1"); tx.Status != StatusIncluded || tx.Depth != 1 {
+		t.Fatalf("after inclusion: %s at depth %d, want %s at 1", tx.Status, tx.Depth, StatusIncluded)
+	}
+	fixture.AdvanceTip(2)
+	if tx := status(t, tracker, "tx1"); tx.Status != StatusConfirmed {
+		t.Fatalf("at depth 3: %s, want %s", tx.Status, StatusConfirmed)
+	}
+}
+
+func TestTrackerDropsOnlyWellPastTTL(t *testing.T) {
+	tracker, fixture := newTestTracker(3)
+	ctx := context.Background()
+	head, _ := fixture.Tip(ctx)
+	ttl := head.Slot + 100
+	if err := tracker.Track(ctx, TrackedTx{TxHash: "tx1", Purpose: PurposeHarvest, TTL: ttl}); err != nil {
+		t.Fatal(err)
+	}
+
+	// just past the TTL a lagging provider may not have indexed the tx yet
+	fixture.AdvanceTip(6)
+	if tx := status(t, tracker, "tx1"); tx.Status != StatusPending {
+		t.Fatalf("just past TTL: %s, want %s", tx.Status, StatusPending)
+	}
+
+	fixture.AdvanceTip(3)
+	if tx := status(t, tracker, "tx1"); tx.Status != StatusDropped {
+		t.Fatalf("TTL plus the confirmation depth: %s, want %s", tx.Status, StatusDropped)
+	}
+}
+
+func TestTrackerRollbackNeedsRepeatedMisses(t *testing.T) {
+	tracker, fixture := newTestTracker(10)
+	ctx := context.Background()
+	if err := fixture.Apply("tx1", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Track(ctx, TrackedTx{TxHash: "tx1", Purpose: PurposeHarvest}); err != nil {
+		t.Fatal(err)
+	}
+	if tx := status(t, tracker, "tx1"); tx.Status != StatusIncluded {
+		t.Fatalf("after inclusion: %s, want %s", tx.Status, StatusIncluded)
+	}
+
+	var events []Event
+	tracker.Subscribe(func(e Event) { events = append(events, e) })
+
+	// one provider answer without the tx is not a rollback
+	included := fixture.Transactions["tx1"]
+	delete(fixture.Transactions, "tx1")
+	status(t, tracker, "tx1")
+	fixture.Transactions["tx1"] = included
+	if tx := status(t, tracker, "tx1"); tx.Status != StatusIncluded || tx.Misses != 0 {
+		t.Fatalf("after a single miss: %s with %d misses, want %s with 0", tx.Status, tx.Misses, StatusIncluded)
+	}
+
+	delete(fixture.Transactions, "tx1")
+	for n := 0; n < rollbackMisses; n++ {
+		status(t, tracker, "tx1")
+	}
+	tx, _ := tracker.Store.Load(ctx, "tx1")
+	if tx.Status != StatusRolledBack || tx.Rollbacks != 1 {
+		t.Fatalf("after %d misses: %s with %d rollbacks, want %s with 1", rollbackMisses, tx.Status, tx.Rollbacks, StatusRolledBack)
+	}
+	if len(events) != 1 || events[0].Status != StatusRolledBack {
+		t.Errorf("events %+v, want a single rollback", events)
+	}
+}
@@ -0,0 +1,100 @@
+package confirm
+
+import (
+	"cardano-valley/pkg/chain"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errNoDB = errors.New("database is not connected")
+
+// MongoStore keeps tracked txs in the "tx-tracker" collection so the poller
+// picks up where it left off after a restart.
+type MongoStore struct{}
+
+func (MongoStore) Save(ctx context.Context, tx TrackedTx) error {
+	if mongo.DB == nil {
+		return errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("tx-tracker")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "tx_hash", Value: tx.TxHash}}
+
+	_, err := collection.ReplaceOne(ctx, filter, tx, opts)
+	return err
+}
+
+func (MongoStore) Load(ctx context.Context, hash string) (TrackedTx, error) {
+	var tx TrackedTx
+	if mongo.DB == nil {
+		return tx, errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("tx-tracker")
+	filter := bson.D{{Key: "tx_hash", Value: hash}}
+
+	err := collection.FindOne(ctx, filter).Decode(&tx)
+	return tx, err
+}
+
+func (MongoStore) Open(ctx context.Context) ([]TrackedTx, error) {
+	if mongo.DB == nil {
+		return nil, errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("tx-tracker")
+	filter := bson.D{{Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{StatusPending, StatusIncluded, StatusRolledBack}}}}}
+
+	var txs []TrackedTx
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &txs); err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+// MemoryStore is used in offline mode, where there is no database.
+type MemoryStore struct {
+	mu  sync.Mutex
+	txs map[string]TrackedTx
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{txs: make(map[string]TrackedTx)}
+}
+
+func (m *MemoryStore) Save(ctx context.Context, tx TrackedTx) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.txs[tx.TxHash] = tx
+	return nil
+}
+
+func (m *MemoryStore) Load(ctx context.Context, hash string) (TrackedTx, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tx, ok := m.txs[hash]
+	if !ok {
+		return tx, fmt.Errorf("%w: tracked tx %s", chain.ErrNotFound, hash)
+	}
+	return tx, nil
+}
+
+func (m *MemoryStore) Open(ctx context.Context) ([]TrackedTx, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var txs []TrackedTx
+	for _, tx := range m.txs {
+		if !tx.Status.Final() {
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
@@ -0,0 +1,198 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	AirdropCadence string
+
+	// AirdropSchedule is a recurring airdrop definition. Every run splits
+	// TotalADA among the holders and is paid from the guild treasury.
+	AirdropSchedule struct {
+		ID        string           `bson:"id"`
+		GuildID   ServerID         `bson:"guild_id"`
+		CreatedBy string           `bson:"created_by"`
+		CreatedAt time.Time        `bson:"created_at"`
+		PolicyID  string           `bson:"policy_id,omitempty"` // Holders are looked up on every run
+		Holders   []cardano.Holder `bson:"holders,omitempty"`   // Fixed holder list (uploaded file)
+		TotalADA  uint64           `bson:"total_ada"`
+		Cadence   AirdropCadence   `bson:"cadence"`
+		StartAt   time.Time        `bson:"start_at"`
+		EndAt     time.Time        `bson:"end_at,omitempty"` // Zero means no end
+		NextRunAt time.Time        `bson:"next_run_at"`
+		LastEpoch uint64           `bson:"last_epoch,omitempty"` // Only used by the epoch cadence
+		Active    bool             `bson:"active"`
+		Runs      []AirdropRun     `bson:"runs,omitempty"`
+	}
+
+	// AirdropRun records a single child AirdropSession of a schedule.
+	AirdropRun struct {
+		SessionID       string    `bson:"session_id"`
+		StartedAt       time.Time `bson:"started_at"`
+		FinishedAt      time.Time `bson:"finished_at,omitempty"`
+		Epoch           uint64    `bson:"epoch,omitempty"`
+		Stage           string    `bson:"stage"`
+		TotalRecipients uint64    `bson:"total_recipients"`
+		TotalLovelace   uint64    `bson:"total_lovelace"`
+		TxIDs           []string  `bson:"tx_ids,omitempty"`
+		Error           string    `bson:"error,omitempty"`
+	}
+)
+
+const (
+	CadenceEpoch  AirdropCadence = "epoch"
+	CadenceDaily  AirdropCadence = "daily"
+	CadenceWeekly AirdropCadence = "weekly"
+)
+
+// Next returns the time of the run after from. Epoch schedules are driven by
+// the chain tip instead, so they are simply re-checked a day later.
+func (c AirdropCadence) Next(from time.Time) time.Time {
+	switch c {
+	case CadenceWeekly:
+		return from.Add(7 * 24 * time.Hour)
+	default:
+		return from.Add(24 * time.Hour)
+	}
+}
+
+// Due reports whether the schedule should run at the given time.
+func (a AirdropSchedule) Due(now time.Time) bool {
+	if !a.Active || now.Before(a.StartAt) {
+		return false
+	}
+	if !a.EndAt.IsZero() && now.After(a.EndAt) {
+		return false
+	}
+	if a.Cadence == CadenceEpoch {
+		return true
+	}
+
+	return !now.Before(a.NextRunAt)
+}
+
+func (a AirdropSchedule) Save() interface{} {
+	collection := mongo.DB.Database("cardano-valley").Collection("airdrop-schedule")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "id", Value: a.ID}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result, err := collection.ReplaceOne(ctx, filter, a, opts)
+	if err != nil {
+		log.Printf("cannot save airdrop schedule: %v", err)
+		return nil
+	}
+
+	return result.UpsertedID
+}
+
+// SaveProgress records where the scheduler is with the schedule. Only the
+// fields the scheduler moves are written, so a concurrent cancel or run
+// update is not overwritten.
+func (a AirdropSchedule) SaveProgress() {
+	a.update(bson.D{{Key: "$set", Value: bson.D{
+		{Key: "next_run_at", Value: a.NextRunAt},
+		{Key: "last_epoch", Value: a.LastEpoch},
+	}}})
+}
+
+// Deactivate stops the schedule from running again.
+func (a AirdropSchedule) Deactivate() {
+	a.update(bson.D{{Key: "$set", Value: bson.D{{Key: "active", Value: false}}}})
+}
+
+// AddRun appends a run to the schedule history.
+func (a AirdropSchedule) AddRun(run AirdropRun) {
+	a.update(bson.D{{Key: "$push", Value: bson.D{{Key: "runs", Value: run}}}})
+}
+
+// SaveRun replaces the run with the same session ID.
+func (a AirdropSchedule) SaveRun(run AirdropRun) {
+	a.updateRun(run.SessionID, bson.D{{Key: "runs.$", Value: run}})
+}
+
+// SetAirdropRunError records err on the run of the session, leaving the rest of
+// the schedule alone.
+func SetAirdropRunError(scheduleID, sessionID, err string) {
+	AirdropSchedule{ID: scheduleID}.updateRun(sessionID, bson.D{{Key: "runs.$.error", Value: err}})
+}
+
+func (a AirdropSchedule) updateRun(sessionID string, set bson.D) {
+	collection := mongo.DB.Database("cardano-valley").Collection("airdrop-schedule")
+	filter := bson.D{{Key: "id", Value: a.ID}, {Key: "runs.session_id", Value: sessionID}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if _, err := collection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: set}}); err != nil {
+		log.Printf("cannot update airdrop run: %v", err)
+	}
+}
+
+func (a AirdropSchedule) update(update bson.D) {
+	collection := mongo.DB.Database("cardano-valley").Collection("airdrop-schedule")
+	filter := bson.D{{Key: "id", Value: a.ID}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		log.Printf("cannot update airdrop schedule: %v", err)
+	}
+}
+
+func LoadAirdropSchedule(id string) (AirdropSchedule, error) {
+	collection := mongo.DB.Database("cardano-valley").Collection("airdrop-schedule")
+	filter := bson.D{{Key: "id", Value: id}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var schedule AirdropSchedule
+	err := collection.FindOne(ctx, filter).Decode(&schedule)
+
+	return schedule, err
+}
+
+func LoadAirdropSchedules(filter bson.D) []AirdropSchedule {
+	if mongo.DB == nil {
+		log.Println("Waiting for DB...")
+		return nil
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("airdrop-schedule")
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var schedules []AirdropSchedule
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("cannot find airdrop schedules: %v", err)
+		return nil
+	}
+
+	if err := cursor.All(ctx, &schedules); err != nil {
+		log.Printf("cannot decode airdrop schedules: %v", err)
+		return nil
+	}
+
+	return schedules
+}
+
+func LoadActiveAirdropSchedules() []AirdropSchedule {
+	return LoadAirdropSchedules(bson.D{{Key: "active", Value: true}})
+}
+
+func LoadAirdropSchedulesByGuildID(guild_id string) []AirdropSchedule {
+	return LoadAirdropSchedules(bson.D{{Key: "guild_id", Value: guild_id}})
+}
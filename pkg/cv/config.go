@@ -17,6 +17,10 @@ type (
 		GuildID         ServerID       `bson:"guild_id,omitempty"`
 		Name 		    string         `bson:"name,omitempty"` // Name of the server
 		Wallet          cardano.Keys   `bson:"wallet,omitempty"`
+		Treasury        cardano.Keys   `bson:"treasury,omitempty"` // Pre-funded wallet for scheduled airdrops
+		Delegation      Delegation     `bson:"delegation,omitempty"` // Pool the farm wallet stakes with
+		Multisig        Multisig       `bson:"multisig,omitempty"` // Admin co-signing for large payouts
+		ClaimFee        uint64         `bson:"claim_fee,omitempty"` // Lovelace users pay from their own wallet to harvest
 		Rewards     	[]Reward       `json:"rewards,omitempty"`
 	}
 
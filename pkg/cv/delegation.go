@@ -0,0 +1,156 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type (
+	// Delegation is the pool a custodial wallet was last delegated to and
+	// the tx that did it.
+	Delegation struct {
+		PoolID      string    `bson:"pool_id,omitempty"`
+		TxHash      string    `bson:"tx_hash,omitempty"`
+		Registered  bool      `bson:"registered,omitempty"` // the tx also registered the stake address
+		SubmittedAt time.Time `bson:"submitted_at,omitempty"`
+	}
+)
+
+// delegation txs expire if they are not in a block within about an hour
+const delegationValiditySlots = 3600
+
+var ErrAlreadyDelegated = errors.New("the wallet is already delegated to this pool")
+
+// DelegateWallet delegates a custodial wallet to poolID. When its stake
+// address is not registered yet the same tx registers it and pays the
+// deposit. The deposit and fee come from the wallet itself, and reference
+// ends up on the tracked tx (a guild or user ID).
+func DelegateWallet(ctx context.Context, wallet cardano.Keys, poolID, reference string) (*Delegation, error) {
+	if wallet.Address == "" {
+		return nil, errors.New("no wallet to delegate")
+	}
+	// providers report bech32 pool IDs, so compare in that form
+	poolHash, err := cardano.PoolKeyHash(poolID)
+	if err != nil {
+		return nil, fmt.Errorf("pool %s: %w", poolID, err)
+	}
+	if poolID, err = cardano.Bech32Encode("pool", poolHash); err != nil {
+		return nil, err
+	}
+
+	stake, err := wallet.StakeVerificationKey()
+	if err != nil {
+		return nil, err
+	}
+	stakeAddress, err := cardano.StakeAddress(stake)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := false
+	account, err := chain.Current.StakeAccount(ctx, stakeAddress)
+	switch {
+	case err == nil:
+		registered = account.Active
+		if registered && account.PoolID == poolID {
+			return nil, ErrAlreadyDelegated
+		}
+	case errors.Is(err, chain.ErrNotFound):
+		// never seen on-chain, so not registered either
+	default:
+		return nil, fmt.Errorf("stake account %s: %w", stakeAddress, err)
+	}
+
+	var certs []cardano.Certificate
+	if !registered {
+		reg, err := cardano.StakeRegistrationCertificate(stake, cardano.Params.StakeAddressDeposit)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, reg)
+	}
+	deleg, err := cardano.StakeDelegationCertificate(stake, poolID)
+	if err != nil {
+		return nil, err
+	}
+	certs = append(certs, deleg)
+
+	utxos, err := cardano.QueryUTxOs(ctx, wallet.Address)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := chain.Current.Tip(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tip: %w", err)
+	}
+	ttl := tip.Slot + delegationValiditySlots
+
+	tx, err := cardano.Builder.Build(cardano.TxRequest{
+		Inputs:        utxos.Inputs(),
+		ChangeAddress: wallet.Address,
+		Certificates:  certs,
+		TTL:           ttl,
+		Signers:       2,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	paymentKey, err := wallet.PaymentSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	stakeKey, err := wallet.StakeSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := cardano.Builder.Sign(tx, paymentKey, stakeKey); err != nil {
+		return nil, err
+	}
+	res, err := chain.Submit(ctx, tx.CBOR)
+	if err != nil {
+		return nil, err
+	}
+
+	err = confirm.Track(ctx, confirm.TrackedTx{
+		TxHash:    res.TxID,
+		Purpose:   confirm.PurposeDelegation,
+		Reference: reference,
+		TTL:       ttl,
+	})
+	if err != nil {
+		log.Printf("cannot track delegation %s: %v", res.TxID, err)
+	}
+
+	return &Delegation{
+		PoolID:      poolID,
+		TxHash:      res.TxID,
+		Registered:  !registered,
+		SubmittedAt: time.Now().UTC(),
+	}, nil
+}
+
+// SaveDelegation records the delegation of the user's custodial wallet. Only
+// the delegation is written, saving the whole user could drop rewards
+// credited since it was loaded.
+func (u User) SaveDelegation() {
+	collection := mongo.DB.Database("cardano-valley").Collection("user")
+	filter := bson.D{{Key: "id", Value: u.ID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "delegation", Value: u.Delegation}}}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		log.Printf("cannot save user delegation: %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// Harvest is a payout of a user's earned rewards from one guild's farm
+	// wallet. It is kept so the rewards can be credited back if the tx never
+	// makes it on-chain.
+	Harvest struct {
+		TxHash    string           `bson:"tx_hash"`
+		UserID    string           `bson:"user_id"`
+		GuildID   ServerID         `bson:"guild_id"`
+		Address   string           `bson:"address"`
+		Assets    map[Asset]uint64 `bson:"assets"`
+		Status    HarvestStatus    `bson:"status"`
+		CreatedAt time.Time        `bson:"created_at"`
+		UpdatedAt time.Time        `bson:"updated_at"`
+	}
+
+	HarvestStatus string
+)
+
+const (
+	HarvestAwaiting  HarvestStatus = "awaiting_approval" // above the multisig threshold, waiting on the admins
+	HarvestPending   HarvestStatus = "pending"
+	HarvestConfirmed HarvestStatus = "confirmed"
+	HarvestReturned  HarvestStatus = "returned" // the tx was dropped and the rewards credited back
+
+	// harvest txs expire if they are not in a block within about an hour
+	harvestValiditySlots = 3600
+)
+
+var ErrNothingToHarvest = errors.New("nothing to harvest")
+
+// HarvestRewards pays everything the user has earned to address, with one tx
+// per guild farm wallet, and takes it off their balance. A guild that fails
+// does not stop the others; its balance is left as it was. Guilds that charge
+// a claim fee are skipped, see ClaimFeesDue.
+func (u User) HarvestRewards(ctx context.Context, address string) ([]Harvest, error) {
+	unlock := lockHarvest(u.ID)
+	defer unlock()
+
+	var harvests []Harvest
+	var errs []error
+	for guildID := range u.Rewards {
+		config := LoadConfig(string(guildID))
+		if config.ClaimFee > 0 {
+			continue
+		}
+		h, err := u.harvest(ctx, config, address)
+		if errors.Is(err, ErrNothingToHarvest) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", guildID, err))
+			continue
+		}
+		harvests = append(harvests, *h)
+	}
+
+	if len(harvests) == 0 && len(errs) == 0 && len(u.ClaimFeesDue()) == 0 {
+		return nil, ErrNothingToHarvest
+	}
+	return harvests, errors.Join(errs...)
+}
+
+// HarvestGuild pays what the user earned in one guild, once its claim fee
+// has been paid.
+func (u User) HarvestGuild(ctx context.Context, guildID ServerID, address string) (*Harvest, error) {
+	unlock := lockHarvest(u.ID)
+	defer unlock()
+
+	return u.harvest(ctx, LoadConfig(string(guildID)), address)
+}
+
+// ClaimFeesDue lists the guilds the user has rewards in that charge a claim
+// fee, with the fee in lovelace.
+func (u User) ClaimFeesDue() map[ServerID]uint64 {
+	due := make(map[ServerID]uint64)
+	for guildID, balance := range u.Rewards {
+		earned := false
+		for _, entry := range balance {
+			earned = earned || entry.Earned > 0
+		}
+		if !earned {
+			continue
+		}
+		if fee := LoadConfig(string(guildID)).ClaimFee; fee > 0 {
+			due[guildID] = fee
+		}
+	}
+	return due
+}
+
+// in-memory locker so a double click, or a claim fee confirming during a
+// /harvest, does not build two txs for the same balance
+var harvestLocks sync.Map // map[userID]*sync.Mutex
+
+func lockHarvest(userID string) func() {
+	muAny, _ := harvestLocks.LoadOrStore(userID, &sync.Mutex{})
+	mu := muAny.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// harvest takes one guild's balance off the user's rewards and pays it out.
+// The balance is reserved before the tx is built, so rewards credited in the
+// meantime stay for the next harvest, and is credited back if the tx is not
+// submitted.
+func (u User) harvest(ctx context.Context, config Config, address string) (*Harvest, error) {
+	balance, err := reserveRewards(ctx, u.ID, config.GuildID)
+	if err != nil {
+		return nil, err
+	}
+	h, err := harvestGuild(ctx, u.ID, config, balance, address)
+	if err != nil {
+		reserved := make(map[Asset]uint64)
+		for asset, entry := range balance {
+			reserved[asset] = entry.Earned
+		}
+		if err := CreditRewards(context.Background(), u.ID, config.GuildID, reserved); err != nil {
+			log.Printf("cannot restore rewards of %s in %s: %v", u.ID, config.GuildID, err)
+		}
+		return nil, err
+	}
+	h.Save()
+	return h, nil
+}
+
+// reserveRewards zeroes what the user earned in a guild and returns it.
+func reserveRewards(ctx context.Context, userID string, guildID ServerID) (Balance, error) {
+	reserved := make(Balance)
+	err := updateRewards(ctx, userID, guildID, func(balance Balance) error {
+		// a retried transaction starts over
+		clear(reserved)
+		now := time.Now().UTC()
+		for asset, entry := range balance {
+			if entry.Earned == 0 {
+				continue
+			}
+			reserved[asset] = entry
+			entry.Earned = 0
+			entry.LastClaimed = now
+			balance[asset] = entry
+		}
+		if len(reserved) == 0 {
+			return ErrNothingToHarvest
+		}
+		return nil
+	})
+	return reserved, err
+}
+
+func harvestGuild(ctx context.Context, userID string, config Config, balance Balance, address string) (*Harvest, error) {
+	guildID := config.GuildID
+	claimed := make(map[Asset]uint64)
+	out := cardano.TxOut{Address: address, Assets: make(map[string]uint64)}
+	for asset, entry := range balance {
+		if entry.Earned == 0 {
+			continue
+		}
+		claimed[asset] = entry.Earned
+		out.Assets[asset.Unit()] += entry.Earned
+	}
+	if len(claimed) == 0 {
+		return nil, ErrNothingToHarvest
+	}
+
+	if config.Wallet.Address == "" {
+		return nil, errors.New("the server has no farm wallet")
+	}
+
+	min, err := cardano.Params.MinUTxO(out)
+	if err != nil {
+		return nil, err
+	}
+	out.Lovelace = min
+	metadata := cardano.Metadata{674: map[string]any{"msg": []string{"Cardano Valley harvest"}}}
+
+	if config.Multisig.NeedsApproval(out) {
+		description := fmt.Sprintf("Harvest for <@%s> to %s", userID, address)
+		p, err := config.ProposeMultisigTx(ctx, []cardano.TxOut{out}, metadata, confirm.PurposeHarvest, userID, description)
+		if err != nil {
+			return nil, fmt.Errorf("multisig: %w", err)
+		}
+		now := time.Now().UTC()
+		return &Harvest{
+			TxHash:    p.TxHash,
+			UserID:    userID,
+			GuildID:   guildID,
+			Address:   address,
+			Assets:    claimed,
+			Status:    HarvestAwaiting,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}, nil
+	}
+
+	utxos, err := cardano.QueryUTxOs(ctx, config.Wallet.Address)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := chain.Current.Tip(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tip: %w", err)
+	}
+	ttl := tip.Slot + harvestValiditySlots
+
+	tx, err := cardano.Builder.Build(cardano.TxRequest{
+		Inputs:        utxos.Inputs(),
+		Outputs:       []cardano.TxOut{out},
+		ChangeAddress: config.Wallet.Address,
+		Metadata:      metadata,
+		TTL:           ttl,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("farm wallet: %w", err)
+	}
+
+	skey, err := config.Wallet.PaymentSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := cardano.Builder.Sign(tx, skey); err != nil {
+		return nil, err
+	}
+	res, err := chain.Submit(ctx, tx.CBOR)
+	if err != nil {
+		return nil, err
+	}
+
+	err = confirm.Track(ctx, confirm.TrackedTx{
+		TxHash:    res.TxID,
+		Purpose:   confirm.PurposeHarvest,
+		Reference: userID,
+		TTL:       ttl,
+	})
+	if err != nil {
+		log.Printf("cannot track harvest %s: %v", res.TxID, err)
+	}
+
+	now := time.Now().UTC()
+	return &Harvest{
+		TxHash:    res.TxID,
+		UserID:    userID,
+		GuildID:   guildID,
+		Address:   address,
+		Assets:    claimed,
+		Status:    HarvestPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// ReturnHarvest credits a dropped or rejected harvest back to the user's
+// balance. It only does so once, while the harvest is still open.
+func ReturnHarvest(txHash string) (*Harvest, error) {
+	h, err := LoadHarvest(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if h.Status != HarvestPending && h.Status != HarvestAwaiting {
+		return &h, nil
+	}
+
+	if err := CreditRewards(context.Background(), h.UserID, h.GuildID, h.Assets); err != nil {
+		return nil, err
+	}
+
+	h.Status = HarvestReturned
+	h.UpdatedAt = time.Now().UTC()
+	h.Save()
+	return &h, nil
+}
+
+func (h Harvest) Save() interface{} {
+	collection := mongo.DB.Database("cardano-valley").Collection("harvest")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "tx_hash", Value: h.TxHash}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result, err := collection.ReplaceOne(ctx, filter, h, opts)
+	if err != nil {
+		log.Printf("cannot save harvest: %v", err)
+		return nil
+	}
+
+	return result.UpsertedID
+}
+
+func LoadHarvest(txHash string) (Harvest, error) {
+	collection := mongo.DB.Database("cardano-valley").Collection("harvest")
+	filter := bson.D{{Key: "tx_hash", Value: txHash}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var harvest Harvest
+	err := collection.FindOne(ctx, filter).Decode(&harvest)
+
+	return harvest, err
+}
@@ -0,0 +1,186 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	"cardano-valley/pkg/db"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+const (
+	testPolicy = "e633efbf5e4a0c5bd1e6c3d8bdcd82ea93ad6c4dc0b1a1a7c0f1a2b3"
+	cropUnit   = testPolicy + "43524f50"
+	seedUnit   = testPolicy + "53454544"
+)
+
+// farmConfig is a guild whose farm wallet holds 20 ADA and the given assets.
+func farmConfig(t *testing.T, f *chain.Fixture, assets map[string]uint64) Config {
+	t.Helper()
+	useKeyring(t)
+	farm := newTestWallet(t)
+	sealed, err := db.Encrypt(farm.payment.Envelope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.AddUTxO(chain.UTxO{TxHash: fmt.Sprintf("%064x", 1), Address: farm.address, Lovelace: 20_000_000, Assets: assets})
+	return Config{GuildID: "guild", Wallet: cardano.Keys{Address: farm.address, SigningPaymentKey: sealed}}
+}
+
+func earned(assets map[Asset]uint64) Balance {
+	balance := make(Balance)
+	for asset, qty := range assets {
+		entry := balance[asset]
+		entry.Earned = qty
+		balance[asset] = entry
+	}
+	return balance
+}
+
+func TestHarvestGuild(t *testing.T) {
+	crop, seed := Asset(testPolicy+".43524f50"), Asset(testPolicy+".53454544")
+	ctx := context.Background()
+
+	t.Run("pays the balance from the farm wallet", func(t *testing.T) {
+		f, _ := chain.UseFixtureT(t)
+		config := farmConfig(t, f, map[string]uint64{cropUnit: 10_000, seedUnit: 50})
+		user := newTestWallet(t)
+
+		h, err := harvestGuild(ctx, "user", config, earned(map[Asset]uint64{crop: 500, seed: 5}), user.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Status != HarvestPending || h.Assets[crop] != 500 || h.Assets[seed] != 5 {
+			t.Errorf("harvest %+v", h)
+		}
+
+		tx, ok := f.Transactions[h.TxHash]
+		if !ok {
+			t.Fatalf("harvest %s is not on the ledger", h.TxHash)
+		}
+		held := make(map[string]map[string]uint64)
+		for _, out := range tx.Outputs {
+			if held[out.Address] == nil {
+				held[out.Address] = make(map[string]uint64)
+			}
+			for unit, qty := range out.Assets {
+				held[out.Address][unit] += qty
+			}
+		}
+		if held[user.address][cropUnit] != 500 || held[user.address][seedUnit] != 5 {
+			t.Errorf("user received %v", held[user.address])
+		}
+		if held[config.Wallet.Address][cropUnit] != 9_500 || held[config.Wallet.Address][seedUnit] != 45 {
+			t.Errorf("farm kept %v", held[config.Wallet.Address])
+		}
+
+		tracked, err := confirm.Default.Store.Load(ctx, h.TxHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracked.Purpose != confirm.PurposeHarvest || tracked.Reference != "user" {
+			t.Errorf("tracked %+v", tracked)
+		}
+	})
+
+	t.Run("nothing earned", func(t *testing.T) {
+		f, _ := chain.UseFixtureT(t)
+		config := farmConfig(t, f, map[string]uint64{cropUnit: 10_000})
+		_, err := harvestGuild(ctx, "user", config, earned(map[Asset]uint64{crop: 0}), newTestWallet(t).address)
+		if !errors.Is(err, ErrNothingToHarvest) {
+			t.Errorf("harvestGuild = %v, want %v", err, ErrNothingToHarvest)
+		}
+	})
+
+	t.Run("farm wallet short of the asset", func(t *testing.T) {
+		f, _ := chain.UseFixtureT(t)
+		config := farmConfig(t, f, map[string]uint64{cropUnit: 100})
+		_, err := harvestGuild(ctx, "user", config, earned(map[Asset]uint64{crop: 500}), newTestWallet(t).address)
+		if !errors.Is(err, cardano.ErrInsufficientFunds) {
+			t.Errorf("harvestGuild = %v, want %v", err, cardano.ErrInsufficientFunds)
+		}
+		if len(f.Transactions) != 0 {
+			t.Errorf("%d txs on the ledger, want none", len(f.Transactions))
+		}
+	})
+}
+
+// creditingBuilder credits the user while their harvest tx is being built.
+type creditingBuilder struct {
+	cardano.TxBuilder
+	credit func()
+}
+
+func (b creditingBuilder) Build(req cardano.TxRequest) (*cardano.Tx, error) {
+	b.credit()
+	return b.TxBuilder.Build(req)
+}
+
+func TestHarvestRewards(t *testing.T) {
+	crop := Asset(testPolicy + ".43524f50")
+	ctx := context.Background()
+
+	t.Run("keeps rewards credited during the harvest", func(t *testing.T) {
+		f, _ := chain.UseFixtureT(t)
+		farmConfig(t, f, map[string]uint64{cropUnit: 10_000}).Save()
+		User{ID: "alice", Rewards: map[ServerID]Balance{"guild": earned(map[Asset]uint64{crop: 500})}}.Save()
+		cardano.Builder = creditingBuilder{TxBuilder: cardano.Builder, credit: func() {
+			if err := CreditRewards(ctx, "alice", "guild", map[Asset]uint64{crop: 20}); err != nil {
+				t.Error(err)
+			}
+		}}
+
+		harvests, err := LoadUser("alice").HarvestRewards(ctx, newTestWallet(t).address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(harvests) != 1 || harvests[0].Assets[crop] != 500 {
+			t.Errorf("harvests %+v", harvests)
+		}
+		if left := LoadUser("alice").Rewards["guild"][crop].Earned; left != 20 {
+			t.Errorf("alice has %d earned after the harvest, want the 20 credited meanwhile", left)
+		}
+	})
+
+	t.Run("restores the balance when the tx fails", func(t *testing.T) {
+		f, _ := chain.UseFixtureT(t)
+		farmConfig(t, f, map[string]uint64{cropUnit: 100}).Save()
+		User{ID: "alice", Rewards: map[ServerID]Balance{"guild": earned(map[Asset]uint64{crop: 500})}}.Save()
+
+		_, err := LoadUser("alice").HarvestRewards(ctx, newTestWallet(t).address)
+		if !errors.Is(err, cardano.ErrInsufficientFunds) {
+			t.Errorf("HarvestRewards = %v, want %v", err, cardano.ErrInsufficientFunds)
+		}
+		if left := LoadUser("alice").Rewards["guild"][crop].Earned; left != 500 {
+			t.Errorf("alice has %d earned after the failed harvest, want 500", left)
+		}
+	})
+
+	t.Run("pays a balance once", func(t *testing.T) {
+		f, _ := chain.UseFixtureT(t)
+		farmConfig(t, f, map[string]uint64{cropUnit: 10_000}).Save()
+		User{ID: "alice", Rewards: map[ServerID]Balance{"guild": earned(map[Asset]uint64{crop: 500})}}.Save()
+		address := newTestWallet(t).address
+
+		// both read the user before either harvested
+		user := LoadUser("alice")
+		var wg sync.WaitGroup
+		paid := make([]int, 2)
+		for n := range paid {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				harvests, _ := user.HarvestRewards(ctx, address)
+				paid[n] = len(harvests)
+			}()
+		}
+		wg.Wait()
+		if paid[0]+paid[1] != 1 || len(f.Transactions) != 1 {
+			t.Errorf("%v harvests and %d txs, want one", paid, len(f.Transactions))
+		}
+	})
+}
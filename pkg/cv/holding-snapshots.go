@@ -0,0 +1,173 @@
+package cv
+
+import (
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// HoldingSnapshot is how much of an asset a user held when the holder
+	// cycle ran on Day, and the wallets it was counted from.
+	HoldingSnapshot struct {
+		UserID  string    `bson:"user_id"`
+		Asset   string    `bson:"asset"`
+		Amount  uint64    `bson:"amount"`
+		Wallets []string  `bson:"wallets,omitempty"`
+		Day     string    `bson:"day"` // 2006-01-02, UTC
+		At      time.Time `bson:"at"`
+	}
+
+	// HoldingHistory is a user's snapshots by asset and day.
+	HoldingHistory map[string]map[string]uint64
+)
+
+const (
+	snapshotDay = "2006-01-02"
+
+	// snapshots are kept a year, longer than any hold period
+	snapshotRetention = 366 * 24 * time.Hour
+)
+
+func SnapshotDay(t time.Time) string {
+	return t.UTC().Format(snapshotDay)
+}
+
+// SaveHoldingSnapshots records the holder cycle of day: the holdings of
+// every user with the wallets they were counted from, and the day itself, so
+// a day the cycle did not run does not break anyone's streak.
+func SaveHoldingSnapshots(day time.Time, holdings map[string]map[string]uint64, wallets map[string][]string) error {
+	db := mongo.DB.Database("cardano-valley")
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	collection := db.Collection("holding-snapshots")
+	_, err := collection.Indexes().CreateOne(ctx, mongodriver.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "day", Value: -1}, {Key: "asset", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	d := SnapshotDay(day)
+	var writes []mongodriver.WriteModel
+	for userID, assets := range holdings {
+		for asset, amount := range assets {
+			s := HoldingSnapshot{UserID: userID, Asset: asset, Amount: amount, Wallets: wallets[userID], Day: d, At: now}
+			filter := bson.D{{Key: "user_id", Value: userID}, {Key: "day", Value: d}, {Key: "asset", Value: asset}}
+			writes = append(writes, mongodriver.NewReplaceOneModel().SetFilter(filter).SetReplacement(s).SetUpsert(true))
+		}
+	}
+	if len(writes) > 0 {
+		if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+
+	cycles := db.Collection("holding-cycles")
+	filter := bson.D{{Key: "day", Value: d}}
+	cycle := bson.D{{Key: "day", Value: d}, {Key: "at", Value: now}}
+	if _, err := cycles.ReplaceOne(ctx, filter, cycle, options.Replace().SetUpsert(true)); err != nil {
+		return err
+	}
+
+	before := SnapshotDay(now.Add(-snapshotRetention))
+	old := bson.D{{Key: "day", Value: bson.D{{Key: "$lt", Value: before}}}}
+	if _, err := collection.DeleteMany(ctx, old); err != nil {
+		log.Printf("cannot prune holding snapshots: %v", err)
+	}
+	if _, err := cycles.DeleteMany(ctx, old); err != nil {
+		log.Printf("cannot prune holding cycles: %v", err)
+	}
+	return nil
+}
+
+// AuditSnapshotWallets lists the wallets a snapshot of the user taken at t
+// counted that the wallet ledger does not show linked at t.
+func AuditSnapshotWallets(userID string, t time.Time, counted []string) []string {
+	linked := WalletsAt(userID, t)
+	var missing []string
+	for _, p := range counted {
+		if !slices.Contains(linked, p) {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// LoadHoldingCycles lists the days the holder cycle ran, newest first.
+func LoadHoldingCycles(limit int64) []string {
+	collection := mongo.DB.Database("cardano-valley").Collection("holding-cycles")
+	opts := options.Find().SetSort(bson.D{{Key: "day", Value: -1}}).SetLimit(limit)
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var cycles []struct {
+		Day string `bson:"day"`
+	}
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		log.Printf("cannot find holding cycles: %v", err)
+		return nil
+	}
+	if err := cursor.All(ctx, &cycles); err != nil {
+		log.Printf("cannot decode holding cycles: %v", err)
+		return nil
+	}
+	days := make([]string, len(cycles))
+	for n, c := range cycles {
+		days[n] = c.Day
+	}
+	return days
+}
+
+// LoadHoldingHistory returns the user's snapshots since the day given.
+func LoadHoldingHistory(userID, since string) HoldingHistory {
+	collection := mongo.DB.Database("cardano-valley").Collection("holding-snapshots")
+	filter := bson.D{{Key: "user_id", Value: userID}, {Key: "day", Value: bson.D{{Key: "$gte", Value: since}}}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	history := make(HoldingHistory)
+	var snapshots []HoldingSnapshot
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("cannot find holding snapshots: %v", err)
+		return history
+	}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		log.Printf("cannot decode holding snapshots: %v", err)
+		return history
+	}
+	for _, s := range snapshots {
+		if _, ok := history[s.Asset]; !ok {
+			history[s.Asset] = make(map[string]uint64)
+		}
+		history[s.Asset][s.Day] = s.Amount
+	}
+	return history
+}
+
+// Streak counts the cycles in a row, back from the newest, the user held more
+// than minimum of asset. cycles is newest first, as LoadHoldingCycles returns.
+func (h HoldingHistory) Streak(cycles []string, asset string, minimum uint64) int {
+	streak := 0
+	for _, day := range cycles {
+		if h[asset][day] <= minimum {
+			break
+		}
+		streak++
+	}
+	return streak
+}
@@ -0,0 +1,27 @@
+package cv
+
+import "testing"
+
+func TestHoldingStreak(t *testing.T) {
+	cycles := []string{"2026-10-05", "2026-10-04", "2026-10-03", "2026-10-01"} // no cycle ran on the 2nd
+	history := HoldingHistory{
+		cropUnit: {"2026-10-05": 20, "2026-10-04": 20, "2026-10-03": 20, "2026-10-01": 20},
+		seedUnit: {"2026-10-05": 20, "2026-10-04": 5, "2026-10-03": 20},
+	}
+
+	tests := []struct {
+		asset   string
+		minimum uint64
+		want    int
+	}{
+		{cropUnit, 10, 4},
+		{cropUnit, 20, 0}, // the minimum has to be exceeded
+		{seedUnit, 10, 1},
+		{testPolicy + "00", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := history.Streak(cycles, tt.asset, tt.minimum); got != tt.want {
+			t.Errorf("Streak(%s, %d) = %d, want %d", tt.asset, tt.minimum, got, tt.want)
+		}
+	}
+}
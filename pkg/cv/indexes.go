@@ -0,0 +1,50 @@
+package cv
+
+import (
+	mongo "cardano-valley/pkg/db"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionIndexes are the indexes the collections rely on for uniqueness.
+var collectionIndexes = map[string][]mongodriver.IndexModel{
+	// a wallet belongs to one account: its key, each of its addresses and a
+	// stake key that signed can only be claimed once
+	"wallet-claims": {
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "payments", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "stake", Value: 1}}, Options: options.Index().SetName("stake_verified").SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "stake_verified", Value: true}})},
+	},
+	// a self-send tx links one wallet; challenges without one are left out
+	"link-challenges": {
+		{Keys: bson.D{{Key: "tx_hash", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	},
+}
+
+// obsoleteIndexes are dropped before collectionIndexes are created, when an
+// index changed options under the same keys.
+var obsoleteIndexes = map[string][]string{
+	"wallet-claims": {"stake_1"}, // was not unique
+}
+
+// EnsureIndexes creates the indexes of every collection. It runs once at
+// startup; creating an index that exists is a no-op.
+func EnsureIndexes(ctx context.Context) error {
+	db := mongo.DB.Database("cardano-valley")
+	for name, indexes := range obsoleteIndexes {
+		for _, index := range indexes {
+			// fails when the index is gone already
+			_, _ = db.Collection(name).Indexes().DropOne(ctx, index)
+		}
+	}
+	for name, indexes := range collectionIndexes {
+		if _, err := db.Collection(name).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	mongo "cardano-valley/pkg/db"
+	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/vault"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// KeyRotation is the progress of re-encrypting stored secrets under the
+	// current master key. There is one per key ID; a run that finishes with
+	// no failures means the retired keys can be dropped.
+	KeyRotation struct {
+		KeyID       string    `bson:"key_id"`
+		Total       int       `bson:"total"`       // documents to check
+		Done        int       `bson:"done"`        // documents checked
+		Reencrypted int       `bson:"reencrypted"` // documents that had to be rewritten
+		Failed      int       `bson:"failed"`
+		Errors      []string  `bson:"errors,omitempty"`
+		StartedAt   time.Time `bson:"started_at"`
+		UpdatedAt   time.Time `bson:"updated_at"`
+		FinishedAt  time.Time `bson:"finished_at,omitempty"`
+	}
+)
+
+const (
+	// progress is logged and saved every this many documents
+	keyRotationReportEvery = 25
+
+	// errors kept on the record, the rest are only logged
+	keyRotationMaxErrors = 20
+)
+
+// RotateKeys walks the config and user collections and the key vault and
+// re-encrypts every wallet secret that is not yet under the current master
+// key. Only the wallet fields are written, so it runs next to the bot.
+// report, if set, gets the progress as it is saved.
+func RotateKeys(ctx context.Context, report func(KeyRotation)) (*KeyRotation, error) {
+	if mongo.DB == nil {
+		return nil, errors.New("database is not connected")
+	}
+
+	configs := LoadConfigs()
+	users := LoadUsers()
+	vaultIDs, err := vault.IDs()
+	if err != nil {
+		return nil, fmt.Errorf("key vault: %w", err)
+	}
+
+	now := time.Now().UTC()
+	rotation := &KeyRotation{
+		KeyID:     mongo.Keys.Current,
+		Total:     len(configs) + len(users) + len(vaultIDs),
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	l := logger.Record.WithGroup("KEY ROTATION").With("KEY", rotation.KeyID)
+	l.Info("started", "TOTAL", rotation.Total)
+
+	step := func(what string, changed bool, err error) {
+		rotation.Done++
+		switch {
+		case err != nil:
+			rotation.Failed++
+			l.Error("could not re-encrypt", "DOCUMENT", what, "ERROR", err)
+			if len(rotation.Errors) < keyRotationMaxErrors {
+				rotation.Errors = append(rotation.Errors, fmt.Sprintf("%s: %v", what, err))
+			}
+		case changed:
+			rotation.Reencrypted++
+		}
+		if rotation.Done%keyRotationReportEvery == 0 || rotation.Done == rotation.Total {
+			rotation.UpdatedAt = time.Now().UTC()
+			rotation.Save()
+			l.Info("progress", "DONE", rotation.Done, "TOTAL", rotation.Total, "REENCRYPTED", rotation.Reencrypted, "FAILED", rotation.Failed)
+			if report != nil {
+				report(*rotation)
+			}
+		}
+	}
+
+	for _, config := range configs {
+		if ctx.Err() != nil {
+			return rotation, ctx.Err()
+		}
+		changed, err := reencryptConfig(ctx, config)
+		step("config "+string(config.GuildID), changed, err)
+	}
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return rotation, ctx.Err()
+		}
+		changed, err := reencryptUser(ctx, user)
+		step("user "+user.ID, changed, err)
+	}
+	for _, id := range vaultIDs {
+		if ctx.Err() != nil {
+			return rotation, ctx.Err()
+		}
+		changed, err := vault.Rewrap(id)
+		step("vault "+id, changed, err)
+	}
+
+	rotation.FinishedAt = time.Now().UTC()
+	rotation.UpdatedAt = rotation.FinishedAt
+	rotation.Save()
+	l.Info("finished", "TOTAL", rotation.Total, "REENCRYPTED", rotation.Reencrypted, "FAILED", rotation.Failed)
+	if report != nil {
+		report(*rotation)
+	}
+	return rotation, nil
+}
+
+// reencryptConfig rewrites the guild's wallet secrets that are not under the
+// current key. Only the re-encrypted fields are set, and only while they
+// still hold the ciphertext they were read with: a wallet written since
+// wins over the stale copy.
+func reencryptConfig(ctx context.Context, config Config) (bool, error) {
+	walletFields, err := config.Wallet.Reencrypt()
+	if err != nil {
+		return false, fmt.Errorf("wallet: %w", err)
+	}
+	treasuryFields, err := config.Treasury.Reencrypt()
+	if err != nil {
+		return false, fmt.Errorf("treasury: %w", err)
+	}
+
+	collection := mongo.DB.Database("cardano-valley").Collection("config")
+	filter := bson.D{{Key: "guild_id", Value: config.GuildID}}
+	return reencryptFields(ctx, collection, filter, map[string][]cardano.ReencryptedField{
+		"wallet":   walletFields,
+		"treasury": treasuryFields,
+	})
+}
+
+func reencryptUser(ctx context.Context, user User) (bool, error) {
+	fields, err := user.Wallet.Reencrypt()
+	if err != nil {
+		return false, err
+	}
+
+	collection := mongo.DB.Database("cardano-valley").Collection("user")
+	filter := bson.D{{Key: "id", Value: user.ID}}
+	return reencryptFields(ctx, collection, filter, map[string][]cardano.ReencryptedField{"wallet": fields})
+}
+
+// reencryptFields sets the re-encrypted fields of the document filter
+// matches, keyed by the path of the wallet they belong to. It reports whether
+// the document was written; a document changed since it was read is left
+// alone and re-encrypted by the next run.
+func reencryptFields(ctx context.Context, collection *mongodriver.Collection, filter bson.D, wallets map[string][]cardano.ReencryptedField) (bool, error) {
+	set := bson.D{}
+	for path, fields := range wallets {
+		for _, f := range fields {
+			filter = append(filter, bson.E{Key: path + "." + f.Field, Value: f.Old})
+			set = append(set, bson.E{Key: path + "." + f.Field, Value: f.New})
+		}
+	}
+	if len(set) == 0 {
+		return false, nil
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: set}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r KeyRotation) Save() interface{} {
+	collection := mongo.DB.Database("cardano-valley").Collection("key-rotation")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "key_id", Value: r.KeyID}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result, err := collection.ReplaceOne(ctx, filter, r, opts)
+	if err != nil {
+		log.Printf("cannot save key rotation: %v", err)
+		return nil
+	}
+
+	return result.UpsertedID
+}
@@ -0,0 +1,99 @@
+package cv
+
+import (
+	"bytes"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/db"
+	"cardano-valley/pkg/vault"
+	"context"
+	"testing"
+)
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	chain.UseFixtureT(t)
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	old, err := db.NewKeyring("old", oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.Keys
+	db.Keys = old
+	t.Cleanup(func() { db.Keys = previous })
+
+	seal := func(plaintext string) string {
+		t.Helper()
+		sealed, err := db.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	Config{GuildID: "guild", Wallet: cardano.Keys{Address: "farm", PaymentKey: seal("farm vkey"), SigningPaymentKey: seal("farm skey")}}.Save()
+	User{ID: "alice", Wallet: cardano.Keys{Address: "custodial", SigningPaymentKey: seal("alice skey")}}.Save()
+	if err := vault.Put("hd", "mnemonic", []byte("abandon")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new key takes over, the old one is kept to read what it sealed
+	rotated, err := db.NewKeyring("new", newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rotated.Add("old", oldKey); err != nil {
+		t.Fatal(err)
+	}
+	db.Keys = rotated
+
+	// alice earns rewards and the farm gets a new signing key after the
+	// rotation read them
+	staleConfig, staleUser := LoadConfig("guild"), LoadUser("alice")
+	credited := LoadUser("alice")
+	credited.Rewards["guild"] = Balance{"policy.crop": {Earned: 5}}
+	credited.Save()
+	replaced := LoadConfig("guild")
+	replaced.Wallet.SigningPaymentKey = seal("new farm skey")
+	replaced.Save()
+
+	if changed, err := reencryptConfig(ctx, staleConfig); err != nil || changed {
+		t.Errorf("stale config re-encrypted: %v, %v", changed, err)
+	}
+	if changed, err := reencryptUser(ctx, staleUser); err != nil || !changed {
+		t.Errorf("reencryptUser = %v, %v", changed, err)
+	}
+	if LoadUser("alice").Rewards["guild"]["policy.crop"].Earned != 5 {
+		t.Error("re-encrypting the user's wallet dropped rewards credited since")
+	}
+
+	rotation, err := RotateKeys(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the config still has its old payment key, the vault its old wrap
+	if rotation.KeyID != "new" || rotation.Total != 3 || rotation.Reencrypted != 2 || rotation.Failed != 0 {
+		t.Errorf("rotation %+v", rotation)
+	}
+
+	config, user := LoadConfig("guild"), LoadUser("alice")
+	for _, f := range []struct{ sealed, want string }{
+		{config.Wallet.PaymentKey, "farm vkey"},
+		{config.Wallet.SigningPaymentKey, "new farm skey"},
+		{user.Wallet.SigningPaymentKey, "alice skey"},
+	} {
+		if !db.Keys.IsCurrent(f.sealed) {
+			t.Errorf("%q is still sealed under %s", f.want, db.KeyID(f.sealed))
+		}
+		if got, err := db.Decrypt(f.sealed); err != nil || got != f.want {
+			t.Errorf("Decrypt = %q, %v, want %q", got, err, f.want)
+		}
+	}
+	if secret, err := vault.Get("hd", "mnemonic"); err != nil || string(secret) != "abandon" {
+		t.Errorf("vault secret %q, %v", secret, err)
+	}
+
+	again, err := RotateKeys(ctx, nil)
+	if err != nil || again.Reencrypted != 0 || again.Failed != 0 {
+		t.Errorf("second rotation %+v, %v", again, err)
+	}
+}
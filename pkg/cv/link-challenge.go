@@ -0,0 +1,403 @@
+package cv
+
+import (
+	"bytes"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// LinkChallenge is how a user proves they own a wallet: by signing its
+	// message in the wallet (CIP-8 signData), or by sending exactly Lovelace
+	// to themselves.
+	LinkChallenge struct {
+		Token     string     `bson:"token"` // the nonce, also the secret part of the web link
+		UserID    string     `bson:"user_id"`
+		Method    LinkMethod `bson:"method"`
+		Lovelace  uint64     `bson:"lovelace,omitempty"` // self-send amount
+		TxHash    string     `bson:"tx_hash,omitempty"`  // the self-send that proved it
+		Address   string     `bson:"address,omitempty"`  // set once a wallet proved it
+		Used      bool       `bson:"used"`
+		ExpiresAt time.Time  `bson:"expires_at"`
+		CreatedAt time.Time  `bson:"created_at"`
+	}
+
+	LinkMethod string
+)
+
+const (
+	LinkSignature LinkMethod = "signature"
+	LinkSelfSend  LinkMethod = "self_send"
+
+	// how long a challenge can be signed
+	linkChallengeLifetime = 15 * time.Minute
+
+	// a self-send has to be built, signed and reach a block
+	selfSendLifetime = 30 * time.Minute
+
+	// how often the provider is asked for the self-send tx
+	selfSendPollInterval = 20 * time.Second
+)
+
+var (
+	ErrChallengeExpired = errors.New("this link request has expired, please run /link-wallet again")
+	ErrChallengeUsed    = errors.New("this link request was already used")
+	ErrStakeNotLinked   = errors.New("link an address of this wallet first, its stake key can only add the rest of the wallet")
+	ErrTxAlreadyUsed    = errors.New("this transaction was already used to link a wallet")
+	ErrSelfSendMismatch = errors.New("the transaction does not send the exact amount back to the same wallet")
+)
+
+func NewLinkChallenge(userID string) (*LinkChallenge, error) {
+	return newLinkChallenge(userID, LinkSignature, 0, linkChallengeLifetime)
+}
+
+// NewSelfSendChallenge asks the user to send themselves 1 ADA plus a random
+// number of lovelace, which tells their tx apart from any other.
+func NewSelfSendChallenge(userID string) (*LinkChallenge, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(999_000))
+	if err != nil {
+		return nil, err
+	}
+	return newLinkChallenge(userID, LinkSelfSend, 1_000_000+1_000+n.Uint64(), selfSendLifetime)
+}
+
+func newLinkChallenge(userID string, method LinkMethod, lovelace uint64, lifetime time.Duration) (*LinkChallenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	c := &LinkChallenge{
+		Token:     hex.EncodeToString(nonce),
+		UserID:    userID,
+		Method:    method,
+		Lovelace:  lovelace,
+		ExpiresAt: now.Add(lifetime),
+		CreatedAt: now,
+	}
+	c.Save()
+	return c, nil
+}
+
+// Message is the text the wallet shows and signs.
+func (c LinkChallenge) Message() string {
+	return fmt.Sprintf("Link this wallet to Discord user %s on Cardano Valley.\nNonce: %s\nExpires: %s",
+		c.UserID, c.Token, c.ExpiresAt.Format(time.RFC3339))
+}
+
+func (c LinkChallenge) Open() error {
+	if c.Used {
+		return ErrChallengeUsed
+	}
+	if time.Now().After(c.ExpiresAt) {
+		return ErrChallengeExpired
+	}
+	return nil
+}
+
+// VerifySignature checks the signData result signs this challenge and links
+// the signing wallet to the user. It reports whether the wallet is new to
+// them. A payment key signature links the address it signed with. A stake
+// key signature only proves the stake credential: it cannot vouch for the
+// payment part of an address, so it adds the whole wallet to addresses of it
+// the user already linked instead of linking a new one.
+func (c *LinkChallenge) VerifySignature(signed cardano.SignedData) (Wallet, bool, error) {
+	if err := c.Open(); err != nil {
+		return Wallet{}, false, err
+	}
+	if c.Method == LinkSelfSend {
+		return Wallet{}, false, errors.New("this link request is for a self-send")
+	}
+	addr, err := signed.Verify([]byte(c.Message()))
+	if err != nil {
+		return Wallet{}, false, err
+	}
+	stake, err := addr.StakeAddress()
+	if err != nil {
+		return Wallet{}, false, err
+	}
+	keyHash, err := signed.KeyHash()
+	if err != nil {
+		return Wallet{}, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if addr.Payment == nil || !bytes.Equal(keyHash, addr.Payment) {
+		return c.verifyStake(ctx, stake)
+	}
+
+	user := LoadUser(c.UserID)
+	wallet := Wallet{Payment: addr.Bech32, Stake: stake, StakeVerified: user.stakeVerified(stake)}
+	if err := claimWallet(ctx, c.UserID, wallet, c.Method); err != nil {
+		return Wallet{}, false, err
+	}
+
+	c.Used = true
+	c.Address = addr.Bech32
+	c.Save()
+
+	user = LoadUser(c.UserID)
+	if !user.LinkWallet(wallet) {
+		return wallet, false, nil
+	}
+	user.Save()
+	return wallet, true, nil
+}
+
+// verifyStake claims the stake credential for the user and marks their
+// addresses under it, so rewards count every address of the wallet. It
+// reports whether any of them was not stake verified yet.
+func (c *LinkChallenge) verifyStake(ctx context.Context, stake string) (Wallet, bool, error) {
+	user := LoadUser(c.UserID)
+	var own []Wallet
+	for _, w := range user.LinkedWallets {
+		if addressStake(w.Payment) == stake {
+			own = append(own, w)
+		}
+	}
+	if len(own) == 0 {
+		return Wallet{}, false, ErrStakeNotLinked
+	}
+
+	wallet := own[0]
+	wallet.Stake, wallet.StakeVerified = stake, true
+	if err := claimWallet(ctx, c.UserID, wallet, c.Method); err != nil {
+		return Wallet{}, false, err
+	}
+
+	c.Used = true
+	c.Address = stake
+	c.Save()
+
+	// the claim may have taken other accounts' addresses, reload
+	user = LoadUser(c.UserID)
+	changed := false
+	for n, w := range user.LinkedWallets {
+		if addressStake(w.Payment) == stake && !w.StakeVerified {
+			user.LinkedWallets[n].Stake, user.LinkedWallets[n].StakeVerified = stake, true
+			changed = true
+		}
+	}
+	if changed {
+		user.Save()
+	}
+	return wallet, changed, nil
+}
+
+// VerifySelfSend waits for the self-send tx to reach the chain, polling the
+// provider until it shows up or the challenge expires, and links the wallet
+// once the tx sends exactly Lovelace from and to the same stake credential.
+func (c *LinkChallenge) VerifySelfSend(ctx context.Context, txHash string) (Wallet, bool, error) {
+	if err := c.Open(); err != nil {
+		return Wallet{}, false, err
+	}
+	if c.Method != LinkSelfSend {
+		return Wallet{}, false, errors.New("this link request is not for a self-send")
+	}
+	if raw, err := hex.DecodeString(txHash); err != nil || len(raw) != 32 {
+		return Wallet{}, false, errors.New("that is not a transaction ID")
+	}
+	if used, err := linkTxUsed(txHash); err != nil {
+		return Wallet{}, false, err
+	} else if used {
+		return Wallet{}, false, ErrTxAlreadyUsed
+	}
+
+	ctx, cancel := context.WithDeadline(ctx, c.ExpiresAt)
+	defer cancel()
+	var tx *chain.Transaction
+	for {
+		var err error
+		tx, err = chain.Current.Transaction(ctx, txHash)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, chain.ErrNotFound) {
+			log.Printf("link self-send %s: %v", txHash, err)
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && time.Now().After(c.ExpiresAt) {
+				return Wallet{}, false, fmt.Errorf("%w: the transaction did not show up on-chain in time", ErrChallengeExpired)
+			}
+			return Wallet{}, false, ctx.Err()
+		case <-time.After(selfSendPollInterval):
+		}
+	}
+
+	if !tx.BlockTime.IsZero() && tx.BlockTime.Before(c.CreatedAt) {
+		return Wallet{}, false, errors.New("the transaction is older than this link request")
+	}
+	addr, err := selfSendAddress(*tx, c.Lovelace)
+	if err != nil {
+		return Wallet{}, false, err
+	}
+	stake, err := addr.StakeAddress()
+	if err != nil {
+		return Wallet{}, false, err
+	}
+
+	// another challenge may have taken the tx while this one waited
+	if err := c.reserveTx(ctx, txHash); err != nil {
+		return Wallet{}, false, err
+	}
+	// a self-send spends with the payment key, the stake key never signs
+	wallet := Wallet{Payment: addr.Bech32, Stake: stake, StakeVerified: LoadUser(c.UserID).stakeVerified(stake)}
+	if err := claimWallet(ctx, c.UserID, wallet, c.Method); err != nil {
+		c.releaseTx(txHash)
+		return Wallet{}, false, err
+	}
+
+	c.Used = true
+	c.TxHash = txHash
+	c.Address = addr.Bech32
+	c.Save()
+
+	user := LoadUser(c.UserID)
+	if !user.LinkWallet(wallet) {
+		return wallet, false, nil
+	}
+	user.Save()
+	return wallet, true, nil
+}
+
+// selfSendAddress finds the output paying exactly lovelace back to the wallet
+// every input came from. Wallets are told apart by stake credential, or by
+// payment key for addresses without a stake part. The output also has to use
+// the payment key of an input: anyone can put a stake key they do not own in
+// an address, so a matching stake credential alone proves nothing.
+func selfSendAddress(tx chain.Transaction, lovelace uint64) (*cardano.Address, error) {
+	owner := ""
+	spent := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		addr, err := cardano.ParseAddress(in.Address)
+		if err != nil {
+			return nil, fmt.Errorf("%w: input %s: %v", ErrSelfSendMismatch, in.Address, err)
+		}
+		cred := ownerCredential(addr)
+		if owner != "" && cred != owner {
+			return nil, fmt.Errorf("%w: its inputs come from more than one wallet", ErrSelfSendMismatch)
+		}
+		owner = cred
+		spent[hex.EncodeToString(addr.Payment)] = true
+	}
+	if owner == "" {
+		return nil, fmt.Errorf("%w: it has no inputs", ErrSelfSendMismatch)
+	}
+
+	for _, out := range tx.Outputs {
+		if out.Lovelace != lovelace {
+			continue
+		}
+		addr, err := cardano.ParseAddress(out.Address)
+		if err != nil || !addr.IsPayment() || addr.PaymentScript {
+			continue
+		}
+		if ownerCredential(addr) == owner && spent[hex.EncodeToString(addr.Payment)] {
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no output of %.6f ADA to an address it was sent from", ErrSelfSendMismatch, float64(lovelace)/1_000_000)
+}
+
+func ownerCredential(addr *cardano.Address) string {
+	if addr.Stake != nil {
+		return "stake:" + hex.EncodeToString(addr.Stake)
+	}
+	return "payment:" + hex.EncodeToString(addr.Payment)
+}
+
+// linkTxUsed reports whether a challenge already took a self-send tx. It only
+// fails early: reserveTx is what keeps two challenges from using one tx.
+func linkTxUsed(txHash string) (bool, error) {
+	collection := mongo.DB.Database("cardano-valley").Collection("link-challenges")
+	filter := bson.D{{Key: "tx_hash", Value: txHash}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n, err := collection.CountDocuments(ctx, filter)
+	return n > 0, err
+}
+
+// reserveTx records txHash on the challenge before the wallet is claimed. The
+// unique tx_hash index turns a second challenge using the tx into a
+// duplicate key error.
+func (c *LinkChallenge) reserveTx(ctx context.Context, txHash string) error {
+	collection := mongo.DB.Database("cardano-valley").Collection("link-challenges")
+	filter := bson.D{{Key: "token", Value: c.Token}, {Key: "used", Value: false}, {Key: "tx_hash", Value: bson.D{{Key: "$exists", Value: false}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "tx_hash", Value: txHash}}}}
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if mongodriver.IsDuplicateKeyError(err) {
+		return ErrTxAlreadyUsed
+	}
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrChallengeUsed
+	}
+	c.TxHash = txHash
+	return nil
+}
+
+// releaseTx gives the tx back when the wallet could not be claimed, so the
+// challenge can be tried again.
+func (c *LinkChallenge) releaseTx(txHash string) {
+	collection := mongo.DB.Database("cardano-valley").Collection("link-challenges")
+	filter := bson.D{{Key: "token", Value: c.Token}, {Key: "tx_hash", Value: txHash}}
+	update := bson.D{{Key: "$unset", Value: bson.D{{Key: "tx_hash", Value: ""}}}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		log.Printf("cannot release link tx %s: %v", txHash, err)
+	}
+	c.TxHash = ""
+}
+
+func (c LinkChallenge) Save() interface{} {
+	collection := mongo.DB.Database("cardano-valley").Collection("link-challenges")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "token", Value: c.Token}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result, err := collection.ReplaceOne(ctx, filter, c, opts)
+	if err != nil {
+		log.Printf("cannot save link challenge: %v", err)
+		return nil
+	}
+
+	return result.UpsertedID
+}
+
+func LoadLinkChallenge(token string) (LinkChallenge, error) {
+	collection := mongo.DB.Database("cardano-valley").Collection("link-challenges")
+	filter := bson.D{{Key: "token", Value: token}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var c LinkChallenge
+	err := collection.FindOne(ctx, filter).Decode(&c)
+
+	return c, err
+}
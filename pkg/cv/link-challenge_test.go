@@ -0,0 +1,260 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfSendAddress(t *testing.T) {
+	const amount = 1_234_567
+	owner := newTestWallet(t)
+	other := newTestWallet(t)
+	enterprise, _ := cardano.EnterpriseAddress(owner.payment.Public())
+	// the owner's stake key behind someone else's payment key
+	borrowed, _ := cardano.BaseAddress(other.payment.Public(), owner.stake.Public())
+
+	tests := []struct {
+		name string
+		from []string
+		outs []cardano.TxOut
+		want string
+	}{
+		{
+			name: "back to the same address",
+			from: []string{owner.address},
+			outs: []cardano.TxOut{{Address: owner.address, Lovelace: amount}, {Address: owner.address, Lovelace: 8_000_000}},
+			want: owner.address,
+		},
+		{
+			name: "enterprise address",
+			from: []string{enterprise},
+			outs: []cardano.TxOut{{Address: enterprise, Lovelace: amount}},
+			want: enterprise,
+		},
+		{
+			name: "wrong amount",
+			from: []string{owner.address},
+			outs: []cardano.TxOut{{Address: owner.address, Lovelace: amount + 1}},
+		},
+		{
+			name: "stake key put behind another payment key",
+			from: []string{owner.address},
+			outs: []cardano.TxOut{{Address: borrowed, Lovelace: amount}},
+		},
+		{
+			name: "inputs from two wallets",
+			from: []string{owner.address, other.address},
+			outs: []cardano.TxOut{{Address: owner.address, Lovelace: amount}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _ := chain.UseFixtureT(t)
+			var ins []string
+			for n, address := range tt.from {
+				hash := fmt.Sprintf("%064x", n+1)
+				f.AddUTxO(chain.UTxO{TxHash: hash, Address: address, Lovelace: 10_000_000})
+				ins = append(ins, hash+"#0")
+			}
+			txID := fmt.Sprintf("%064x", 100)
+			if err := f.Apply(txID, ins, tt.outs); err != nil {
+				t.Fatal(err)
+			}
+			tx, err := chain.Current.Transaction(context.Background(), txID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			addr, err := selfSendAddress(*tx, amount)
+			if tt.want == "" {
+				if !errors.Is(err, ErrSelfSendMismatch) {
+					t.Fatalf("selfSendAddress = %v, %v, want %v", addr, err, ErrSelfSendMismatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr.Bech32 != tt.want {
+				t.Errorf("address %s, want %s", addr.Bech32, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySelfSend(t *testing.T) {
+	ctx := context.Background()
+	f, cli := chain.UseFixtureT(t)
+	cardano.Builder = &cardano.CLIBuilder{}
+	chain.Submitters = chain.NewSubmitFailover(&chain.Node{})
+	if err := EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	User{ID: "alice"}.Save()
+	User{ID: "bob"}.Save()
+	wallet := newTestWallet(t)
+	f.AddUTxO(chain.UTxO{TxHash: fmt.Sprintf("%064x", 1), Address: wallet.address, Lovelace: 10_000_000})
+
+	c, err := NewSelfSendChallenge("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Head.Time = time.Now().UTC()
+
+	// the user's wallet sends the amount back to itself
+	utxos, err := cardano.QueryUTxOs(ctx, wallet.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := cardano.Builder.Build(cardano.TxRequest{
+		Inputs:        utxos.Inputs(),
+		Outputs:       []cardano.TxOut{{Address: wallet.address, Lovelace: c.Lovelace}},
+		ChangeAddress: wallet.address,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cardano.Builder.Sign(tx, wallet.payment.Envelope()); err != nil {
+		t.Fatal(err)
+	}
+	res, err := chain.Submit(ctx, tx.CBOR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cli.TxSubcommands(), []string{"build", "txid", "sign", "submit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cardano-cli ran transaction %v, want %v", got, want)
+	}
+	build := strings.Join(cli.Recorded("transaction build")[0].Args, " ")
+	if !strings.Contains(build, fmt.Sprintf("--tx-out %s+%d", wallet.address, c.Lovelace)) {
+		t.Errorf("build did not pay the challenge amount: %s", build)
+	}
+	if sign := cli.Recorded("transaction sign")[0]; !sign.HasStdin {
+		t.Error("the signing key was not passed over stdin")
+	}
+	if res.TxID != tx.ID || f.Transactions[tx.ID] == nil {
+		t.Fatalf("submitted %s, built %s", res.TxID, tx.ID)
+	}
+
+	linked, isNew, err := c.VerifySelfSend(ctx, res.TxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.Payment != wallet.address || linked.StakeVerified || !isNew {
+		t.Errorf("linked %+v, new %v", linked, isNew)
+	}
+	if owners := WalletOwners(); owners[wallet.address] != "alice" {
+		t.Errorf("wallet owners %v", owners)
+	}
+	user := LoadUser("alice")
+	if len(user.LinkedWallets) != 1 || user.LinkedWallets[0].Payment != wallet.address {
+		t.Errorf("alice has %+v", user.LinkedWallets)
+	}
+	saved, err := LoadLinkChallenge(c.Token)
+	if err != nil || !saved.Used || saved.TxHash != res.TxID {
+		t.Errorf("challenge %+v, %v", saved, err)
+	}
+
+	// someone else replays the tx with a challenge for the same amount
+	replay, err := NewSelfSendChallenge("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay.Lovelace = c.Lovelace
+	replay.Save()
+	if _, _, err := replay.VerifySelfSend(ctx, res.TxID); !errors.Is(err, ErrTxAlreadyUsed) {
+		t.Errorf("replayed VerifySelfSend = %v, want %v", err, ErrTxAlreadyUsed)
+	}
+	// past the early check, the unique index still refuses it
+	if err := replay.reserveTx(ctx, res.TxID); !errors.Is(err, ErrTxAlreadyUsed) {
+		t.Errorf("reserveTx = %v, want %v", err, ErrTxAlreadyUsed)
+	}
+	if len(LoadUser("bob").LinkedWallets) != 0 {
+		t.Error("bob linked the wallet with a replayed tx")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	ctx := context.Background()
+	_, cli := chain.UseFixtureT(t)
+	if err := EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	User{ID: "alice"}.Save()
+	User{ID: "bob"}.Save()
+	wallet := newTestWallet(t)
+	sign := func(c *LinkChallenge, key *cardano.SigningKey) cardano.SignedData {
+		t.Helper()
+		signed, err := cardano.SignData(key, wallet.address, []byte(c.Message()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	// a stake key cannot link a wallet on its own
+	c, err := NewLinkChallenge("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.VerifySignature(sign(c, wallet.stake)); !errors.Is(err, ErrStakeNotLinked) {
+		t.Errorf("stake key signature = %v, want %v", err, ErrStakeNotLinked)
+	}
+
+	signed := sign(c, wallet.payment)
+	linked, isNew, err := c.VerifySignature(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked.Payment != wallet.address || linked.StakeVerified || !isNew {
+		t.Errorf("linked %+v, new %v", linked, isNew)
+	}
+	saved, err := LoadLinkChallenge(c.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := saved.VerifySignature(signed); !errors.Is(err, ErrChallengeUsed) {
+		t.Errorf("reused challenge = %v, want %v", err, ErrChallengeUsed)
+	}
+
+	// the stake key then verifies the rest of the wallet
+	c, err = NewLinkChallenge("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	linked, changed, err := c.VerifySignature(sign(c, wallet.stake))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !linked.StakeVerified || !changed {
+		t.Errorf("stake linked %+v, changed %v", linked, changed)
+	}
+	user := LoadUser("alice")
+	if len(user.LinkedWallets) != 1 || !user.LinkedWallets[0].StakeVerified {
+		t.Errorf("alice has %+v", user.LinkedWallets)
+	}
+
+	// a message signed for someone else does not verify
+	c, err = NewLinkChallenge("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewLinkChallenge("mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.VerifySignature(sign(other, wallet.payment)); !errors.Is(err, cardano.ErrInvalidSignature) {
+		t.Errorf("signature of another challenge = %v, want %v", err, cardano.ErrInvalidSignature)
+	}
+
+	if len(cli.Calls) != 0 {
+		t.Errorf("linking by signature ran cardano-cli %d times", len(cli.Calls))
+	}
+}
@@ -0,0 +1,45 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/db"
+	"crypto/rand"
+	"testing"
+)
+
+// useKeyring seals secrets with a random key for the length of the test.
+func useKeyring(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	keys, err := db.NewKeyring("test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.Keys
+	db.Keys = keys
+	t.Cleanup(func() { db.Keys = previous })
+}
+
+// testWallet is a fresh key pair and its base address.
+type testWallet struct {
+	payment, stake *cardano.SigningKey
+	address        string
+}
+
+func newTestWallet(t *testing.T) testWallet {
+	t.Helper()
+	payment, err := cardano.NewSigningKey(cardano.PaymentSigningKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stake, err := cardano.NewSigningKey(cardano.StakeSigningKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := cardano.BaseAddress(payment.Public(), stake.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testWallet{payment: payment, stake: stake, address: address}
+}
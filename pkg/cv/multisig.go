@@ -0,0 +1,583 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// Multisig puts large payouts behind admin approval. Funds at Address can
+	// only move with the bot's farm key plus Required of the admin keys, so
+	// the farm wallet stays the hot wallet for small payouts and the reserve
+	// lives at the script address.
+	Multisig struct {
+		Required int              `bson:"required"`
+		Signers  []MultisigSigner `bson:"signers"` // registered keys, the next setup builds the script from them
+
+		// ScriptSigners are the keys the script at Address was built from.
+		// They sign until the script is set up again, whatever keys the
+		// admins registered since.
+		ScriptSigners []MultisigSigner `bson:"script_signers"`
+
+		Threshold       uint64               `bson:"threshold"`        // lovelace, see NeedsApproval
+		AssetThresholds map[string]uint64    `bson:"asset_thresholds"` // unit -> quantity, see NeedsApproval
+		Script          cardano.NativeScript `bson:"script"`
+		Address         string               `bson:"address"`
+		ChannelID       string               `bson:"channel_id"` // where approval requests are posted
+	}
+
+	// MultisigSigner is an admin key that can co-sign.
+	MultisigSigner struct {
+		UserID  string `bson:"user_id"`
+		KeyHash string `bson:"key_hash"`
+		Address string `bson:"address"` // the wallet address the key was taken from
+	}
+
+	// MultisigTx is a payout from the script address waiting for approval.
+	// It carries the bot's witness from the start and gains one per admin.
+	MultisigTx struct {
+		TxHash      string          `bson:"tx_hash"`
+		GuildID     ServerID        `bson:"guild_id"`
+		Purpose     confirm.Purpose `bson:"purpose"`   // what submitting it settles
+		Reference   string          `bson:"reference"` // e.g. the user being paid
+		Description string          `bson:"description"`
+		Outputs     []cardano.TxOut `bson:"outputs"`
+		CBOR        string          `bson:"cbor"`     // hex, with the witnesses so far
+		Signed      []string        `bson:"signed"`   // admins who signed
+		Rejected    []string        `bson:"rejected"` // admins who said no
+		Status      MultisigStatus  `bson:"status"`
+		TTL         uint64          `bson:"ttl"`
+		ChannelID   string          `bson:"channel_id"`
+		MessageID   string          `bson:"message_id"`
+		CreatedAt   time.Time       `bson:"created_at"`
+		UpdatedAt   time.Time       `bson:"updated_at"`
+	}
+
+	MultisigStatus string
+)
+
+const (
+	MultisigAwaiting  MultisigStatus = "awaiting"
+	MultisigSubmitted MultisigStatus = "submitted"
+	MultisigRejected  MultisigStatus = "rejected"
+	MultisigExpired   MultisigStatus = "expired"
+
+	// admins get about three days to sign
+	multisigValiditySlots = 3 * 24 * 3600
+)
+
+var (
+	ErrNotSigner        = errors.New("you are not a registered signer for this farm")
+	ErrMultisigClosed   = errors.New("this transaction is no longer awaiting approval")
+	ErrMultisigHasFunds = errors.New("the current multisig address still holds funds, move them before changing the signers")
+	ErrMultisigReserved = errors.New("the multisig address has no funds that are not already in a payout awaiting approval")
+)
+
+// Approvals of one tx, and proposals from one script address, are serialised
+// so witnesses are not lost to a concurrent save and two proposals do not
+// spend the same UTxOs.
+var multisigLocks sync.Map // map[tx hash or address]*sync.Mutex
+
+func lockMultisig(key string) func() {
+	muAny, _ := multisigLocks.LoadOrStore(key, &sync.Mutex{})
+	mu := muAny.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Enabled reports whether a script address has been set up.
+func (m Multisig) Enabled() bool {
+	return m.Address != ""
+}
+
+// NeedsApproval reports whether a payout has to go through the multisig
+// address: it does when its ADA reaches Threshold, or any asset in it reaches
+// the asset's own threshold. Assets without a threshold always need approval,
+// and a zero threshold sends every payout for approval.
+func (m Multisig) NeedsApproval(out cardano.TxOut) bool {
+	if !m.Enabled() {
+		return false
+	}
+	if out.Lovelace >= m.Threshold {
+		return true
+	}
+	for unit, qty := range out.Assets {
+		threshold, ok := m.AssetThresholds[unit]
+		if !ok || qty >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAssetThreshold lets payouts of less than qty of the asset skip approval.
+func (m *Multisig) SetAssetThreshold(asset Asset, qty uint64) {
+	if m.AssetThresholds == nil {
+		m.AssetThresholds = make(map[string]uint64)
+	}
+	m.AssetThresholds[asset.Unit()] = qty
+}
+
+// Signer is the key an admin signs with for the current script, and whether
+// they are one of its signers.
+func (m Multisig) Signer(userID string) (MultisigSigner, bool) {
+	for _, s := range m.scriptSigners() {
+		if s.UserID == userID {
+			return s, true
+		}
+	}
+	return MultisigSigner{}, false
+}
+
+// scriptSigners lists the admins whose key is in the script. Setups from
+// before ScriptSigners was kept are matched on the registered keys.
+func (m Multisig) scriptSigners() []MultisigSigner {
+	signers := m.ScriptSigners
+	if len(signers) == 0 {
+		signers = m.Signers
+	}
+	hashes := m.Script.KeyHashes()
+	var in []MultisigSigner
+	for _, s := range signers {
+		if contains(hashes, s.KeyHash) {
+			in = append(in, s)
+		}
+	}
+	return in
+}
+
+// AddSigner registers or replaces an admin's key. The script keeps the keys
+// it was built from, a new key only counts once the script is set up again,
+// which moves the farm to a new address.
+func (m *Multisig) AddSigner(userID, address string) error {
+	hash, err := cardano.PaymentKeyHash(address)
+	if err != nil {
+		return err
+	}
+	signer := MultisigSigner{UserID: userID, KeyHash: hex.EncodeToString(hash), Address: address}
+	for n, s := range m.Signers {
+		if s.UserID == userID {
+			m.Signers[n] = signer
+			return nil
+		}
+	}
+	m.Signers = append(m.Signers, signer)
+	return nil
+}
+
+// SetupMultisig builds the script from the farm key and the registered
+// signers and moves the guild to the new address. It refuses while the old
+// address still holds funds, they would need the old signers to move.
+func (c *Config) SetupMultisig(ctx context.Context, required int, threshold uint64, channelID string) error {
+	m := &c.Multisig
+	if required < 1 || required > len(m.Signers) {
+		return fmt.Errorf("need between 1 and %d signatures, %d admins have registered a key", len(m.Signers), len(m.Signers))
+	}
+	if c.Wallet.Address == "" {
+		return errors.New("the server has no farm wallet")
+	}
+	payment, err := c.Wallet.PaymentVerificationKey()
+	if err != nil {
+		return err
+	}
+	stake, err := c.Wallet.StakeVerificationKey()
+	if err != nil {
+		return err
+	}
+
+	admins := make([]cardano.NativeScript, 0, len(m.Signers))
+	for _, s := range m.Signers {
+		hash, _ := hex.DecodeString(s.KeyHash)
+		admins = append(admins, cardano.SigScript(hash))
+	}
+	script := cardano.AllOf(cardano.SigScript(cardano.KeyHash(payment)), cardano.AtLeast(required, admins...))
+	address, err := script.Address(stake)
+	if err != nil {
+		return err
+	}
+
+	if m.Address != "" && m.Address != address {
+		utxos, err := cardano.QueryUTxOs(ctx, m.Address)
+		if err != nil {
+			return err
+		}
+		if len(utxos.Inputs()) > 0 {
+			return ErrMultisigHasFunds
+		}
+	}
+
+	m.Required = required
+	m.Threshold = threshold
+	m.ScriptSigners = slices.Clone(m.Signers)
+	m.Script = script
+	m.Address = address
+	m.ChannelID = channelID
+	return nil
+}
+
+// ProposeMultisigTx builds a payout from the script address, adds the bot's
+// witness and stores it for the admins. Submitting it settles purpose for
+// reference, the same way a direct payout would. UTxOs spent by payouts that
+// are still awaiting approval, or submitted and not yet expired, are left out
+// so every proposal can be submitted on its own.
+func (c Config) ProposeMultisigTx(ctx context.Context, outputs []cardano.TxOut, metadata cardano.Metadata, purpose confirm.Purpose, reference, description string) (*MultisigTx, error) {
+	m := c.Multisig
+	if !m.Enabled() {
+		return nil, errors.New("multisig is not set up")
+	}
+	unlock := lockMultisig(m.Address)
+	defer unlock()
+
+	utxos, err := cardano.QueryUTxOs(ctx, m.Address)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := chain.Current.Tip(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tip: %w", err)
+	}
+	ttl := tip.Slot + multisigValiditySlots
+
+	reserved := reservedMultisigInputs(c.GuildID, tip.Slot)
+	var inputs []cardano.TxInput
+	for _, in := range utxos.Inputs() {
+		if !reserved[in.Ref()] {
+			inputs = append(inputs, in)
+		}
+	}
+	if len(inputs) == 0 && len(reserved) > 0 {
+		return nil, ErrMultisigReserved
+	}
+
+	tx, err := cardano.Builder.Build(cardano.TxRequest{
+		Inputs:        inputs,
+		Outputs:       outputs,
+		ChangeAddress: m.Address,
+		Metadata:      metadata,
+		TTL:           ttl,
+		Signers:       1 + m.Required,
+		Scripts:       []cardano.NativeScript{m.Script},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("multisig address: %w", err)
+	}
+	skey, err := c.Wallet.PaymentSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := cardano.Builder.Sign(tx, skey); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	p := &MultisigTx{
+		TxHash:      tx.ID,
+		GuildID:     c.GuildID,
+		Purpose:     purpose,
+		Reference:   reference,
+		Description: description,
+		Outputs:     outputs,
+		CBOR:        hex.EncodeToString(tx.CBOR),
+		Status:      MultisigAwaiting,
+		TTL:         ttl,
+		ChannelID:   m.ChannelID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	p.Save()
+	return p, nil
+}
+
+// Tx returns the transaction with the witnesses collected so far.
+func (p MultisigTx) Tx() (*cardano.Tx, error) {
+	data, err := hex.DecodeString(p.CBOR)
+	if err != nil {
+		return nil, err
+	}
+	return &cardano.Tx{ID: p.TxHash, CBOR: data, TTL: p.TTL}, nil
+}
+
+// reservedMultisigInputs returns the inputs of the guild's payouts that may
+// still reach the chain.
+func reservedMultisigInputs(guildID ServerID, slot uint64) map[string]bool {
+	reserved := make(map[string]bool)
+	for _, status := range []MultisigStatus{MultisigAwaiting, MultisigSubmitted} {
+		for _, p := range LoadMultisigTxs(status) {
+			if p.GuildID != guildID || (p.TTL != 0 && p.TTL < slot) {
+				continue
+			}
+			data, err := hex.DecodeString(p.CBOR)
+			if err != nil {
+				continue
+			}
+			tx, err := cardano.DecodeTx(data)
+			if err != nil {
+				log.Printf("cannot decode multisig tx %s: %v", p.TxHash, err)
+				continue
+			}
+			for _, in := range tx.Inputs {
+				reserved[in.Ref()] = true
+			}
+		}
+	}
+	return reserved
+}
+
+// AddWitness takes an admin's witness, from a witness file or a CIP-30
+// wallet, and submits the tx once the script is satisfied. It reports
+// whether the tx was submitted. p is reloaded first, so it carries the
+// witnesses of approvals that came in meanwhile.
+func (p *MultisigTx) AddWitness(ctx context.Context, userID, witness string) (bool, error) {
+	unlock := lockMultisig(p.TxHash)
+	defer unlock()
+	if err := p.reload(); err != nil {
+		return false, err
+	}
+	if p.Status != MultisigAwaiting {
+		return false, ErrMultisigClosed
+	}
+	m := LoadConfig(string(p.GuildID)).Multisig
+	signer, ok := m.Signer(userID)
+	if !ok {
+		return false, ErrNotSigner
+	}
+
+	witnesses, err := cardano.ParseWitnesses(witness)
+	if err != nil {
+		return false, err
+	}
+	var own []cardano.VKeyWitness
+	for _, w := range witnesses {
+		if w.KeyHash() == signer.KeyHash {
+			own = append(own, w)
+		}
+	}
+	if len(own) == 0 {
+		return false, fmt.Errorf("%w: it is not signed by the key registered for %s", cardano.ErrInvalidWitness, signer.Address)
+	}
+
+	tx, err := p.Tx()
+	if err != nil {
+		return false, err
+	}
+	if err := tx.AddWitnesses(own...); err != nil {
+		return false, err
+	}
+	p.CBOR = hex.EncodeToString(tx.CBOR)
+	if !contains(p.Signed, userID) {
+		p.Signed = append(p.Signed, userID)
+	}
+	p.UpdatedAt = time.Now().UTC()
+
+	all, err := tx.Witnesses()
+	if err != nil {
+		return false, err
+	}
+	signed := make(map[string]bool, len(all))
+	for _, w := range all {
+		signed[w.KeyHash()] = true
+	}
+	if !m.Script.Satisfied(signed) {
+		p.Save()
+		return false, nil
+	}
+
+	res, err := chain.Submit(ctx, tx.CBOR)
+	if err != nil {
+		// keep the witnesses, the next approval retries the submit
+		p.Save()
+		return false, err
+	}
+	err = confirm.Track(ctx, confirm.TrackedTx{
+		TxHash:    res.TxID,
+		Purpose:   p.Purpose,
+		Reference: p.Reference,
+		TTL:       p.TTL,
+	})
+	if err != nil {
+		log.Printf("cannot track multisig tx %s: %v", res.TxID, err)
+	}
+	p.Status = MultisigSubmitted
+	p.Save()
+	settleSubmitted(*p)
+	return true, nil
+}
+
+// Reject records an admin's veto and closes the tx once the remaining
+// signers can no longer reach the threshold. It reports whether it closed.
+func (p *MultisigTx) Reject(userID string) (bool, error) {
+	unlock := lockMultisig(p.TxHash)
+	defer unlock()
+	if err := p.reload(); err != nil {
+		return false, err
+	}
+	if p.Status != MultisigAwaiting {
+		return false, ErrMultisigClosed
+	}
+	m := LoadConfig(string(p.GuildID)).Multisig
+	if _, ok := m.Signer(userID); !ok {
+		return false, ErrNotSigner
+	}
+	if !contains(p.Rejected, userID) {
+		p.Rejected = append(p.Rejected, userID)
+	}
+	p.UpdatedAt = time.Now().UTC()
+
+	// only the script's signers can still approve
+	signers := m.scriptSigners()
+	remaining := len(signers)
+	for _, s := range signers {
+		if contains(p.Rejected, s.UserID) {
+			remaining--
+		}
+	}
+	if remaining >= m.Required {
+		p.Save()
+		return false, nil
+	}
+	p.close(MultisigRejected)
+	return true, nil
+}
+
+// ExpireMultisigTxs closes the txs whose validity has run out unsigned.
+func ExpireMultisigTxs(ctx context.Context) ([]MultisigTx, error) {
+	tip, err := chain.Current.Tip(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tip: %w", err)
+	}
+	var expired []MultisigTx
+	for _, p := range LoadMultisigTxs(MultisigAwaiting) {
+		if p.TTL == 0 || tip.Slot <= p.TTL {
+			continue
+		}
+		if p.expire() {
+			expired = append(expired, p)
+		}
+	}
+	return expired, nil
+}
+
+// expire closes p unless an approval submitted it meanwhile.
+func (p *MultisigTx) expire() bool {
+	unlock := lockMultisig(p.TxHash)
+	defer unlock()
+	if err := p.reload(); err != nil || p.Status != MultisigAwaiting {
+		return false
+	}
+	p.close(MultisigExpired)
+	return true
+}
+
+// close gives back whatever the payout took, e.g. a harvest's rewards.
+func (p *MultisigTx) close(status MultisigStatus) {
+	p.Status = status
+	p.UpdatedAt = time.Now().UTC()
+	p.Save()
+
+	switch p.Purpose {
+	case confirm.PurposeHarvest:
+		if _, err := ReturnHarvest(p.TxHash); err != nil {
+			log.Printf("cannot return harvest %s: %v", p.TxHash, err)
+		}
+	}
+}
+
+// settleSubmitted moves the payout on to the same pending state a direct
+// payout starts in.
+func settleSubmitted(p MultisigTx) {
+	switch p.Purpose {
+	case confirm.PurposeHarvest:
+		h, err := LoadHarvest(p.TxHash)
+		if err != nil {
+			log.Printf("cannot load harvest %s: %v", p.TxHash, err)
+			return
+		}
+		h.Status = HarvestPending
+		h.UpdatedAt = time.Now().UTC()
+		h.Save()
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (p MultisigTx) Save() interface{} {
+	collection := mongo.DB.Database("cardano-valley").Collection("multisig-tx")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "tx_hash", Value: p.TxHash}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result, err := collection.ReplaceOne(ctx, filter, p, opts)
+	if err != nil {
+		log.Printf("cannot save multisig tx: %v", err)
+		return nil
+	}
+
+	return result.UpsertedID
+}
+
+func (p *MultisigTx) reload() error {
+	latest, err := LoadMultisigTx(p.TxHash)
+	if err != nil {
+		return err
+	}
+	*p = latest
+	return nil
+}
+
+func LoadMultisigTx(txHash string) (MultisigTx, error) {
+	collection := mongo.DB.Database("cardano-valley").Collection("multisig-tx")
+	filter := bson.D{{Key: "tx_hash", Value: txHash}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var p MultisigTx
+	err := collection.FindOne(ctx, filter).Decode(&p)
+
+	return p, err
+}
+
+func LoadMultisigTxs(status MultisigStatus) []MultisigTx {
+	if mongo.DB == nil {
+		return nil
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("multisig-tx")
+	filter := bson.D{{Key: "status", Value: status}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var txs []MultisigTx
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("cannot find multisig txs: %v", err)
+		return nil
+	}
+	if err := cursor.All(ctx, &txs); err != nil {
+		log.Printf("cannot decode multisig txs: %v", err)
+		return nil
+	}
+	return txs
+}
@@ -0,0 +1,139 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/db"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// keyWitness is the cardano-cli witness of key over the tx.
+func keyWitness(t *testing.T, key *cardano.SigningKey, txHash string) string {
+	t.Helper()
+	id, err := hex.DecodeString(txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	witness := append([]byte{0x82, 0x00, 0x82, 0x58, 0x20}, key.Public()...)
+	witness = append(witness, 0x58, 0x40)
+	return hex.EncodeToString(append(witness, key.Sign(id)...))
+}
+
+func TestNeedsApproval(t *testing.T) {
+	m := Multisig{Address: "addr_test1script", Threshold: 100_000_000, AssetThresholds: map[string]uint64{cropUnit: 1_000}}
+	for _, c := range []struct {
+		name string
+		out  cardano.TxOut
+		want bool
+	}{
+		{"small ADA payout", cardano.TxOut{Lovelace: 5_000_000}, false},
+		{"large ADA payout", cardano.TxOut{Lovelace: 100_000_000}, true},
+		{"token below its threshold", cardano.TxOut{Lovelace: 1_500_000, Assets: map[string]uint64{cropUnit: 999}}, false},
+		{"token at its threshold", cardano.TxOut{Lovelace: 1_500_000, Assets: map[string]uint64{cropUnit: 1_000}}, true},
+		{"token without a threshold", cardano.TxOut{Lovelace: 1_500_000, Assets: map[string]uint64{seedUnit: 1}}, true},
+	} {
+		if got := m.NeedsApproval(c.out); got != c.want {
+			t.Errorf("%s: NeedsApproval = %v, want %v", c.name, got, c.want)
+		}
+	}
+	if (Multisig{}).NeedsApproval(cardano.TxOut{Lovelace: 100_000_000}) {
+		t.Error("a farm without multisig needs approval")
+	}
+}
+
+func TestMultisigHarvest(t *testing.T) {
+	crop := Asset(testPolicy + ".43524f50")
+	ctx := context.Background()
+	f, _ := chain.UseFixtureT(t)
+	config := farmConfig(t, f, map[string]uint64{cropUnit: 10_000})
+	farm, err := config.Wallet.PaymentSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// farmConfig only keeps the signing key, the script needs both halves
+	key, err := cardano.ParseSigningKey(farm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Wallet.PaymentKey, err = db.Encrypt(key.VerificationEnvelope()); err != nil {
+		t.Fatal(err)
+	}
+	if config.Wallet.StakeKey, err = db.Encrypt(newTestWallet(t).stake.VerificationEnvelope()); err != nil {
+		t.Fatal(err)
+	}
+
+	admins := map[string]testWallet{"a": newTestWallet(t), "b": newTestWallet(t), "c": newTestWallet(t)}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := config.Multisig.AddSigner(id, admins[id].address); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := config.SetupMultisig(ctx, 2, 100_000_000, "approvals"); err != nil {
+		t.Fatal(err)
+	}
+	f.AddUTxO(chain.UTxO{TxHash: fmt.Sprintf("%064x", 2), Address: config.Multisig.Address, Lovelace: 20_000_000, Assets: map[string]uint64{cropUnit: 10_000}})
+
+	// a replaces their key and d registers one, neither is in the script
+	replaced := newTestWallet(t)
+	if err := config.Multisig.AddSigner("a", replaced.address); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Multisig.AddSigner("d", newTestWallet(t).address); err != nil {
+		t.Fatal(err)
+	}
+	config.Save()
+	User{ID: "alice", Rewards: map[ServerID]Balance{"guild": earned(map[Asset]uint64{crop: 500})}}.Save()
+
+	// a harvest only carries the minimum ADA, the token puts it up for approval
+	harvests, err := LoadUser("alice").HarvestRewards(ctx, newTestWallet(t).address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(harvests) != 1 || harvests[0].Status != HarvestAwaiting {
+		t.Fatalf("harvests %+v", harvests)
+	}
+	p, err := LoadMultisigTx(harvests[0].TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.AddWitness(ctx, "d", keyWitness(t, admins["a"].payment, p.TxHash)); !errors.Is(err, ErrNotSigner) {
+		t.Errorf("d signed: %v", err)
+	}
+	if _, err := p.Reject("d"); !errors.Is(err, ErrNotSigner) {
+		t.Errorf("d rejected: %v", err)
+	}
+	if _, err := p.AddWitness(ctx, "a", keyWitness(t, replaced.payment, p.TxHash)); !errors.Is(err, cardano.ErrInvalidWitness) {
+		t.Errorf("a signed with a key the script does not know: %v", err)
+	}
+	if submitted, err := p.AddWitness(ctx, "a", keyWitness(t, admins["a"].payment, p.TxHash)); err != nil || submitted {
+		t.Errorf("AddWitness = %v, %v", submitted, err)
+	}
+
+	// with b out, a and c can still approve, with c out too they cannot
+	if closed, err := p.Reject("b"); err != nil || closed {
+		t.Errorf("Reject(b) = %v, %v", closed, err)
+	}
+	if closed, err := p.Reject("c"); err != nil || !closed {
+		t.Errorf("Reject(c) = %v, %v", closed, err)
+	}
+	if left := LoadUser("alice").Rewards["guild"][crop].Earned; left != 500 {
+		t.Errorf("alice has %d earned after the rejection, want 500 back", left)
+	}
+
+	// below the token's threshold the farm wallet pays directly
+	config = LoadConfig("guild")
+	config.Multisig.SetAssetThreshold(crop, 1_000)
+	config.Save()
+	harvests, err = LoadUser("alice").HarvestRewards(ctx, newTestWallet(t).address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(harvests) != 1 || harvests[0].Status != HarvestPending || f.Transactions[harvests[0].TxHash] == nil {
+		t.Errorf("harvests %+v", harvests)
+	}
+}
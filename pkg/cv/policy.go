@@ -1,5 +1,7 @@
 package cv
 
+import "strings"
+
 type (
 	PolicyID string
 	Policy struct {
@@ -11,4 +13,9 @@ type (
 	PolicyIDs map[PolicyID]Policy
 
 	Asset string // policyid.assetname
-)
\ No newline at end of file
+)
+// Unit drops the dot from "policyid.assetname", giving the unit the chain
+// providers and the tx builder use. The asset name is hex.
+func (a Asset) Unit() string {
+	return strings.Replace(string(a), ".", "", 1)
+}
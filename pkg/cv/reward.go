@@ -19,6 +19,8 @@ type (
 		RolesEligible  []string        `json:"rolesEligible,omitempty"`  // Discord role names or IDs
 		AssetsEligible []string        `json:"assetsEligible,omitempty"` // List of asset policy IDs or names
 		AssetMinimum   uint64          `json:"assetMinimum,omitempty"` // Minimum amount of asset required to claim
+		MinHoldDays    int             `json:"minHoldDays,omitempty"`  // Daily holder cycles in a row the minimum must be held
+		Rule           *Rule           `json:"rule,omitempty"`         // When set, who earns the reward and how much more
 		Balance        uint64          `json:"balance"`
 		GuildID		   ServerID        `json:"guild_id"`
 	}
@@ -0,0 +1,257 @@
+package cv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	// Rule decides who earns a reward. It is either a condition or combines
+	// other rules with and, or and not. A passing condition with a Multiplier
+	// scales the payout; multipliers of several passing conditions multiply.
+	//
+	//	{"op": "and", "rules": [
+	//		{"op": "role", "role": "1273259456389714054"},
+	//		{"op": "or", "rules": [
+	//			{"op": "holds", "asset": "e633efbf…", "minimum": 5000},
+	//			{"op": "delegated", "pool_id": "pool1…", "minimum": 3, "multiplier": 1.5}
+	//		]}
+	//	]}
+	Rule struct {
+		Op         RuleOp  `json:"op"`
+		Name       string  `json:"name,omitempty"` // shown instead of the description
+		Rules      []Rule  `json:"rules,omitempty"`
+		Role       string  `json:"role,omitempty"`    // role ID
+		Asset      string  `json:"asset,omitempty"`   // policy ID, or policy ID + hex asset name
+		PoolID     string  `json:"pool_id,omitempty"` // bech32 pool ID
+		Minimum    uint64  `json:"minimum,omitempty"` // quantity, epochs, days or wallets
+		Multiplier float64 `json:"multiplier,omitempty"`
+	}
+
+	RuleOp string
+
+	// Facts is what rules are checked against, gathered by the caller.
+	Facts struct {
+		Roles      []string
+		Holdings   map[string]uint64 // unit -> quantity
+		Delegated  map[string]int    // pool ID -> epochs delegated to it in a row
+		AccountAge time.Duration     // age of the Discord account
+		Wallets    int               // claimed linked wallets
+	}
+
+	// RuleResult is how a rule came out for a user, with the results of its
+	// parts, to explain eligibility.
+	RuleResult struct {
+		Rule       Rule
+		Pass       bool
+		Detail     string  // what the user has, for conditions
+		Multiplier float64 // of the passing conditions in passing branches
+		Results    []RuleResult
+	}
+)
+
+const (
+	RuleAnd RuleOp = "and"
+	RuleOr  RuleOp = "or"
+	RuleNot RuleOp = "not"
+
+	RuleRole       RuleOp = "role"
+	RuleHolds      RuleOp = "holds"
+	RuleDelegated  RuleOp = "delegated"
+	RuleAccountAge RuleOp = "account_age" // Minimum in days
+	RuleWallets    RuleOp = "wallets"
+
+	maxRuleDepth = 8
+)
+
+// Eligibility lists the rules a user is checked against for the reward: its
+// Rule, or for rewards without one their role and holder paths.
+func (r Reward) Eligibility() []Rule {
+	if r.Rule != nil {
+		return []Rule{*r.Rule}
+	}
+	var rules []Rule
+	if len(r.RolesEligible) > 0 {
+		roles := Rule{Op: RuleOr, Name: fmt.Sprintf("Role reward, %d per day", r.RoleAmount)}
+		for _, role := range r.RolesEligible {
+			roles.Rules = append(roles.Rules, Rule{Op: RuleRole, Role: role})
+		}
+		rules = append(rules, roles)
+	}
+	if len(r.AssetsEligible) > 0 {
+		holds := Rule{Op: RuleOr, Name: "Holder reward, a share of the daily pool"}
+		for _, asset := range r.AssetsEligible {
+			holds.Rules = append(holds.Rules, Rule{Op: RuleHolds, Asset: asset, Minimum: r.AssetMinimum + 1})
+		}
+		rules = append(rules, holds)
+	}
+	return rules
+}
+
+func (r Rule) Validate() error {
+	return r.validate(0)
+}
+
+func (r Rule) validate(depth int) error {
+	if depth > maxRuleDepth {
+		return fmt.Errorf("rules can be nested at most %d deep", maxRuleDepth)
+	}
+	if r.Multiplier < 0 {
+		return errors.New("a multiplier cannot be negative")
+	}
+	switch r.Op {
+	case RuleAnd, RuleOr:
+		if len(r.Rules) == 0 {
+			return fmt.Errorf("%q needs rules", r.Op)
+		}
+	case RuleNot:
+		if len(r.Rules) != 1 {
+			return errors.New(`"not" takes exactly one rule`)
+		}
+	case RuleRole:
+		if r.Role == "" {
+			return errors.New(`"role" needs a role ID`)
+		}
+	case RuleHolds:
+		if len(r.Asset) < 56 {
+			return errors.New(`"holds" needs a policy ID or asset unit`)
+		}
+	case RuleDelegated:
+		if !strings.HasPrefix(r.PoolID, "pool1") {
+			return errors.New(`"delegated" needs a bech32 pool ID`)
+		}
+	case RuleAccountAge, RuleWallets:
+	default:
+		return fmt.Errorf("unknown rule %q", r.Op)
+	}
+	for _, sub := range r.Rules {
+		if err := sub.validate(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Uses reports whether the rule checks a condition of kind op, so facts that
+// are slow to gather are only gathered when needed.
+func (r Rule) Uses(op RuleOp) bool {
+	if r.Op == op {
+		return true
+	}
+	for _, sub := range r.Rules {
+		if sub.Uses(op) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pools lists the pools the rule checks delegation to.
+func (r Rule) Pools() []string {
+	var pools []string
+	if r.Op == RuleDelegated {
+		pools = append(pools, r.PoolID)
+	}
+	for _, sub := range r.Rules {
+		pools = append(pools, sub.Pools()...)
+	}
+	return pools
+}
+
+func (r Rule) Evaluate(f Facts) RuleResult {
+	res := RuleResult{Rule: r, Multiplier: 1}
+	switch r.Op {
+	case RuleAnd:
+		res.Pass = true
+		for _, sub := range r.Rules {
+			s := sub.Evaluate(f)
+			res.Results = append(res.Results, s)
+			res.Pass = res.Pass && s.Pass
+			res.Multiplier *= s.Multiplier
+		}
+	case RuleOr:
+		for _, sub := range r.Rules {
+			s := sub.Evaluate(f)
+			res.Results = append(res.Results, s)
+			if s.Pass {
+				res.Pass = true
+				res.Multiplier *= s.Multiplier
+			}
+		}
+	case RuleNot:
+		s := r.Rules[0].Evaluate(f)
+		res.Results = append(res.Results, s)
+		res.Pass = !s.Pass
+	case RuleRole:
+		for _, role := range f.Roles {
+			res.Pass = res.Pass || role == r.Role
+		}
+		res.Detail = "missing"
+		if res.Pass {
+			res.Detail = "has it"
+		}
+	case RuleHolds:
+		held := f.held(r.Asset)
+		res.Pass = held >= r.Minimum
+		res.Detail = fmt.Sprintf("holds %d", held)
+	case RuleDelegated:
+		epochs := f.Delegated[r.PoolID]
+		res.Pass = epochs > 0 && uint64(epochs) >= r.Minimum
+		res.Detail = fmt.Sprintf("%d epoch(s)", epochs)
+	case RuleAccountAge:
+		days := uint64(f.AccountAge / (24 * time.Hour))
+		res.Pass = days >= r.Minimum
+		res.Detail = fmt.Sprintf("%d day(s) old", days)
+	case RuleWallets:
+		res.Pass = uint64(f.Wallets) >= r.Minimum
+		res.Detail = fmt.Sprintf("%d wallet(s)", f.Wallets)
+	}
+	if !res.Pass {
+		res.Multiplier = 1
+	} else if r.Multiplier > 0 {
+		res.Multiplier *= r.Multiplier
+	}
+	return res
+}
+
+// held adds up the units of asset, a policy ID or a full unit.
+func (f Facts) held(asset string) uint64 {
+	if len(asset) > 56 {
+		return f.Holdings[asset]
+	}
+	var total uint64
+	for unit, qty := range f.Holdings {
+		if strings.HasPrefix(unit, asset) {
+			total += qty
+		}
+	}
+	return total
+}
+
+// Describe says what the rule asks for.
+func (r Rule) Describe() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	switch r.Op {
+	case RuleAnd:
+		return "All of"
+	case RuleOr:
+		return "Any of"
+	case RuleNot:
+		return "None of"
+	case RuleRole:
+		return fmt.Sprintf("Has role <@&%s>", r.Role)
+	case RuleHolds:
+		return fmt.Sprintf("Holds at least %d of `%s`", r.Minimum, TruncateMiddle(r.Asset, 24))
+	case RuleDelegated:
+		return fmt.Sprintf("Delegated to `%s` for %d epoch(s)", TruncateMiddle(r.PoolID, 24), r.Minimum)
+	case RuleAccountAge:
+		return fmt.Sprintf("Discord account at least %d day(s) old", r.Minimum)
+	case RuleWallets:
+		return fmt.Sprintf("At least %d linked wallet(s)", r.Minimum)
+	}
+	return string(r.Op)
+}
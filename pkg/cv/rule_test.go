@@ -0,0 +1,92 @@
+package cv
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+const testPool = "pool1pu5jlj4q9w9jlxeu370a3c9myx47md5j5m2str0naunn2q3lkdy"
+
+func TestRuleEvaluate(t *testing.T) {
+	var rule Rule
+	err := json.Unmarshal([]byte(`{"op": "and", "rules": [
+		{"op": "role", "role": "farmer"},
+		{"op": "or", "rules": [
+			{"op": "holds", "asset": "`+testPolicy+`", "minimum": 100},
+			{"op": "delegated", "pool_id": "`+testPool+`", "minimum": 3, "multiplier": 1.5}
+		]},
+		{"op": "not", "rules": [{"op": "account_age", "minimum": 365}]}
+	]}`), &rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		facts      Facts
+		pass       bool
+		multiplier float64
+	}{
+		{"missing the role", Facts{Holdings: map[string]uint64{cropUnit: 500}}, false, 1},
+		{"holds across units of the policy", Facts{Roles: []string{"farmer"}, Holdings: map[string]uint64{cropUnit: 60, seedUnit: 40}}, true, 1},
+		{"holds too little", Facts{Roles: []string{"farmer"}, Holdings: map[string]uint64{cropUnit: 99}}, false, 1},
+		{"delegated long enough", Facts{Roles: []string{"farmer"}, Delegated: map[string]int{testPool: 3}}, true, 1.5},
+		{"delegated too briefly", Facts{Roles: []string{"farmer"}, Delegated: map[string]int{testPool: 2}}, false, 1},
+		{"both paths", Facts{Roles: []string{"farmer"}, Holdings: map[string]uint64{cropUnit: 100}, Delegated: map[string]int{testPool: 5}}, true, 1.5},
+		{"account too old", Facts{Roles: []string{"farmer"}, Holdings: map[string]uint64{cropUnit: 100}, AccountAge: 400 * 24 * time.Hour}, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := rule.Evaluate(tt.facts)
+			if res.Pass != tt.pass || res.Multiplier != tt.multiplier {
+				t.Errorf("Evaluate = pass %v x%g, want pass %v x%g", res.Pass, res.Multiplier, tt.pass, tt.multiplier)
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	deep := Rule{Op: RuleWallets, Minimum: 1}
+	for n := 0; n <= maxRuleDepth; n++ {
+		deep = Rule{Op: RuleAnd, Rules: []Rule{deep}}
+	}
+
+	for _, r := range []Rule{
+		{Op: "owns"},
+		{Op: RuleAnd},
+		{Op: RuleNot, Rules: []Rule{{Op: RuleWallets}, {Op: RuleWallets}}},
+		{Op: RuleHolds, Asset: "e633"},
+		{Op: RuleDelegated, PoolID: "abc"},
+		{Op: RuleWallets, Multiplier: -1},
+		deep,
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("Validate(%+v) passed", r)
+		}
+	}
+}
+
+func TestRewardEligibility(t *testing.T) {
+	reward := Reward{RolesEligible: []string{"farmer"}, AssetsEligible: []string{cropUnit}, AssetMinimum: 10}
+	rules := reward.Eligibility()
+	if len(rules) != 2 {
+		t.Fatalf("%d rules, want the role and the holder path", len(rules))
+	}
+	holder := rules[1]
+	// AssetMinimum is exclusive, the holds rule inclusive
+	if holder.Evaluate(Facts{Holdings: map[string]uint64{cropUnit: 10}}).Pass {
+		t.Error("holding exactly the minimum passed")
+	}
+	if !holder.Evaluate(Facts{Holdings: map[string]uint64{cropUnit: 11}}).Pass {
+		t.Error("holding more than the minimum failed")
+	}
+
+	reward.Rule = &Rule{Op: RuleWallets, Minimum: 2}
+	if rules := reward.Eligibility(); len(rules) != 1 || rules[0].Op != RuleWallets {
+		t.Errorf("Eligibility = %+v, want only the reward's rule", rules)
+	}
+}
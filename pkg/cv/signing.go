@@ -0,0 +1,228 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// SigningRequest is a tx the user signs with their own CIP-30 wallet on
+	// the signing page, e.g. to pay a farm's claim fee. The bot builds it from
+	// the user's address and never holds their keys.
+	SigningRequest struct {
+		Token       string          `bson:"token"` // the secret part of the signing link
+		UserID      string          `bson:"user_id"`
+		GuildID     ServerID        `bson:"guild_id"`
+		Address     string          `bson:"address"` // the user's wallet, pays and signs
+		Purpose     confirm.Purpose `bson:"purpose"`
+		Description string          `bson:"description"`
+		Outputs     []cardano.TxOut `bson:"outputs"`
+		CBOR        string          `bson:"cbor"` // hex, unsigned until submitted
+		TxHash      string          `bson:"tx_hash"`
+		TTL         uint64          `bson:"ttl"`
+		Status      SigningStatus   `bson:"status"`
+		ExpiresAt   time.Time       `bson:"expires_at"`
+		CreatedAt   time.Time       `bson:"created_at"`
+		UpdatedAt   time.Time       `bson:"updated_at"`
+	}
+
+	SigningStatus string
+)
+
+const (
+	SigningOpen      SigningStatus = "open"
+	SigningSubmitted SigningStatus = "submitted"
+
+	// how long a signing link works
+	signingLinkLifetime = 30 * time.Minute
+
+	// the signed tx has to reach the chain within about an hour
+	signingValiditySlots = 3600
+)
+
+var (
+	ErrSigningExpired   = errors.New("this signing link has expired, please start again from Discord")
+	ErrSigningSubmitted = errors.New("this transaction was already submitted")
+)
+
+// NewClaimFeeRequest asks the user to pay the guild's claim fee from address
+// to the farm wallet. Once it confirms, the guild's rewards are harvested to
+// the same address.
+func NewClaimFeeRequest(userID string, config Config, address string) (*SigningRequest, error) {
+	if config.ClaimFee == 0 {
+		return nil, errors.New("the server has no claim fee")
+	}
+	if config.Wallet.Address == "" {
+		return nil, errors.New("the server has no farm wallet")
+	}
+	if _, err := cardano.PaymentKeyHash(address); err != nil {
+		return nil, err
+	}
+
+	name := config.Name
+	if name == "" {
+		name = "this server"
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	r := &SigningRequest{
+		Token:       hex.EncodeToString(token),
+		UserID:      userID,
+		GuildID:     config.GuildID,
+		Address:     address,
+		Purpose:     confirm.PurposeClaimFee,
+		Description: "Claim fee to harvest your rewards from " + name,
+		Outputs:     []cardano.TxOut{{Address: config.Wallet.Address, Lovelace: config.ClaimFee}},
+		Status:      SigningOpen,
+		ExpiresAt:   now.Add(signingLinkLifetime),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	r.Save()
+	return r, nil
+}
+
+// Open checks the link can still be used.
+func (r SigningRequest) Open() error {
+	if r.Status != SigningOpen {
+		return ErrSigningSubmitted
+	}
+	if time.Now().After(r.ExpiresAt) {
+		return ErrSigningExpired
+	}
+	return nil
+}
+
+// Build makes the unsigned tx from the user's current UTxOs. It is built
+// again each time the page asks, so it never spends inputs that are gone.
+func (r *SigningRequest) Build(ctx context.Context) error {
+	if err := r.Open(); err != nil {
+		return err
+	}
+	utxos, err := cardano.QueryUTxOs(ctx, r.Address)
+	if err != nil {
+		return err
+	}
+	tip, err := chain.Current.Tip(ctx)
+	if err != nil {
+		return fmt.Errorf("tip: %w", err)
+	}
+	ttl := tip.Slot + signingValiditySlots
+
+	tx, err := cardano.Builder.Build(cardano.TxRequest{
+		Inputs:        utxos.Inputs(),
+		Outputs:       r.Outputs,
+		ChangeAddress: r.Address,
+		Metadata:      cardano.Metadata{674: map[string]any{"msg": []string{"Cardano Valley " + string(r.Purpose)}}},
+		TTL:           ttl,
+	})
+	if err != nil {
+		return err
+	}
+	r.CBOR = hex.EncodeToString(tx.CBOR)
+	r.TxHash = tx.ID
+	r.TTL = ttl
+	r.UpdatedAt = time.Now().UTC()
+	r.Save()
+	return nil
+}
+
+// Submit adds the witness set the wallet returned from signTx and submits
+// the tx. The user's payment key has to be among the signers.
+func (r *SigningRequest) Submit(ctx context.Context, witness string) (string, error) {
+	if err := r.Open(); err != nil {
+		return "", err
+	}
+	if r.CBOR == "" {
+		return "", errors.New("the transaction was not built yet")
+	}
+	keyHash, err := cardano.PaymentKeyHash(r.Address)
+	if err != nil {
+		return "", err
+	}
+	witnesses, err := cardano.ParseWitnesses(witness)
+	if err != nil {
+		return "", err
+	}
+	signed := false
+	for _, w := range witnesses {
+		signed = signed || w.KeyHash() == hex.EncodeToString(keyHash)
+	}
+	if !signed {
+		return "", fmt.Errorf("%w: it is not signed by the wallet of %s", cardano.ErrInvalidWitness, r.Address)
+	}
+
+	data, err := hex.DecodeString(r.CBOR)
+	if err != nil {
+		return "", err
+	}
+	tx := &cardano.Tx{ID: r.TxHash, CBOR: data, TTL: r.TTL}
+	if err := tx.AddWitnesses(witnesses...); err != nil {
+		return "", err
+	}
+	res, err := chain.Submit(ctx, tx.CBOR)
+	if err != nil {
+		return "", err
+	}
+
+	err = confirm.Track(ctx, confirm.TrackedTx{
+		TxHash:    res.TxID,
+		Purpose:   r.Purpose,
+		Reference: r.Token,
+		TTL:       r.TTL,
+	})
+	if err != nil {
+		log.Printf("cannot track signed tx %s: %v", res.TxID, err)
+	}
+	r.CBOR = hex.EncodeToString(tx.CBOR)
+	r.TxHash = res.TxID
+	r.Status = SigningSubmitted
+	r.UpdatedAt = time.Now().UTC()
+	r.Save()
+	return res.TxID, nil
+}
+
+func (r SigningRequest) Save() interface{} {
+	collection := mongo.DB.Database("cardano-valley").Collection("signing-requests")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "token", Value: r.Token}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result, err := collection.ReplaceOne(ctx, filter, r, opts)
+	if err != nil {
+		log.Printf("cannot save signing request: %v", err)
+		return nil
+	}
+
+	return result.UpsertedID
+}
+
+func LoadSigningRequest(token string) (SigningRequest, error) {
+	collection := mongo.DB.Database("cardano-valley").Collection("signing-requests")
+	filter := bson.D{{Key: "token", Value: token}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var r SigningRequest
+	err := collection.FindOne(ctx, filter).Decode(&r)
+
+	return r, err
+}
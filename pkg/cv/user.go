@@ -3,12 +3,13 @@ package cv
 import (
 	"cardano-valley/pkg/cardano"
 	mongo "cardano-valley/pkg/db"
-	"cardano-valley/pkg/logger"
 	"context"
+	"fmt"
 	"log"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -19,10 +20,19 @@ type (
 		Wallet        cardano.Keys   `json:"wallet,omitempty"`
 		LinkedWallets []Wallet      `json:"linked_wallets,omitempty"` // List of linked wallets
 		Rewards       map[ServerID]Balance `json:"rewards"`
+		Delegation    Delegation     `json:"delegation,omitempty"`
 	}
 	Wallet struct {
-		Payment string `json:"payment,omitempty"`
-		Stake   string `json:"stake,omitempty"`
+		Payment  string    `json:"payment,omitempty"`
+		Stake    string    `json:"stake,omitempty"`
+		Label    string    `json:"label,omitempty"`
+		Primary  bool      `json:"primary,omitempty"` // harvest default
+		LinkedAt time.Time `json:"linked_at,omitempty"`
+
+		// StakeVerified is set once the stake key signed for the wallet.
+		// Without it only the payment address is proven, anyone can put a
+		// stake key they do not own into an address.
+		StakeVerified bool `json:"stake_verified,omitempty"`
 	}
 
 	Balance map[Asset]struct {
@@ -79,6 +89,54 @@ func (u User) Save() interface{} {
 	return result.UpsertedID
 }
 
+// CreditRewards adds earned rewards to the user's balance in a guild. Only
+// that guild's balance is written, so a harvest or another credit running at
+// the same time is not overwritten.
+func CreditRewards(ctx context.Context, userID string, guildID ServerID, credits map[Asset]uint64) error {
+	return updateRewards(ctx, userID, guildID, func(balance Balance) error {
+		for asset, qty := range credits {
+			entry := balance[asset]
+			entry.Earned += qty
+			entry.LastClaimed = time.Now()
+			balance[asset] = entry
+		}
+		return nil
+	})
+}
+
+// updateRewards reads the user's balance in a guild, lets update change it
+// and sets it back, in a transaction so concurrent writers are retried
+// instead of lost. Assets are keyed by policy.name, which cannot be updated
+// by path, so the guild's balance is set as a whole.
+func updateRewards(ctx context.Context, userID string, guildID ServerID, update func(Balance) error) error {
+	collection := mongo.DB.Database("cardano-valley").Collection("user")
+	filter := bson.D{{Key: "id", Value: userID}}
+
+	session, err := mongo.DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongodriver.SessionContext) (interface{}, error) {
+		var user User
+		if err := collection.FindOne(sc, filter).Decode(&user); err != nil {
+			return nil, fmt.Errorf("user %s: %w", userID, err)
+		}
+		balance := user.Rewards[guildID]
+		if balance == nil {
+			balance = make(Balance)
+		}
+		if err := update(balance); err != nil {
+			return nil, err
+		}
+		set := bson.D{{Key: "rewards." + string(guildID), Value: balance}}
+		_, err := collection.UpdateOne(sc, filter, bson.D{{Key: "$set", Value: set}})
+		return nil, err
+	})
+	return err
+}
+
 func LoadUsers() Users {
 	if mongo.DB == nil {
 		log.Println("Waiting for DB...")
@@ -121,24 +179,3 @@ func LoadUsers() Users {
 
 	return users
 }
-
-func (u User) HarvestRewards(changeAddr string) error {
-	// This function should implement the logic to harvest rewards for the user
-	// For now, we will just log the action
-	logger.Record.Info("Harvesting rewards", "userID", u.ID, "address", changeAddr)
-
-	txOutMap := make(cardano.TxOutMap)
-
-	for serverID, reward := range u.Rewards {
-		for asset, balance := range reward {
-			txOutMap[string(serverID)] = struct{Asset cardano.Asset; Amount uint64}{
-				Asset: cardano.Asset(asset),
-				Amount: balance.Earned,
-			}
-		}
-	}
-
-	cardano.BuildTxFromBalance(changeAddr, txOutMap)
-
-	return nil
-}
\ No newline at end of file
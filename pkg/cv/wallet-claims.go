@@ -0,0 +1,511 @@
+package cv
+
+import (
+	"cardano-valley/pkg/cardano"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// WalletClaim records which user a wallet belongs to. A wallet whose
+	// stake key signed is keyed by its stake address and covers every
+	// address under it; any other wallet only proved its payment address and
+	// is keyed by that. Unique indexes on the key and on the payment
+	// addresses keep a wallet to one Discord account.
+	WalletClaim struct {
+		Key           string     `bson:"key"`
+		StakeVerified bool       `bson:"stake_verified,omitempty"` // Key is a stake address whose key signed
+		Stake         string     `bson:"stake,omitempty"`          // stake address of the payments, proven or not
+		UserID        string     `bson:"user_id"`                  // empty while contested
+		Payments      []string   `bson:"payments"`
+		Method        LinkMethod `bson:"method,omitempty"`
+		Contenders    []string   `bson:"contenders,omitempty"` // accounts it was linked to before claims existed
+		ClaimedAt     time.Time  `bson:"claimed_at"`
+	}
+
+	// WalletContest is a conflict over a wallet, kept for the admin report.
+	WalletContest struct {
+		Key     string         `bson:"key"`
+		Payment string         `bson:"payment"`
+		From    []string       `bson:"from"` // accounts that held it
+		To      string         `bson:"to,omitempty"`
+		Outcome ContestOutcome `bson:"outcome"`
+		Method  LinkMethod     `bson:"method,omitempty"`
+		At      time.Time      `bson:"at"`
+	}
+
+	ContestOutcome string
+)
+
+const (
+	ContestTransferred ContestOutcome = "transferred" // a newer proof took the wallet
+	ContestRefused     ContestOutcome = "refused"     // the proof did not cover the stake key
+	ContestUnresolved  ContestOutcome = "unresolved"  // linked to several accounts before claims existed
+)
+
+var (
+	ErrWalletClaimed = errors.New("this wallet's stake key is linked to another Discord account, sign with the stake key to claim it")
+
+	contestSubscribers   []func(WalletContest)
+	contestSubscribersMu sync.Mutex
+)
+
+// SubscribeWalletContests registers fn for every contest, to tell the
+// accounts that lost a wallet.
+func SubscribeWalletContests(fn func(WalletContest)) {
+	contestSubscribersMu.Lock()
+	defer contestSubscribersMu.Unlock()
+	contestSubscribers = append(contestSubscribers, fn)
+}
+
+// holders lists the accounts the wallet is linked to.
+func (c WalletClaim) holders() []string {
+	var ids []string
+	if c.UserID != "" {
+		ids = append(ids, c.UserID)
+	}
+	for _, id := range c.Contenders {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// claimKey is the stake address when the stake key signed for the wallet,
+// the payment address otherwise.
+func (w Wallet) claimKey() string {
+	if w.StakeVerified && w.Stake != "" {
+		return w.Stake
+	}
+	return w.Payment
+}
+
+// addressStake is the stake address of payment, or empty for addresses
+// without a stake part. It is read from the address rather than the stored
+// Wallet.Stake, which wallets linked before CIP-8 do not always have.
+func addressStake(payment string) string {
+	addr, err := cardano.ParseAddress(payment)
+	if err != nil {
+		return ""
+	}
+	stake, _ := addr.StakeAddress()
+	return stake
+}
+
+// walletClaims is the claims collection. Its indexes are created at startup
+// by EnsureIndexes.
+func walletClaims() *mongodriver.Collection {
+	return mongo.DB.Database("cardano-valley").Collection("wallet-claims")
+}
+
+// claimWallet gives the wallet to userID. The newest verified proof wins: a
+// wallet linked to another account moves over and that account is told, as
+// long as the proof covers it. A payment proof covers its own address only,
+// and gives way to an account that proved the stake key; a stake proof
+// covers every address under the stake key.
+func claimWallet(ctx context.Context, userID string, w Wallet, method LinkMethod) error {
+	collection := walletClaims()
+	if w.Stake == "" {
+		w.Stake = addressStake(w.Payment)
+	}
+	if w.StakeVerified && w.Stake != "" {
+		return claimStake(ctx, collection, userID, w, method)
+	}
+	return claimPayment(ctx, collection, userID, w, method)
+}
+
+func claimPayment(ctx context.Context, collection *mongodriver.Collection, userID string, w Wallet, method LinkMethod) error {
+	now := time.Now().UTC()
+	contest := WalletContest{Key: w.Payment, Payment: w.Payment, To: userID, Method: method, At: now}
+
+	var stakeClaim *WalletClaim
+	if w.Stake != "" {
+		var c WalletClaim
+		err := collection.FindOne(ctx, bson.D{{Key: "key", Value: w.Stake}, {Key: "stake_verified", Value: true}}).Decode(&c)
+		switch {
+		case err == nil:
+			stakeClaim = &c
+		case !errors.Is(err, mongodriver.ErrNoDocuments):
+			return err
+		}
+	}
+	if stakeClaim != nil && stakeClaim.UserID != userID {
+		contest.Key = stakeClaim.Key
+		contest.From = stakeClaim.holders()
+		contest.Outcome = ContestRefused
+		recordWalletContest(contest)
+		return ErrWalletClaimed
+	}
+
+	var claim WalletClaim
+	err := collection.FindOne(ctx, bson.D{{Key: "payments", Value: w.Payment}}).Decode(&claim)
+	switch {
+	case errors.Is(err, mongodriver.ErrNoDocuments):
+	case err != nil:
+		return err
+	case claim.UserID == userID:
+		return nil
+	default:
+		if err := dropClaimPayments(ctx, collection, claim, []string{w.Payment}); err != nil {
+			return err
+		}
+		for _, id := range claim.holders() {
+			if id != userID {
+				contest.From = append(contest.From, id)
+			}
+		}
+	}
+
+	if stakeClaim != nil {
+		update := bson.D{{Key: "$addToSet", Value: bson.D{{Key: "payments", Value: w.Payment}}}}
+		_, err = collection.UpdateOne(ctx, bson.D{{Key: "key", Value: stakeClaim.Key}}, update)
+	} else {
+		_, err = collection.InsertOne(ctx, WalletClaim{Key: w.Payment, Stake: w.Stake, UserID: userID, Payments: []string{w.Payment}, Method: method, ClaimedAt: now})
+		if mongodriver.IsDuplicateKeyError(err) {
+			return errors.New("this wallet is being linked by another account right now, please try again")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	transferWallets(contest, []string{w.Payment})
+	return nil
+}
+
+// claimStake replaces every claim under the stake address, and the claim of
+// the signing address, with one stake keyed claim. It runs in a transaction so
+// a concurrent link never sees the claims half replaced.
+func claimStake(ctx context.Context, collection *mongodriver.Collection, userID string, w Wallet, method LinkMethod) error {
+	now := time.Now().UTC()
+	contest := WalletContest{Key: w.Stake, Payment: w.Payment, To: userID, Method: method, At: now}
+
+	session, err := mongo.DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	var taken []string
+	_, err = session.WithTransaction(ctx, func(sc mongodriver.SessionContext) (interface{}, error) {
+		// a retried transaction starts over
+		contest.From, taken = nil, nil
+
+		filter := bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "key", Value: w.Stake}},
+			bson.D{{Key: "stake", Value: w.Stake}},
+			bson.D{{Key: "payments", Value: w.Payment}},
+		}}}
+		cursor, err := collection.Find(sc, filter)
+		if err != nil {
+			return nil, err
+		}
+		var existing []WalletClaim
+		if err := cursor.All(sc, &existing); err != nil {
+			return nil, err
+		}
+
+		payments := []string{w.Payment}
+		for _, c := range existing {
+			if c.UserID == userID {
+				for _, p := range c.Payments {
+					if !slices.Contains(payments, p) {
+						payments = append(payments, p)
+					}
+				}
+			} else {
+				taken = append(taken, c.Payments...)
+				for _, id := range c.holders() {
+					if id != userID && !slices.Contains(contest.From, id) {
+						contest.From = append(contest.From, id)
+					}
+				}
+			}
+			if c.Key == w.Stake {
+				continue // replaced below
+			}
+			if _, err := collection.DeleteOne(sc, bson.D{{Key: "key", Value: c.Key}}); err != nil {
+				return nil, err
+			}
+		}
+
+		claim := WalletClaim{Key: w.Stake, StakeVerified: true, Stake: w.Stake, UserID: userID, Payments: payments, Method: method, ClaimedAt: now}
+		_, err = collection.ReplaceOne(sc, bson.D{{Key: "key", Value: w.Stake}}, claim, options.Replace().SetUpsert(true))
+		return nil, err
+	})
+	if mongodriver.IsDuplicateKeyError(err) {
+		return errors.New("this wallet is being linked by another account right now, please try again")
+	}
+	if err != nil {
+		return err
+	}
+	transferWallets(contest, taken)
+	return nil
+}
+
+// dropClaimPayments takes payments out of a claim, and drops the claim once
+// it has none left.
+func dropClaimPayments(ctx context.Context, collection *mongodriver.Collection, c WalletClaim, payments []string) error {
+	filter := bson.D{{Key: "key", Value: c.Key}}
+	remaining := slices.DeleteFunc(slices.Clone(c.Payments), func(p string) bool { return slices.Contains(payments, p) })
+	if len(remaining) == 0 {
+		_, err := collection.DeleteOne(ctx, filter)
+		return err
+	}
+	_, err := collection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: bson.D{{Key: "payments", Value: remaining}}}})
+	return err
+}
+
+// transferWallets unlinks the payments from the accounts that lost them and
+// records the contest, once the new claim is in place.
+func transferWallets(contest WalletContest, payments []string) {
+	if len(contest.From) == 0 {
+		return
+	}
+	for _, id := range contest.From {
+		old := LoadUser(id)
+		for _, p := range payments {
+			old.UnlinkWallet(p)
+		}
+		old.Save()
+	}
+	contest.Outcome = ContestTransferred
+	recordWalletContest(contest)
+}
+
+// releaseWalletClaim drops payment from the user's claim once they unlink it.
+// A contested wallet goes to the last account still holding it.
+func releaseWalletClaim(userID, payment string) {
+	if mongo.DB == nil {
+		return
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-claims")
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var claim WalletClaim
+	if err := collection.FindOne(ctx, bson.D{{Key: "payments", Value: payment}}).Decode(&claim); err != nil {
+		return
+	}
+	filter := bson.D{{Key: "key", Value: claim.Key}}
+	switch {
+	case claim.UserID == userID:
+		claim.Payments = slices.DeleteFunc(claim.Payments, func(p string) bool { return p == payment })
+	case claim.UserID == "" && slices.Contains(claim.Contenders, userID):
+		claim.Contenders = slices.DeleteFunc(claim.Contenders, func(id string) bool { return id == userID })
+		if len(claim.Contenders) == 1 {
+			claim.UserID, claim.Contenders = claim.Contenders[0], nil
+		}
+	default:
+		return
+	}
+
+	var err error
+	if len(claim.Payments) == 0 || (claim.UserID == "" && len(claim.Contenders) == 0) {
+		_, err = collection.DeleteOne(ctx, filter)
+	} else {
+		_, err = collection.ReplaceOne(ctx, filter, claim)
+	}
+	if err != nil {
+		log.Printf("cannot release wallet claim: %v", err)
+	}
+}
+
+// BackfillWalletClaims creates the claims of wallets linked before claims
+// existed. A wallet linked to several accounts is left without an owner, so
+// none of them is paid for it, until one of them proves it again. Claims
+// keyed by stake address without the stake key having signed are split into
+// one claim per address first.
+func BackfillWalletClaims(ctx context.Context) error {
+	if mongo.DB == nil {
+		return errors.New("database is not connected")
+	}
+	collection := walletClaims()
+	if err := splitUnverifiedStakeClaims(ctx, collection); err != nil {
+		return err
+	}
+
+	claims := make(map[string]*WalletClaim)
+	var keys []string
+	for _, u := range LoadUsers() {
+		for _, w := range u.LinkedWallets {
+			key := w.claimKey()
+			c, ok := claims[key]
+			if !ok {
+				c = &WalletClaim{Key: key, StakeVerified: key != w.Payment, Stake: addressStake(w.Payment), ClaimedAt: time.Now().UTC()}
+				claims[key] = c
+				keys = append(keys, key)
+			}
+			if !slices.Contains(c.Payments, w.Payment) {
+				c.Payments = append(c.Payments, w.Payment)
+			}
+			if !slices.Contains(c.Contenders, u.ID) {
+				c.Contenders = append(c.Contenders, u.ID)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		c := claims[key]
+		if n, err := collection.CountDocuments(ctx, bson.D{{Key: "key", Value: key}}); err != nil || n > 0 {
+			continue
+		}
+		if n, err := collection.CountDocuments(ctx, bson.D{{Key: "payments", Value: bson.D{{Key: "$in", Value: c.Payments}}}}); err != nil || n > 0 {
+			continue
+		}
+		if len(c.Contenders) == 1 {
+			c.UserID, c.Contenders = c.Contenders[0], nil
+		}
+		if _, err := collection.InsertOne(ctx, c); err != nil {
+			log.Printf("cannot backfill wallet claim %s: %v", key, err)
+			continue
+		}
+		if c.UserID == "" {
+			recordWalletContest(WalletContest{Key: key, Payment: c.Payments[0], From: c.Contenders, Outcome: ContestUnresolved, At: c.ClaimedAt})
+		}
+	}
+	return nil
+}
+
+// splitUnverifiedStakeClaims gives each address of a stake keyed claim its
+// own claim, unless the stake key signed for it.
+func splitUnverifiedStakeClaims(ctx context.Context, collection *mongodriver.Collection) error {
+	cursor, err := collection.Find(ctx, bson.D{{Key: "stake_verified", Value: bson.D{{Key: "$ne", Value: true}}}})
+	if err != nil {
+		return err
+	}
+	var claims []WalletClaim
+	if err := cursor.All(ctx, &claims); err != nil {
+		return err
+	}
+
+	for _, c := range claims {
+		if len(c.Payments) == 1 && c.Key == c.Payments[0] {
+			continue
+		}
+		if _, err := collection.DeleteOne(ctx, bson.D{{Key: "key", Value: c.Key}}); err != nil {
+			return err
+		}
+		for _, p := range c.Payments {
+			split := c
+			split.Key, split.Stake, split.Payments = p, addressStake(p), []string{p}
+			if _, err := collection.InsertOne(ctx, split); err != nil {
+				log.Printf("cannot split wallet claim %s: %v", c.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WalletOwners maps every claimed payment address to its owner. Contested
+// wallets map to no one.
+func WalletOwners() map[string]string {
+	if mongo.DB == nil {
+		return nil
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-claims")
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var claims []WalletClaim
+	cursor, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		log.Printf("cannot find wallet claims: %v", err)
+		return nil
+	}
+	if err := cursor.All(ctx, &claims); err != nil {
+		log.Printf("cannot decode wallet claims: %v", err)
+		return nil
+	}
+	owners := make(map[string]string)
+	for _, c := range claims {
+		for _, p := range c.Payments {
+			owners[p] = c.UserID
+		}
+	}
+	return owners
+}
+
+// ClaimedWallets leaves out the linked wallets that belong to someone else or
+// are contested. Wallets without a claim yet are kept.
+func (u User) ClaimedWallets(owners map[string]string) []Wallet {
+	var wallets []Wallet
+	for _, w := range u.LinkedWallets {
+		if owner, ok := owners[w.Payment]; ok && owner != u.ID {
+			continue
+		}
+		wallets = append(wallets, w)
+	}
+	return wallets
+}
+
+// LoadContestedClaims lists the wallets still linked to several accounts.
+func LoadContestedClaims() []WalletClaim {
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-claims")
+	filter := bson.D{{Key: "user_id", Value: ""}}
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var claims []WalletClaim
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("cannot find contested wallets: %v", err)
+		return nil
+	}
+	if err := cursor.All(ctx, &claims); err != nil {
+		log.Printf("cannot decode contested wallets: %v", err)
+		return nil
+	}
+	return claims
+}
+
+func recordWalletContest(c WalletContest) {
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-contests")
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if _, err := collection.InsertOne(ctx, c); err != nil {
+		log.Printf("cannot record wallet contest: %v", err)
+	}
+
+	contestSubscribersMu.Lock()
+	subscribers := slices.Clone(contestSubscribers)
+	contestSubscribersMu.Unlock()
+	for _, fn := range subscribers {
+		fn(c)
+	}
+}
+
+// LoadWalletContests returns the latest contests, newest first.
+func LoadWalletContests(limit int64) []WalletContest {
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-contests")
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}}).SetLimit(limit)
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var contests []WalletContest
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		log.Printf("cannot find wallet contests: %v", err)
+		return nil
+	}
+	if err := cursor.All(ctx, &contests); err != nil {
+		log.Printf("cannot decode wallet contests: %v", err)
+		return nil
+	}
+	return contests
+}
@@ -0,0 +1,240 @@
+package cv
+
+import (
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type (
+	// WalletEvent is an entry in the wallet ledger. LinkedWallets only holds
+	// the current wallets; the ledger keeps when each one was linked and
+	// unlinked, so a past holder snapshot can be checked against the wallets
+	// the user had at the time.
+	WalletEvent struct {
+		UserID  string       `bson:"user_id"`
+		Payment string       `bson:"payment"`
+		Stake   string       `bson:"stake,omitempty"`
+		Action  WalletAction `bson:"action"`
+		Label   string       `bson:"label,omitempty"`
+		At      time.Time    `bson:"at"`
+	}
+
+	WalletAction string
+)
+
+const (
+	WalletLinked   WalletAction = "linked"
+	WalletUnlinked WalletAction = "unlinked"
+	WalletRenamed  WalletAction = "renamed"
+	WalletPrimary  WalletAction = "primary"
+
+	// longest wallet label, it has to fit in select menus
+	MaxWalletLabel = 32
+)
+
+// LinkWallet adds the wallet unless the user already has it, and reports
+// whether it did. The caller saves the user.
+func (u *User) LinkWallet(wallet Wallet) bool {
+	for _, w := range u.LinkedWallets {
+		if w.Payment == wallet.Payment {
+			return false
+		}
+	}
+	wallet.LinkedAt = time.Now().UTC()
+	wallet.Primary = len(u.LinkedWallets) == 0
+	u.LinkedWallets = append(u.LinkedWallets, wallet)
+	recordWalletEvent(u.ID, wallet, WalletLinked)
+	return true
+}
+
+// stakeVerified reports whether the stake key of stake signed for one of
+// the user's wallets, which covers every address under it.
+func (u User) stakeVerified(stake string) bool {
+	if stake == "" {
+		return false
+	}
+	for _, w := range u.LinkedWallets {
+		if w.StakeVerified && addressStake(w.Payment) == stake {
+			return true
+		}
+	}
+	return false
+}
+
+// UnlinkWallet removes the wallet. If it was the primary, the oldest of the
+// remaining wallets takes its place.
+func (u *User) UnlinkWallet(payment string) (Wallet, bool) {
+	for n, w := range u.LinkedWallets {
+		if w.Payment != payment {
+			continue
+		}
+		u.LinkedWallets = append(u.LinkedWallets[:n], u.LinkedWallets[n+1:]...)
+		if w.Primary && len(u.LinkedWallets) > 0 {
+			u.LinkedWallets[0].Primary = true
+		}
+		recordWalletEvent(u.ID, w, WalletUnlinked)
+		releaseWalletClaim(u.ID, w.Payment)
+		return w, true
+	}
+	return Wallet{}, false
+}
+
+func (u *User) RenameWallet(payment, label string) bool {
+	for n, w := range u.LinkedWallets {
+		if w.Payment == payment {
+			u.LinkedWallets[n].Label = label
+			recordWalletEvent(u.ID, u.LinkedWallets[n], WalletRenamed)
+			return true
+		}
+	}
+	return false
+}
+
+// SetPrimaryWallet makes the wallet the /harvest default.
+func (u *User) SetPrimaryWallet(payment string) bool {
+	found := false
+	for n := range u.LinkedWallets {
+		u.LinkedWallets[n].Primary = u.LinkedWallets[n].Payment == payment
+		if u.LinkedWallets[n].Primary {
+			found = true
+			recordWalletEvent(u.ID, u.LinkedWallets[n], WalletPrimary)
+		}
+	}
+	return found
+}
+
+// Wallets lists the linked wallets with the primary first. Users who linked
+// before there was a primary get their first wallet as one.
+func (u User) Wallets() []Wallet {
+	wallets := make([]Wallet, 0, len(u.LinkedWallets))
+	primary := -1
+	for n, w := range u.LinkedWallets {
+		if w.Primary && primary < 0 {
+			primary = n
+		}
+	}
+	if primary < 0 && len(u.LinkedWallets) > 0 {
+		primary = 0
+	}
+	for n, w := range u.LinkedWallets {
+		if n == primary {
+			w.Primary = true
+			wallets = append([]Wallet{w}, wallets...)
+			continue
+		}
+		w.Primary = false
+		wallets = append(wallets, w)
+	}
+	return wallets
+}
+
+// Name is the label, or the shortened address when there is none.
+func (w Wallet) Name() string {
+	if w.Label != "" {
+		return w.Label
+	}
+	return TruncateMiddle(w.Payment, 32)
+}
+
+func recordWalletEvent(userID string, w Wallet, action WalletAction) {
+	if mongo.DB == nil {
+		return
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-ledger")
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, WalletEvent{
+		UserID:  userID,
+		Payment: w.Payment,
+		Stake:   w.Stake,
+		Action:  action,
+		Label:   w.Label,
+		At:      time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("cannot record wallet event: %v", err)
+	}
+}
+
+// BackfillWalletLedger records a linked event for every wallet linked before
+// the ledger existed, dated when it was linked, so snapshot audits find it.
+func BackfillWalletLedger(ctx context.Context) error {
+	if mongo.DB == nil {
+		return errors.New("database is not connected")
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-ledger")
+	for _, u := range LoadUsers() {
+		for _, w := range u.LinkedWallets {
+			filter := bson.D{{Key: "user_id", Value: u.ID}, {Key: "payment", Value: w.Payment}}
+			n, err := collection.CountDocuments(ctx, filter)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				continue
+			}
+			e := WalletEvent{UserID: u.ID, Payment: w.Payment, Stake: w.Stake, Action: WalletLinked, Label: w.Label, At: w.LinkedAt}
+			if _, err := collection.InsertOne(ctx, e); err != nil {
+				log.Printf("cannot backfill wallet event: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadWalletEvents returns a user's wallet ledger, oldest first.
+func LoadWalletEvents(userID string) []WalletEvent {
+	collection := mongo.DB.Database("cardano-valley").Collection("wallet-ledger")
+	filter := bson.D{{Key: "user_id", Value: userID}}
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: 1}})
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var events []WalletEvent
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("cannot find wallet events: %v", err)
+		return nil
+	}
+	if err := cursor.All(ctx, &events); err != nil {
+		log.Printf("cannot decode wallet events: %v", err)
+		return nil
+	}
+	return events
+}
+
+// WalletsAt lists the addresses the user had linked at t, from the ledger.
+func WalletsAt(userID string, t time.Time) []string {
+	linked := make(map[string]bool)
+	var order []string
+	for _, e := range LoadWalletEvents(userID) {
+		if e.At.After(t) {
+			break
+		}
+		switch e.Action {
+		case WalletLinked:
+			if !linked[e.Payment] {
+				order = append(order, e.Payment)
+			}
+			linked[e.Payment] = true
+		case WalletUnlinked:
+			linked[e.Payment] = false
+		}
+	}
+	var wallets []string
+	for _, p := range order {
+		if linked[p] {
+			wallets = append(wallets, p)
+		}
+	}
+	return wallets
+}
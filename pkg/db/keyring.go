@@ -0,0 +1,192 @@
+package db
+
+import (
+	"cardano-valley/pkg/logger"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Ciphertexts are "<key ID>:<base64 nonce+sealed>". The key ID says which
+// master key to open them with, so the key can be rotated: the new key goes
+// in CARDANO_VALLEY_CYPHER with a new CARDANO_VALLEY_CYPHER_ID, the old one
+// moves to CARDANO_VALLEY_CYPHER_RETIRED and stays usable for decryption
+// until everything has been re-encrypted. Ciphertexts written before key IDs
+// existed have no prefix and are tried against every key.
+
+type Keyring struct {
+	Current string            // ID of the key new ciphertexts are sealed with
+	keys    map[string][]byte // every key that may still open a ciphertext
+	order   []string          // current first, then retired keys as configured
+}
+
+const defaultKeyID = "k0"
+
+var (
+	ErrUnknownKey = errors.New("ciphertext was sealed with a key that is not configured")
+
+	// Keys is loaded by LoadKeys.
+	Keys *Keyring
+)
+
+// NewKeyring starts a keyring whose current key is id.
+func NewKeyring(id string, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte)}
+	if err := k.Add(id, key); err != nil {
+		return nil, err
+	}
+	k.Current = id
+	return k, nil
+}
+
+// Add makes a retired key available for decryption.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || strings.ContainsAny(id, ": ") {
+		return fmt.Errorf("invalid key ID %q", id)
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("key %s: %w", id, err)
+	}
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("key %s is configured twice", id)
+	}
+	k.keys[id] = key
+	k.order = append(k.order, id)
+	return nil
+}
+
+// IDs returns the configured key IDs, current first.
+func (k *Keyring) IDs() []string {
+	return append([]string(nil), k.order...)
+}
+
+// Seal encrypts plaintext with the current key. aad is authenticated but not
+// stored, and has to be passed to Open again.
+func (k *Keyring) Seal(plaintext, aad []byte) (string, error) {
+	gcm, err := newGCM(k.keys[k.Current])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, aad)
+	return k.Current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a ciphertext sealed under any configured key.
+func (k *Keyring) Open(ciphertext string, aad []byte) ([]byte, error) {
+	id, encoded, ok := strings.Cut(ciphertext, ":")
+	if !ok {
+		// written before key IDs, try each key
+		var lastErr error = ErrUnknownKey
+		for _, id := range k.order {
+			plaintext, err := open(k.keys[id], ciphertext, aad)
+			if err == nil {
+				return plaintext, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return open(key, encoded, aad)
+}
+
+// KeyID returns the ID a ciphertext was sealed with, "" for ciphertexts
+// written before key IDs.
+func KeyID(ciphertext string) string {
+	id, _, ok := strings.Cut(ciphertext, ":")
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// IsCurrent reports whether the ciphertext is sealed with the current key.
+// Empty values have nothing to re-encrypt and count as current.
+func (k *Keyring) IsCurrent(ciphertext string) bool {
+	return ciphertext == "" || KeyID(ciphertext) == k.Current
+}
+
+// Reseal opens a ciphertext and seals it again under the current key.
+func (k *Keyring) Reseal(ciphertext string, aad []byte) (string, error) {
+	if k.IsCurrent(ciphertext) {
+		return ciphertext, nil
+	}
+	plaintext, err := k.Open(ciphertext, aad)
+	if err != nil {
+		return "", err
+	}
+	defer wipe(plaintext)
+	return k.Seal(plaintext, aad)
+}
+
+// loadKeyring reads CARDANO_VALLEY_CYPHER_ID (default "k0") for the current
+// key and CARDANO_VALLEY_CYPHER_RETIRED, a comma separated list of id:key
+// pairs, for the keys it replaced.
+func loadKeyring(current []byte) *Keyring {
+	id := os.Getenv("CARDANO_VALLEY_CYPHER_ID")
+	if id == "" {
+		id = defaultKeyID
+	}
+	k, err := NewKeyring(id, current)
+	if err != nil {
+		log.Fatalf("Invalid CARDANO_VALLEY_CYPHER: %v", err)
+	}
+
+	if retired := os.Getenv("CARDANO_VALLEY_CYPHER_RETIRED"); retired != "" {
+		for _, entry := range strings.Split(retired, ",") {
+			oldID, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+			if !ok {
+				log.Fatalf("CARDANO_VALLEY_CYPHER_RETIRED entries must be id:key")
+			}
+			if err := k.Add(oldID, []byte(key)); err != nil {
+				log.Fatalf("Invalid CARDANO_VALLEY_CYPHER_RETIRED key %q: %v", oldID, err)
+			}
+		}
+	}
+	logger.Record.Info("DB", "CYPHER", k.Current, "KEYS", strings.Join(k.order, ","))
+	return k
+}
+
+func open(key []byte, encoded string, aad []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
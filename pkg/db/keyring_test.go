@@ -0,0 +1,136 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func newKeyring(t *testing.T, id string, key []byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	k := newKeyring(t, "k1", oldKey)
+	sealed, err := k.Seal([]byte("secret"), []byte("aad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, "k1:") || KeyID(sealed) != "k1" {
+		t.Errorf("ciphertext %q does not name its key", sealed)
+	}
+	if !k.IsCurrent(sealed) || !k.IsCurrent("") {
+		t.Error("IsCurrent is false for the current key")
+	}
+
+	got, err := k.Open(sealed, []byte("aad"))
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+	if _, err := k.Open(sealed, []byte("other")); err == nil {
+		t.Error("Open with other additional data succeeded")
+	}
+	tampered := []byte(sealed)
+	tampered[len(tampered)-3] ^= 1
+	if _, err := k.Open(string(tampered), []byte("aad")); err == nil {
+		t.Error("Open of a tampered ciphertext succeeded")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	sealed, err := newKeyring(t, "k1", oldKey).Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := newKeyring(t, "k2", newKey)
+	if _, err := k.Open(sealed, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open without the key = %v, want %v", err, ErrUnknownKey)
+	}
+	if err := k.Add("k1", oldKey); err != nil {
+		t.Fatal(err)
+	}
+	if ids := k.IDs(); len(ids) != 2 || ids[0] != "k2" {
+		t.Errorf("IDs = %v, want the current key first", ids)
+	}
+	if k.IsCurrent(sealed) {
+		t.Error("a ciphertext under the retired key is current")
+	}
+
+	resealed, err := k.Reseal(sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyID(resealed) != "k2" {
+		t.Errorf("Reseal used key %q, want k2", KeyID(resealed))
+	}
+	if again, _ := k.Reseal(resealed, nil); again != resealed {
+		t.Error("Reseal rewrote a current ciphertext")
+	}
+	got, err := newKeyring(t, "k2", newKey).Open(resealed, nil)
+	if err != nil || string(got) != "secret" {
+		t.Errorf("Open after Reseal = %q, %v", got, err)
+	}
+}
+
+func TestKeyringLegacyCiphertext(t *testing.T) {
+	sealed, err := newKeyring(t, "k1", oldKey).Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written before key IDs: no prefix, tried against every key
+	_, legacy, _ := strings.Cut(sealed, ":")
+	if KeyID(legacy) != "" {
+		t.Errorf("KeyID(%q) = %q", legacy, KeyID(legacy))
+	}
+
+	k := newKeyring(t, "k2", newKey)
+	if err := k.Add("k1", oldKey); err != nil {
+		t.Fatal(err)
+	}
+	got, err := k.Open(legacy, nil)
+	if err != nil || string(got) != "secret" {
+		t.Errorf("Open = %q, %v", got, err)
+	}
+	if _, err := newKeyring(t, "k2", newKey).Open(legacy, nil); err == nil {
+		t.Error("Open without the key succeeded")
+	}
+}
+
+func TestKeyringAdd(t *testing.T) {
+	k := newKeyring(t, "k1", oldKey)
+	for _, tt := range []struct {
+		id  string
+		key []byte
+	}{
+		{"", newKey},
+		{"k:2", newKey},
+		{"k 2", newKey},
+		{"k2", []byte("short")},
+		{"k1", newKey},
+	} {
+		if err := k.Add(tt.id, tt.key); err == nil {
+			t.Errorf("Add(%q) succeeded", tt.id)
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	t.Setenv("CARDANO_VALLEY_CYPHER_ID", "k2")
+	t.Setenv("CARDANO_VALLEY_CYPHER_RETIRED", "k1:"+string(oldKey))
+	k := loadKeyring(newKey)
+	if k.Current != "k2" || len(k.IDs()) != 2 {
+		t.Errorf("keyring %s with %v", k.Current, k.IDs())
+	}
+}
@@ -3,12 +3,8 @@ package db
 import (
 	"cardano-valley/pkg/logger"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
-	"encoding/base64"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"time"
@@ -22,12 +18,24 @@ var (
 	CARDANO_VALLEY_CYPHER []byte
 )
 
-func init() {
+// LoadKeys reads the master keys. It is called by main before anything is
+// encrypted; tests set Keys themselves. Offline runs without a configured key
+// get a random one that only lives as long as the process.
+func LoadKeys() {
 	key, ok := os.LookupEnv("CARDANO_VALLEY_CYPHER")
 	if !ok {
-		log.Fatalf("Missing CARDANO_VALLEY_CYPHER")
+		if os.Getenv("CARDANO_VALLEY_OFFLINE") == "" {
+			log.Fatalf("Missing CARDANO_VALLEY_CYPHER")
+		}
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			log.Fatalf("Cannot generate an offline key: %v", err)
+		}
+		logger.Record.Warn("DB", "CYPHER", "CARDANO_VALLEY_CYPHER is not set, using a key for this run only")
+		key = string(random)
 	}
 	CARDANO_VALLEY_CYPHER = []byte(key)
+	Keys = loadKeyring(CARDANO_VALLEY_CYPHER)
 }
 
 func Close(client *mongo.Client, ctx context.Context, cancel context.CancelFunc){
@@ -41,7 +49,20 @@ func Close(client *mongo.Client, ctx context.Context, cancel context.CancelFunc)
 }
 
 
+// Connect opens the Atlas cluster, or a database in memory in offline mode
+// that is gone when the process exits.
 func Connect() (*mongo.Client, context.Context, context.CancelFunc, error) {
+	if os.Getenv("CARDANO_VALLEY_OFFLINE") != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		DB, err := NewMemoryClient()
+		if err != nil {
+			cancel()
+			return nil, nil, nil, err
+		}
+		logger.Record.Warn("DB", "OFFLINE", "using a database in memory, nothing is persisted")
+		return DB, ctx, cancel, nil
+	}
+
 	// Use the SetServerAPIOptions() method to set the version of the Stable API on the client
 	CARDANO_VALLEY_MONGODB_PASSWORD, ok := os.LookupEnv("CARDANO_VALLEY_MONGODB_PASSWORD")
 	if !ok {
@@ -75,54 +96,18 @@ func Connect() (*mongo.Client, context.Context, context.CancelFunc, error) {
 	return DB, ctx, cancel, err
 }
 
+// Encrypt seals plaintext with the current master key.
 func Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(CARDANO_VALLEY_CYPHER)
-	if err != nil {
-		return "", err
-	}
-
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonce := make([]byte, aesGCM.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
-	}
-
-	ciphertext := aesGCM.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return Keys.Seal([]byte(plaintext), nil)
 }
 
+// Decrypt opens a ciphertext from Encrypt under whichever configured key
+// sealed it.
 func Decrypt(encryptedString string) (string, error) {
-	ciphertext, err := base64.StdEncoding.DecodeString(encryptedString)
+	plaintext, err := Keys.Open(encryptedString, nil)
 	if err != nil {
 		return "", err
 	}
-
-	block, err := aes.NewCipher(CARDANO_VALLEY_CYPHER)
-	if err != nil {
-		return "", err
-	}
-
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonceSize := aesGCM.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return "", err
-	}
-
-	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return "", err
-	}
-
 	return string(plaintext), nil
 }
 
@@ -0,0 +1,820 @@
+package db
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  MEMORY QUERIES: filters, sorting and update operators
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// lookup returns the top level field key of doc, nil when it is missing.
+func lookup(doc bson.D, key string) interface{} {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+// lookupPath returns the values a dotted path reaches in doc. Like a query,
+// it descends into every document of an array on the way, so a path may
+// reach several values or none.
+func lookupPath(doc bson.D, path []string) []interface{} {
+	return walk(doc, path)
+}
+
+func walk(v interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	switch t := v.(type) {
+	case bson.D:
+		for _, e := range t {
+			if e.Key == path[0] {
+				return walk(e.Value, path[1:])
+			}
+		}
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i >= 0 && i < len(t) {
+				return walk(t[i], path[1:])
+			}
+			return nil
+		}
+		var out []interface{}
+		for _, elem := range t {
+			if _, ok := elem.(bson.D); ok {
+				out = append(out, walk(elem, path)...)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// expandArrays replaces every array among values with its elements.
+func expandArrays(values []interface{}) []interface{} {
+	var out []interface{}
+	for _, v := range values {
+		if arr, ok := v.(bson.A); ok {
+			out = append(out, arr...)
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// ─── matching ───
+
+// matches reports whether doc satisfies a query filter.
+func matches(doc bson.D, filter bson.D) bool {
+	for _, e := range filter {
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			subs, _ := e.Value.(bson.A)
+			hits := 0
+			for _, sub := range subs {
+				if f, ok := sub.(bson.D); ok && matches(doc, f) {
+					hits++
+				}
+			}
+			switch {
+			case e.Key == "$and" && hits != len(subs),
+				e.Key == "$or" && hits == 0,
+				e.Key == "$nor" && hits > 0:
+				return false
+			}
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return false
+			}
+			if !matchCondition(lookupPath(doc, strings.Split(e.Key, ".")), e.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isOperatorDoc(v interface{}) bool {
+	d, ok := v.(bson.D)
+	return ok && len(d) > 0 && strings.HasPrefix(d[0].Key, "$")
+}
+
+// matchCondition tests the values of a field against either a value to
+// equal or a document of operators.
+func matchCondition(values []interface{}, cond interface{}) bool {
+	if !isOperatorDoc(cond) {
+		return matchEqual(values, cond)
+	}
+	ops := cond.(bson.D)
+	for _, op := range ops {
+		if !matchOperator(values, op.Key, op.Value, ops) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates are the values a field compares by: the values themselves and
+// the elements of those that are arrays.
+func candidates(values []interface{}) []interface{} {
+	out := append([]interface{}{}, values...)
+	for _, v := range values {
+		if arr, ok := v.(bson.A); ok {
+			out = append(out, arr...)
+		}
+	}
+	return out
+}
+
+func matchEqual(values []interface{}, want interface{}) bool {
+	if want == nil && len(values) == 0 {
+		return true
+	}
+	for _, v := range candidates(values) {
+		if valuesEqual(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOperator(values []interface{}, op string, arg interface{}, ops bson.D) bool {
+	switch op {
+	case "$eq":
+		return matchEqual(values, arg)
+	case "$ne":
+		return !matchEqual(values, arg)
+	case "$gt", "$gte", "$lt", "$lte":
+		for _, v := range candidates(values) {
+			if typeRank(v) != typeRank(arg) {
+				continue
+			}
+			c := compareValues(v, arg)
+			if op == "$gt" && c > 0 || op == "$gte" && c >= 0 || op == "$lt" && c < 0 || op == "$lte" && c <= 0 {
+				return true
+			}
+		}
+		return false
+	case "$in", "$nin":
+		arr, _ := arg.(bson.A)
+		in := false
+		for _, want := range arr {
+			if matchEqual(values, want) {
+				in = true
+				break
+			}
+		}
+		return in == (op == "$in")
+	case "$exists":
+		return (len(values) > 0) == truthy(arg)
+	case "$size":
+		n, _ := asInt(arg)
+		for _, v := range values {
+			if arr, ok := v.(bson.A); ok && int64(len(arr)) == n {
+				return true
+			}
+		}
+		return false
+	case "$elemMatch":
+		for _, v := range values {
+			arr, ok := v.(bson.A)
+			if !ok {
+				continue
+			}
+			for _, elem := range arr {
+				if isOperatorDoc(arg) {
+					if matchCondition([]interface{}{elem}, arg) {
+						return true
+					}
+				} else if d, ok := elem.(bson.D); ok {
+					if f, _ := arg.(bson.D); matches(d, f) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	case "$not":
+		return !matchCondition(values, arg)
+	case "$regex":
+		var re *regexp.Regexp
+		var err error
+		switch p := arg.(type) {
+		case primitive.Regex:
+			re, err = compileRegex(p.Pattern, p.Options)
+		case string:
+			options, _ := lookup(ops, "$options").(string)
+			re, err = compileRegex(p, options)
+		}
+		if re == nil || err != nil {
+			return false
+		}
+		for _, v := range candidates(values) {
+			if s, ok := v.(string); ok && re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	case "$options":
+		return true
+	}
+	return false
+}
+
+func compileRegex(pattern, options string) (*regexp.Regexp, error) {
+	flags := ""
+	for _, o := range options {
+		if strings.ContainsRune("ims", o) {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case nil:
+		return false
+	}
+	n, ok := asInt(v)
+	return !ok || n != 0
+}
+
+// ─── comparison ───
+
+// typeRank orders values of different BSON types the way the server sorts
+// them; all numbers share a rank.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case primitive.MinKey:
+		return 1
+	case nil, primitive.Null, primitive.Undefined:
+		return 2
+	case int32, int64, float64, int:
+		return 3
+	case string, primitive.Symbol:
+		return 4
+	case bson.D:
+		return 5
+	case bson.A:
+		return 6
+	case primitive.Binary:
+		return 7
+	case primitive.ObjectID:
+		return 8
+	case bool:
+		return 9
+	case primitive.DateTime:
+		return 10
+	case primitive.Timestamp:
+		return 11
+	case primitive.Regex:
+		return 12
+	case primitive.MaxKey:
+		return 100
+	}
+	return 50
+}
+
+func asInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+func asFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+func isFloat(v interface{}) bool {
+	_, ok := v.(float64)
+	return ok
+}
+
+// compareValues orders two values by BSON type, then by value; numbers of
+// every type compare by value.
+func compareValues(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		return cmpInt(int64(ra), int64(rb))
+	}
+	switch x := a.(type) {
+	case int32, int64, int, float64:
+		if !isFloat(a) && !isFloat(b) {
+			ia, _ := asInt(a)
+			ib, _ := asInt(b)
+			return cmpInt(ia, ib)
+		}
+		fa, fb := asFloat(a), asFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, fmt.Sprint(b))
+	case primitive.Symbol:
+		return strings.Compare(string(x), fmt.Sprint(b))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compareValues(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(int64(len(x)), int64(len(y)))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(int64(len(x)), int64(len(y)))
+	case primitive.Binary:
+		y := b.(primitive.Binary)
+		if len(x.Data) != len(y.Data) {
+			return cmpInt(int64(len(x.Data)), int64(len(y.Data)))
+		}
+		if x.Subtype != y.Subtype {
+			return cmpInt(int64(x.Subtype), int64(y.Subtype))
+		}
+		return bytes.Compare(x.Data, y.Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		switch {
+		case x == y:
+			return 0
+		case !x:
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return cmpInt(int64(x), int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		return primitive.CompareTimestamp(x, b.(primitive.Timestamp))
+	case primitive.Regex:
+		y := b.(primitive.Regex)
+		return strings.Compare(x.Pattern+"/"+x.Options, y.Pattern+"/"+y.Options)
+	}
+	return strings.Compare(indexKey(a), indexKey(b))
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func valuesEqual(a, b interface{}) bool {
+	return typeRank(a) == typeRank(b) && compareValues(a, b) == 0
+}
+
+// sortDocs orders docs by a sort specification of fields and directions.
+func sortDocs(docs []bson.D, spec bson.D) {
+	sort.SliceStable(docs, func(i, j int) bool {
+		return compareDocs(docs[i], docs[j], spec) < 0
+	})
+}
+
+func compareDocs(a, b bson.D, spec bson.D) int {
+	for _, field := range spec {
+		dir, _ := asInt(field.Value)
+		if dir == 0 {
+			dir = 1
+		}
+		path := strings.Split(field.Key, ".")
+		if c := compareValues(sortValue(a, path, dir), sortValue(b, path, dir)); c != 0 {
+			return c * int(dir)
+		}
+	}
+	return 0
+}
+
+// sortValue is the value a document sorts by: for arrays the smallest
+// element ascending and the largest descending.
+func sortValue(doc bson.D, path []string, dir int64) interface{} {
+	values := expandArrays(lookupPath(doc, path))
+	if len(values) == 0 {
+		return nil
+	}
+	best := values[0]
+	for _, v := range values[1:] {
+		if c := compareValues(v, best); dir > 0 && c < 0 || dir < 0 && c > 0 {
+			best = v
+		}
+	}
+	return best
+}
+
+// indexKey renders a value canonically, so values that compare equal,
+// numbers of different types included, render the same.
+func indexKey(v interface{}) string {
+	switch t := v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return "null"
+	case int32, int64, int, float64:
+		f := asFloat(v)
+		if i, ok := asInt(v); ok && (!isFloat(v) || f == math.Trunc(f) && math.Abs(f) < 1<<62) {
+			return "n" + strconv.FormatInt(i, 10)
+		}
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64)
+	case string:
+		return "s" + strconv.Quote(t)
+	case bool:
+		return "b" + strconv.FormatBool(t)
+	case bson.D:
+		parts := make([]string, len(t))
+		for i, e := range t {
+			parts[i] = strconv.Quote(e.Key) + ":" + indexKey(e.Value)
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case bson.A:
+		parts := make([]string, len(t))
+		for i, e := range t {
+			parts[i] = indexKey(e)
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case primitive.ObjectID:
+		return "o" + t.Hex()
+	case primitive.DateTime:
+		return "d" + strconv.FormatInt(int64(t), 10)
+	case primitive.Binary:
+		return "x" + strconv.Itoa(int(t.Subtype)) + ":" + hex.EncodeToString(t.Data)
+	}
+	return fmt.Sprintf("%T:%v", v, v)
+}
+
+// ─── updates ───
+
+func clone(doc bson.D) bson.D {
+	if doc == nil {
+		return nil
+	}
+	return cloneValue(doc).(bson.D)
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.D:
+		out := make(bson.D, len(t))
+		for i, e := range t {
+			out[i] = bson.E{Key: e.Key, Value: cloneValue(e.Value)}
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(t))
+		for i, e := range t {
+			out[i] = cloneValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// addNumbers adds two numbers in the wider of their types; a missing value
+// counts as zero.
+func addNumbers(a, b interface{}) (interface{}, bool) {
+	if a == nil {
+		a = int32(0)
+	}
+	if b == nil {
+		b = int32(0)
+	}
+	if typeRank(a) != 3 || typeRank(b) != 3 {
+		return a, false
+	}
+	if isFloat(a) || isFloat(b) {
+		return asFloat(a) + asFloat(b), true
+	}
+	x, _ := asInt(a)
+	y, _ := asInt(b)
+	_, a32 := a.(int32)
+	_, b32 := b.(int32)
+	if sum := x + y; a32 && b32 && sum >= math.MinInt32 && sum <= math.MaxInt32 {
+		return int32(sum), true
+	}
+	return x + y, true
+}
+
+// setPath sets a dotted path in doc, creating the documents on the way.
+func setPath(doc bson.D, path []string, v interface{}) bson.D {
+	out, err := setIn(doc, path, v)
+	if err != nil {
+		return doc
+	}
+	return out.(bson.D)
+}
+
+func setIn(v interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	switch t := v.(type) {
+	case nil:
+		return setIn(bson.D{}, path, value)
+	case bson.D:
+		for i, e := range t {
+			if e.Key == path[0] {
+				nv, err := setIn(e.Value, path[1:], value)
+				if err != nil {
+					return nil, err
+				}
+				t[i].Value = nv
+				return t, nil
+			}
+		}
+		nv, err := setIn(nil, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return append(t, bson.E{Key: path[0], Value: nv}), nil
+	case bson.A:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 {
+			return nil, memoryError{28, fmt.Sprintf("cannot create field '%s' in an array", path[0])}
+		}
+		for len(t) <= i {
+			t = append(t, nil)
+		}
+		nv, err := setIn(t[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		t[i] = nv
+		return t, nil
+	}
+	return nil, memoryError{28, fmt.Sprintf("cannot create field '%s' in element {%v}", path[0], v)}
+}
+
+func unsetIn(v interface{}, path []string) interface{} {
+	switch t := v.(type) {
+	case bson.D:
+		for i, e := range t {
+			if e.Key != path[0] {
+				continue
+			}
+			if len(path) == 1 {
+				return append(t[:i:i], t[i+1:]...)
+			}
+			t[i].Value = unsetIn(e.Value, path[1:])
+			return t
+		}
+	case bson.A:
+		if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(t) {
+			if len(path) == 1 {
+				t[i] = nil
+			} else {
+				t[i] = unsetIn(t[i], path[1:])
+			}
+		}
+	}
+	return v
+}
+
+// getPath returns the value at an exact dotted path, without descending
+// into arrays other than by index.
+func getPath(doc bson.D, path []string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, key := range path {
+		switch t := v.(type) {
+		case bson.D:
+			found := false
+			for _, e := range t {
+				if e.Key == key {
+					v, found = e.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case bson.A:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// applyUpdate applies either a replacement document or update operators to
+// doc. inserting is set for the document of an upsert.
+func applyUpdate(doc bson.D, update bson.D, inserting bool) (bson.D, error) {
+	if !isOperatorDoc(update) {
+		out := bson.D{}
+		if id := lookup(update, "_id"); id != nil {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		} else if id := lookup(doc, "_id"); id != nil {
+			out = append(out, bson.E{Key: "_id", Value: id})
+		}
+		for _, e := range update {
+			if e.Key != "_id" {
+				out = append(out, bson.E{Key: e.Key, Value: cloneValue(e.Value)})
+			}
+		}
+		return out, nil
+	}
+
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, memoryError{9, fmt.Sprintf("modifier %s takes a document", op.Key)}
+		}
+		for _, f := range fields {
+			path := strings.Split(f.Key, ".")
+			var err error
+			switch op.Key {
+			case "$set":
+				doc, err = assign(doc, path, cloneValue(f.Value))
+			case "$setOnInsert":
+				if inserting {
+					doc, err = assign(doc, path, cloneValue(f.Value))
+				}
+			case "$unset":
+				doc = unsetIn(doc, path).(bson.D)
+			case "$inc":
+				cur, _ := getPath(doc, path)
+				sum, ok := addNumbers(cur, f.Value)
+				if !ok {
+					return nil, memoryError{14, fmt.Sprintf("cannot apply $inc to a value of non-numeric type at %s", f.Key)}
+				}
+				doc, err = assign(doc, path, sum)
+			case "$min", "$max":
+				cur, exists := getPath(doc, path)
+				c := compareValues(f.Value, cur)
+				if !exists || op.Key == "$min" && c < 0 || op.Key == "$max" && c > 0 {
+					doc, err = assign(doc, path, cloneValue(f.Value))
+				}
+			case "$push", "$addToSet":
+				doc, err = push(doc, path, f.Value, op.Key == "$addToSet")
+			case "$pull":
+				doc, err = pull(doc, path, f.Value)
+			default:
+				return nil, memoryError{9, fmt.Sprintf("unknown modifier: %s", op.Key)}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc, nil
+}
+
+func assign(doc bson.D, path []string, v interface{}) (bson.D, error) {
+	out, err := setIn(doc, path, v)
+	if err != nil {
+		return nil, err
+	}
+	return out.(bson.D), nil
+}
+
+func push(doc bson.D, path []string, arg interface{}, unique bool) (bson.D, error) {
+	items := bson.A{arg}
+	if spec, ok := arg.(bson.D); ok && isOperatorDoc(spec) {
+		each, ok := lookup(spec, "$each").(bson.A)
+		if !ok {
+			return nil, memoryError{codeBadValue, "only $each is supported in $push and $addToSet"}
+		}
+		items = each
+	}
+
+	cur, exists := getPath(doc, path)
+	arr, ok := cur.(bson.A)
+	if exists && !ok {
+		return nil, memoryError{codeBadValue, fmt.Sprintf("the field '%s' must be an array", strings.Join(path, "."))}
+	}
+	arr = append(bson.A{}, arr...)
+	for _, item := range items {
+		if unique {
+			seen := false
+			for _, have := range arr {
+				if valuesEqual(have, item) {
+					seen = true
+					break
+				}
+			}
+			if seen {
+				continue
+			}
+		}
+		arr = append(arr, cloneValue(item))
+	}
+	return assign(doc, path, arr)
+}
+
+func pull(doc bson.D, path []string, cond interface{}) (bson.D, error) {
+	cur, exists := getPath(doc, path)
+	if !exists {
+		return doc, nil
+	}
+	arr, ok := cur.(bson.A)
+	if !ok {
+		return nil, memoryError{codeBadValue, fmt.Sprintf("cannot apply $pull to a non-array value at %s", strings.Join(path, "."))}
+	}
+	kept := bson.A{}
+	for _, item := range arr {
+		var hit bool
+		switch {
+		case isOperatorDoc(cond):
+			hit = matchCondition([]interface{}{item}, cond)
+		default:
+			if d, ok := item.(bson.D); ok {
+				if f, ok := cond.(bson.D); ok {
+					hit = matches(d, f)
+					break
+				}
+			}
+			hit = valuesEqual(item, cond)
+		}
+		if !hit {
+			kept = append(kept, item)
+		}
+	}
+	return assign(doc, path, kept)
+}
+
+// upsertBase is the document an upsert starts from: the equalities of its
+// filter.
+func upsertBase(filter bson.D) bson.D {
+	doc := bson.D{}
+	var add func(f bson.D)
+	add = func(f bson.D) {
+		for _, e := range f {
+			switch {
+			case e.Key == "$and":
+				subs, _ := e.Value.(bson.A)
+				for _, sub := range subs {
+					if d, ok := sub.(bson.D); ok {
+						add(d)
+					}
+				}
+			case strings.HasPrefix(e.Key, "$"):
+			case isOperatorDoc(e.Value):
+				if eq, ok := getPath(e.Value.(bson.D), []string{"$eq"}); ok {
+					doc = setPath(doc, strings.Split(e.Key, "."), cloneValue(eq))
+				}
+			default:
+				doc = setPath(doc, strings.Split(e.Key, "."), cloneValue(e.Value))
+			}
+		}
+	}
+	add(filter)
+	return doc
+}
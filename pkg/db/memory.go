@@ -0,0 +1,942 @@
+package db
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/address"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"go.mongodb.org/mongo-driver/x/mongo/driver"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/wiremessage"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  MEMORY: a database held in memory, for offline runs and tests
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// The memory deployment plugs into the driver below the wire protocol, so a
+// *mongo.Client talks to it like to a server and every caller of DB works
+// unchanged. It answers the commands the bot sends: CRUD, findAndModify,
+// count and distinct, unique, sparse and partial indexes, and transactions,
+// which are undone on abort but not isolated. Every command runs under one
+// lock.
+
+type (
+	memoryDeployment struct {
+		mu   sync.Mutex
+		dbs  map[string]map[string]*memoryCollection // database -> collection
+		txns map[string][]memoryUndo                 // session ID -> writes of its transaction
+	}
+
+	memoryCollection struct {
+		docs    []bson.D
+		indexes []memoryIndex
+	}
+
+	memoryIndex struct {
+		Name    string
+		Keys    bson.D
+		Unique  bool
+		Sparse  bool
+		Partial bson.D
+	}
+
+	// memoryUndo restores a document to before a write of a transaction;
+	// before is nil for a document the write inserted.
+	memoryUndo struct {
+		db, collection string
+		id             interface{}
+		before         bson.D
+	}
+
+	memoryServer     struct{ d *memoryDeployment }
+	memoryConnection struct {
+		d     *memoryDeployment
+		reply []byte
+	}
+	memoryRTT struct{}
+
+	// memoryError is a command failure, sent back as {ok: 0}.
+	memoryError struct {
+		Code int32
+		Msg  string
+	}
+)
+
+const (
+	memoryAddress = address.Address("memory")
+
+	codeIndexNotFound         = 27
+	codeNoSuchTransaction     = 251
+	codeCommandNotFound       = 59
+	codeIndexOptionsConflict  = 85
+	codeIndexKeySpecsConflict = 86
+	codeBadValue              = 2
+	codeDuplicateKey          = 11000
+)
+
+func (e memoryError) Error() string { return e.Msg }
+
+// NewMemoryClient returns a connected client for an empty database held in
+// memory.
+func NewMemoryClient() (*mongo.Client, error) {
+	d := &memoryDeployment{
+		dbs:  make(map[string]map[string]*memoryCollection),
+		txns: make(map[string][]memoryUndo),
+	}
+	opts := options.Client()
+	opts.Deployment = d
+	return mongo.Connect(context.Background(), opts)
+}
+
+// ─── driver plumbing ───
+
+func (d *memoryDeployment) SelectServer(context.Context, description.ServerSelector) (driver.Server, error) {
+	return memoryServer{d}, nil
+}
+
+func (d *memoryDeployment) Kind() description.TopologyKind { return description.ReplicaSetWithPrimary }
+
+func (s memoryServer) Connection(context.Context) (driver.Connection, error) {
+	return &memoryConnection{d: s.d}, nil
+}
+
+func (s memoryServer) RTTMonitor() driver.RTTMonitor { return memoryRTT{} }
+
+func (memoryRTT) EWMA() time.Duration { return 0 }
+func (memoryRTT) Min() time.Duration  { return 0 }
+func (memoryRTT) P90() time.Duration  { return 0 }
+func (memoryRTT) Stats() string       { return "" }
+
+func (c *memoryConnection) WriteWireMessage(_ context.Context, wm []byte) error {
+	_, requestID, _, opcode, rem, ok := wiremessage.ReadHeader(wm)
+	if !ok || opcode != wiremessage.OpMsg {
+		return fmt.Errorf("memory database: unsupported wire message %v", opcode)
+	}
+	cmd, err := readMsg(rem)
+	if err != nil {
+		return err
+	}
+	body, err := bson.Marshal(c.d.run(cmd))
+	if err != nil {
+		return err
+	}
+
+	idx, reply := wiremessage.AppendHeaderStart(nil, wiremessage.NextRequestID(), requestID, wiremessage.OpMsg)
+	reply = wiremessage.AppendMsgFlags(reply, 0)
+	reply = wiremessage.AppendMsgSectionType(reply, wiremessage.SingleDocument)
+	reply = append(reply, body...)
+	c.reply = bsoncore.UpdateLength(reply, idx, int32(len(reply[idx:])))
+	return nil
+}
+
+func (c *memoryConnection) ReadWireMessage(context.Context) ([]byte, error) {
+	if c.reply == nil {
+		return nil, errors.New("memory database: no reply pending")
+	}
+	reply := c.reply
+	c.reply = nil
+	return reply, nil
+}
+
+func (c *memoryConnection) Description() description.Server {
+	timeout := int64(30)
+	return description.Server{
+		Addr:                     memoryAddress,
+		CanonicalAddr:            memoryAddress,
+		Kind:                     description.RSPrimary,
+		SetName:                  "memory",
+		WireVersion:              &description.VersionRange{Min: 0, Max: 21},
+		SessionTimeoutMinutes:    uint32(timeout),
+		SessionTimeoutMinutesPtr: &timeout,
+		MaxBatchCount:            100_000,
+		MaxDocumentSize:          16 << 20,
+		MaxMessageSize:           48 << 20,
+	}
+}
+
+func (c *memoryConnection) Close() error               { return nil }
+func (c *memoryConnection) ID() string                 { return "memory" }
+func (c *memoryConnection) ServerConnectionID() *int64 { return nil }
+func (c *memoryConnection) DriverConnectionID() uint64 { return 0 }
+func (c *memoryConnection) Address() address.Address   { return memoryAddress }
+func (c *memoryConnection) Stale() bool                { return false }
+func (c *memoryConnection) OIDCTokenGenID() uint64     { return 0 }
+func (c *memoryConnection) SetOIDCTokenGenID(uint64)   {}
+
+// readMsg decodes an OP_MSG into its command, with document sequences added
+// to it as arrays.
+func readMsg(src []byte) (bson.D, error) {
+	flags, rem, ok := wiremessage.ReadMsgFlags(src)
+	if !ok {
+		return nil, errors.New("memory database: malformed OP_MSG")
+	}
+	if flags&wiremessage.ChecksumPresent != 0 {
+		rem = rem[:len(rem)-4]
+	}
+
+	var cmd bson.D
+	var sequences bson.D
+	for len(rem) > 0 {
+		var stype wiremessage.SectionType
+		stype, rem, ok = wiremessage.ReadMsgSectionType(rem)
+		if !ok {
+			return nil, errors.New("memory database: malformed OP_MSG section")
+		}
+		switch stype {
+		case wiremessage.SingleDocument:
+			var doc bsoncore.Document
+			doc, rem, ok = wiremessage.ReadMsgSectionSingleDocument(rem)
+			if !ok {
+				return nil, errors.New("memory database: malformed OP_MSG body")
+			}
+			if err := bson.Unmarshal(doc, &cmd); err != nil {
+				return nil, err
+			}
+		case wiremessage.DocumentSequence:
+			var id string
+			var docs []bsoncore.Document
+			id, docs, rem, ok = wiremessage.ReadMsgSectionDocumentSequence(rem)
+			if !ok {
+				return nil, errors.New("memory database: malformed OP_MSG document sequence")
+			}
+			seq := bson.A{}
+			for _, raw := range docs {
+				var doc bson.D
+				if err := bson.Unmarshal(raw, &doc); err != nil {
+					return nil, err
+				}
+				seq = append(seq, doc)
+			}
+			sequences = append(sequences, bson.E{Key: id, Value: seq})
+		default:
+			return nil, fmt.Errorf("memory database: unknown OP_MSG section %d", stype)
+		}
+	}
+	if len(cmd) == 0 {
+		return nil, errors.New("memory database: OP_MSG without a command")
+	}
+	return append(cmd, sequences...), nil
+}
+
+// ─── commands ───
+
+// run executes a command and returns its reply.
+func (d *memoryDeployment) run(cmd bson.D) bson.D {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := cmd[0].Key
+	db, _ := lookup(cmd, "$db").(string)
+	collection, _ := cmd[0].Value.(string)
+
+	// writes of a transaction are recorded so an abort can undo them
+	var undo *[]memoryUndo
+	session := sessionID(cmd)
+	if session != "" && lookup(cmd, "autocommit") == false {
+		if lookup(cmd, "startTransaction") == true {
+			d.txns[session] = nil
+		}
+		log, ok := d.txns[session]
+		if !ok && name != "commitTransaction" && name != "abortTransaction" {
+			return failed(memoryError{codeNoSuchTransaction, "transaction is not in progress"})
+		}
+		undo = &log
+		defer func() {
+			if _, ok := d.txns[session]; ok {
+				d.txns[session] = *undo
+			}
+		}()
+	}
+
+	var reply bson.D
+	var err error
+	switch name {
+	case "insert":
+		reply, err = d.insert(db, collection, cmd, undo)
+	case "update":
+		reply, err = d.update(db, collection, cmd, undo)
+	case "delete":
+		reply, err = d.delete(db, collection, cmd, undo)
+	case "find":
+		reply, err = d.find(db, collection, cmd)
+	case "aggregate":
+		reply, err = d.aggregate(db, collection, cmd)
+	case "count":
+		reply, err = d.count(db, collection, cmd)
+	case "distinct":
+		reply, err = d.distinct(db, collection, cmd)
+	case "findAndModify":
+		reply, err = d.findAndModify(db, collection, cmd, undo)
+	case "createIndexes":
+		reply, err = d.createIndexes(db, collection, cmd)
+	case "dropIndexes":
+		reply, err = d.dropIndexes(db, collection, cmd)
+	case "listIndexes":
+		reply, err = d.listIndexes(db, collection)
+	case "drop":
+		delete(d.dbs[db], collection)
+		reply = bson.D{}
+	case "dropDatabase":
+		delete(d.dbs, db)
+		reply = bson.D{}
+	case "commitTransaction":
+		delete(d.txns, session)
+		undo = nil
+		reply = bson.D{}
+	case "abortTransaction":
+		if undo != nil {
+			d.revert(*undo)
+		}
+		delete(d.txns, session)
+		undo = nil
+		reply = bson.D{}
+	case "hello", "isMaster", "ismaster":
+		reply = bson.D{{Key: "isWritablePrimary", Value: true}, {Key: "maxWireVersion", Value: int32(21)}, {Key: "setName", Value: "memory"}}
+	case "ping", "endSessions", "killCursors", "buildInfo":
+		reply = bson.D{}
+	default:
+		err = memoryError{codeCommandNotFound, fmt.Sprintf("no such command: '%s'", name)}
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return append(reply, bson.E{Key: "ok", Value: 1.0})
+}
+
+func failed(err error) bson.D {
+	var merr memoryError
+	if !errors.As(err, &merr) {
+		merr = memoryError{codeBadValue, err.Error()}
+	}
+	return bson.D{{Key: "ok", Value: 0.0}, {Key: "errmsg", Value: merr.Msg}, {Key: "code", Value: merr.Code}}
+}
+
+// sessionID is the logical session a command runs in, if any.
+func sessionID(cmd bson.D) string {
+	lsid, ok := lookup(cmd, "lsid").(bson.D)
+	if !ok {
+		return ""
+	}
+	if id, ok := lookup(lsid, "id").(primitive.Binary); ok {
+		return hex.EncodeToString(id.Data)
+	}
+	return ""
+}
+
+func (d *memoryDeployment) collection(db, name string) *memoryCollection {
+	if d.dbs[db] == nil {
+		d.dbs[db] = make(map[string]*memoryCollection)
+	}
+	c, ok := d.dbs[db][name]
+	if !ok {
+		c = &memoryCollection{}
+		d.dbs[db][name] = c
+	}
+	return c
+}
+
+func (d *memoryDeployment) insert(db, name string, cmd bson.D, undo *[]memoryUndo) (bson.D, error) {
+	c := d.collection(db, name)
+	docs, _ := lookup(cmd, "documents").(bson.A)
+	ordered := lookup(cmd, "ordered") != false
+
+	n := 0
+	var writeErrors bson.A
+	for i, v := range docs {
+		doc, ok := v.(bson.D)
+		if !ok {
+			return nil, memoryError{codeBadValue, "documents must be documents"}
+		}
+		if lookup(doc, "_id") == nil {
+			doc = append(bson.D{{Key: "_id", Value: primitive.NewObjectID()}}, doc...)
+		}
+		if err := c.put(db, name, -1, doc, undo); err != nil {
+			writeErrors = append(writeErrors, writeError(i, err))
+			if ordered {
+				break
+			}
+			continue
+		}
+		n++
+	}
+	reply := bson.D{{Key: "n", Value: int32(n)}}
+	if len(writeErrors) > 0 {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: writeErrors})
+	}
+	return reply, nil
+}
+
+func (d *memoryDeployment) update(db, name string, cmd bson.D, undo *[]memoryUndo) (bson.D, error) {
+	c := d.collection(db, name)
+	updates, _ := lookup(cmd, "updates").(bson.A)
+	ordered := lookup(cmd, "ordered") != false
+
+	n, modified := 0, 0
+	var upserted, writeErrors bson.A
+	for i, v := range updates {
+		spec, _ := v.(bson.D)
+		filter, _ := lookup(spec, "q").(bson.D)
+		update, ok := lookup(spec, "u").(bson.D)
+		if !ok {
+			return nil, memoryError{codeBadValue, "update pipelines are not supported"}
+		}
+		multi := lookup(spec, "multi") == true
+
+		matched, changed, id, err := c.modify(db, name, filter, nil, update, multi, lookup(spec, "upsert") == true, undo)
+		if err != nil {
+			writeErrors = append(writeErrors, writeError(i, err))
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += matched
+		modified += changed
+		if id != nil {
+			n++
+			upserted = append(upserted, bson.D{{Key: "index", Value: int32(i)}, {Key: "_id", Value: id}})
+		}
+	}
+	reply := bson.D{{Key: "n", Value: int32(n)}, {Key: "nModified", Value: int32(modified)}}
+	if len(upserted) > 0 {
+		reply = append(reply, bson.E{Key: "upserted", Value: upserted})
+	}
+	if len(writeErrors) > 0 {
+		reply = append(reply, bson.E{Key: "writeErrors", Value: writeErrors})
+	}
+	return reply, nil
+}
+
+func (d *memoryDeployment) delete(db, name string, cmd bson.D, undo *[]memoryUndo) (bson.D, error) {
+	c := d.collection(db, name)
+	deletes, _ := lookup(cmd, "deletes").(bson.A)
+
+	n := 0
+	for _, v := range deletes {
+		spec, _ := v.(bson.D)
+		filter, _ := lookup(spec, "q").(bson.D)
+		limit, _ := asInt(lookup(spec, "limit"))
+		for i := 0; i < len(c.docs); {
+			if !matches(c.docs[i], filter) {
+				i++
+				continue
+			}
+			c.remove(db, name, i, undo)
+			n++
+			if limit == 1 {
+				break
+			}
+		}
+	}
+	return bson.D{{Key: "n", Value: int32(n)}}, nil
+}
+
+func (d *memoryDeployment) find(db, name string, cmd bson.D) (bson.D, error) {
+	c := d.collection(db, name)
+	filter, _ := lookup(cmd, "filter").(bson.D)
+	docs, err := c.query(filter)
+	if err != nil {
+		return nil, err
+	}
+	if spec, ok := lookup(cmd, "sort").(bson.D); ok {
+		sortDocs(docs, spec)
+	}
+	if skip, _ := asInt(lookup(cmd, "skip")); skip > 0 {
+		docs = docs[min(int(skip), len(docs)):]
+	}
+	if limit, _ := asInt(lookup(cmd, "limit")); limit != 0 {
+		if limit < 0 {
+			limit = -limit
+		}
+		docs = docs[:min(int(limit), len(docs))]
+	}
+	return cursorReply(db, name, docs), nil
+}
+
+// aggregate runs the stages CountDocuments and simple reports use: $match,
+// $sort, $skip, $limit, $count and $group with $sum.
+func (d *memoryDeployment) aggregate(db, name string, cmd bson.D) (bson.D, error) {
+	c := d.collection(db, name)
+	docs, err := c.query(bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	pipeline, _ := lookup(cmd, "pipeline").(bson.A)
+	for _, v := range pipeline {
+		stage, _ := v.(bson.D)
+		if len(stage) != 1 {
+			return nil, memoryError{codeBadValue, "a pipeline stage takes one operator"}
+		}
+		switch stage[0].Key {
+		case "$match":
+			filter, _ := stage[0].Value.(bson.D)
+			var kept []bson.D
+			for _, doc := range docs {
+				if matches(doc, filter) {
+					kept = append(kept, doc)
+				}
+			}
+			docs = kept
+		case "$sort":
+			spec, _ := stage[0].Value.(bson.D)
+			sortDocs(docs, spec)
+		case "$skip":
+			skip, _ := asInt(stage[0].Value)
+			docs = docs[min(int(skip), len(docs)):]
+		case "$limit":
+			limit, _ := asInt(stage[0].Value)
+			docs = docs[:min(int(limit), len(docs))]
+		case "$count":
+			field, _ := stage[0].Value.(string)
+			if len(docs) == 0 {
+				docs = nil
+			} else {
+				docs = []bson.D{{{Key: field, Value: int32(len(docs))}}}
+			}
+		case "$group":
+			spec, _ := stage[0].Value.(bson.D)
+			if docs, err = group(docs, spec); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, memoryError{codeBadValue, fmt.Sprintf("pipeline stage %s is not supported", stage[0].Key)}
+		}
+	}
+	return cursorReply(db, name, docs), nil
+}
+
+func group(docs []bson.D, spec bson.D) ([]bson.D, error) {
+	var keys []string
+	groups := make(map[string]bson.D)
+	for _, doc := range docs {
+		id := fieldValue(doc, lookup(spec, "_id"))
+		key := indexKey(id)
+		out, ok := groups[key]
+		if !ok {
+			out = bson.D{{Key: "_id", Value: id}}
+			keys = append(keys, key)
+		}
+		for _, e := range spec {
+			if e.Key == "_id" {
+				continue
+			}
+			acc, _ := e.Value.(bson.D)
+			if len(acc) != 1 || acc[0].Key != "$sum" {
+				return nil, memoryError{codeBadValue, "only $sum is supported in $group"}
+			}
+			sum, _ := addNumbers(lookup(out, e.Key), fieldValue(doc, acc[0].Value))
+			out = setPath(out, []string{e.Key}, sum)
+		}
+		groups[key] = out
+	}
+	out := make([]bson.D, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, groups[key])
+	}
+	return out, nil
+}
+
+// fieldValue resolves a "$path" expression against doc, other values are
+// constants.
+func fieldValue(doc bson.D, expr interface{}) interface{} {
+	path, ok := expr.(string)
+	if !ok || !strings.HasPrefix(path, "$") {
+		return expr
+	}
+	values := lookupPath(doc, strings.Split(path[1:], "."))
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+func (d *memoryDeployment) count(db, name string, cmd bson.D) (bson.D, error) {
+	filter, _ := lookup(cmd, "query").(bson.D)
+	docs, err := d.collection(db, name).query(filter)
+	if err != nil {
+		return nil, err
+	}
+	return bson.D{{Key: "n", Value: int32(len(docs))}}, nil
+}
+
+func (d *memoryDeployment) distinct(db, name string, cmd bson.D) (bson.D, error) {
+	key, _ := lookup(cmd, "key").(string)
+	filter, _ := lookup(cmd, "query").(bson.D)
+	docs, err := d.collection(db, name).query(filter)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	values := bson.A{}
+	for _, doc := range docs {
+		for _, v := range expandArrays(lookupPath(doc, strings.Split(key, "."))) {
+			if k := indexKey(v); !seen[k] {
+				seen[k] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return bson.D{{Key: "values", Value: values}}, nil
+}
+
+func (d *memoryDeployment) findAndModify(db, name string, cmd bson.D, undo *[]memoryUndo) (bson.D, error) {
+	c := d.collection(db, name)
+	filter, _ := lookup(cmd, "query").(bson.D)
+	sortSpec, _ := lookup(cmd, "sort").(bson.D)
+	returnNew := lookup(cmd, "new") == true
+
+	if lookup(cmd, "remove") == true {
+		i := c.first(filter, sortSpec)
+		if i < 0 {
+			return bson.D{{Key: "lastErrorObject", Value: bson.D{{Key: "n", Value: int32(0)}}}, {Key: "value", Value: nil}}, nil
+		}
+		old := c.docs[i]
+		c.remove(db, name, i, undo)
+		return bson.D{{Key: "lastErrorObject", Value: bson.D{{Key: "n", Value: int32(1)}}}, {Key: "value", Value: old}}, nil
+	}
+
+	update, ok := lookup(cmd, "update").(bson.D)
+	if !ok {
+		return nil, memoryError{codeBadValue, "findAndModify needs update or remove"}
+	}
+	var before bson.D
+	if i := c.first(filter, sortSpec); i >= 0 {
+		before = clone(c.docs[i])
+	}
+	matched, _, id, err := c.modify(db, name, filter, sortSpec, update, false, lookup(cmd, "upsert") == true, undo)
+	if err != nil {
+		return nil, err
+	}
+
+	lastError := bson.D{{Key: "n", Value: int32(matched)}, {Key: "updatedExisting", Value: matched > 0}}
+	var value interface{}
+	switch {
+	case id != nil:
+		lastError[0].Value = int32(1)
+		lastError = append(lastError, bson.E{Key: "upserted", Value: id})
+		if returnNew {
+			value = c.docs[c.byID(id)]
+		}
+	case matched > 0 && returnNew:
+		value = c.docs[c.byID(lookup(before, "_id"))]
+	case matched > 0:
+		value = before
+	}
+	return bson.D{{Key: "lastErrorObject", Value: lastError}, {Key: "value", Value: value}}, nil
+}
+
+func (d *memoryDeployment) createIndexes(db, name string, cmd bson.D) (bson.D, error) {
+	c := d.collection(db, name)
+	before := len(c.indexes) + 1
+	specs, _ := lookup(cmd, "indexes").(bson.A)
+	for _, v := range specs {
+		spec, _ := v.(bson.D)
+		ix := memoryIndex{
+			Name:   fmt.Sprint(lookup(spec, "name")),
+			Unique: lookup(spec, "unique") == true,
+			Sparse: lookup(spec, "sparse") == true,
+		}
+		ix.Keys, _ = lookup(spec, "key").(bson.D)
+		ix.Partial, _ = lookup(spec, "partialFilterExpression").(bson.D)
+		if len(ix.Keys) == 0 {
+			return nil, memoryError{codeBadValue, "index has no keys"}
+		}
+
+		exists := false
+		for _, other := range c.indexes {
+			sameKeys := valuesEqual(other.Keys, ix.Keys)
+			switch {
+			case other.Name == ix.Name && sameKeys && other.Unique == ix.Unique && other.Sparse == ix.Sparse && valuesEqual(other.Partial, ix.Partial):
+				exists = true
+			case other.Name == ix.Name:
+				return nil, memoryError{codeIndexKeySpecsConflict, fmt.Sprintf("an existing index has the same name as the requested index: %s", ix.Name)}
+			case sameKeys && valuesEqual(other.Partial, ix.Partial):
+				return nil, memoryError{codeIndexOptionsConflict, fmt.Sprintf("index already exists with a different name: %s", other.Name)}
+			}
+		}
+		if exists {
+			continue
+		}
+		if ix.Unique {
+			for i, doc := range c.docs {
+				if err := c.duplicate(db, name, ix, doc, i); err != nil {
+					return nil, err
+				}
+			}
+		}
+		c.indexes = append(c.indexes, ix)
+	}
+	return bson.D{{Key: "numIndexesBefore", Value: int32(before)}, {Key: "numIndexesAfter", Value: int32(len(c.indexes) + 1)}}, nil
+}
+
+func (d *memoryDeployment) dropIndexes(db, name string, cmd bson.D) (bson.D, error) {
+	c := d.collection(db, name)
+	index := lookup(cmd, "index")
+	if index == "*" {
+		c.indexes = nil
+		return bson.D{}, nil
+	}
+	for i, ix := range c.indexes {
+		if ix.Name == index || valuesEqual(ix.Keys, index) {
+			c.indexes = append(c.indexes[:i], c.indexes[i+1:]...)
+			return bson.D{}, nil
+		}
+	}
+	return nil, memoryError{codeIndexNotFound, fmt.Sprintf("index not found with name [%v]", index)}
+}
+
+func (d *memoryDeployment) listIndexes(db, name string) (bson.D, error) {
+	c := d.collection(db, name)
+	docs := []bson.D{{{Key: "v", Value: int32(2)}, {Key: "key", Value: bson.D{{Key: "_id", Value: int32(1)}}}, {Key: "name", Value: "_id_"}}}
+	for _, ix := range c.indexes {
+		spec := bson.D{{Key: "v", Value: int32(2)}, {Key: "key", Value: ix.Keys}, {Key: "name", Value: ix.Name}}
+		if ix.Unique {
+			spec = append(spec, bson.E{Key: "unique", Value: true})
+		}
+		if ix.Sparse {
+			spec = append(spec, bson.E{Key: "sparse", Value: true})
+		}
+		if ix.Partial != nil {
+			spec = append(spec, bson.E{Key: "partialFilterExpression", Value: ix.Partial})
+		}
+		docs = append(docs, spec)
+	}
+	return cursorReply(db, name, docs), nil
+}
+
+func cursorReply(db, name string, docs []bson.D) bson.D {
+	batch := bson.A{}
+	for _, doc := range docs {
+		batch = append(batch, doc)
+	}
+	return bson.D{{Key: "cursor", Value: bson.D{
+		{Key: "firstBatch", Value: batch},
+		{Key: "id", Value: int64(0)},
+		{Key: "ns", Value: db + "." + name},
+	}}}
+}
+
+func writeError(index int, err error) bson.D {
+	var merr memoryError
+	if !errors.As(err, &merr) {
+		merr = memoryError{codeBadValue, err.Error()}
+	}
+	return bson.D{{Key: "index", Value: int32(index)}, {Key: "code", Value: merr.Code}, {Key: "errmsg", Value: merr.Msg}}
+}
+
+// ─── collection ───
+
+// query returns copies of the documents filter matches, in insertion order.
+func (c *memoryCollection) query(filter bson.D) ([]bson.D, error) {
+	var docs []bson.D
+	for _, doc := range c.docs {
+		if matches(doc, filter) {
+			docs = append(docs, clone(doc))
+		}
+	}
+	return docs, nil
+}
+
+// first is the position of the first document filter matches in sort order,
+// -1 if none does.
+func (c *memoryCollection) first(filter, sortSpec bson.D) int {
+	var hits []int
+	for i, doc := range c.docs {
+		if matches(doc, filter) {
+			hits = append(hits, i)
+		}
+	}
+	if len(hits) == 0 {
+		return -1
+	}
+	if len(sortSpec) > 0 {
+		sort.SliceStable(hits, func(a, b int) bool {
+			return compareDocs(c.docs[hits[a]], c.docs[hits[b]], sortSpec) < 0
+		})
+	}
+	return hits[0]
+}
+
+func (c *memoryCollection) byID(id interface{}) int {
+	for i, doc := range c.docs {
+		if valuesEqual(lookup(doc, "_id"), id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// modify applies update to the documents filter matches, or to a new one
+// when none does and upsert is set. It returns how many matched and changed,
+// and the _id of an upserted document.
+func (c *memoryCollection) modify(db, name string, filter, sortSpec, update bson.D, multi, upsert bool, undo *[]memoryUndo) (int, int, interface{}, error) {
+	var targets []int
+	if multi {
+		for i, doc := range c.docs {
+			if matches(doc, filter) {
+				targets = append(targets, i)
+			}
+		}
+	} else if i := c.first(filter, sortSpec); i >= 0 {
+		targets = []int{i}
+	}
+
+	if len(targets) == 0 {
+		if !upsert {
+			return 0, 0, nil, nil
+		}
+		doc, err := applyUpdate(upsertBase(filter), update, true)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		id := lookup(doc, "_id")
+		if id == nil {
+			id = primitive.NewObjectID()
+			doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+		}
+		if err := c.put(db, name, -1, doc, undo); err != nil {
+			return 0, 0, nil, err
+		}
+		return 0, 0, id, nil
+	}
+
+	changed := 0
+	for _, i := range targets {
+		old := c.docs[i]
+		doc, err := applyUpdate(clone(old), update, false)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if !valuesEqual(lookup(doc, "_id"), lookup(old, "_id")) {
+			return 0, 0, nil, memoryError{66, "the _id field cannot be changed"}
+		}
+		if valuesEqual(doc, old) {
+			continue
+		}
+		if err := c.put(db, name, i, doc, undo); err != nil {
+			return 0, 0, nil, err
+		}
+		changed++
+	}
+	return len(targets), changed, nil, nil
+}
+
+// put stores doc at position i, or appends it for -1, once the unique
+// indexes allow it.
+func (c *memoryCollection) put(db, name string, i int, doc bson.D, undo *[]memoryUndo) error {
+	if i < 0 && c.byID(lookup(doc, "_id")) >= 0 {
+		return memoryError{codeDuplicateKey, fmt.Sprintf("E11000 duplicate key error collection: %s.%s index: _id_ dup key: { _id: %v }", db, name, lookup(doc, "_id"))}
+	}
+	for _, ix := range c.indexes {
+		if !ix.Unique {
+			continue
+		}
+		if err := c.duplicate(db, name, ix, doc, i); err != nil {
+			return err
+		}
+	}
+
+	if undo != nil {
+		var before bson.D
+		if i >= 0 {
+			before = clone(c.docs[i])
+		}
+		*undo = append(*undo, memoryUndo{db: db, collection: name, id: lookup(doc, "_id"), before: before})
+	}
+	if i < 0 {
+		c.docs = append(c.docs, clone(doc))
+	} else {
+		c.docs[i] = clone(doc)
+	}
+	return nil
+}
+
+func (c *memoryCollection) remove(db, name string, i int, undo *[]memoryUndo) {
+	if undo != nil {
+		*undo = append(*undo, memoryUndo{db: db, collection: name, id: lookup(c.docs[i], "_id"), before: clone(c.docs[i])})
+	}
+	c.docs = append(c.docs[:i], c.docs[i+1:]...)
+}
+
+// duplicate fails when doc, stored at position self, would share a key of the
+// unique index ix with another document.
+func (c *memoryCollection) duplicate(db, name string, ix memoryIndex, doc bson.D, self int) error {
+	keys := ix.entries(doc)
+	if len(keys) == 0 {
+		return nil
+	}
+	for i, other := range c.docs {
+		if i == self {
+			continue
+		}
+		for key := range ix.entries(other) {
+			if keys[key] {
+				return memoryError{codeDuplicateKey, fmt.Sprintf("E11000 duplicate key error collection: %s.%s index: %s dup key", db, name, ix.Name)}
+			}
+		}
+	}
+	return nil
+}
+
+// entries are the keys doc has in the index, none when the index leaves it
+// out. Arrays get a key per element.
+func (ix memoryIndex) entries(doc bson.D) map[string]bool {
+	if ix.Partial != nil && !matches(doc, ix.Partial) {
+		return nil
+	}
+	keys := []string{""}
+	missing := 0
+	for _, field := range ix.Keys {
+		values := expandArrays(lookupPath(doc, strings.Split(field.Key, ".")))
+		if len(values) == 0 {
+			missing++
+			values = []interface{}{nil}
+		}
+		var next []string
+		for _, key := range keys {
+			for _, v := range values {
+				next = append(next, key+"\x00"+indexKey(v))
+			}
+		}
+		keys = next
+	}
+	if ix.Sparse && missing == len(ix.Keys) {
+		return nil
+	}
+	entries := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		entries[key] = true
+	}
+	return entries
+}
+
+// revert undoes the writes of an aborted transaction, newest first.
+func (d *memoryDeployment) revert(undo []memoryUndo) {
+	for n := len(undo) - 1; n >= 0; n-- {
+		u := undo[n]
+		c := d.collection(u.db, u.collection)
+		i := c.byID(u.id)
+		switch {
+		case u.before == nil && i >= 0:
+			c.docs = append(c.docs[:i], c.docs[i+1:]...)
+		case u.before != nil && i >= 0:
+			c.docs[i] = u.before
+		case u.before != nil:
+			c.docs = append(c.docs, u.before)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func memoryCollectionT(t *testing.T, name string) *mongo.Collection {
+	t.Helper()
+	client, err := NewMemoryClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client.Database("test").Collection(name)
+}
+
+type memoryUser struct {
+	ID      string            `bson:"_id"`
+	Name    string            `bson:"name"`
+	Tags    []string          `bson:"tags,omitempty"`
+	Rewards map[string]uint64 `bson:"rewards,omitempty"`
+}
+
+func TestMemoryCRUD(t *testing.T) {
+	ctx := context.Background()
+	c := memoryCollectionT(t, "users")
+
+	for _, u := range []memoryUser{
+		{ID: "1", Name: "alice", Tags: []string{"farmer"}},
+		{ID: "2", Name: "bob"},
+		{ID: "3", Name: "carol", Tags: []string{"farmer", "admin"}},
+	} {
+		if _, err := c.InsertOne(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got memoryUser
+	if err := c.FindOne(ctx, bson.M{"name": "bob"}).Decode(&got); err != nil || got.ID != "2" {
+		t.Fatalf("FindOne = %+v, %v", got, err)
+	}
+	if err := c.FindOne(ctx, bson.M{"name": "dave"}).Err(); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("FindOne of a missing document = %v", err)
+	}
+
+	cur, err := c.Find(ctx, bson.M{"tags": "farmer"}, options.Find().SetSort(bson.D{{Key: "name", Value: -1}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var farmers []memoryUser
+	if err := cur.All(ctx, &farmers); err != nil {
+		t.Fatal(err)
+	}
+	if len(farmers) != 2 || farmers[0].Name != "carol" || farmers[1].Name != "alice" {
+		t.Errorf("farmers = %+v", farmers)
+	}
+
+	if n, err := c.CountDocuments(ctx, bson.M{"tags": bson.M{"$exists": false}}); err != nil || n != 1 {
+		t.Errorf("CountDocuments = %d, %v", n, err)
+	}
+	names, err := c.Distinct(ctx, "tags", bson.M{})
+	if err != nil || len(names) != 2 {
+		t.Errorf("Distinct = %v, %v", names, err)
+	}
+
+	res, err := c.UpdateOne(ctx, bson.M{"_id": "2"}, bson.M{"$push": bson.M{"tags": "admin"}, "$set": bson.M{"rewards.g1": 5}})
+	if err != nil || res.MatchedCount != 1 || res.ModifiedCount != 1 {
+		t.Fatalf("UpdateOne = %+v, %v", res, err)
+	}
+	if _, err := c.ReplaceOne(ctx, bson.M{"_id": "4"}, memoryUser{ID: "4", Name: "dave"}, options.Replace().SetUpsert(true)); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.CountDocuments(ctx, bson.M{"tags": "admin"}); n != 2 {
+		t.Errorf("%d admins after the update, want 2", n)
+	}
+
+	del, err := c.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{"1", "4"}}})
+	if err != nil || del.DeletedCount != 2 {
+		t.Errorf("DeleteMany = %+v, %v", del, err)
+	}
+	if n, _ := c.CountDocuments(ctx, bson.M{}); n != 2 {
+		t.Errorf("%d documents left, want 2", n)
+	}
+}
+
+func TestMemoryConditionalInc(t *testing.T) {
+	ctx := context.Background()
+	c := memoryCollectionT(t, "users")
+	if _, err := c.InsertOne(ctx, memoryUser{ID: "1", Rewards: map[string]uint64{"g1": 10}}); err != nil {
+		t.Fatal(err)
+	}
+
+	take := func(n int64) bool {
+		res, err := c.UpdateOne(ctx,
+			bson.M{"_id": "1", "rewards.g1": bson.M{"$gte": n}},
+			bson.M{"$inc": bson.M{"rewards.g1": -n}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.ModifiedCount == 1
+	}
+	if !take(7) {
+		t.Fatal("could not take 7 of 10")
+	}
+	if take(7) {
+		t.Fatal("took 7 of 3")
+	}
+
+	var u memoryUser
+	if err := c.FindOneAndUpdate(ctx, bson.M{"_id": "1"}, bson.M{"$inc": bson.M{"rewards.g1": 2}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Rewards["g1"] != 5 {
+		t.Errorf("rewards = %d, want 5", u.Rewards["g1"])
+	}
+}
+
+func TestMemoryUniqueIndexes(t *testing.T) {
+	ctx := context.Background()
+	c := memoryCollectionT(t, "claims")
+	if _, err := c.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "payments", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tx", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{
+			Keys: bson.D{{Key: "stake", Value: 1}},
+			Options: options.Index().SetUnique(true).SetName("stake_verified").
+				SetPartialFilterExpression(bson.M{"verified": true}),
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(doc bson.M) error {
+		_, err := c.InsertOne(ctx, doc)
+		return err
+	}
+	steps := []struct {
+		doc bson.M
+		dup bool
+	}{
+		{bson.M{"payments": bson.A{"a", "a"}, "stake": "s1", "verified": true}, false},
+		{bson.M{"payments": bson.A{"b", "a"}}, true},
+		{bson.M{"payments": bson.A{"c"}, "stake": "s1"}, false},
+		{bson.M{"payments": bson.A{"d"}, "stake": "s1", "verified": true}, true},
+		{bson.M{"payments": bson.A{"e"}, "tx": 1}, false},
+		{bson.M{"payments": bson.A{"f"}, "tx": 1.0}, true},
+		{bson.M{"payments": bson.A{"g"}}, false},
+	}
+	for i, step := range steps {
+		err := insert(step.doc)
+		if mongo.IsDuplicateKeyError(err) != step.dup {
+			t.Errorf("step %d: insert = %v, want duplicate %v", i, err, step.dup)
+		}
+	}
+
+	if _, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "stake", Value: 1}}, Options: options.Index().SetUnique(true),
+	}); err == nil {
+		t.Error("a unique index over duplicate values was created")
+	}
+	if _, err := c.Indexes().DropOne(ctx, "missing_1"); err == nil {
+		t.Error("dropping a missing index succeeded")
+	}
+}
+
+func TestMemoryTransaction(t *testing.T) {
+	ctx := context.Background()
+	c := memoryCollectionT(t, "users")
+	if _, err := c.InsertOne(ctx, memoryUser{ID: "1", Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := c.Database().Client().StartSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.EndSession(ctx)
+
+	abort := errors.New("abort")
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if _, err := c.InsertOne(sc, memoryUser{ID: "2", Name: "bob"}); err != nil {
+			return nil, err
+		}
+		if _, err := c.UpdateOne(sc, bson.M{"_id": "1"}, bson.M{"$set": bson.M{"name": "alicia"}}); err != nil {
+			return nil, err
+		}
+		if _, err := c.DeleteOne(sc, bson.M{"_id": "1"}); err != nil {
+			return nil, err
+		}
+		return nil, abort
+	})
+	if !errors.Is(err, abort) {
+		t.Fatalf("WithTransaction = %v", err)
+	}
+	var u memoryUser
+	if err := c.FindOne(ctx, bson.M{"_id": "1"}).Decode(&u); err != nil || u.Name != "alice" {
+		t.Errorf("after abort alice is %+v, %v", u, err)
+	}
+	if n, _ := c.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("%d documents after abort, want 1", n)
+	}
+
+	if _, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return c.InsertOne(sc, memoryUser{ID: "2", Name: "bob"})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.CountDocuments(ctx, bson.M{}); n != 2 {
+		t.Errorf("%d documents after commit, want 2", n)
+	}
+}
@@ -0,0 +1,435 @@
+package discord
+
+import (
+	"bytes"
+	"cardano-valley/pkg/blockfrost"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/koios"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  HOLDER SOURCES: every input of /create-airdrop normalizes into []Holder
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// HolderSource loads airdrop recipients. Entries that cannot be used are
+// reported as HolderErrors instead of failing the whole source.
+type HolderSource interface {
+	Name() string
+	Holders(ctx context.Context) ([]Holder, []HolderError, error)
+}
+
+// HolderError describes a rejected entry. Line is 1-based for file sources and
+// 0 when the entry has no line.
+type HolderError struct {
+	Line   int
+	Input  string
+	Reason string
+}
+
+func (e HolderError) String() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s (%s)", e.Line, e.Reason, cv.TruncateMiddle(e.Input, 40))
+	}
+	return fmt.Sprintf("%s (%s)", e.Reason, cv.TruncateMiddle(e.Input, 40))
+}
+
+type PolicyMode string
+
+const (
+	PolicyUnion        PolicyMode = "union"
+	PolicyIntersection PolicyMode = "intersection"
+)
+
+// validateHolder checks a single entry before it is accepted. Addresses must
+// decode as bech32 Shelley payment addresses on the configured network.
+func validateHolder(h Holder) error {
+	if h.Quantity == 0 {
+		return errors.New("quantity must be greater than 0")
+	}
+	if err := cardano.ValidatePaymentAddress(h.Address); err != nil {
+		return err
+	}
+	return nil
+}
+
+// normalizeHolders resolves $handles, validates every entry and merges
+// duplicate addresses.
+func normalizeHolders(ctx context.Context, holders []Holder, lines []int) ([]Holder, []HolderError) {
+	var errs []HolderError
+	merged := make(map[string]Holder)
+	for n, h := range holders {
+		line := 0
+		if lines != nil {
+			line = lines[n]
+		}
+
+		h.Address = strings.TrimSpace(h.Address)
+		if strings.HasPrefix(h.Address, blockfrost.ADA_HANDLE_PREFIX) {
+			h.Handle = h.Address
+			resolved, err := blockfrost.HandleAddress(ctx, h.Handle)
+			if err != nil || resolved == h.Handle {
+				errs = append(errs, HolderError{Line: line, Input: h.Handle, Reason: "ADA Handle could not be resolved"})
+				continue
+			}
+			h.Address = resolved
+		}
+
+		if err := validateHolder(h); err != nil {
+			input := h.Address
+			if h.Handle != "" {
+				input = h.Handle + " → " + h.Address
+			}
+			errs = append(errs, HolderError{Line: line, Input: input, Reason: err.Error()})
+			continue
+		}
+
+		if existing, ok := merged[h.Address]; ok {
+			h.Quantity += existing.Quantity
+			if h.Handle == "" {
+				h.Handle = existing.Handle
+			}
+		}
+		merged[h.Address] = h
+	}
+
+	result := make([]Holder, 0, len(merged))
+	for _, h := range merged {
+		result = append(result, h)
+	}
+	// deterministic order
+	sort.Slice(result, func(i, j int) bool { return result[i].Address < result[j].Address })
+
+	return result, errs
+}
+
+//
+// File upload: JSON, CSV or TSV
+//
+
+// maxHoldersFileSize caps the download of an uploaded holders file. Discord
+// allows attachments up to 25 MiB; a holder list never comes close.
+const maxHoldersFileSize = 16 << 20
+
+type FileHolderSource struct {
+	URL      string
+	Filename string
+}
+
+func (f FileHolderSource) Name() string { return "file " + f.Filename }
+
+func (f FileHolderSource) Holders(ctx context.Context) ([]Holder, []HolderError, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxHoldersFileSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	// a truncated file would silently drop holders
+	if len(body) > maxHoldersFileSize {
+		return nil, nil, fmt.Errorf("holders file is larger than %d MiB", maxHoldersFileSize>>20)
+	}
+
+	return parseHoldersFile(ctx, f.Filename, body)
+}
+
+func parseHoldersFile(ctx context.Context, filename string, body []byte) ([]Holder, []HolderError, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".json":
+		return parseHoldersJSON(ctx, body)
+	case ".tsv":
+		return parseHoldersDelimited(ctx, body, '\t')
+	case ".csv":
+		return parseHoldersDelimited(ctx, body, ',')
+	}
+
+	// Unknown extension, sniff the content
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		return parseHoldersJSON(ctx, body)
+	}
+	if bytes.Contains(trimmed, []byte("\t")) {
+		return parseHoldersDelimited(ctx, body, '\t')
+	}
+	return parseHoldersDelimited(ctx, body, ',')
+}
+
+func parseHoldersJSON(ctx context.Context, body []byte) ([]Holder, []HolderError, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, nil, fmt.Errorf("expected a JSON array of {\"address\",\"quantity\"}: %w", err)
+	}
+
+	var (
+		holders []Holder
+		lines   []int
+		errs    []HolderError
+	)
+	for n, entry := range raw {
+		var h Holder
+		if err := json.Unmarshal(entry, &h); err != nil {
+			errs = append(errs, HolderError{Line: n + 1, Input: string(entry), Reason: "invalid entry: " + err.Error()})
+			continue
+		}
+		holders = append(holders, h)
+		lines = append(lines, n+1)
+	}
+
+	valid, invalid := normalizeHolders(ctx, holders, lines)
+	return valid, append(errs, invalid...), nil
+}
+
+// parseHoldersDelimited reads `address<sep>quantity` rows. A header row and a
+// missing quantity column (one unit per address) are both accepted.
+func parseHoldersDelimited(ctx context.Context, body []byte, sep rune) ([]Holder, []HolderError, error) {
+	r := csv.NewReader(bytes.NewReader(body))
+	r.Comma = sep
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	var (
+		holders []Holder
+		lines   []int
+		errs    []HolderError
+	)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			line := 0
+			if errors.As(err, &perr) {
+				line = perr.Line
+			}
+			errs = append(errs, HolderError{Line: line, Input: strings.Join(record, string(sep)), Reason: err.Error()})
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) == 0 || strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		address := strings.TrimSpace(record[0])
+		if len(holders) == 0 && len(errs) == 0 && strings.EqualFold(address, "address") {
+			continue // header
+		}
+
+		qty := uint64(1)
+		if len(record) > 1 && strings.TrimSpace(record[1]) != "" {
+			qty, err = strconv.ParseUint(strings.TrimSpace(record[1]), 10, 64)
+			if err != nil {
+				errs = append(errs, HolderError{Line: line, Input: strings.Join(record, string(sep)), Reason: "quantity is not a whole number"})
+				continue
+			}
+		}
+
+		holders = append(holders, Holder{Address: address, Quantity: qty})
+		lines = append(lines, line)
+	}
+
+	valid, invalid := normalizeHolders(ctx, holders, lines)
+	return valid, append(errs, invalid...), nil
+}
+
+//
+// One or more policy IDs
+//
+
+type PolicyHolderSource struct {
+	PolicyIDs []string
+	Mode      PolicyMode
+}
+
+func (p PolicyHolderSource) Name() string {
+	return fmt.Sprintf("policy %s (%s)", strings.Join(p.PolicyIDs, ", "), p.Mode)
+}
+
+// Holders sums quantities across policies. In intersection mode only
+// addresses holding every policy are kept.
+func (p PolicyHolderSource) Holders(ctx context.Context) ([]Holder, []HolderError, error) {
+	// every policy counts in intersection mode, so one bad ID would leave
+	// nobody holding all of them
+	for _, policyID := range p.PolicyIDs {
+		if len(policyID) != 56 || !isHex(policyID) {
+			return nil, nil, fmt.Errorf("policy ID %q must be 56 hex characters", policyID)
+		}
+	}
+
+	totals := make(map[string]uint64)
+	seen := make(map[string]int)
+	for _, policyID := range p.PolicyIDs {
+		policyHolders, err := chain.Current.PolicyHolders(ctx, policyID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("policy %s: %w", policyID, err)
+		}
+		for address, qty := range policyHolders {
+			totals[address] += qty
+			seen[address]++
+		}
+	}
+
+	var holders []Holder
+	for address, qty := range totals {
+		if p.Mode == PolicyIntersection && seen[address] < len(p.PolicyIDs) {
+			continue
+		}
+		holders = append(holders, Holder{Address: address, Quantity: qty})
+	}
+
+	valid, invalid := normalizeHolders(ctx, holders, nil)
+	return valid, invalid, nil
+}
+
+//
+// Specific assets, by fingerprint (asset1...) or unit (policy ID + hex name)
+//
+
+type AssetHolderSource struct {
+	Assets []string
+}
+
+func (a AssetHolderSource) Name() string { return fmt.Sprintf("%d assets", len(a.Assets)) }
+
+func (a AssetHolderSource) Holders(ctx context.Context) ([]Holder, []HolderError, error) {
+	type unit struct{ policy, name string }
+	var (
+		units        []unit
+		fingerprints []string
+		errs         []HolderError
+	)
+
+	for _, asset := range a.Assets {
+		asset = strings.TrimSpace(asset)
+		unitStr := strings.ReplaceAll(asset, ".", "")
+		switch {
+		case strings.HasPrefix(asset, "asset1"):
+			fingerprints = append(fingerprints, asset)
+		case len(unitStr) >= 56 && len(unitStr) <= 56+64 && len(unitStr)%2 == 0 && isHex(unitStr):
+			units = append(units, unit{policy: unitStr[:56], name: unitStr[56:]})
+		default:
+			errs = append(errs, HolderError{Input: asset, Reason: "expected an asset fingerprint or policy ID + hex asset name"})
+		}
+	}
+
+	if len(fingerprints) > 0 {
+		resolved, err := koios.GetAssetsByFingerprint(ctx, fingerprints)
+		if err != nil {
+			return nil, errs, fmt.Errorf("fingerprint lookup: %w", err)
+		}
+		found := make(map[string]bool)
+		for _, item := range resolved {
+			found[item.Fingerprint.String()] = true
+			units = append(units, unit{policy: string(item.PolicyID), name: string(item.AssetName)})
+		}
+		for _, fp := range fingerprints {
+			if !found[fp] {
+				errs = append(errs, HolderError{Input: fp, Reason: "unknown asset fingerprint"})
+			}
+		}
+	}
+
+	var holders []Holder
+	for _, u := range units {
+		assetHolders, err := koios.GetAssetHolders(ctx, u.policy, u.name)
+		if err != nil {
+			return nil, errs, fmt.Errorf("asset %s%s: %w", u.policy, u.name, err)
+		}
+		for address, qty := range assetHolders {
+			holders = append(holders, Holder{Address: address, Quantity: qty})
+		}
+	}
+
+	valid, invalid := normalizeHolders(ctx, holders, nil)
+	return valid, append(errs, invalid...), nil
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+//
+// Linked wallets of registered users in the guild
+//
+
+type RegisteredUsersHolderSource struct {
+	GuildID string
+}
+
+func (r RegisteredUsersHolderSource) Name() string { return "registered users" }
+
+// Holders gives every linked wallet of a guild member one unit.
+func (r RegisteredUsersHolderSource) Holders(ctx context.Context) ([]Holder, []HolderError, error) {
+	var holders []Holder
+	owners := cv.WalletOwners()
+	for _, user := range cv.LoadUsers() {
+		wallets := user.ClaimedWallets(owners)
+		if len(wallets) == 0 {
+			continue
+		}
+		if _, err := S.GuildMember(r.GuildID, user.ID); err != nil {
+			// User not in guild, skip
+			continue
+		}
+		for _, wallet := range wallets {
+			holders = append(holders, Holder{Address: wallet.Payment, Quantity: 1})
+		}
+	}
+
+	valid, invalid := normalizeHolders(ctx, holders, nil)
+	return valid, invalid, nil
+}
+
+// formatHandleResolutions lists the $handles that were resolved to addresses.
+func formatHandleResolutions(holders []Holder, max int) string {
+	var lines []string
+	for _, h := range holders {
+		if h.Handle == "" {
+			continue
+		}
+		if len(lines) == max {
+			lines = append(lines, "…")
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s → %s", h.Handle, cv.TruncateMiddle(h.Address, 32)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatHolderErrors lists the first few rejected entries for an embed field.
+func formatHolderErrors(errs []HolderError, max int) string {
+	var lines []string
+	for n, e := range errs {
+		if n == max {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(errs)-max))
+			break
+		}
+		lines = append(lines, e.String())
+	}
+	return strings.Join(lines, "\n")
+}
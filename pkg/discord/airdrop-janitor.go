@@ -0,0 +1,179 @@
+package discord
+
+import (
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/db"
+	"cardano-valley/pkg/logger"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  JANITOR: archive finished sessions, then purge keys after the retention window
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+const (
+	// How long temp wallet keys are kept after a session finishes
+	airdropKeyRetention = 30 * 24 * time.Hour
+
+	// How often the janitor sweeps the sessions directory
+	airdropJanitorInterval = 1 * time.Hour
+)
+
+func archiveDir() string { return filepath.Join(baseAirdropDir, "archive") }
+
+func airdropJanitor(ctx context.Context) {
+	for {
+		sweepAirdropSessions(ctx)
+		time.Sleep(airdropJanitorInterval)
+	}
+}
+
+func sweepAirdropSessions(ctx context.Context) {
+	l := logger.Record.WithGroup("AIRDROP JANITOR")
+
+	entries, err := os.ReadDir(sessionDir())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.Error("could not read sessions", "ERROR", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		sessionID := strings.TrimSuffix(entry.Name(), ".json")
+		sweepAirdropSession(ctx, sessionID)
+	}
+}
+
+func sweepAirdropSession(ctx context.Context, sessionID string) {
+	l := logger.Record.WithGroup("AIRDROP JANITOR").With("SESSION", sessionID)
+
+	// in-flight sessions are held by their watcher, they are swept once it
+	// lets go
+	unlock, ok := tryLockSession(sessionID)
+	if !ok {
+		l.Debug("session busy, skipped")
+		return
+	}
+	defer unlock()
+
+	ses, err := loadSession(sessionID)
+	if err != nil {
+		l.Error("could not load session", "ERROR", err)
+		return
+	}
+
+	// Sessions created before keys were encrypted still have plaintext keys
+	if !ses.PurgedAt.IsZero() {
+		return
+	}
+	if ses.SKeyEncrypted == "" && !ses.Treasury {
+		if err := encryptSessionKey(ses); err != nil {
+			l.Error("could not encrypt legacy key", "ERROR", err)
+		} else {
+			l.Info("encrypted legacy plaintext key")
+			_ = saveSession(ses)
+		}
+	} else if ses.SKeyEncrypted != "" && !db.Keys.IsCurrent(ses.SKeyEncrypted) {
+		// the session was busy when the keys were rotated
+		if sealed, err := db.Keys.Reseal(ses.SKeyEncrypted, nil); err != nil {
+			l.Error("could not re-encrypt key", "ERROR", err)
+		} else {
+			ses.SKeyEncrypted = sealed
+			_ = saveSession(ses)
+		}
+	}
+
+	// a watcher that is gone, after a restart, no longer expires its session
+	if expireUnfunded(ses) {
+		l.Info("cancelled unfunded session", "CREATED", ses.CreatedAt)
+	}
+
+	if ses.Stage != StageCompleted && ses.Stage != StageCancelled {
+		return
+	}
+
+	finishedAt := ses.FinishedAt
+	if finishedAt.IsZero() {
+		finishedAt = ses.CreatedAt
+	}
+
+	// 1) Move the session's working directory out of active
+	if ses.ArchivedAt.IsZero() {
+		dest := filepath.Join(archiveDir(), ses.SessionID)
+		if err := os.MkdirAll(archiveDir(), 0700); err != nil {
+			l.Error("could not create archive dir", "ERROR", err)
+			return
+		}
+		if err := os.Rename(ses.WalletDir, dest); err != nil && !os.IsNotExist(err) {
+			l.Error("could not archive session", "FROM", ses.WalletDir, "TO", dest, "ERROR", err)
+			return
+		}
+		l.Info("archived session", "FROM", ses.WalletDir, "TO", dest)
+
+		rebase := func(p string) string {
+			if p == "" || !strings.HasPrefix(p, ses.WalletDir) {
+				return p
+			}
+			return filepath.Join(dest, strings.TrimPrefix(p, ses.WalletDir))
+		}
+		ses.HoldersPath = rebase(ses.HoldersPath)
+		ses.AddrFile = rebase(ses.AddrFile)
+		ses.VKeyFile = rebase(ses.VKeyFile)
+		if !ses.Treasury {
+			ses.SKeyFile = rebase(ses.SKeyFile)
+		}
+		ses.WalletDir = dest
+		ses.ArchivedAt = time.Now()
+		_ = saveSession(ses)
+	}
+
+	// Treasury keys belong to the guild wallet and are never purged here
+	if ses.Treasury {
+		return
+	}
+
+	// 2) Keep the keys until the retention window has passed
+	if time.Since(finishedAt) < airdropKeyRetention {
+		return
+	}
+
+	// 3) Never delete keys for an address that still holds funds
+	balance, err := chain.Current.AddressBalance(ctx, ses.Address)
+	if err != nil {
+		l.Error("could not verify address is empty", "ADDRESS", ses.Address, "ERROR", err)
+		return
+	}
+	if balance > 0 {
+		l.Warn("address still holds funds, keeping keys", "ADDRESS", ses.Address, "LOVELACE", balance)
+		return
+	}
+	l.Info("verified address is empty", "ADDRESS", ses.Address)
+
+	// 4) Securely delete every key file and drop the encrypted copy
+	for _, f := range []string{ses.SKeyFile, ses.VKeyFile} {
+		if err := cardano.ShredFile(f); err != nil {
+			l.Error("could not shred key file", "FILE", f, "ERROR", err)
+			return
+		}
+		l.Info("shredded key file", "FILE", f)
+	}
+
+	ses.SKeyEncrypted = ""
+	ses.PurgedAt = time.Now()
+	if err := saveSession(ses); err != nil {
+		l.Error("could not save purged session", "ERROR", err)
+		return
+	}
+	l.Info("purged session keys")
+}
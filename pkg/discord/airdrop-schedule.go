@@ -0,0 +1,250 @@
+package discord
+
+import (
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  SCHEDULED AIRDROPS: recurring definitions paid from the guild treasury
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+const (
+	// How often the scheduler looks for due airdrops
+	airdropSchedulePollInterval = 10 * time.Minute
+)
+
+// treasuryWalletID is the wallet ID of a guild's airdrop treasury.
+func treasuryWalletID(guildID string) string {
+	return guildID + "-treasury"
+}
+
+// ensureTreasury returns the guild's treasury wallet, creating it if needed.
+func ensureTreasury(config *cv.Config) (*cardano.Keys, error) {
+	if config.Treasury.Address != "" {
+		return &config.Treasury, nil
+	}
+
+	wallet, err := cardano.GenerateWallet(treasuryWalletID(string(config.GuildID)))
+	if err != nil && !errors.Is(err, cardano.ErrWalletExists) {
+		return nil, err
+	}
+
+	config.Treasury = *wallet
+	config.Save()
+
+	return wallet, nil
+}
+
+func airdropScheduler(ctx context.Context) {
+	for {
+		time.Sleep(airdropSchedulePollInterval)
+		runDueAirdropSchedules(ctx)
+	}
+}
+
+func runDueAirdropSchedules(ctx context.Context) {
+	l := logger.Record.WithGroup("AIRDROP SCHEDULER")
+	now := time.Now().UTC()
+
+	var epoch uint64
+	for _, schedule := range cv.LoadActiveAirdropSchedules() {
+		if !schedule.EndAt.IsZero() && now.After(schedule.EndAt) {
+			l.Info("schedule ended", "SCHEDULE", schedule.ID)
+			schedule.Deactivate()
+			continue
+		}
+
+		if !schedule.Due(now) {
+			continue
+		}
+
+		// a run waits for its confirmations, which can outlast the poll
+		// interval; skip the schedule until it finishes
+		unlock, ok := tryLockSession(schedule.ID)
+		if !ok {
+			l.Info("previous run still in progress", "SCHEDULE", schedule.ID)
+			continue
+		}
+
+		if schedule.Cadence == cv.CadenceEpoch {
+			if epoch == 0 {
+				tip, err := chain.Current.Tip(ctx)
+				if err != nil {
+					l.Error("could not get tip", "SCHEDULE", schedule.ID, "ERROR", err)
+					unlock()
+					continue
+				}
+				epoch = uint64(tip.Epoch)
+			}
+
+			if epoch <= schedule.LastEpoch {
+				unlock()
+				continue
+			}
+			schedule.LastEpoch = epoch
+		}
+
+		schedule.NextRunAt = schedule.Cadence.Next(now)
+		schedule.SaveProgress()
+		go func(schedule cv.AirdropSchedule, epoch uint64) {
+			defer unlock()
+			runScheduledAirdrop(ctx, S, &schedule, epoch)
+		}(schedule, epoch)
+	}
+}
+
+// runScheduledAirdrop creates a child AirdropSession for the schedule, pays it
+// from the guild treasury and records the run in the schedule history.
+func runScheduledAirdrop(ctx context.Context, s *discordgo.Session, schedule *cv.AirdropSchedule, epoch uint64) {
+	l := logger.Record.WithGroup("AIRDROP SCHEDULER").With("SCHEDULE", schedule.ID)
+	now := time.Now().UTC()
+
+	run := cv.AirdropRun{
+		SessionID: fmt.Sprintf("%s_%d", schedule.ID, now.Unix()),
+		StartedAt: now,
+		Epoch:     epoch,
+	}
+	schedule.AddRun(run)
+	defer func() {
+		run.FinishedAt = time.Now().UTC()
+		schedule.SaveRun(run)
+	}()
+
+	fail := func(msg string, err error) {
+		run.Stage = string(StageCancelled)
+		run.Error = fmt.Sprintf("%s: %v", msg, err)
+		l.Error(msg, "ERROR", err)
+		sendDM(s, schedule.CreatedBy, fmt.Sprintf("❌ Scheduled airdrop `%s` did not run: %s", schedule.ID, run.Error))
+	}
+
+	config := cv.LoadConfig(string(schedule.GuildID))
+	if config.Treasury.Address == "" {
+		fail("no treasury", errors.New("run /schedule-airdrop again to create one"))
+		return
+	}
+
+	holders, err := scheduleHolders(ctx, schedule)
+	if err != nil {
+		fail("holder lookup failed", err)
+		return
+	}
+
+	holders, totalAssets, adaPerAsset, _ := prepareHolders(holders, schedule.TotalADA)
+	if len(holders) == 0 {
+		fail("no eligible holders", errors.New("no holder would receive at least 1 ADA"))
+		return
+	}
+
+	ses := &AirdropSession{
+		DiscordUserID:         schedule.CreatedBy,
+		SessionID:             run.SessionID,
+		CreatedAt:             now,
+		PolicyID:              schedule.PolicyID,
+		ADAperAsset:           adaPerAsset,
+		Holders:               holders,
+		TotalAssets:           totalAssets,
+		TotalRecipients:       uint64(len(holders)),
+		TotalLovelaceRequired: schedule.TotalADA*1_000_000 + feeBufferLovelace + serviceFeeLovelace,
+		WalletDir:             filepath.Join(baseAirdropDir, "scheduled", run.SessionID),
+		Address:               config.Treasury.Address,
+		GuildID:               string(schedule.GuildID),
+		ScheduleID:            schedule.ID,
+		Treasury:              true,
+		Stage:                 StageBuildingTx,
+	}
+	run.TotalRecipients = ses.TotalRecipients
+	run.TotalLovelace = schedule.TotalADA * 1_000_000
+
+	unlock := lockSession(ses.SessionID)
+	defer unlock()
+	// schedules of a guild share the treasury: one spends it at a time
+	unlockTreasury := lockSession(treasuryWalletID(ses.GuildID))
+	defer unlockTreasury()
+
+	if err := os.MkdirAll(ses.WalletDir, 0700); err != nil {
+		fail("session dir", err)
+		return
+	}
+
+	have, err := chain.Current.AddressBalance(ctx, ses.Address)
+	if err != nil {
+		fail("treasury balance check", err)
+		return
+	}
+	if have < ses.TotalLovelaceRequired {
+		fail("treasury underfunded", fmt.Errorf("have %.6f ADA, need %.6f ADA", float64(have)/1_000_000, float64(ses.TotalLovelaceRequired)/1_000_000))
+		return
+	}
+	_ = saveSession(ses)
+
+	txids, err := buildSignSubmitAirdropTxs(ses)
+	if err != nil {
+		ses.Stage = StageCancelled
+		ses.FinishedAt = time.Now()
+		ses.LastError = "distribution failed: " + err.Error()
+		_ = saveSession(ses)
+		fail("distribution failed", err)
+		return
+	}
+
+	ses.DistributionTxIDs = txids
+	ses.Stage = StageDistributing
+	_ = saveSession(ses)
+
+	run.Stage = string(StageDistributing)
+	run.TxIDs = txids
+	schedule.SaveRun(run)
+
+	if err := waitForConfirmations(txids); err != nil {
+		ses.Stage = StageCancelled
+		ses.FinishedAt = time.Now()
+		ses.LastError = "distribution not confirmed: " + err.Error()
+		_ = saveSession(ses)
+		fail("distribution not confirmed", err)
+		return
+	}
+
+	ses.Stage = StageCompleted
+	ses.FinishedAt = time.Now()
+	_ = saveSession(ses)
+
+	run.Stage = string(StageCompleted)
+	l.Info("scheduled airdrop complete", "SESSION", ses.SessionID, "TXS", txids)
+
+	announceAirdrop(s, ses)
+}
+
+// markScheduledRunDropped records a dropped tx on the schedule run that
+// submitted it.
+func markScheduledRunDropped(ses *AirdropSession, txid string) {
+	cv.SetAirdropRunError(ses.ScheduleID, ses.SessionID, "tx dropped: "+txid)
+}
+
+func scheduleHolders(ctx context.Context, schedule *cv.AirdropSchedule) ([]Holder, error) {
+	if schedule.PolicyID == "" {
+		var holders []Holder
+		for _, h := range schedule.Holders {
+			holders = append(holders, Holder{Address: h.Address, Quantity: h.Quantity})
+		}
+		return holders, nil
+	}
+
+	source := PolicyHolderSource{PolicyIDs: strings.Split(schedule.PolicyID, ","), Mode: PolicyUnion}
+	holders, _, err := source.Holders(ctx)
+
+	return holders, err
+}
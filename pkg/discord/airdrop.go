@@ -1,21 +1,19 @@
 package discord
 
 import (
-	"bytes"
-	"cardano-valley/pkg/blockfrost"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/db"
 	"cardano-valley/pkg/logger"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -30,12 +28,6 @@ import (
 //
 
 const (
-	// Change to "--mainnet" if you're on mainnet
-	CardanoNetworkTag = "--mainnet"
-	// For pre-prod/testnet use: "--testnet-magic", "1xxxxxx"
-	// cardanoNetworkTag = "--testnet-magic"
-	// testnetMagicValue = "1097911063"
-
 	// Where to store temp wallets/sessions
 	baseAirdropDir = "./airdrops"
 
@@ -52,6 +44,15 @@ const (
 
 	// How often to poll for deposit
 	depositPollInterval = 1 * time.Minute
+
+	// Sessions not funded within this window are cancelled
+	depositTimeout = 7 * 24 * time.Hour
+
+	// Airdrop txs expire if they are not in a block within about an hour
+	txValiditySlots = 3600
+
+	// How long to wait for the distribution to confirm before giving up
+	confirmTimeout = 45 * time.Minute
 )
 
 // Required ENV:
@@ -59,6 +60,7 @@ const (
 //   CARDANO_VALLEY_ADDRESS:    cardano addr for the 20 ADA fee
 // Optional:
 //   AIRDROP_PUBLIC_CHANNEL_ID: to post the announcement embed
+//   CARDANO_VALLEY_METADATA_FILE: tx metadata JSON (defaults to a CIP-20 message)
 
 func getEnv(key string) string {
 	v := os.Getenv(key)
@@ -74,6 +76,7 @@ func getEnv(key string) string {
 type Holder struct {
 	Address  string `json:"address"`
 	Quantity uint64    `json:"quantity"`
+	Handle   string `json:"handle,omitempty"` // $handle the address was resolved from
 }
 
 type AirdropStage string
@@ -113,23 +116,25 @@ type AirdropSession struct {
 	SKeyFile  string `json:"skey_file"`
 	Address   string `json:"address"`
 
+	// SKeyEncrypted is the signing key encrypted with db.Encrypt. The
+	// plaintext SKeyFile is shredded once this is set.
+	SKeyEncrypted string `json:"skey_encrypted,omitempty"`
+
+	// scheduled runs are paid from the guild treasury instead of a temp wallet
+	GuildID    string `json:"guild_id,omitempty"`
+	ScheduleID string `json:"schedule_id,omitempty"`
+	Treasury   bool   `json:"treasury,omitempty"`
+
 	// lifecycle
 	Stage AirdropStage `json:"stage"`
 
 	// bookkeeping
-	LastError string `json:"last_error,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	ArchivedAt time.Time `json:"archived_at,omitempty"`
+	PurgedAt   time.Time `json:"purged_at,omitempty"`
 }
 
-type out struct {
-	Addr     string
-	Lovelace int64
-}
-
-type UTxOMap map[string]struct {
-		TxHash string `json:"tx_hash"`
-		TxIx   int    `json:"tx_index"`
-	}
-
 // in-memory locker so concurrent workers don't trample the same session
 var sessionLocks sync.Map // map[sessionID]*sync.Mutex
 
@@ -140,112 +145,62 @@ func lockSession(id string) func() {
 	return mu.Unlock
 }
 
+// tryLockSession is lockSession for background sweeps: a session held by its
+// watcher can be busy for days while it waits for funds, so it is skipped
+// instead of waited for.
+func tryLockSession(id string) (func(), bool) {
+	muAny, _ := sessionLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := muAny.(*sync.Mutex)
+	if !mu.TryLock() {
+		return nil, false
+	}
+	return mu.Unlock, true
+}
+
+// expireUnfunded cancels a session that was never funded. Its keys are kept
+// and purged by the janitor like any finished session, once the address is
+// verified empty.
+func expireUnfunded(ses *AirdropSession) bool {
+	if ses.Stage != StageAwaitingFunds || time.Since(ses.CreatedAt) < depositTimeout {
+		return false
+	}
+	ses.Stage = StageCancelled
+	ses.FinishedAt = time.Now()
+	ses.LastError = fmt.Sprintf("not funded within %s", depositTimeout)
+	_ = saveSession(ses)
+	return true
+}
+
 //
 // ────────────────────────────────────────────────────────────────────────────────
 //  HOLDERS: Load from file or Blockfrost policy lookup
 // ────────────────────────────────────────────────────────────────────────────────
 //
 
-func loadHoldersFromAttachment(url string) ([]Holder, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-	var holders []Holder
-	if err := json.Unmarshal(body, &holders); err != nil {
-		return nil, err
-	}
-	return holders, nil
-}
-
-// Strategy:
-// 1) list assets under policy
-// 2) for each asset, fetch current addresses/qty (should be 1 with qty=1 for NFTs)
-// 3) accumulate by address (quantity == number of NFTs)
-func queryHoldersByPolicy_Blockfrost(policyID, apiKey string) ([]Holder, error) {
-	if apiKey == "" {
-		return nil, errors.New("BLOCKFROST_PROJECT_ID is required")
+// prepareHolders drops zero quantity and invalid addresses, then drops holders
+// whose share of totalAda would be 1 ADA or less.
+func prepareHolders(holders []Holder, totalAda uint64) (filtered []Holder, totalAssets uint64, adaPerAsset float64, skipped int) {
+	valid := make([]Holder, 0, len(holders))
+	for _, h := range holders {
+		totalAssets += h.Quantity
+		if validateHolder(h) == nil {
+			valid = append(valid, h)
+		}
 	}
-	type asset struct {
-		Asset string `json:"asset"` // policy + hex asset name
+	if totalAssets == 0 {
+		return nil, 0, 0, len(valid)
 	}
-	assets := []asset{}
 
-	// paginate /assets/policy/{policy_id}
-	page := 1
-	for {
-		req, _ := http.NewRequest("GET",
-			fmt.Sprintf("https://cardano-mainnet.blockfrost.io/api/v0/assets/policy/%s?page=%d", policyID, page),
-			nil,
-		)
-		req.Header.Set("project_id", apiKey)
-		rsp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		defer rsp.Body.Close()
-		if rsp.StatusCode == 404 {
-			break
-		}
-		if rsp.StatusCode >= 300 {
-			b, _ := io.ReadAll(rsp.Body)
-			return nil, fmt.Errorf("blockfrost policy assets: %s", string(b))
-		}
-		var pageAssets []asset
-		if err := json.NewDecoder(rsp.Body).Decode(&pageAssets); err != nil {
-			return nil, err
-		}
-		if len(pageAssets) == 0 {
-			break
-		}
-		assets = append(assets, pageAssets...)
-		page++
-	}
-
-	// Collect holders count
-	counts := map[string]int{}
-	for _, a := range assets {
-		// /assets/{asset}/addresses
-		req, _ := http.NewRequest("GET",
-			fmt.Sprintf("https://cardano-mainnet.blockfrost.io/api/v0/assets/%s/addresses", a.Asset),
-			nil,
-		)
-		req.Header.Set("project_id", apiKey)
-		rsp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		if rsp.StatusCode >= 300 {
-			b, _ := io.ReadAll(rsp.Body)
-			_ = rsp.Body.Close()
-			return nil, fmt.Errorf("blockfrost asset addresses: %s", string(b))
-		}
-		var addrs []struct {
-			Address  string `json:"address"`
-			Quantity string `json:"quantity"` // string integer
-		}
-		if err := json.NewDecoder(rsp.Body).Decode(&addrs); err != nil {
-			_ = rsp.Body.Close()
-			return nil, err
-		}
-		_ = rsp.Body.Close()
-		for _, rec := range addrs {
-			qty, _ := strconv.ParseInt(rec.Quantity, 10, 64)
-			if qty > 0 {
-				counts[rec.Address] += int(qty)
-			}
+	adaPerAsset = float64(totalAda) / float64(totalAssets)
+	filtered = make([]Holder, 0, len(valid))
+	for _, h := range valid {
+		if float64(h.Quantity)*adaPerAsset > 1.0 {
+			filtered = append(filtered, h)
 		}
 	}
+	skipped = len(valid) - len(filtered)
 
-	holders := make([]Holder, 0, len(counts))
-	for addr, qty := range counts {
-		holders = append(holders, Holder{Address: addr, Quantity: uint64(qty)})
-	}
-	// deterministic order
-	sort.Slice(holders, func(i, j int) bool { return holders[i].Address < holders[j].Address })
-	return holders, nil
+	return filtered, totalAssets, adaPerAsset, skipped
 }
 
 //
@@ -286,7 +241,7 @@ func loadSession(sessionID string) (*AirdropSession, error) {
 
 //
 // ────────────────────────────────────────────────────────────────────────────────
-//  TEMP WALLET CREATION (names include Discord ID; retained for 30 days)
+//  TEMP WALLET CREATION (names include Discord ID; keys retained for 30 days)
 // ────────────────────────────────────────────────────────────────────────────────
 //
 
@@ -302,22 +257,30 @@ func createTempWallet(discordID string) (*AirdropSession, error) {
 	addr := filepath.Join(dir, fmt.Sprintf("airdrop_%s_%d.addr", discordID, now.Unix()))
 
 	// Generate keys
-	args := []string{"address", "key-gen", "--verification-key-file", vkey, "--signing-key-file", skey}
-	if out, err := execCmd("cardano-cli", args...); err != nil {
-		return nil, fmt.Errorf("key-gen: %v (%s)", err, out)
+	key, err := cardano.NewSigningKey(cardano.PaymentSigningKeyType)
+	if err != nil {
+		return nil, fmt.Errorf("key-gen: %w", err)
+	}
+	if err := os.WriteFile(vkey, []byte(key.VerificationEnvelope()), 0600); err != nil {
+		return nil, fmt.Errorf("key-gen: %w", err)
 	}
 
 	// Build address
-	buildArgs := []string{"address", "build", "--payment-verification-key-file", vkey, "--out-file", addr, CardanoNetworkTag}
-	if out, err := execCmd("cardano-cli", buildArgs...); err != nil {
-		return nil, fmt.Errorf("address build: %v (%s)", err, out)
+	address, err := cardano.EnterpriseAddress(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("address build: %w", err)
+	}
+	if err := os.WriteFile(addr, []byte(address), 0600); err != nil {
+		return nil, fmt.Errorf("address build: %w", err)
 	}
 
-	// Load address text
-	ab, _ := os.ReadFile(addr)
-	address := strings.TrimSpace(string(ab))
+	// The signing key only ever exists encrypted
+	enc, err := db.Encrypt(key.Envelope())
+	if err != nil {
+		return nil, fmt.Errorf("encrypt skey: %w", err)
+	}
 
-	return &AirdropSession{
+	ses := &AirdropSession{
 		DiscordUserID: discordID,
 		SessionID:     sessionID,
 		CreatedAt:     now,
@@ -326,10 +289,155 @@ func createTempWallet(discordID string) (*AirdropSession, error) {
 		SKeyFile:      skey,
 		AddrFile:      addr,
 		Address:       address,
+		SKeyEncrypted: enc,
 		Stage:         StageAwaitingFunds,
-	}, nil
+	}
+
+	return ses, nil
+}
+
+// encryptSessionKey encrypts the plaintext signing key into the session and
+// shreds the file. Treasury keys belong to the guild wallet and are skipped.
+func encryptSessionKey(ses *AirdropSession) error {
+	if ses.Treasury || ses.SKeyEncrypted != "" {
+		return nil
+	}
+
+	skey, err := os.ReadFile(ses.SKeyFile)
+	if err != nil {
+		return fmt.Errorf("read skey: %w", err)
+	}
+
+	enc, err := db.Encrypt(string(skey))
+	if err != nil {
+		return fmt.Errorf("encrypt skey: %w", err)
+	}
+	ses.SKeyEncrypted = enc
+
+	if err := cardano.ShredFile(ses.SKeyFile); err != nil {
+		return fmt.Errorf("shred skey: %w", err)
+	}
+	logger.Record.Info("AIRDROP", "SESSION", ses.SessionID, "SKEY", "encrypted and shredded plaintext")
+
+	return nil
+}
+
+// sessionSigningKey returns the session's signing key envelope, decrypting it
+// in memory. Treasury sessions use the guild treasury wallet's key.
+func sessionSigningKey(ses *AirdropSession) (string, error) {
+	if ses.Treasury {
+		config := cv.LoadConfig(ses.GuildID)
+		skey, err := config.Treasury.PaymentSigningKey()
+		if err != nil {
+			return "", fmt.Errorf("treasury skey: %w", err)
+		}
+		return skey, nil
+	}
+	if ses.SKeyEncrypted != "" {
+		skey, err := db.Decrypt(ses.SKeyEncrypted)
+		if err != nil {
+			return "", fmt.Errorf("decrypt skey: %w", err)
+		}
+		return skey, nil
+	}
+
+	skey, err := os.ReadFile(ses.SKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("read skey: %w", err)
+	}
+	return string(skey), nil
 }
 
+// signAndSubmit signs tx with the session key, submits it and hands it to
+// the confirmation tracker.
+func signAndSubmit(ses *AirdropSession, tx *cardano.Tx, purpose confirm.Purpose) (string, error) {
+	skey, err := sessionSigningKey(ses)
+	if err != nil {
+		return "", err
+	}
+	if err := cardano.Builder.Sign(tx, skey); err != nil {
+		return "", err
+	}
+
+	res, err := chain.Submit(context.Background(), tx.CBOR)
+	if err != nil {
+		return "", fmt.Errorf("tx submit: %w", err)
+	}
+
+	err = confirm.Track(context.Background(), confirm.TrackedTx{
+		TxHash:    res.TxID,
+		Purpose:   purpose,
+		Reference: ses.SessionID,
+		TTL:       tx.TTL,
+	})
+	if err != nil {
+		logger.Record.Error("AIRDROP", "SESSION", ses.SessionID, "TX", res.TxID, "TRACK", err)
+	}
+	return res.TxID, nil
+}
+
+// currentTTL is the last slot a tx built now may be included in.
+func currentTTL(ctx context.Context) (uint64, error) {
+	tip, err := chain.Current.Tip(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("tip: %w", err)
+	}
+	return tip.Slot + txValiditySlots, nil
+}
+
+// waitForConfirmations blocks until every tx is confirmed. Rollbacks are
+// waited out; a dropped tx or the timeout is an error.
+func waitForConfirmations(txids []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), confirmTimeout)
+	defer cancel()
+
+	for _, txid := range txids {
+		if _, err := confirm.Wait(ctx, txid); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("%s not confirmed after %s", txid, confirmTimeout)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// onAirdropTxEvent tells the airdrop creator when one of their txs is dropped
+// or rolled back. Confirmations are reported by the watcher itself.
+func onAirdropTxEvent(s *discordgo.Session) func(confirm.Event) {
+	return func(e confirm.Event) {
+		if e.Tx.Purpose != confirm.PurposeAirdrop && e.Tx.Purpose != confirm.PurposeServiceFee {
+			return
+		}
+		if e.Status == confirm.StatusConfirmed {
+			return
+		}
+
+		ses, err := loadSession(e.Tx.Reference)
+		if err != nil {
+			logger.Record.Warn("AIRDROP", "TX EVENT", e.Tx.TxHash, "SESSION", e.Tx.Reference, "ERROR", err)
+			return
+		}
+
+		var msg string
+		switch e.Status {
+		case confirm.StatusRolledBack:
+			msg = fmt.Sprintf("⚠️ Airdrop tx `%s` was rolled back out of its block. It is still being tracked and should be included again shortly.", e.Tx.TxHash)
+		case confirm.StatusDropped:
+			msg = fmt.Sprintf("❌ Airdrop tx `%s` was dropped before it reached the chain. The funds it spent are still in the airdrop wallet.", e.Tx.TxHash)
+			ses.LastError = "tx dropped: " + e.Tx.TxHash
+			_ = saveSession(ses)
+			if ses.ScheduleID != "" {
+				markScheduledRunDropped(ses, e.Tx.TxHash)
+			}
+		default:
+			return
+		}
+		sendDM(s, ses.DiscordUserID, msg)
+	}
+}
+
+
 //
 // ────────────────────────────────────────────────────────────────────────────────
 //  WATCHER: Wait for deposit → distribute → pay service fee → announce
@@ -356,7 +464,11 @@ func watchAndRunAirdrop(s *discordgo.Session, i *discordgo.InteractionCreate, se
 	ctx := context.Background()
 	for {
 		time.Sleep(depositPollInterval)
-		have, err = blockfrost.GetAddressBalance(ctx, ses.Address)
+		if expireUnfunded(ses) {
+			sendDM(s, ses.DiscordUserID, fmt.Sprintf("⌛ Airdrop `%s` was cancelled, it was not funded within %d days.", ses.SessionID, int(depositTimeout.Hours()/24)))
+			return
+		}
+		have, err = chain.Current.AddressBalance(ctx, ses.Address)
 		if err != nil {
 			ses.LastError = "balance check: " + err.Error()
 			_ = saveSession(ses)
@@ -386,8 +498,13 @@ func watchAndRunAirdrop(s *discordgo.Session, i *discordgo.InteractionCreate, se
 	ses.Stage = StagePayingFee
 	_ = saveSession(ses)
 
-	// Wait for the tx to be settled.
-	time.Sleep(2 * time.Minute)
+	// The drain spends the distribution change, so wait for it to settle.
+	if err := waitForConfirmations(ses.DistributionTxIDs); err != nil {
+		ses.LastError = "distribution not confirmed: " + err.Error()
+		_ = saveSession(ses)
+		sendDM(s, ses.DiscordUserID, fmt.Sprintf("❌ Airdrop TXs were submitted but not confirmed: %v. The remaining funds were left in the airdrop wallet.", err))
+		return
+	}
 	if err := payServiceFeeAndDrain(ses); err != nil {
 		ses.LastError = "service fee failed: " + err.Error()
 		_ = saveSession(ses)
@@ -397,9 +514,14 @@ func watchAndRunAirdrop(s *discordgo.Session, i *discordgo.InteractionCreate, se
 
 	// 4) Mark complete
 	ses.Stage = StageCompleted
+	ses.FinishedAt = time.Now()
 	_ = saveSession(ses)
 
-	// 5) DM receipt
+	// 5) DM receipt and public announcement
+	announceAirdrop(s, ses)
+}
+
+func announceAirdrop(s *discordgo.Session, ses *AirdropSession) {
 	var buf strings.Builder
 	fmt.Fprintf(&buf, "🎉 **Airdrop Complete!**\n\n")
 	fmt.Fprintf(&buf, "- Recipients: %d\n", ses.TotalRecipients)
@@ -414,7 +536,7 @@ func watchAndRunAirdrop(s *discordgo.Session, i *discordgo.InteractionCreate, se
 	}
 	sendDM(s, ses.DiscordUserID, buf.String())
 
-	// 6) Public announcement
+	// Public announcement
 	publicChan := getEnv("AIRDROP_PUBLIC_CHANNEL_ID")
 	if publicChan != "" {
 		embed := &discordgo.MessageEmbed{
@@ -437,21 +559,30 @@ func watchAndRunAirdrop(s *discordgo.Session, i *discordgo.InteractionCreate, se
 
 //
 // ────────────────────────────────────────────────────────────────────────────────
-//  CARDANO CHAIN HELPERS (Blockfrost + cardano-cli)
+//  CARDANO CHAIN HELPERS (chain provider + cardano.Builder)
 // ────────────────────────────────────────────────────────────────────────────────
 //
 
 func buildSignSubmitAirdropTxs(ses *AirdropSession) ([]string, error) {
 	// Chunk outputs into batches
-	var outputs []out
+	var outputs []cardano.TxOut
 	for _, h := range ses.Holders {
 		amt := int64(math.Round(float64(h.Quantity) * ses.ADAperAsset * 1_000_000))
 		if amt > 0 {
-			outputs = append(outputs, out{Addr: h.Address, Lovelace: amt})
+			outputs = append(outputs, cardano.TxOut{Address: h.Address, Lovelace: uint64(amt)})
 		}
 	}
+	// Treasury funded runs are never drained, so the service fee rides along
+	// with the distribution instead of a separate sweep transaction.
+	if ses.Treasury {
+		feeAddr := getEnv("CARDANO_VALLEY_ADDRESS")
+		if feeAddr == "" {
+			return nil, errors.New("CARDANO_VALLEY_ADDRESS env var is required")
+		}
+		outputs = append(outputs, cardano.TxOut{Address: feeAddr, Lovelace: serviceFeeLovelace})
+	}
 	// Split into batches
-	var batches [][]out
+	var batches [][]cardano.TxOut
 	for i := 0; i < len(outputs); i += maxOutputsPerTx {
 		j := i + maxOutputsPerTx
 		if j > len(outputs) {
@@ -460,221 +591,108 @@ func buildSignSubmitAirdropTxs(ses *AirdropSession) ([]string, error) {
 		batches = append(batches, outputs[i:j])
 	}
 
+	utxos, err := chain.Current.AddressUTxOs(context.Background(), ses.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query UTXOs: %w", err)
+	}
+	available := chain.TxInputs(utxos)
+
+	ttl, err := currentTTL(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := airdropMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each batch spends the previous batch's change, so the next tx does not
+	// have to wait for the provider to see the last one.
 	var txIDs []string
 	for _, batch := range batches {
-		txid, err := buildSignSubmitSingleTx(ses, batch)
+		txid, remaining, err := buildSignSubmitSingleTx(ses, batch, available, metadata, ttl)
 		if err != nil {
 			return nil, err
 		}
 		txIDs = append(txIDs, txid)
+		available = remaining
 	}
 	return txIDs, nil
 }
 
-// Build a single transaction with multiple --tx-out outputs and change back to the same airdrop address.
-func buildSignSubmitSingleTx(ses *AirdropSession, batch []out) (string, error) {
-
-	txBody := filepath.Join(ses.WalletDir, fmt.Sprintf("txbody_%d.raw", time.Now().UnixNano()))
-	txSigned := filepath.Join(ses.WalletDir, fmt.Sprintf("txsigned_%d.signed", time.Now().UnixNano()))
-	socketPath := os.Getenv("CARDANO_NODE_SOCKET_PATH")
-
-	// Gather tx-out args
-	var outArgs []string
-	var totalBatch int64
-	for _, o := range batch {
-		outArgs = append(outArgs, "--tx-out", fmt.Sprintf("%s+%d", o.Addr, o.Lovelace))
-		totalBatch += o.Lovelace
-	}
-
-	// We have to have a tx-in
-	out, err := execCmd("cardano-cli", "query", "utxo",
-		"--address", ses.Address,
-		CardanoNetworkTag,
-		"--socket-path", socketPath,
-		"--out-file", "/dev/stdout",
-		"--output-json",
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to query UTXOs: %w", err)
+// Build a single transaction paying the batch, with change back to the same
+// airdrop address. Returns the UTxOs left for the next batch.
+func buildSignSubmitSingleTx(ses *AirdropSession, batch []cardano.TxOut, available []cardano.TxInput, metadata cardano.Metadata, ttl uint64) (string, []cardano.TxInput, error) {
+	if len(available) == 0 {
+		return "", nil, fmt.Errorf("no UTXOs found at address %s", ses.Address)
 	}
 
-	// Parse JSON into map
-	var utxos UTxOMap
-	if err := json.Unmarshal([]byte(out), &utxos); err != nil {
-		return "", fmt.Errorf("failed to parse UTXO JSON: %w", err)
-	}
-
-	txIns := []string{}
-	for utxo := range utxos {
-		// key is like "txhash#txix"
-		txIns = append(txIns, "--tx-in", utxo)
-	}
-	if len(txIns) == 0 {
-		return "", fmt.Errorf("no UTXOs found at address %s", ses.Address)
-	}
-
-	// Build (letting cardano-cli calculate fee and change)
-	args := []string{"conway", "transaction", "build",
-		"--change-address", ses.Address,
-		CardanoNetworkTag,
-		"--socket-path", socketPath,
-		"--out-file", txBody,
-		"--metadata-json-file", "/home/cardano/cardano-valley-metadata.json",
-	}
-	args = append(args, txIns...)
-	args = append(args, outArgs...)
-
-	logger.Record.Info("building tx", "ARGS", args)
-
-	if out, err := execCmd("cardano-cli", args...); err != nil {
-		return "", fmt.Errorf("tx build: %v (%s)", err, out)
+	tx, err := cardano.Builder.Build(cardano.TxRequest{
+		Inputs:        available,
+		Outputs:       batch,
+		ChangeAddress: ses.Address,
+		Metadata:      metadata,
+		TTL:           ttl,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("tx build: %w", err)
 	}
+	logger.Record.Info("AIRDROP", "SESSION", ses.SessionID, "TX", tx.ID, "OUTPUTS", len(batch), "FEE", tx.Fee, "BUILDER", cardano.Builder.Name())
 
-	// Sign
-	signArgs := []string{"conway", "transaction", "sign",
-		"--tx-body-file", txBody,
-		"--signing-key-file", ses.SKeyFile,
-		CardanoNetworkTag,
-		"--out-file", txSigned,
-	}
-	logger.Record.Info("signing tx", "ARGS", signArgs)
-	if out, err := execCmd("cardano-cli", signArgs...); err != nil {
-		return "", fmt.Errorf("tx sign: %v (%s)", err, out)
+	txid, err := signAndSubmit(ses, tx, confirm.PurposeAirdrop)
+	if err != nil {
+		return "", nil, err
 	}
+	return txid, tx.Remaining(available, ses.Address), nil
+}
 
-	// Submit
-	submitArgs := []string{"conway", "transaction", "submit", CardanoNetworkTag, "--tx-file", txSigned, "--socket-path", socketPath,}
-	logger.Record.Info("submitting tx", "ARGS", submitArgs)
-	if out, err := execCmd("cardano-cli", submitArgs...); err != nil {
-		return "", fmt.Errorf("tx submit: %v (%s)", err, out)
+// airdropMetadata tags distribution txs with a CIP-20 message, or with the
+// contents of CARDANO_VALLEY_METADATA_FILE when it is set.
+func airdropMetadata() (cardano.Metadata, error) {
+	if path := getEnv("CARDANO_VALLEY_METADATA_FILE"); path != "" {
+		return cardano.LoadMetadataJSON(path)
 	}
-
-	// Query the txid from the signed file
-	idArgs := []string{"conway", "transaction", "txid", "--tx-file", txSigned,}
-	out, err = execCmd("cardano-cli", idArgs...)
-	if err != nil {
-		return "", fmt.Errorf("txid: %v (%s)", err, out)
-	}
-	return strings.TrimSpace(out), nil
+	return cardano.Metadata{674: map[string]any{"msg": []string{"Cardano Valley airdrop"}}}, nil
 }
 
-// After distribution, send 20 ADA to CARDANO_VALLEY, send leftover to refund address or to CARDANO_VALLEY too.
-// Ensure wallet ends up 0.
+// After distribution, send everything left (covering the 20 ADA service fee)
+// to CARDANO_VALLEY so the wallet ends up empty.
 func payServiceFeeAndDrain(s *AirdropSession) error {
-	socketPath := os.Getenv("CARDANO_NODE_SOCKET_PATH")
 	cardano_valley_address := getEnv("CARDANO_VALLEY_ADDRESS")
 	if cardano_valley_address == "" {
 		return errors.New("CARDANO_VALLEY_ADDRESS env var is required")
 	}
 
 	ctx := context.Background()
-	// Check current balance
-	bal, err := blockfrost.GetAddressBalance(ctx, s.Address)
+	utxos, err := chain.Current.AddressUTxOs(ctx, s.Address)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to query UTXOs: %w", err)
 	}
-	if bal <= 0 {
+	if len(utxos) == 0 {
 		return nil // already empty
 	}
 
-	txBody := filepath.Join(s.WalletDir, "fee_tx.raw")
-	txSigned := filepath.Join(s.WalletDir, "fee_tx.signed")
-	pParams := "/home/cardano/cardano/pparams.json"
-
-	// We have to have a tx-in
-	out, err := execCmd("cardano-cli", "query", "utxo",
-		"--address", s.Address,
-		CardanoNetworkTag,
-		"--socket-path", socketPath,
-		"--out-file", "/dev/stdout",
-		"--output-json",
-	)
+	ttl, err := currentTTL(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to query UTXOs: %w", err)
-	}
-
-	// Parse JSON into map
-	var utxos UTxOMap
-	if err := json.Unmarshal([]byte(out), &utxos); err != nil {
-		return fmt.Errorf("failed to parse UTXO JSON: %w", err)
-	}
-
-	txIns := []string{}
-	for utxo := range utxos {
-		// key is like "txhash#txix"
-		txIns = append(txIns, "--tx-in", utxo)
-	}
-	if len(txIns) == 0 {
-		return fmt.Errorf("no UTXOs found at address %s", s.Address)
-	}
-
-	args := []string{"conway", "transaction", "build-raw",
-		"--fee", "0", // let cardano-cli calculate
-		"--tx-out", fmt.Sprintf("%s+%d", cardano_valley_address, bal),
-		"--out-file", txBody,
-	}
-	args = append(args, txIns...)
-	if out, err := execCmd("cardano-cli", args...); err != nil {
-		return fmt.Errorf("fee tx build: %v (%s)", err, out)
+		return err
 	}
 
-	feeArgs := []string{"conway", "transaction", "calculate-min-fee",
-		"--tx-body-file", txBody,
-		"--witness-count", "1",
-		"--tx-in-count", fmt.Sprintf("%d", len(txIns)),
-		"--tx-out-count", "1",
-		CardanoNetworkTag,
-		"--protocol-params-file", pParams,
-	}
-	out, err = execCmd("cardano-cli", feeArgs...)
-	if err != nil {
-		return fmt.Errorf("fee calc: %v (%s)", err, out)
-	}
-	fields := strings.Fields(out)
-	if len(fields) < 1 {
-		return fmt.Errorf("fee calc: unexpected output: %s", out)
-	}
-	fee, err := strconv.ParseUint(fields[0], 10, 64)
+	tx, err := cardano.Builder.Build(cardano.TxRequest{
+		Inputs:        chain.TxInputs(utxos),
+		ChangeAddress: cardano_valley_address,
+		SpendAll:      true,
+		TTL:           ttl,
+	})
 	if err != nil {
-		return fmt.Errorf("fee parse: %v (%s)", err, out)
-	}
-	bal -= fee
-	if bal < 0 {
-		return fmt.Errorf("insufficient funds to pay fee of %d lovelace", fee)
-	}
-
-
-	args = []string{"conway", "transaction", "build-raw",
-		"--fee", fmt.Sprintf("%d", fee),
-		"--tx-out", fmt.Sprintf("%s+%d", cardano_valley_address, bal),
-		"--out-file", txBody,
-	}
-	args = append(args, txIns...)
-	if out, err := execCmd("cardano-cli", args...); err != nil {
-		return fmt.Errorf("fee tx build: %v (%s)", err, out)
-	}
-
-	signArgs := []string{"conway", "transaction", "sign",
-		"--tx-body-file", txBody,
-		"--signing-key-file", s.SKeyFile,
-		CardanoNetworkTag,
-		"--out-file", txSigned,
-	}
-	if out, err := execCmd("cardano-cli", signArgs...); err != nil {
-		return fmt.Errorf("fee tx sign: %v (%s)", err, out)
+		return fmt.Errorf("fee tx build: %w", err)
 	}
 
-	submitArgs := []string{"conway", "transaction", "submit", CardanoNetworkTag, "--tx-file", txSigned,"--socket-path", socketPath}
-	if out, err := execCmd("cardano-cli", submitArgs...); err != nil {
-		return fmt.Errorf("fee tx submit: %v (%s)", err, out)
-	}
-
-	idArgs := []string{"conway", "transaction", "txid", "--tx-file", txSigned}
-	out, err = execCmd("cardano-cli", idArgs...)
+	txid, err := signAndSubmit(s, tx, confirm.PurposeServiceFee)
 	if err != nil {
-		return fmt.Errorf("fee txid: %v (%s)", err, out)
+		return fmt.Errorf("fee tx: %w", err)
 	}
-	s.ServiceFeeTxID = strings.TrimSpace(out)
+	s.ServiceFeeTxID = txid
 
 	return nil
 }
@@ -685,19 +703,6 @@ func payServiceFeeAndDrain(s *AirdropSession) error {
 // ────────────────────────────────────────────────────────────────────────────────
 //
 
-func execCmd(bin string, args ...string) (string, error) {
-	cmd := exec.Command(bin, args...)
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-	err := cmd.Run()
-	out := stdout.String()
-	if err != nil {
-		return out, fmt.Errorf("%w: %s", err, stderr.String())
-	}
-	return out, nil
-}
-
 func sendDM(s *discordgo.Session, userID, content string) {
 	ch, err := s.UserChannelCreate(userID)
 	if err != nil {
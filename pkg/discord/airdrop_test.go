@@ -0,0 +1,143 @@
+package discord
+
+import (
+	"bytes"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testAddress is the enterprise address of a fresh key.
+func testAddress(t *testing.T) (string, *cardano.SigningKey) {
+	t.Helper()
+	key, err := cardano.NewSigningKey(cardano.PaymentSigningKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := cardano.EnterpriseAddress(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return address, key
+}
+
+// balances adds up the lovelace at each address of the ledger.
+func balances(f *chain.Fixture) map[string]uint64 {
+	held := make(map[string]uint64)
+	for _, u := range f.UTxOs {
+		held[u.Address] += u.Lovelace
+	}
+	return held
+}
+
+func TestAirdropPipeline(t *testing.T) {
+	f, _ := chain.UseFixtureT(t)
+	address, key := testAddress(t)
+	skeyFile := filepath.Join(t.TempDir(), "payment.skey")
+	if err := os.WriteFile(skeyFile, []byte(key.Envelope()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	feeAddress, _ := testAddress(t)
+	t.Setenv("CARDANO_VALLEY_ADDRESS", feeAddress)
+	t.Setenv("CARDANO_VALLEY_METADATA_FILE", "")
+
+	// one more holder than fits in a tx
+	var holders []Holder
+	for n := 0; n <= maxOutputsPerTx; n++ {
+		holder, _ := testAddress(t)
+		holders = append(holders, Holder{Address: holder, Quantity: uint64(n%3 + 1)})
+	}
+	const deposit = 600_000_000
+	f.AddUTxO(chain.UTxO{TxHash: fmt.Sprintf("%064x", 1), Address: address, Lovelace: deposit})
+
+	holders, totalAssets, adaPerAsset, skipped := prepareHolders(holders, 400)
+	if skipped != 0 {
+		t.Fatalf("%d holders skipped", skipped)
+	}
+	ses := &AirdropSession{SessionID: "test", Address: address, SKeyFile: skeyFile, Holders: holders, ADAperAsset: adaPerAsset, TotalAssets: totalAssets}
+
+	txids, err := buildSignSubmitAirdropTxs(ses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txids) != 2 {
+		t.Fatalf("%d txs, want 2", len(txids))
+	}
+	// the second batch spends the change of the first
+	chained := false
+	for _, in := range f.Transactions[txids[1]].Inputs {
+		chained = chained || in.TxHash == txids[0]
+	}
+	if !chained {
+		t.Error("the second tx does not spend the first tx's change")
+	}
+
+	held := balances(f)
+	var paid, fees uint64
+	for _, h := range holders {
+		want := uint64(float64(h.Quantity)*adaPerAsset*1_000_000 + 0.5)
+		if held[h.Address] != want {
+			t.Errorf("%s got %d lovelace, want %d", h.Address, held[h.Address], want)
+		}
+		paid += want
+	}
+	for _, txid := range txids {
+		fees += f.Transactions[txid].Fee
+		if _, err := confirm.Default.Store.Load(context.Background(), txid); err != nil {
+			t.Errorf("%s is not tracked: %v", txid, err)
+		}
+	}
+	if held[address] != deposit-paid-fees {
+		t.Errorf("session wallet kept %d, want %d", held[address], deposit-paid-fees)
+	}
+
+	if err := payServiceFeeAndDrain(ses); err != nil {
+		t.Fatal(err)
+	}
+	held = balances(f)
+	if held[address] != 0 {
+		t.Errorf("session wallet kept %d lovelace after the drain", held[address])
+	}
+	drain := f.Transactions[ses.ServiceFeeTxID]
+	if drain == nil || held[feeAddress] != deposit-paid-fees-drain.Fee {
+		t.Errorf("service fee address got %d lovelace", held[feeAddress])
+	}
+}
+
+func TestPrepareHolders(t *testing.T) {
+	valid, _ := testAddress(t)
+	small, _ := testAddress(t)
+	holders := []Holder{
+		{Address: valid, Quantity: 90},
+		{Address: small, Quantity: 1},               // 1 ADA share is not worth a tx output
+		{Address: "addr1notanaddress", Quantity: 9}, // still counts towards the total
+		{Address: valid, Quantity: 0},
+	}
+
+	filtered, totalAssets, adaPerAsset, skipped := prepareHolders(holders, 100)
+	if totalAssets != 100 || adaPerAsset != 1 {
+		t.Errorf("total %d, %g ADA per asset, want 100 and 1", totalAssets, adaPerAsset)
+	}
+	if len(filtered) != 1 || filtered[0].Address != valid || skipped != 1 {
+		t.Errorf("filtered %+v, skipped %d", filtered, skipped)
+	}
+}
+
+func TestFileHolderSourceTooLarge(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("addr1x,1\n"), maxHoldersFileSize/9+1))
+	}))
+	defer srv.Close()
+
+	_, _, err := FileHolderSource{URL: srv.URL, Filename: "holders.csv"}.Holders(context.Background())
+	if err == nil {
+		t.Error("an oversized holders file was accepted")
+	}
+}
@@ -0,0 +1,97 @@
+package discord
+
+import (
+	"cardano-valley/pkg/confirm"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/web"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  CLAIM FEES: harvests the user pays for from their own wallet
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// claimFeeLinks opens a signing request for every farm that charges a claim
+// fee and returns a link button for each, noting them in buf.
+func claimFeeLinks(user cv.User, address string, buf *strings.Builder) []discordgo.MessageComponent {
+	due := user.ClaimFeesDue()
+	if len(due) == 0 {
+		return nil
+	}
+	if !web.Enabled() {
+		fmt.Fprintf(buf, "\n%d server(s) charge a claim fee, which cannot be paid on this bot right now.", len(due))
+		return nil
+	}
+
+	fmt.Fprintf(buf, "\nThese servers charge a claim fee. Pay it with your wallet and your rewards are sent to %s once it confirms:\n", address)
+	var buttons []discordgo.MessageComponent
+	for guildID := range due {
+		config := cv.LoadConfig(string(guildID))
+		req, err := cv.NewClaimFeeRequest(user.ID, config, address)
+		if err != nil {
+			fmt.Fprintf(buf, "- %s: %s\n", valOr(config.Name, string(guildID)), err.Error())
+			continue
+		}
+		fmt.Fprintf(buf, "- %s: %.6f ADA\n", valOr(config.Name, string(guildID)), float64(config.ClaimFee)/1_000_000)
+		buttons = append(buttons, discordgo.Button{
+			Label: "Pay " + valOr(config.Name, string(guildID)),
+			Style: discordgo.LinkButton,
+			URL:   web.SigningLink(req.Token),
+		})
+	}
+
+	var rows []discordgo.MessageComponent
+	for len(buttons) > 0 {
+		n := min(len(buttons), 5)
+		rows = append(rows, discordgo.ActionsRow{Components: buttons[:n]})
+		buttons = buttons[n:]
+	}
+	return rows
+}
+
+// onClaimFeeTxEvent harvests the farm's rewards once the user's claim fee is
+// confirmed.
+func onClaimFeeTxEvent(s *discordgo.Session) func(confirm.Event) {
+	return func(e confirm.Event) {
+		if e.Tx.Purpose != confirm.PurposeClaimFee {
+			return
+		}
+		req, err := cv.LoadSigningRequest(e.Tx.Reference)
+		if err != nil {
+			logger.Record.Warn("CLAIM FEE", "TX", e.Tx.TxHash, "ERROR", err)
+			return
+		}
+
+		switch e.Status {
+		case confirm.StatusConfirmed:
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+
+			h, err := cv.LoadUser(req.UserID).HarvestGuild(ctx, req.GuildID, req.Address)
+			if err != nil {
+				logger.Record.Error("CLAIM FEE", "TX", e.Tx.TxHash, "USER", req.UserID, "GUILD", req.GuildID, "HARVEST", err)
+				sendDM(s, req.UserID, fmt.Sprintf("⚠️ Your claim fee `%s` is confirmed but the harvest failed: %s. Please contact the server admins.", e.Tx.TxHash, err.Error()))
+				return
+			}
+			if h.Status == cv.HarvestAwaiting {
+				if p, err := cv.LoadMultisigTx(h.TxHash); err == nil {
+					postMultisigApproval(s, p)
+				}
+				sendDM(s, req.UserID, fmt.Sprintf("✅ Your claim fee is confirmed. The harvest `%s` is a large payout and is waiting for admin approval.", h.TxHash))
+				return
+			}
+			sendDM(s, req.UserID, fmt.Sprintf("✅ Your claim fee is confirmed and your rewards are on their way in `%s`.", h.TxHash))
+
+		case confirm.StatusDropped:
+			sendDM(s, req.UserID, fmt.Sprintf("⚠️ Your claim fee tx `%s` did not make it on-chain, so nothing was charged. Please /harvest again.", e.Tx.TxHash))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var CHECK_ELIGIBILITY_COMMAND = discordgo.ApplicationCommand{
+	Name:        "check-eligibility",
+	Description: "See which reward conditions you meet on this server.",
+	Options: []*discordgo.ApplicationCommandOption{{
+		Type:        discordgo.ApplicationCommandOptionString,
+		Name:        "reward",
+		Description: "Only check this reward",
+		Required:    false,
+		MaxLength:   25,
+	}},
+}
+
+var CHECK_ELIGIBILITY_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := GetOptions(i)
+	name := ""
+	if opt, ok := options["reward"]; ok {
+		name = strings.TrimSpace(opt.StringValue())
+	}
+
+	config := cv.LoadConfig(i.GuildID)
+	var rewards []cv.Reward
+	for _, reward := range config.Rewards {
+		if name == "" || strings.EqualFold(reward.Name, name) {
+			rewards = append(rewards, reward)
+		}
+	}
+	if len(rewards) == 0 {
+		respondError(s, i, "There are no rewards to check on this server.")
+		return
+	}
+
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	user := cv.LoadUser(i.Member.User.ID)
+	user.ID = i.Member.User.ID
+	owners := cv.WalletOwners()
+	holdings := holderHoldings(ctx, cv.Users{user}, owners, logger.Record.WithGroup("CHECK ELIGIBILITY"))
+	facts := newRuleFacts(ctx, owners, holdings)
+
+	var embeds []*discordgo.MessageEmbed
+	size := 0
+	for _, reward := range rewards {
+		var lines []string
+		multiplier := 1.0
+		eligible := false
+		for _, rule := range reward.Eligibility() {
+			result := rule.Evaluate(facts.For(ctx, i.Member, user, rule))
+			formatRuleResult(result, 0, &lines)
+			if result.Pass {
+				eligible = true
+				multiplier = result.Multiplier
+			}
+		}
+		if len(lines) == 0 {
+			lines = append(lines, "This reward has no conditions set up yet.")
+		}
+
+		status := "❌ You are not eligible yet."
+		if eligible {
+			status = "✅ You are eligible."
+			if multiplier != 1 {
+				status += fmt.Sprintf(" Payouts are multiplied by **%g**.", multiplier)
+			}
+		}
+		if reward.MinHoldDays > 0 {
+			status += fmt.Sprintf("\nHolder payouts also need the assets held for %d days in a row, see `/dashboard`.", reward.MinHoldDays)
+		}
+
+		// all embeds of a message share a 6000 character limit
+		description := strings.Join(lines, "\n")
+		if cut := strings.LastIndex(description[:min(len(description), 1500)], "\n"); len(description) > 1500 && cut > 0 {
+			description = description[:cut] + "\n…"
+		}
+		embed := &discordgo.MessageEmbed{
+			Title:       "🌾 " + reward.Name,
+			Description: status + "\n\n" + description,
+			Color:       0x3aa657,
+		}
+		size += len(embed.Title) + len(embed.Description)
+		if size > 5500 || len(embeds) == 10 {
+			embeds[len(embeds)-1].Footer = &discordgo.MessageEmbedFooter{Text: "More rewards left out, check one with the reward option."}
+			break
+		}
+		embeds = append(embeds, embed)
+	}
+
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds: &embeds,
+	})
+}
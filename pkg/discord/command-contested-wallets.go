@@ -0,0 +1,25 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+var CONTESTED_WALLETS_COMMAND = discordgo.ApplicationCommand{
+	Name:                     "contested-wallets",
+	Description:              "Show wallets that more than one member tried to link.",
+	DefaultMemberPermissions: &ADMIN,
+}
+
+var CONTESTED_WALLETS_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+
+	embed := contestedWalletsEmbed(s, i.GuildID)
+	_, _ = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{embed},
+	})
+}
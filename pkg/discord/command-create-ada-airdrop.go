@@ -1,18 +1,20 @@
 package discord
 
 import (
-	"cardano-valley/pkg/koios"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 var CREATE_AIRDROP_COMMAND = discordgo.ApplicationCommand{
 	Name:        "create-airdrop",
-	Description: "Create a new ADA airdrop (file OR policy_id required, along with a minimum of 1 ada per holder).",
+	Description: "Create a new ADA airdrop (one holder source required, along with a minimum of 1 ada per holder).",
 	Options: []*discordgo.ApplicationCommandOption{
 		{
 			Type:        discordgo.ApplicationCommandOptionInteger,
@@ -23,45 +25,99 @@ var CREATE_AIRDROP_COMMAND = discordgo.ApplicationCommand{
 		{
 			Type:        discordgo.ApplicationCommandOptionAttachment,
 			Name:        "holders_file",
-			Description: "JSON file: [{\"address\":\"addr...\",\"quantity\":N}, ...]",
+			Description: "CSV/TSV (address,quantity) or JSON [{\"address\":\"addr... or $handle\",\"quantity\":N}]",
 			Required:    false,
 		},
 		{
 			Type:        discordgo.ApplicationCommandOptionString,
 			Name:        "policy_id",
-			Description: "Policy ID to fetch holders from chain",
+			Description: "One or more policy IDs (comma separated) to fetch holders from chain",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "policy_mode",
+			Description: "How multiple policy IDs are combined (default: union)",
+			Required:    false,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "Holders of any policy", Value: string(PolicyUnion)},
+				{Name: "Holders of every policy", Value: string(PolicyIntersection)},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "assets",
+			Description: "Asset fingerprints (asset1...) or units, comma separated",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionBoolean,
+			Name:        "registered_users",
+			Description: "Airdrop to every linked wallet of registered users in this server",
 			Required:    false,
 		},
 	},
 }
 
-var CREATE_AIRDROP_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	data := i.ApplicationCommandData()
-
-	var (
-		attachment *discordgo.MessageAttachment
-		policyID   string
-		adaPerAsset  float64
-		totalAda   uint64
-	)
-
-	for _, opt := range data.Options {
-		switch opt.Name {
-		case "holders_file":
-			attachmentID := opt.Value.(string)
-			attachment = i.ApplicationCommandData().Resolved.Attachments[attachmentID]
-		case "policy_id":
-			policyID = opt.StringValue()
-		case "total_ada":
-			totalAda = uint64(opt.IntValue())
+// holderSourceFromOptions picks the single holder source given to the command.
+func holderSourceFromOptions(i *discordgo.InteractionCreate) (HolderSource, error) {
+	var sources []HolderSource
+	options := GetOptions(i)
+
+	if opt, ok := options["holders_file"]; ok {
+		attachment := i.ApplicationCommandData().Resolved.Attachments[opt.Value.(string)]
+		if attachment != nil {
+			sources = append(sources, FileHolderSource{URL: attachment.URL, Filename: attachment.Filename})
+		}
+	}
+
+	if opt, ok := options["policy_id"]; ok {
+		mode := PolicyUnion
+		if m, ok := options["policy_mode"]; ok {
+			mode = PolicyMode(m.StringValue())
 		}
+		sources = append(sources, PolicyHolderSource{PolicyIDs: splitList(opt.StringValue()), Mode: mode})
+	}
+
+	if opt, ok := options["assets"]; ok {
+		sources = append(sources, AssetHolderSource{Assets: splitList(opt.StringValue())})
+	}
+
+	if opt, ok := options["registered_users"]; ok && opt.BoolValue() {
+		sources = append(sources, RegisteredUsersHolderSource{GuildID: i.GuildID})
+	}
+
+	switch len(sources) {
+	case 0:
+		return nil, errors.New("you must provide a holders file, policy_id, assets or registered_users")
+	case 1:
+		return sources[0], nil
+	default:
+		return nil, errors.New("please provide only one holder source per airdrop")
 	}
+}
+
+// splitList splits a comma or whitespace separated option value.
+func splitList(v string) []string {
+	return strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
+
+var CREATE_AIRDROP_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	totalAda := uint64(GetOptions(i)["total_ada"].IntValue())
 
-	if attachment == nil && policyID == "" {
-		respondError(s, i, "You must provide either a holders JSON file or a policy_id.")
+	source, err := holderSourceFromOptions(i)
+	if err != nil {
+		respondError(s, i, err.Error())
 		return
 	}
 
+	var policyID string
+	if p, ok := source.(PolicyHolderSource); ok {
+		policyID = strings.Join(p.PolicyIDs, ",")
+	}
+
 	// Respond immediately (ephemeral) while we process
 	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -72,54 +128,19 @@ var CREATE_AIRDROP_HANDLER = func(s *discordgo.Session, i *discordgo.Interaction
 	})
 
 	// 1) Load holders
-	var holders []Holder
-	var err error
-	if attachment != nil {
-		holders, err = loadHoldersFromAttachment(attachment.URL)
-		if err != nil {
-			followupError(s, i, "Failed to parse holders file. Make sure it follows this format: JSON file: [{\"address\":\"addr...\",\"quantity\":N}, ...] "+err.Error())
-			return
-		}
-	} else {
-		policyHolders, err := koios.GetPolicyHolders(policyID)
-		if err != nil {
-			followupError(s, i, "Failed to fetch holders by policy: "+err.Error())
-			return
-		}
-
-		for address, qty := range policyHolders {
-			holders = append(holders, Holder{
-				Address:  address,
-				Quantity: qty,
-			})
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	holders, holderErrs, err := source.Holders(ctx)
+	if err != nil {
+		followupError(s, i, fmt.Sprintf("Failed to load holders from %s: %s", source.Name(), err.Error()))
+		return
 	}
 	if len(holders) == 0 {
 		followupError(s, i, "No holders found.")
 		return
 	}
 
-	// Normalize: drop zero/neg qty and invalid addrs
-	totalAssets := uint64(0)
-	filtered := make([]Holder, 0, len(holders))
-	for _, h := range holders {
-		totalAssets += h.Quantity
-		if h.Quantity > 0 && strings.HasPrefix(h.Address, "addr") {
-			filtered = append(filtered, h)
-		}
-	}
-	holders = filtered
-
-	adaPerAsset = float64(totalAda) / float64(totalAssets)
-	filtered = make([]Holder, 0, len(holders))
-	for _, h := range holders {
-		if float64(h.Quantity) * adaPerAsset > 1.0 {
-			filtered = append(filtered, h)
-		}
-	}
-	skipped := len(holders) - len(filtered)
-	holders = filtered
-	
+	holders, totalAssets, adaPerAsset, skipped := prepareHolders(holders, totalAda)
 	if len(holders) == 0 {
 		followupError(s, i, "No holders with at least 1 ADA airdrop amount (after calculating per-Asset). Try increasing total_ada.")
 		return
@@ -167,12 +188,27 @@ var CREATE_AIRDROP_HANDLER = func(s *discordgo.Session, i *discordgo.Interaction
 			{Name: "Required ADA (incl. 5 ADA for tx fees)", Value: fmt.Sprintf("%.6f", float64(totalWithBuffer)/1_000_000.0), Inline: true},
 			{Name: "Service Fee", Value: "20 ADA", Inline: true},
 			{Name: "Deposit Address", Value: "```\n" + session.Address + "\n```", Inline: false},
+			{Name: "Holder Source", Value: source.Name(), Inline: false},
 			{Name: "Skipping Holders", Value: fmt.Sprintf("%d (less than 1 ADA each)", skipped), Inline: false},
 		},
 		Footer: &discordgo.MessageEmbedFooter{
 			Text: "We’ll watch this address until funded (no timeout).",
 		},
 	}
+	if resolved := formatHandleResolutions(holders, 10); resolved != "" {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   "Resolved ADA Handles",
+			Value:  "```\n" + resolved + "\n```",
+			Inline: false,
+		})
+	}
+	if len(holderErrs) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   fmt.Sprintf("Rejected Entries (%d)", len(holderErrs)),
+			Value:  "```\n" + formatHolderErrors(holderErrs, 10) + "\n```",
+			Inline: false,
+		})
+	}
 	_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 		Embeds: []*discordgo.MessageEmbed{embed},
 	})
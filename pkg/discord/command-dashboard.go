@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -83,9 +84,20 @@ var DASHBOARD_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreat
 		}
 	}
 
+	if streaks := holdingStreaks(user.ID, cv.LoadConfig(i.GuildID)); streaks != "" {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Holding Streaks", Value: streaks, Inline: false})
+	}
+
 	var wallets []string
-	for _, wallet := range user.LinkedWallets {
-		wallets = append(wallets, fmt.Sprintf("1. %s", cv.TruncateMiddle(wallet.Payment, 32)))
+	for _, wallet := range user.Wallets() {
+		line := fmt.Sprintf("1. %s", cv.TruncateMiddle(wallet.Payment, 32))
+		if wallet.Label != "" {
+			line = fmt.Sprintf("1. **%s** %s", wallet.Label, cv.TruncateMiddle(wallet.Payment, 32))
+		}
+		if wallet.Primary {
+			line += " ⭐"
+		}
+		wallets = append(wallets, line)
 	}
 	walletList := strings.Join(wallets, "\n")
 	fields = append(fields, &discordgo.MessageEmbedField{Name: fmt.Sprintf("Linked Wallets (%d)", len(wallets)), Value: walletList, Inline: false})
@@ -111,3 +123,71 @@ var DASHBOARD_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreat
 		},
 	})
 }
+
+// holdingStreaks lists, for each holder reward of the guild, how many daily
+// cycles in a row the user has held each eligible asset.
+func holdingStreaks(userID string, config cv.Config) string {
+	maxHoldDays := 30 // how far back streaks are shown for rewards without a hold period
+	for _, reward := range config.Rewards {
+		maxHoldDays = max(maxHoldDays, reward.MinHoldDays)
+	}
+	cycles := cv.LoadHoldingCycles(int64(maxHoldDays))
+	if len(cycles) == 0 {
+		return ""
+	}
+	history := cv.LoadHoldingHistory(userID, cycles[len(cycles)-1])
+
+	var lines []string
+	for _, reward := range config.Rewards {
+		for _, asset := range reward.AssetsEligible {
+			streak := history.Streak(cycles, asset, reward.AssetMinimum)
+			days := fmt.Sprint(streak)
+			if streak == maxHoldDays {
+				days += "+"
+			}
+			line := fmt.Sprintf("🔥 %s · %s: %s day(s)", reward.Name, assetName(asset), days)
+			if reward.MinHoldDays > 0 {
+				line = fmt.Sprintf("🔥 %s · %s: %d/%d days", reward.Name, assetName(asset), min(streak, reward.MinHoldDays), reward.MinHoldDays)
+				if streak >= reward.MinHoldDays {
+					line += " ✅"
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+	return fieldValue(lines)
+}
+
+// fieldValue joins lines into an embed field value, leaving out the lines
+// past Discord's 1024 character limit.
+func fieldValue(lines []string) string {
+	const limit = 1024
+	value := ""
+	for n, line := range lines {
+		if n > 0 {
+			line = "\n" + line
+		}
+		more := fmt.Sprintf("\n…and %d more", len(lines)-n-1)
+		if n == len(lines)-1 {
+			more = ""
+		}
+		if utf8.RuneCountInString(value+line+more) > limit {
+			if n == 0 {
+				return string([]rune(lines[0])[:limit-1]) + "…"
+			}
+			return value + fmt.Sprintf("\n…and %d more", len(lines)-n)
+		}
+		value += line
+	}
+	return value
+}
+
+// assetName is the readable name of a policy ID + hex name unit.
+func assetName(unit string) string {
+	if len(unit) > 56 {
+		if name, err := hex.DecodeString(unit[56:]); err == nil {
+			return string(name)
+		}
+	}
+	return cv.TruncateMiddle(unit, 16)
+}
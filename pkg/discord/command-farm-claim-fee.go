@@ -0,0 +1,65 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/web"
+	"fmt"
+	"math"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// the fee is an output of its own, so it has to be at least a min-UTxO
+const minClaimFee = 1_000_000
+
+var FARM_CLAIM_FEE_COMMAND = discordgo.ApplicationCommand{
+	Name:                     "farm-claim-fee",
+	Description:              "Charge users a fee, paid from their own wallet, to harvest your farm's rewards.",
+	DefaultMemberPermissions: &ADMIN,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionNumber,
+			Name:        "ada",
+			Description: "The fee in ADA, at least 1. Use 0 to stop charging.",
+			Required:    true,
+			MinValue:    new(float64),
+		},
+	},
+}
+
+var FARM_CLAIM_FEE_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := GetOptions(i)
+
+	config := cv.LoadConfig(i.GuildID)
+	if config.Wallet.Address == "" {
+		respondError(s, i, "This server has no farm wallet yet, run /build-farm first.")
+		return
+	}
+
+	fee := uint64(math.Round(options["ada"].FloatValue() * 1_000_000))
+	if fee > 0 && fee < minClaimFee {
+		respondError(s, i, "The fee has to be at least 1 ADA.")
+		return
+	}
+	if fee > 0 && !web.Enabled() {
+		respondError(s, i, "Claim fees are signed on the bot's web page, which is not set up on this bot.")
+		return
+	}
+
+	config.ClaimFee = fee
+	config.Save()
+	logger.Record.Info("CLAIM FEE", "GUILD", i.GuildID, "LOVELACE", fee)
+
+	content := "✅ Harvesting your farm's rewards is free again."
+	if fee > 0 {
+		content = fmt.Sprintf("✅ Users now pay %.6f ADA from their own wallet to harvest. It goes to the farm wallet.", float64(fee)/1_000_000)
+	}
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: content,
+		},
+	})
+}
@@ -0,0 +1,131 @@
+package discord
+
+import (
+	"cardano-valley/pkg/blockfrost"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var FARM_DELEGATE_COMMAND = discordgo.ApplicationCommand{
+	Name:                     "farm-delegate",
+	Description:              "Show or change the stake pool your farm wallet delegates to.",
+	DefaultMemberPermissions: &ADMIN,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "pool_id",
+			Description: "Pool ID (pool1...) to delegate to. Leave empty to see the current delegation.",
+			Required:    false,
+		},
+	},
+}
+
+var FARM_DELEGATE_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := GetOptions(i)
+
+	config := cv.LoadConfig(i.GuildID)
+	if config.Wallet.Address == "" {
+		respondError(s, i, "This server has no farm wallet yet, run /build-farm first.")
+		return
+	}
+
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: "Checking your farm's stake…",
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	description := "Your farm wallet's stake and the rewards it has earned."
+	if opt, ok := options["pool_id"]; ok {
+		delegation, err := cv.DelegateWallet(ctx, config.Wallet, opt.StringValue(), farmDelegationReference(i.GuildID))
+		switch {
+		case errors.Is(err, cv.ErrAlreadyDelegated):
+			description = "Your farm wallet already delegates to this pool."
+		case err != nil:
+			logger.Record.Error("DELEGATION", "GUILD", i.GuildID, "POOL", opt.StringValue(), "ERROR", err)
+			followupError(s, i, "Delegation failed: "+err.Error())
+			return
+		default:
+			config.Delegation = *delegation
+			config.Save()
+			logger.Record.Info("DELEGATION", "GUILD", i.GuildID, "POOL", delegation.PoolID, "TX", delegation.TxHash)
+			description = fmt.Sprintf("Delegation submitted in `%s`. It takes effect once the tx is confirmed, and rewards start about two epochs later.", delegation.TxHash)
+			if delegation.Registered {
+				description += fmt.Sprintf("\nThe stake address was registered too, locking a %.6f ADA deposit.", float64(cardano.Params.StakeAddressDeposit)/1_000_000)
+			}
+		}
+	}
+
+	_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{stakeEmbed(ctx, config, description)},
+	})
+}
+
+// stakeEmbed shows the farm wallet's current delegation and rewards as
+// Blockfrost reports them.
+func stakeEmbed(ctx context.Context, config cv.Config, description string) *discordgo.MessageEmbed {
+	stakeAddress, err := config.Wallet.StakeAddress()
+	if err != nil {
+		stakeAddress = ""
+	}
+
+	pool, registered := "—", "No"
+	controlled, withdrawable, rewards := "—", "—", "—"
+	if stakeAddress != "" {
+		account := blockfrost.GetStakeInfo(ctx, stakeAddress)
+		if account.Active {
+			registered = "Yes"
+		}
+		if account.PoolID != nil {
+			pool = *account.PoolID
+			if meta, err := blockfrost.GetPoolMetaData(ctx, pool); err == nil && meta.Ticker != nil {
+				pool = fmt.Sprintf("[%s] %s", *meta.Ticker, pool)
+			}
+		}
+		controlled = formatADA(account.ControlledAmount)
+		withdrawable = formatADA(account.WithdrawableAmount)
+		rewards = formatADA(account.RewardsSum)
+	}
+
+	pending := "—"
+	if config.Delegation.TxHash != "" {
+		pending = fmt.Sprintf("%s in `%s`", config.Delegation.PoolID, config.Delegation.TxHash)
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Farm Delegation",
+		Description: description,
+		Color:       0x3aa657,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Stake Address", Value: "```\n" + valOr(stakeAddress, "—") + "\n```", Inline: false},
+			{Name: "Delegated To", Value: pool, Inline: false},
+			{Name: "Last Submitted", Value: pending, Inline: false},
+			{Name: "Registered", Value: registered, Inline: true},
+			{Name: "Controlled ADA", Value: controlled, Inline: true},
+			{Name: "Withdrawable Rewards", Value: withdrawable, Inline: true},
+			{Name: "Total Rewards", Value: rewards, Inline: true},
+		},
+	}
+}
+
+// formatADA renders a Blockfrost lovelace string as ADA.
+func formatADA(lovelace string) string {
+	n, err := strconv.ParseUint(lovelace, 10, 64)
+	if err != nil {
+		return "—"
+	}
+	return fmt.Sprintf("%.6f", float64(n)/1_000_000)
+}
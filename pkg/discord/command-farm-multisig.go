@@ -0,0 +1,350 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var FARM_MULTISIG_COMMAND = discordgo.ApplicationCommand{
+	Name:                     "farm-multisig",
+	Description:              "Require admin co-signatures for large payouts from the farm.",
+	DefaultMemberPermissions: &ADMIN,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "signer",
+			Description: "Register the wallet you will co-sign with",
+			Options: []*discordgo.ApplicationCommandOption{{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "address",
+				Description: "A payment address of your wallet, its key signs for you",
+				Required:    true,
+				MaxLength:   255,
+			}},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "setup",
+			Description: "Create the multisig address from the registered signers",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "required",
+					Description: "How many admins have to sign, on top of the bot",
+					Required:    true,
+					MinValue:    &integerOne,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "threshold",
+					Description: "Payouts of this much ADA, in lovelace, need approval",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "asset-threshold",
+			Description: "Let smaller payouts of a token skip approval, every payout of it needs approval otherwise",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "asset",
+					Description: "The token as policyid.assetname, with the asset name in hex",
+					Required:    true,
+					MaxLength:   121,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "threshold",
+					Description: "Payouts of this many tokens need approval",
+					Required:    true,
+					MinValue:    &integerOne,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "status",
+			Description: "Show the multisig address, signers and pending approvals",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "witness",
+			Description: "Upload a witness file for a pending transaction",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "tx",
+					Description: "The transaction hash",
+					Required:    true,
+					MinLength:   &txHashLength,
+					MaxLength:   64,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionAttachment,
+					Name:        "file",
+					Description: "The witness from `cardano-cli transaction witness` or your wallet",
+					Required:    true,
+				},
+			},
+		},
+	},
+}
+
+var (
+	integerOne   = 1.0
+	txHashLength = 64
+)
+
+var FARM_MULTISIG_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	sub := i.ApplicationCommandData().Options[0]
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(sub.Options))
+	for _, opt := range sub.Options {
+		options[opt.Name] = opt
+	}
+
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: "Working on it…",
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	config := cv.LoadConfig(i.GuildID)
+	switch sub.Name {
+	case "signer":
+		address := strings.TrimSpace(options["address"].StringValue())
+		if err := config.Multisig.AddSigner(i.Member.User.ID, address); err != nil {
+			followupError(s, i, "That address has no payment key: "+err.Error())
+			return
+		}
+		config.Save()
+		note := ""
+		if config.Multisig.Enabled() {
+			note = "\nThe multisig address keeps the key it was set up with, run `/farm-multisig setup` again for the new key to count."
+		}
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("✅ <@%s> will co-sign with the key of `%s`.%s", i.Member.User.ID, address, note),
+		})
+
+	case "setup":
+		required := int(options["required"].IntValue())
+		threshold := options["threshold"].IntValue()
+		if threshold < 0 {
+			followupError(s, i, "The threshold cannot be negative.")
+			return
+		}
+		if err := config.SetupMultisig(ctx, required, uint64(threshold), i.ChannelID); err != nil {
+			followupError(s, i, err.Error())
+			return
+		}
+		config.Save()
+		logger.Record.Info("MULTISIG", "GUILD", i.GuildID, "ADDRESS", config.Multisig.Address, "REQUIRED", required, "SIGNERS", len(config.Multisig.Signers))
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Embeds: []*discordgo.MessageEmbed{multisigStatusEmbed(i.GuildID, config.Multisig)},
+		})
+
+	case "asset-threshold":
+		asset := strings.TrimSpace(options["asset"].StringValue())
+		policy, name, ok := strings.Cut(asset, ".")
+		if _, err := hex.DecodeString(policy + name); !ok || len(policy) != 56 || err != nil {
+			followupError(s, i, "The asset has to be policyid.assetname, with the asset name in hex.")
+			return
+		}
+		threshold := uint64(options["threshold"].IntValue())
+		config.Multisig.SetAssetThreshold(cv.Asset(asset), threshold)
+		config.Save()
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: fmt.Sprintf("✅ Payouts of %d or more `%s` need approval.", threshold, asset),
+		})
+
+	case "status":
+		if len(config.Multisig.Signers) == 0 {
+			followupError(s, i, "No signers yet. Each co-signing admin runs `/farm-multisig signer` first.")
+			return
+		}
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Embeds: []*discordgo.MessageEmbed{multisigStatusEmbed(i.GuildID, config.Multisig)},
+		})
+
+	case "witness":
+		txHash := strings.TrimSpace(options["tx"].StringValue())
+		attachment := i.ApplicationCommandData().Resolved.Attachments[options["file"].Value.(string)]
+		if attachment == nil {
+			followupError(s, i, "Please attach the witness file.")
+			return
+		}
+		witness, err := downloadAttachment(ctx, attachment.URL)
+		if err != nil {
+			followupError(s, i, "Could not read the file: "+err.Error())
+			return
+		}
+		_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+			Content: addMultisigWitness(ctx, s, i.GuildID, txHash, i.Member.User.ID, string(witness)),
+		})
+	}
+}
+
+// ─── Approval buttons ───
+
+var (
+	MULTISIG_SIGN_COMPONENT_NAME   = "multisig-sign"
+	MULTISIG_TX_COMPONENT_NAME     = "multisig-tx"
+	MULTISIG_REJECT_COMPONENT_NAME = "multisig-reject"
+	MULTISIG_WITNESS_MODAL_NAME    = "multisig-witness"
+)
+
+// MULTISIG_SIGN_COMPONENT_HANDLER asks for the witness a CIP-30 wallet or
+// cardano-cli produced.
+var MULTISIG_SIGN_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+	txHash := strings.TrimPrefix(data.CustomID, MULTISIG_SIGN_COMPONENT_NAME+"_")
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: MULTISIG_WITNESS_MODAL_NAME + "_" + txHash,
+			Title:    "Co-sign Transaction",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "witness",
+							Label:       "Witness (wallet signTx hex or witness file)",
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: "a10081825820… or {\"type\": \"TxWitness ConwayEra\", …}",
+							Required:    true,
+							MaxLength:   4000,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+var MULTISIG_WITNESS_MODAL_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ModalSubmitInteractionData) {
+	txHash := strings.TrimPrefix(data.CustomID, MULTISIG_WITNESS_MODAL_NAME+"_")
+	witness := data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
+
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: "Checking your witness…",
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Content: addMultisigWitness(ctx, s, i.GuildID, txHash, i.Member.User.ID, witness),
+	})
+}
+
+// MULTISIG_TX_COMPONENT_HANDLER hands out the transaction to sign offline or
+// load into a wallet.
+var MULTISIG_TX_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+	txHash := strings.TrimPrefix(data.CustomID, MULTISIG_TX_COMPONENT_NAME+"_")
+	p, err := cv.LoadMultisigTx(txHash)
+	if err != nil || string(p.GuildID) != i.GuildID {
+		respondError(s, i, "That transaction was not found.")
+		return
+	}
+	tx, err := p.Tx()
+	if err != nil {
+		respondError(s, i, err.Error())
+		return
+	}
+
+	content := "Sign with your wallet's `signTx` (partial signing), or with cardano-cli:\n" +
+		"```\ncardano-cli conway transaction witness --tx-file " + txHash[:8] + ".signed --signing-key-file payment.skey --out-file " + txHash[:8] + ".witness\n```" +
+		"Then press **Sign** and paste it, or `/farm-multisig witness`."
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: content,
+			Files: []*discordgo.File{{
+				Name:        txHash[:8] + ".signed",
+				ContentType: "application/json",
+				Reader:      strings.NewReader(tx.Envelope()),
+			}},
+		},
+	})
+}
+
+var MULTISIG_REJECT_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+	txHash := strings.TrimPrefix(data.CustomID, MULTISIG_REJECT_COMPONENT_NAME+"_")
+	p, err := cv.LoadMultisigTx(txHash)
+	if err != nil || string(p.GuildID) != i.GuildID {
+		respondError(s, i, "That transaction was not found.")
+		return
+	}
+	closed, err := p.Reject(i.Member.User.ID)
+	if err != nil {
+		respondError(s, i, err.Error())
+		return
+	}
+
+	content := "Your rejection is recorded."
+	if closed {
+		content = "The transaction can no longer reach enough signatures and was cancelled."
+		notifyMultisigClosed(s, p)
+	}
+	refreshMultisigMessage(s, p)
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: content,
+		},
+	})
+}
+
+// addMultisigWitness adds an admin's witness and describes the outcome.
+func addMultisigWitness(ctx context.Context, s *discordgo.Session, guildID, txHash, userID, witness string) string {
+	p, err := cv.LoadMultisigTx(txHash)
+	if err != nil || string(p.GuildID) != guildID {
+		return "❌ That transaction was not found."
+	}
+	submitted, err := p.AddWitness(ctx, userID, witness)
+	if err != nil {
+		if errors.Is(err, cv.ErrNotSigner) || errors.Is(err, cv.ErrMultisigClosed) {
+			return "❌ " + err.Error()
+		}
+		logger.Record.Warn("MULTISIG", "TX", txHash, "USER", userID, "ERROR", err)
+		return "❌ " + err.Error()
+	}
+	refreshMultisigMessage(s, p)
+	if submitted {
+		return fmt.Sprintf("✅ Signed. That was the last signature needed, the transaction `%s` was submitted.", txHash)
+	}
+	return "✅ Signed. Waiting for the other admins."
+}
+
+func downloadAttachment(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
@@ -2,9 +2,12 @@ package discord
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"cardano-valley/pkg/confirm"
 	"cardano-valley/pkg/cv"
 	"cardano-valley/pkg/logger"
 
@@ -30,15 +33,8 @@ var WITHDRAW_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	user := cv.LoadUser(i.Member.User.ID)
-    if user.Wallet.PaymentKey == "" {
-		s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-			Content: "Error: You have not registered yet. Please /register first.",
-			Flags:   discordgo.MessageFlagsEphemeral,
-		})
-		return
-	}
-
+	// rewards are paid from the farm wallets straight to a linked wallet, no
+	// /register wallet is needed
 	// var withdrawalAddress string
 	// if to != "" {
 	// 	valid := blockfrost.VerifyAddress(ctx, to)
@@ -52,7 +48,7 @@ var WITHDRAW_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate
 
 	// 	withdrawalAddress = to
 	// } else {
-	linkedWallets := cv.LoadUser(i.Member.User.ID).LinkedWallets
+	linkedWallets := cv.LoadUser(i.Member.User.ID).Wallets()
 	if len(linkedWallets) == 0 {
 		s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 			Content: "You need to provide an address to harvest your rewards. If you want to use your linked wallet, please use the `/harvest` command with the address option. Or you can link a new wallet using the `/link-wallet` command.",
@@ -65,10 +61,19 @@ var WITHDRAW_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate
 
 	options := []discordgo.SelectMenuOption{}
 	for _, wallet := range linkedWallets {
+		description := "Harvest your rewards to this wallet"
+		if wallet.Label != "" {
+			description = cv.TruncateMiddle(wallet.Payment, 50)
+		}
+		// the primary is listed first, it cannot be preselected as picking a
+		// default option sends no interaction
+		if wallet.Primary {
+			description = "Primary · " + description
+		}
 		options = append(options, discordgo.SelectMenuOption{
-			Label:       cv.TruncateMiddle(wallet.Payment, 32),
+			Label:       wallet.Name(),
 			Value:       cv.TruncateMiddle(wallet.Payment, 32),
-			Description: "Harvest your rewards to this wallet",
+			Description: description,
 			//addr1q8ur464mlqsqslh0dn9dqg88zn0q0sqag2hkxc0vhtrn5c7wkhumlr876ehcm8ltdwt7s49mwxfw47c4hcf5p6qdlavqaawfcs
 		})
 		logger.Record.Info("WITHDRAW_HANDLER options", "payment", cv.TruncateMiddle(wallet.Payment, 32))
@@ -118,21 +123,78 @@ var WITHDRAW_COMMAND_OPTIONLIST_HANDLER = func(s *discordgo.Session, i *discordg
 		if strings.HasSuffix(v.Payment, suffix) {
 			logger.Record.Info("WITHDRAW_COMMAND_OPTIONLIST_HANDLER found wallet", "wallet", v.Payment)
 			// Call the harvest function with the selected wallet
-			err := user.HarvestRewards(v.Payment)
-			if err != nil {
+			harvests, err := user.HarvestRewards(context.Background(), v.Payment)
+			if errors.Is(err, cv.ErrNothingToHarvest) {
+				s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+					Content: "You have no rewards to harvest yet.",
+					Flags:   discordgo.MessageFlagsEphemeral,
+				})
+				return
+			}
+			if err != nil && len(harvests) == 0 {
 				s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
 					Content: fmt.Sprintf("Error harvesting rewards: %s", err.Error()),
 					Flags:   discordgo.MessageFlagsEphemeral,
 				})
 				return
 			}
+
+			var buf strings.Builder
+			if len(harvests) > 0 {
+				fmt.Fprintf(&buf, "Harvest submitted to %s! You will get a DM if a transaction does not go through.\n", v.Payment)
+			}
+			for _, h := range harvests {
+				if h.Status == cv.HarvestAwaiting {
+					if p, err := cv.LoadMultisigTx(h.TxHash); err == nil {
+						postMultisigApproval(s, p)
+					}
+					fmt.Fprintf(&buf, "- TX: %s (large payout, awaiting admin approval)\n", h.TxHash)
+					continue
+				}
+				fmt.Fprintf(&buf, "- TX: %s\n", h.TxHash)
+			}
+			if err != nil {
+				fmt.Fprintf(&buf, "\nSome rewards could not be harvested and are still in your balance: %s", err.Error())
+			}
+			links := claimFeeLinks(user, v.Payment, &buf)
 			s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
-				Content: fmt.Sprintf("Successfully harvested rewards to %s!", v.Payment),
-				Flags:   discordgo.MessageFlagsEphemeral,
+				Content:    buf.String(),
+				Flags:      discordgo.MessageFlagsEphemeral,
+				Components: links,
 			})
 
 			break
 		}
 		
 	}
-}
\ No newline at end of file
+}
+
+// onHarvestTxEvent settles harvests once their tx is confirmed and credits
+// the rewards back when it is dropped.
+func onHarvestTxEvent(s *discordgo.Session) func(confirm.Event) {
+	return func(e confirm.Event) {
+		if e.Tx.Purpose != confirm.PurposeHarvest {
+			return
+		}
+
+		switch e.Status {
+		case confirm.StatusConfirmed:
+			h, err := cv.LoadHarvest(e.Tx.TxHash)
+			if err != nil {
+				logger.Record.Warn("HARVEST", "TX", e.Tx.TxHash, "ERROR", err)
+				return
+			}
+			h.Status = cv.HarvestConfirmed
+			h.UpdatedAt = time.Now().UTC()
+			h.Save()
+
+		case confirm.StatusDropped:
+			h, err := cv.ReturnHarvest(e.Tx.TxHash)
+			if err != nil {
+				logger.Record.Error("HARVEST", "TX", e.Tx.TxHash, "RETURN", err)
+				return
+			}
+			sendDM(s, h.UserID, fmt.Sprintf("⚠️ Your harvest tx `%s` did not make it on-chain. The rewards are back in your balance, please /harvest again.", e.Tx.TxHash))
+		}
+	}
+}
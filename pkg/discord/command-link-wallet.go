@@ -2,16 +2,17 @@ package discord
 
 import (
 	"cardano-valley/pkg/blockfrost"
+	"cardano-valley/pkg/cardano"
 	"cardano-valley/pkg/cv"
 	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/web"
 	"context"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
-	"strconv"
 	"strings"
 	"time"
 
+	bfg "github.com/blockfrost/blockfrost-go"
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -24,24 +25,156 @@ var (
 
 	LINK_WALLET_MODAL_NAME = "link-wallet"
 	
+	LINK_WALLET_SIGN_COMPONENT_NAME  = "link-wallet-sign"
+	LINK_WALLET_SEND_COMPONENT_NAME  = "link-wallet-send"
+	LINK_WALLET_SIGNATURE_MODAL_NAME = "link-wallet-signature"
+
+	// LINK_WALLET_HANDLER offers the ways to prove the wallet is yours: signing
+	// a message (CIP-8), in the browser or by pasting the signature, or sending
+	// ADA to yourself for wallets that cannot sign messages.
 	LINK_WALLET_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
-		amount := rand.Intn(1_000)
-		linkAmount := fmt.Sprintf("1%s", strconv.Itoa(amount))
-		linkAmountDisplay := fmt.Sprintf("1.%s", strconv.Itoa(amount)) // strconv.FormatFloat(1.0 + (float64(amount) / float64(1000000)), 'f', -1, 64)
+		challenge, err := cv.NewLinkChallenge(i.Member.User.ID)
+		if err != nil {
+			respondError(s, i, "Could not start linking: "+err.Error())
+			return
+		}
+
+		var buttons []discordgo.MessageComponent
+		if web.Enabled() {
+			buttons = append(buttons, discordgo.Button{
+				Label: "Sign in Browser",
+				Style: discordgo.LinkButton,
+				URL:   web.LinkWalletLink(challenge.Token),
+			})
+		}
+		buttons = append(buttons,
+			discordgo.Button{
+				Label:    "Paste Signature",
+				Style:    discordgo.PrimaryButton,
+				CustomID: LINK_WALLET_SIGN_COMPONENT_NAME + "_" + challenge.Token,
+			},
+			discordgo.Button{
+				Label:    "Send ADA to Myself",
+				Style:    discordgo.SecondaryButton,
+				CustomID: LINK_WALLET_SEND_COMPONENT_NAME + "_" + i.Member.User.ID,
+			},
+		)
+
+		content := "**Link your wallet** by signing this message with it. Signing is free and moves no funds.\n" +
+			"```\n" + challenge.Message() + "\n```" +
+			"Sign it in the browser, or sign it with your wallet's *sign data* feature and paste the result. " +
+			"If your wallet cannot sign messages, send yourself a small amount of ADA instead.\n" +
+			"Once an address is linked, signing with the wallet's stake address adds all of its addresses."
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:   discordgo.MessageFlagsEphemeral,
+				Content: content,
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{Components: buttons},
+				},
+			},
+		})
+
+		logger.Record.Info("LINK WALLET", "USER", i.Member.User.ID, "CHALLENGE", challenge.Token)
+	}
 
+	LINK_WALLET_SIGN_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+		token := strings.TrimPrefix(data.CustomID, LINK_WALLET_SIGN_COMPONENT_NAME+"_")
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseModal,
 			Data: &discordgo.InteractionResponseData{
-				CustomID: fmt.Sprintf("%s_%s_%s", LINK_WALLET_MODAL_NAME, i.Interaction.Member.User.ID, linkAmount),
+				CustomID: LINK_WALLET_SIGNATURE_MODAL_NAME + "_" + token,
+				Title:    "Paste Your Signature",
+				Components: []discordgo.MessageComponent{
+					discordgo.ActionsRow{
+						Components: []discordgo.MessageComponent{
+							discordgo.TextInput{
+								CustomID:    "signature",
+								Label:       "signData result (signature and key)",
+								Style:       discordgo.TextInputParagraph,
+								Placeholder: "{\"signature\": \"845846a2…\", \"key\": \"a4010103…\"}",
+								Required:    true,
+								MaxLength:   4000,
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	LINK_WALLET_SIGNATURE_MODAL_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ModalSubmitInteractionData) {
+		token := strings.TrimPrefix(data.CustomID, LINK_WALLET_SIGNATURE_MODAL_NAME+"_")
+		text := data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value
+
+		challenge, err := cv.LoadLinkChallenge(token)
+		if err != nil || challenge.UserID != i.Member.User.ID {
+			respondError(s, i, "This link request was not found, please run /link-wallet again.")
+			return
+		}
+		signed, err := cardano.ParseSignedData(text)
+		if err != nil {
+			respondError(s, i, err.Error())
+			return
+		}
+
+		// claiming the wallet can outlast the 3 seconds Discord waits
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags: discordgo.MessageFlagsEphemeral,
+			},
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		wallet, added, err := challenge.VerifySignature(signed)
+		var content string
+		switch {
+		case err != nil:
+			logger.Record.Warn("LINK WALLET", "USER", i.Member.User.ID, "ERROR", err)
+			content = "❌ " + err.Error()
+		case !added:
+			content = "This wallet was already linked."
+		default:
+			logger.Record.Info("LINK WALLET", "USER", i.Member.User.ID, "ADDRESS", wallet.Payment, "METHOD", "cip8")
+			content = "Your wallet has been linked successfully! " + valOr(strings.Join(adaHandles(ctx, wallet.Payment), ", "), wallet.Payment)
+		}
+		if err == nil {
+			content += "\n**Linked Wallets**\n"
+			for _, w := range cv.LoadUser(i.Member.User.ID).Wallets() {
+				content += fmt.Sprintf("1. %s\n", valOr(w.Label, w.Payment))
+			}
+		}
+		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content: &content,
+		})
+	}
+
+	// LINK_WALLET_SEND_COMPONENT_HANDLER asks for a self-send of a random
+	// amount, for wallets that cannot sign messages.
+	LINK_WALLET_SEND_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+		challenge, err := cv.NewSelfSendChallenge(i.Member.User.ID)
+		if err != nil {
+			respondError(s, i, "Could not start linking: "+err.Error())
+			return
+		}
+		amount := fmt.Sprintf("%.6f", float64(challenge.Lovelace)/1_000_000)
+
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseModal,
+			Data: &discordgo.InteractionResponseData{
+				CustomID: LINK_WALLET_MODAL_NAME + "_" + challenge.Token,
 				Title:    "Link Your Wallet",
 				Components: []discordgo.MessageComponent{
 					discordgo.ActionsRow{
 						Components: []discordgo.MessageComponent{
 							discordgo.TextInput{
 								CustomID:    "tx_id",
-								Label:       fmt.Sprintf("Send %s ADA to yourself, then paste tx ID", linkAmountDisplay),
+								Label:       fmt.Sprintf("Send exactly %s ADA to yourself", amount),
 								Style:       discordgo.TextInputShort,
-								Placeholder: fmt.Sprintf("After sending %s ADA to yourself, paste your tx ID here", linkAmountDisplay),
+								Placeholder: fmt.Sprintf("After sending %s ADA to yourself, paste your tx ID here", amount),
 								Required:    true,
 								MaxLength:   64,
 								MinLength:   64,
@@ -52,103 +185,80 @@ var (
 			},
 		})
 
-		logger.Record.Info(fmt.Sprintf("User %s initiated wallet linking with amount %s", i.Interaction.Member.User.ID, linkAmount))
+		logger.Record.Info("LINK WALLET", "USER", i.Member.User.ID, "CHALLENGE", challenge.Token, "LOVELACE", challenge.Lovelace)
 	}
 
+	// LINK_WALLET_MODAL_HANDLER waits for the self-send to reach the chain and
+	// links the wallet it came from.
 	LINK_WALLET_MODAL_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ModalSubmitInteractionData) {
-		txID := data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value // Assuming the tx ID is the first option
-		logger.Record.Info(fmt.Sprintf("User %s submitted wallet linking with tx ID: %s", i.Interaction.Member.User.ID, txID))
-		// Acknowledge the interaction
+		token := strings.TrimPrefix(data.CustomID, LINK_WALLET_MODAL_NAME+"_")
+		txID := strings.ToLower(strings.TrimSpace(data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value))
+
+		challenge, err := cv.LoadLinkChallenge(token)
+		if err != nil || challenge.UserID != i.Member.User.ID {
+			respondError(s, i, "This link request was not found, please run /link-wallet again.")
+			return
+		}
+		logger.Record.Info("LINK WALLET", "USER", i.Member.User.ID, "CHALLENGE", token, "TX", txID)
+
 		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
-			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
-				Content: "Checking the transaction ID you provided. This may take a minute...",
+				Content: fmt.Sprintf("Waiting for `%s` to reach the chain. This usually takes a minute or two, you can leave this open.", txID),
 				Flags:   discordgo.MessageFlagsEphemeral,
 			},
 		})
 
-		amount := strings.Split(data.CustomID, "_")[2] // Extract the amount from the custom ID
-		
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		time.Sleep(45 * time.Second) // Simulate a delay for checking the transaction
-	    // Check blockfrost using the tx ID provided by the user
-		utxo, err := blockfrost.GetTransaction(ctx, txID)
-		if err != nil {
-			content := fmt.Sprintf("Error checking transaction ID %s: %v", txID, err)
-			logger.Record.Error(fmt.Sprintf("Error checking transaction ID %s: %v", txID, err))
-			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-				Content: &content,
-			})
-			return
-		}
-
-		if len(utxo.Inputs) < 1 && len(utxo.Outputs) < 1 && utxo.Outputs[0].Address != utxo.Inputs[0].Address && utxo.Outputs[0].Amount[0].Unit != "lovelace" && utxo.Outputs[0].Amount[0].Quantity != amount {
-			content := fmt.Sprintf("Transaction ID %s is invalid or does not match the expected amount of %s lovelace.", txID, amount)
-			logger.Record.Error(fmt.Sprintf("Invalid transaction ID %s provided by user %s", txID, i.Interaction.Member.User.ID))
-			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-				Content: &content,
-			})
-			return
-		}
-
-		addr := utxo.Outputs[0].Address
-		address, err := blockfrost.GetAddress(ctx, addr)
-		if err != nil {
-			content := fmt.Sprintf("Error retrieving address information for %s: %v", addr, err)
-			logger.Record.Error(fmt.Sprintf("Error retrieving address information for %s: %v", addr, err))
-			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-				Content: &content,
-			})
-			return
+		wallet, added, err := challenge.VerifySelfSend(ctx, txID)
+		var content string
+		switch {
+		case err != nil:
+			logger.Record.Warn("LINK WALLET", "USER", i.Member.User.ID, "TX", txID, "ERROR", err)
+			content = "❌ Could not link your wallet: " + err.Error()
+		case !added:
+			content = "This wallet was already linked."
+		default:
+			logger.Record.Info("LINK WALLET", "USER", i.Member.User.ID, "ADDRESS", wallet.Payment, "METHOD", "self_send", "TX", txID)
+			content = "Your wallet has been linked successfully! " + valOr(strings.Join(adaHandles(ctx, wallet.Payment), ", "), wallet.Payment)
 		}
-
-		// See if they have an ada handle
-		var handles []string
-		for _, v := range address.Amount {
-			if strings.HasPrefix(v.Unit, blockfrost.ADA_HANDLE_POLICY_ID) {
-				hexHandle := strings.Split(v.Unit, blockfrost.CIP68v1_NONSENSE)[1]
-				handle, err := hex.DecodeString(hexHandle)
-				if err != nil {
-					logger.Record.Error(fmt.Sprintf("Error decoding ADA handle for user %s: %v", i.Interaction.Member.User.ID, err))
-				}
-				handles = append(handles, fmt.Sprintf("%s%s", blockfrost.ADA_HANDLE_PREFIX, string(handle)))
+		if err == nil {
+			content += "\n**Linked Wallets**\n"
+			for _, w := range cv.LoadUser(i.Member.User.ID).Wallets() {
+				content += fmt.Sprintf("1. %s\n", valOr(w.Label, w.Payment))
 			}
 		}
 
-		user := cv.LoadUser(i.Member.User.ID)
-		var content string
-		var walletExists bool
-		for _, wallet := range user.LinkedWallets {
-			if wallet.Payment == address.Address {
-				walletExists = true
-			}
+		// the interaction token only lasts 15 minutes, a slow tx gets a DM
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+			sendDM(s, i.Member.User.ID, content)
 		}
+	}
+)
 
-		if !walletExists {
-			user.LinkedWallets = append(user.LinkedWallets, cv.Wallet{
-				Payment: address.Address,
-				Stake:   *address.StakeAddress,
-			})
-			user.Save()
+// adaHandles lists the ADA handles held at address.
+func adaHandles(ctx context.Context, address string) []string {
+	info, err := blockfrost.GetAddress(ctx, address)
+	if err != nil {
+		logger.Record.Warn("LINK WALLET", "ADDRESS", address, "ERROR", err)
+		return nil
+	}
+	return handlesOf(info)
+}
 
-			if len(handles) > 0 {
-				content = fmt.Sprintf("Your wallet has been linked successfully! %s", strings.Join(handles, ", "))
-				logger.Record.Info(fmt.Sprintf("User %s successfully linked wallet with tx ID: %s and ADA handle: %s", i.Interaction.Member.User.ID, txID, strings.Join(handles, ", ")))
-			} else {
-				content = fmt.Sprintf("Your wallet has been linked successfully! %s", address.Address)
+func handlesOf(address bfg.Address) []string {
+	var handles []string
+	for _, v := range address.Amount {
+		if strings.HasPrefix(v.Unit, blockfrost.ADA_HANDLE_POLICY_ID) {
+			hexHandle := strings.Split(v.Unit, blockfrost.CIP68v1_NONSENSE)[1]
+			handle, err := hex.DecodeString(hexHandle)
+			if err != nil {
+				logger.Record.Error(fmt.Sprintf("Error decoding ADA handle for %s: %v", address.Address, err))
 			}
+			handles = append(handles, fmt.Sprintf("%s%s", blockfrost.ADA_HANDLE_PREFIX, string(handle)))
 		}
-
-		content += "\n**Linked Wallets**\n"
-		for _, wallet := range user.LinkedWallets {
-			content += fmt.Sprintf("1. %s\n", wallet.Payment)
-		}
-
-		logger.Record.Info(fmt.Sprintf("User %s successfully linked wallet with tx ID: %s", i.Interaction.Member.User.ID, txID))
-		s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
-			Content: &content,
-		})
 	}
-)
\ No newline at end of file
+	return handles
+}
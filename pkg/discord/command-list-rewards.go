@@ -49,14 +49,18 @@ var LIST_SERVER_REWARDS_HANDLER = func(s *discordgo.Session, i *discordgo.Intera
 			roles = strings.ReplaceAll(roles, r, fmt.Sprintf("<@&%s>", r))
 		}
 
+		value := fmt.Sprintf(
+			"**Type:** %s\n**Amount:** %d\n**Frequency:** Daily\n**Roles Eligible:** %s",
+			reward.AssetType,
+			reward.RoleAmount,
+			roles,
+		)
+		if reward.MinHoldDays > 0 {
+			value += fmt.Sprintf("\n**Hold Period:** %d days in a row", reward.MinHoldDays)
+		}
 		fields = append(fields, &discordgo.MessageEmbedField{
 			Name: reward.Name,
-			Value: fmt.Sprintf(
-				"**Type:** %s\n**Amount:** %d\n**Frequency:** Daily\n**Roles Eligible:** %s",
-				reward.AssetType,
-				reward.RoleAmount,
-				roles,
-			),
+			Value: value,
 			Inline: false,
 		})
 
@@ -0,0 +1,84 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var MIGRATE_KEYS_COMMAND = discordgo.ApplicationCommand{
+	Name:                     "migrate-keys",
+	Description:              "Move this server's wallet keys into the encrypted key vault and shred the plaintext files.",
+	DefaultMemberPermissions: &ADMIN,
+}
+
+var MIGRATE_KEYS_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: "Migrating wallet keys…",
+		},
+	})
+
+	migrated, failed := migrateGuildKeys(i.GuildID)
+
+	description := fmt.Sprintf("%d wallet(s) moved into the key vault.", migrated)
+	if len(failed) > 0 {
+		description += fmt.Sprintf("\n%d failed and still use their old keys:\n```\n%s\n```", len(failed), strings.Join(failed, "\n"))
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:       "Key Migration",
+			Description: description,
+			Color:       0x3aa657,
+		}},
+	})
+}
+
+// migrateGuildKeys moves the guild's farm and treasury wallets, and the
+// wallets of everyone earning rewards from it, into the key vault. Wallets
+// already in the vault are only checked for leftover plaintext files.
+func migrateGuildKeys(guildID string) (migrated int, failed []string) {
+	l := logger.Record.WithGroup("KEY MIGRATION").With("GUILD", guildID)
+
+	migrate := func(walletID string, keys *cardano.Keys) bool {
+		changed, err := keys.MigrateToVault(walletID)
+		if err != nil {
+			l.Error("could not migrate wallet", "WALLET", walletID, "ERROR", err)
+			failed = append(failed, fmt.Sprintf("%s: %v", walletID, err))
+			return false
+		}
+		if changed {
+			l.Info("migrated wallet", "WALLET", walletID)
+			migrated++
+		}
+		return changed
+	}
+
+	config := cv.LoadConfig(guildID)
+	changed := false
+	if config.Wallet.Address != "" {
+		changed = migrate(guildID, &config.Wallet) || changed
+	}
+	if config.Treasury.Address != "" {
+		changed = migrate(treasuryWalletID(guildID), &config.Treasury) || changed
+	}
+	if changed {
+		config.Save()
+	}
+
+	for _, user := range cv.LoadUsers() {
+		if _, ok := user.Rewards[cv.ServerID(guildID)]; !ok || user.Wallet.Address == "" {
+			continue
+		}
+		if migrate(user.ID, &user.Wallet) {
+			user.Save()
+		}
+	}
+	return migrated, failed
+}
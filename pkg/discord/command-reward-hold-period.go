@@ -0,0 +1,71 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	holdDaysMin, holdDaysMax = 0.0, 365.0
+
+	REWARD_HOLD_PERIOD_COMMAND = discordgo.ApplicationCommand{
+		Name:                     "reward-hold-period",
+		Description:              "Require holders to keep the assets for a number of days before they earn a reward.",
+		DefaultMemberPermissions: &ADMIN,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "reward",
+				Description: "The name of the reward",
+				Required:    true,
+				MaxLength:   25,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "days",
+				Description: "Days in a row the minimum must be held, 0 to turn it off",
+				Required:    true,
+				MinValue:    &holdDaysMin,
+				MaxValue:    holdDaysMax,
+			},
+		},
+	}
+)
+
+var REWARD_HOLD_PERIOD_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := GetOptions(i)
+	name := strings.TrimSpace(options["reward"].StringValue())
+	days := int(options["days"].IntValue())
+
+	config := cv.LoadConfig(i.GuildID)
+	var names []string
+	for n, reward := range config.Rewards {
+		if !strings.EqualFold(reward.Name, name) {
+			names = append(names, reward.Name)
+			continue
+		}
+		if len(reward.AssetsEligible) == 0 {
+			respondError(s, i, fmt.Sprintf("%s is not a holder reward, it has no eligible assets.", reward.Name))
+			return
+		}
+		config.Rewards[n].MinHoldDays = days
+		config.Save()
+
+		content := fmt.Sprintf("✅ Holders now have to keep more than %d of the eligible assets for %d daily cycles in a row to earn %s.", reward.AssetMinimum, days, reward.Name)
+		if days == 0 {
+			content = fmt.Sprintf("✅ %s no longer has a hold period.", reward.Name)
+		}
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+	respondError(s, i, fmt.Sprintf("There is no reward called %s. Rewards on this server: %s", name, valOr(strings.Join(names, ", "), "none")))
+}
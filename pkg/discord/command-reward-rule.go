@@ -0,0 +1,133 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	REWARD_RULE_COMMAND = discordgo.ApplicationCommand{
+		Name:                     "reward-rule",
+		Description:              "Set who earns a reward with conditions on roles, holdings, delegation and more.",
+		DefaultMemberPermissions: &ADMIN,
+		Options: []*discordgo.ApplicationCommandOption{{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "reward",
+			Description: "The name of the reward",
+			Required:    true,
+			MaxLength:   25,
+		}},
+	}
+
+	REWARD_RULE_MODAL_NAME = "reward-rule"
+)
+
+// REWARD_RULE_HANDLER opens the reward's rule as JSON for editing.
+var REWARD_RULE_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	name := strings.TrimSpace(GetOptions(i)["reward"].StringValue())
+	reward, ok := findReward(cv.LoadConfig(i.GuildID), name)
+	if !ok {
+		respondError(s, i, fmt.Sprintf("There is no reward called %s.", name))
+		return
+	}
+
+	current := ""
+	if reward.Rule != nil {
+		raw, _ := json.MarshalIndent(reward.Rule, "", "  ")
+		current = string(raw)
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: REWARD_RULE_MODAL_NAME + "_" + reward.Name,
+			Title:    "Rule for " + reward.Name,
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{
+					Components: []discordgo.MessageComponent{
+						discordgo.TextInput{
+							CustomID:    "rule",
+							Label:       "Rule JSON, leave empty to remove the rule",
+							Style:       discordgo.TextInputParagraph,
+							Placeholder: `{"op": "and", "rules": [{"op": "role", "role": "123"}, {"op": "holds", "asset": "<policy id>", "minimum": 1}]}`,
+							Value:       current,
+							Required:    false,
+							MaxLength:   4000,
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+var REWARD_RULE_MODAL_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.ModalSubmitInteractionData) {
+	name := strings.TrimPrefix(data.CustomID, REWARD_RULE_MODAL_NAME+"_")
+	text := strings.TrimSpace(data.Components[0].(*discordgo.ActionsRow).Components[0].(*discordgo.TextInput).Value)
+
+	var rule *cv.Rule
+	if text != "" {
+		rule = new(cv.Rule)
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(rule); err != nil {
+			respondError(s, i, "That is not a valid rule: "+err.Error())
+			return
+		}
+		if err := rule.Validate(); err != nil {
+			respondError(s, i, "That is not a valid rule: "+err.Error())
+			return
+		}
+	}
+
+	config := cv.LoadConfig(i.GuildID)
+	found := false
+	for n, reward := range config.Rewards {
+		if reward.Name == name {
+			config.Rewards[n].Rule = rule
+			found = true
+		}
+	}
+	if !found {
+		respondError(s, i, fmt.Sprintf("There is no reward called %s.", name))
+		return
+	}
+	config.Save()
+
+	content := fmt.Sprintf("✅ %s no longer has a rule, it is paid by roles and holdings again.", name)
+	if rule != nil {
+		var lines []string
+		describeRule(*rule, 0, &lines)
+		content = fmt.Sprintf("✅ Saved the rule for %s:\n%s\nMembers can see how they do with `/check-eligibility`.", name, strings.Join(lines, "\n"))
+	}
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+func findReward(config cv.Config, name string) (cv.Reward, bool) {
+	for _, reward := range config.Rewards {
+		if strings.EqualFold(reward.Name, name) {
+			return reward, true
+		}
+	}
+	return cv.Reward{}, false
+}
+
+func describeRule(rule cv.Rule, depth int, lines *[]string) {
+	line := strings.Repeat("　", depth) + "• " + rule.Describe()
+	if rule.Multiplier > 0 && rule.Multiplier != 1 {
+		line += fmt.Sprintf(" ×%g", rule.Multiplier)
+	}
+	*lines = append(*lines, line)
+	for _, sub := range rule.Rules {
+		describeRule(sub, depth+1, lines)
+	}
+}
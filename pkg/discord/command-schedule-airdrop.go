@@ -0,0 +1,273 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var SCHEDULE_AIRDROP_COMMAND = discordgo.ApplicationCommand{
+	Name:                     "schedule-airdrop",
+	Description:              "Schedule a recurring ADA airdrop paid from your farm's treasury.",
+	DefaultMemberPermissions: &ADMIN,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "total_ada",
+			Description: "Total ADA split among holders on every run",
+			Required:    true,
+			MinValue:    &integerOne,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "cadence",
+			Description: "How often the airdrop runs",
+			Required:    true,
+			Choices: []*discordgo.ApplicationCommandOptionChoice{
+				{Name: "Every epoch", Value: string(cv.CadenceEpoch)},
+				{Name: "Daily", Value: string(cv.CadenceDaily)},
+				{Name: "Weekly", Value: string(cv.CadenceWeekly)},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "policy_id",
+			Description: "One or more policy IDs (comma separated) to fetch holders from on every run",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionAttachment,
+			Name:        "holders_file",
+			Description: "CSV/TSV (address,quantity) or JSON [{\"address\":\"addr...\",\"quantity\":N}, ...]",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "start",
+			Description: "First run date (YYYY-MM-DD, UTC). Defaults to now.",
+			Required:    false,
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "end",
+			Description: "Last run date (YYYY-MM-DD, UTC). Defaults to no end.",
+			Required:    false,
+		},
+	},
+}
+
+var SCHEDULE_AIRDROP_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := GetOptions(i)
+	now := time.Now().UTC()
+
+	// Discord enforces the minimum, but never trust the client with a value
+	// that is converted to an unsigned amount
+	totalADA := options["total_ada"].IntValue()
+	if totalADA < 1 {
+		respondError(s, i, "total_ada must be at least 1 ADA.")
+		return
+	}
+
+	schedule := cv.AirdropSchedule{
+		ID:        fmt.Sprintf("%s_%d", i.GuildID, now.Unix()),
+		GuildID:   cv.ServerID(i.GuildID),
+		CreatedBy: i.Member.User.ID,
+		CreatedAt: now,
+		TotalADA:  uint64(totalADA),
+		Cadence:   cv.AirdropCadence(options["cadence"].StringValue()),
+		StartAt:   now,
+		Active:    true,
+	}
+
+	if opt, ok := options["policy_id"]; ok {
+		schedule.PolicyID = strings.Join(splitList(opt.StringValue()), ",")
+	}
+
+	var attachment *discordgo.MessageAttachment
+	if opt, ok := options["holders_file"]; ok {
+		attachment = i.ApplicationCommandData().Resolved.Attachments[opt.Value.(string)]
+	}
+
+	if attachment == nil && schedule.PolicyID == "" {
+		respondError(s, i, "You must provide either a holders file or a policy_id.")
+		return
+	}
+
+	if opt, ok := options["start"]; ok {
+		start, err := time.Parse(time.DateOnly, opt.StringValue())
+		if err != nil {
+			respondError(s, i, "Invalid start date, expected YYYY-MM-DD.")
+			return
+		}
+		schedule.StartAt = start
+	}
+
+	if opt, ok := options["end"]; ok {
+		end, err := time.Parse(time.DateOnly, opt.StringValue())
+		if err != nil {
+			respondError(s, i, "Invalid end date, expected YYYY-MM-DD.")
+			return
+		}
+		schedule.EndAt = end.Add(24*time.Hour - time.Second)
+		if schedule.EndAt.Before(schedule.StartAt) {
+			respondError(s, i, "The end date must be after the start date.")
+			return
+		}
+	}
+	schedule.NextRunAt = schedule.StartAt
+
+	_ = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:   discordgo.MessageFlagsEphemeral,
+			Content: "Scheduling your airdrop…",
+		},
+	})
+
+	if attachment != nil {
+		source := FileHolderSource{URL: attachment.URL, Filename: attachment.Filename}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		holders, holderErrs, err := source.Holders(ctx)
+		if err != nil {
+			followupError(s, i, "Failed to parse holders file: "+err.Error())
+			return
+		}
+		if len(holderErrs) > 0 {
+			followupError(s, i, fmt.Sprintf("The holders file has %d invalid entries:\n```\n%s\n```", len(holderErrs), formatHolderErrors(holderErrs, 10)))
+			return
+		}
+		for _, h := range holders {
+			schedule.Holders = append(schedule.Holders, cardano.Holder{Address: h.Address, Quantity: h.Quantity})
+		}
+	}
+
+	config := cv.LoadConfig(i.GuildID)
+	treasury, err := ensureTreasury(&config)
+	if err != nil {
+		followupError(s, i, "Treasury wallet creation failed: "+err.Error())
+		return
+	}
+
+	schedule.Save()
+	logger.Record.Info("AIRDROP", "SCHEDULED", schedule.ID, "GUILD", i.GuildID, "CADENCE", schedule.Cadence)
+
+	end := "—"
+	if !schedule.EndAt.IsZero() {
+		end = schedule.EndAt.Format(time.DateOnly)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Recurring Airdrop Scheduled",
+		Description: "Keep the treasury address below funded. Every run pays the airdrop, network fees and the service fee from it.",
+		Color:       0x3aa657,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Schedule ID", Value: schedule.ID, Inline: false},
+			{Name: "Policy ID", Value: valOr(schedule.PolicyID, "—"), Inline: true},
+			{Name: "Fixed Holders", Value: fmt.Sprintf("%d", len(schedule.Holders)), Inline: true},
+			{Name: "ADA per Run", Value: fmt.Sprintf("%d", schedule.TotalADA), Inline: true},
+			{Name: "Cadence", Value: string(schedule.Cadence), Inline: true},
+			{Name: "Start", Value: schedule.StartAt.Format(time.DateOnly), Inline: true},
+			{Name: "End", Value: end, Inline: true},
+			{Name: "Treasury Address", Value: "```\n" + treasury.Address + "\n```", Inline: false},
+		},
+	}
+	_, _ = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	})
+}
+
+var AIRDROP_SCHEDULES_COMMAND = discordgo.ApplicationCommand{
+	Name:                     "airdrop-schedules",
+	Description:              "List this server's recurring airdrops and their run history.",
+	DefaultMemberPermissions: &ADMIN,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        "cancel",
+			Description: "Schedule ID to cancel",
+			Required:    false,
+		},
+	},
+}
+
+var AIRDROP_SCHEDULES_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	options := GetOptions(i)
+
+	if opt, ok := options["cancel"]; ok {
+		schedule, err := cv.LoadAirdropSchedule(opt.StringValue())
+		if err != nil || string(schedule.GuildID) != i.GuildID {
+			respondError(s, i, "Schedule not found.")
+			return
+		}
+
+		schedule.Deactivate()
+
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("Schedule `%s` has been cancelled.", schedule.ID),
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	schedules := cv.LoadAirdropSchedulesByGuildID(i.GuildID)
+	if len(schedules) == 0 {
+		respondError(s, i, "No airdrops have been scheduled on this server.")
+		return
+	}
+
+	var embeds []*discordgo.MessageEmbed
+	for _, schedule := range schedules {
+		status := "Active"
+		if !schedule.Active {
+			status = "Inactive"
+		}
+
+		var history []string
+		runs := schedule.Runs
+		if len(runs) > 5 {
+			runs = runs[len(runs)-5:]
+		}
+		for _, run := range runs {
+			line := fmt.Sprintf("• %s — %s, %d recipients", run.StartedAt.Format(time.DateOnly), run.Stage, run.TotalRecipients)
+			if run.Error != "" {
+				line += " (" + run.Error + ")"
+			}
+			history = append(history, line)
+		}
+
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			Title: fmt.Sprintf("🌾 %s", schedule.ID),
+			Color: 0x00cc99,
+			Fields: []*discordgo.MessageEmbedField{
+				{Name: "Status", Value: status, Inline: true},
+				{Name: "Cadence", Value: string(schedule.Cadence), Inline: true},
+				{Name: "ADA per Run", Value: fmt.Sprintf("%d", schedule.TotalADA), Inline: true},
+				{Name: "Policy ID", Value: valOr(schedule.PolicyID, "—"), Inline: false},
+				{Name: fmt.Sprintf("Recent Runs (%d total)", len(schedule.Runs)), Value: valOr(strings.Join(history, "\n"), "—"), Inline: false},
+			},
+		})
+	}
+
+	// Discord allows at most 10 embeds per message
+	if len(embeds) > 10 {
+		embeds = embeds[len(embeds)-10:]
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: embeds,
+			Flags:  discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
@@ -0,0 +1,224 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var WALLETS_COMMAND = discordgo.ApplicationCommand{
+	Name:        "wallets",
+	Description: "Manage the wallets linked to your Discord account.",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "Show your linked wallets",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "unlink",
+			Description: "Remove a wallet you no longer use",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "rename",
+			Description: "Give a wallet a label",
+			Options: []*discordgo.ApplicationCommandOption{{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "label",
+				Description: "The new label, leave it out to clear the label",
+				Required:    false,
+				MaxLength:   cv.MaxWalletLabel,
+			}},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "set-primary",
+			Description: "Pick the wallet /harvest suggests first",
+		},
+	},
+}
+
+var (
+	WALLETS_UNLINK_COMPONENT_NAME  = "wallets-unlink"
+	WALLETS_RENAME_COMPONENT_NAME  = "wallets-rename"
+	WALLETS_PRIMARY_COMPONENT_NAME = "wallets-primary"
+)
+
+// select menu values are capped at 100 characters, wallets are picked by the
+// end of their address instead
+const walletSuffixLength = 24
+
+var WALLETS_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	sub := i.ApplicationCommandData().Options[0]
+	user := cv.LoadUser(i.Member.User.ID)
+
+	if sub.Name == "list" || len(user.LinkedWallets) == 0 {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Flags:  discordgo.MessageFlagsEphemeral,
+				Embeds: []*discordgo.MessageEmbed{walletsEmbed(user)},
+			},
+		})
+		return
+	}
+
+	var customID, placeholder string
+	switch sub.Name {
+	case "unlink":
+		customID = WALLETS_UNLINK_COMPONENT_NAME
+		placeholder = "Select the wallet to unlink"
+	case "rename":
+		label := ""
+		if len(sub.Options) > 0 {
+			label = strings.TrimSpace(sub.Options[0].StringValue())
+		}
+		customID = WALLETS_RENAME_COMPONENT_NAME + "_" + label
+		placeholder = "Select the wallet to rename"
+	case "set-primary":
+		customID = WALLETS_PRIMARY_COMPONENT_NAME
+		placeholder = "Select your primary wallet"
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Flags:      discordgo.MessageFlagsEphemeral,
+			Components: walletSelect(user, customID, placeholder),
+		},
+	})
+}
+
+var WALLETS_UNLINK_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+	user, wallet, ok := selectedWallet(i, data)
+	if !ok {
+		updateWalletsMessage(s, i, user, "That wallet is no longer linked.")
+		return
+	}
+	user.UnlinkWallet(wallet.Payment)
+	user.Save()
+	logger.Record.Info("WALLETS", "USER", user.ID, "UNLINKED", wallet.Payment)
+	updateWalletsMessage(s, i, user, fmt.Sprintf("Unlinked `%s`. It no longer counts for holder rewards.", wallet.Payment))
+}
+
+var WALLETS_RENAME_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+	label := strings.TrimPrefix(data.CustomID, WALLETS_RENAME_COMPONENT_NAME+"_")
+	user, wallet, ok := selectedWallet(i, data)
+	if !ok {
+		updateWalletsMessage(s, i, user, "That wallet is no longer linked.")
+		return
+	}
+	user.RenameWallet(wallet.Payment, label)
+	user.Save()
+	logger.Record.Info("WALLETS", "USER", user.ID, "RENAMED", wallet.Payment, "LABEL", label)
+	content := fmt.Sprintf("Labelled `%s` as **%s**.", wallet.Payment, label)
+	if label == "" {
+		content = fmt.Sprintf("Cleared the label of `%s`.", wallet.Payment)
+	}
+	updateWalletsMessage(s, i, user, content)
+}
+
+var WALLETS_PRIMARY_COMPONENT_HANDLER = func(s *discordgo.Session, i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) {
+	user, wallet, ok := selectedWallet(i, data)
+	if !ok {
+		updateWalletsMessage(s, i, user, "That wallet is no longer linked.")
+		return
+	}
+	user.SetPrimaryWallet(wallet.Payment)
+	user.Save()
+	logger.Record.Info("WALLETS", "USER", user.ID, "PRIMARY", wallet.Payment)
+	updateWalletsMessage(s, i, user, fmt.Sprintf("**%s** is now your primary wallet.", wallet.Name()))
+}
+
+func walletsEmbed(user cv.User) *discordgo.MessageEmbed {
+	var lines []string
+	for _, w := range user.Wallets() {
+		line := "• "
+		if w.Label != "" {
+			line += "**" + w.Label + "** "
+		}
+		line += "`" + w.Payment + "`"
+		if w.Primary {
+			line += " ⭐"
+		}
+		lines = append(lines, line)
+	}
+	description := strings.Join(lines, "\n")
+	if description == "" {
+		description = "You have no linked wallets. Use `/link-wallet` to add one."
+	}
+	return &discordgo.MessageEmbed{
+		Title:       fmt.Sprintf("Linked Wallets (%d)", len(lines)),
+		Description: description,
+		Color:       0x3aa657,
+		Footer:      &discordgo.MessageEmbedFooter{Text: "⭐ primary wallet, suggested first by /harvest"},
+	}
+}
+
+func walletSelect(user cv.User, customID, placeholder string) []discordgo.MessageComponent {
+	var options []discordgo.SelectMenuOption
+	for _, w := range user.Wallets() {
+		description := cv.TruncateMiddle(w.Payment, 50)
+		if w.Primary {
+			description = "Primary · " + description
+		}
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       w.Name(),
+			Value:       walletSuffix(w.Payment),
+			Description: description,
+		})
+		if len(options) == 25 {
+			break
+		}
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					CustomID:    customID,
+					Placeholder: placeholder,
+					Options:     options,
+				},
+			},
+		},
+	}
+}
+
+func walletSuffix(payment string) string {
+	if len(payment) <= walletSuffixLength {
+		return payment
+	}
+	return payment[len(payment)-walletSuffixLength:]
+}
+
+// selectedWallet finds the linked wallet picked in a wallet select menu.
+func selectedWallet(i *discordgo.InteractionCreate, data discordgo.MessageComponentInteractionData) (cv.User, cv.Wallet, bool) {
+	user := cv.LoadUser(i.Member.User.ID)
+	if len(data.Values) == 0 {
+		return user, cv.Wallet{}, false
+	}
+	for _, w := range user.LinkedWallets {
+		if walletSuffix(w.Payment) == data.Values[0] {
+			return user, w, true
+		}
+	}
+	return user, cv.Wallet{}, false
+}
+
+// updateWalletsMessage replaces the select menu with the outcome and the
+// wallets as they are now.
+func updateWalletsMessage(s *discordgo.Session, i *discordgo.InteractionCreate, user cv.User, content string) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Embeds:     []*discordgo.MessageEmbed{walletsEmbed(user)},
+			Components: []discordgo.MessageComponent{},
+		},
+	})
+}
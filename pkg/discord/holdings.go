@@ -0,0 +1,134 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/koios"
+	"context"
+	"log/slog"
+	"slices"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  HOLDINGS: what each user holds, looked up once per holder cycle
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+type (
+	// holdingsSource answers the batched lookups of a holder cycle: the
+	// native assets under each stake address and at each payment address.
+	holdingsSource interface {
+		StakeHoldings(ctx context.Context, stakes []string) (map[string]map[string]uint64, error)
+		AddressHoldings(ctx context.Context, addresses []string) (map[string]map[string]uint64, error)
+	}
+
+	koiosHoldings struct{}
+)
+
+// holdings is where holderHoldings looks assets up.
+var holdings holdingsSource = koiosHoldings{}
+
+func (koiosHoldings) StakeHoldings(ctx context.Context, stakes []string) (map[string]map[string]uint64, error) {
+	return koios.GetStakeAddressHoldings(ctx, stakes)
+}
+
+func (koiosHoldings) AddressHoldings(ctx context.Context, addresses []string) (map[string]map[string]uint64, error) {
+	return koios.GetAddressHoldings(ctx, addresses)
+}
+
+// holderHoldings adds up the native assets each user holds across their
+// claimed wallets. Wallets whose stake key signed are looked up by stake
+// address, which also counts tokens at the wallet's other addresses, and a
+// stake address is only counted once however many of its addresses are
+// linked. Every other wallet only proved its payment address and is looked
+// up by that address. Both lookups are batched; when one fails its addresses
+// are queried one by one.
+func holderHoldings(ctx context.Context, users cv.Users, owners map[string]string, log *slog.Logger) map[string]map[string]uint64 {
+	userStakes := make(map[string][]string)       // userID -> stake addresses
+	userAddresses := make(map[string][]string)    // userID -> payment addresses
+	userStakeWallets := make(map[string][]string) // userID -> addresses with a stake part
+	var stakes, addresses []string
+	batched := make(map[string]bool) // payment addresses in the batched lookup
+	for _, user := range users {
+		for _, wallet := range user.ClaimedWallets(owners) {
+			addr, err := cardano.ParseAddress(wallet.Payment)
+			if err != nil {
+				log.With("USER", user.ID, "WALLET", wallet.Payment).Warn("Invalid linked wallet address", "ERROR", err)
+				continue
+			}
+			stake := ""
+			if wallet.StakeVerified {
+				stake, _ = addr.StakeAddress()
+			}
+			if stake == "" {
+				if !batched[wallet.Payment] {
+					batched[wallet.Payment] = true
+					addresses = append(addresses, wallet.Payment)
+				}
+				userAddresses[user.ID] = append(userAddresses[user.ID], wallet.Payment)
+				continue
+			}
+			if !slices.Contains(stakes, stake) {
+				stakes = append(stakes, stake)
+			}
+			userStakeWallets[user.ID] = append(userStakeWallets[user.ID], wallet.Payment)
+			if !slices.Contains(userStakes[user.ID], stake) {
+				userStakes[user.ID] = append(userStakes[user.ID], stake)
+			}
+		}
+	}
+
+	// the cache for this cycle
+	byStake, err := holdings.StakeHoldings(ctx, stakes)
+	if err != nil {
+		log.Warn("Batched stake lookup failed, querying each address", "STAKE ADDRESSES", len(stakes), "ERROR", err)
+		for userID, wallets := range userStakeWallets {
+			userAddresses[userID] = append(userAddresses[userID], wallets...)
+		}
+		userStakes = nil
+		byStake = nil
+	}
+	var byAddress map[string]map[string]uint64
+	if len(addresses) > 0 {
+		byAddress, err = holdings.AddressHoldings(ctx, addresses)
+		if err != nil {
+			log.Warn("Batched address lookup failed, querying each address", "ADDRESSES", len(addresses), "ERROR", err)
+			byAddress = nil
+		}
+	}
+
+	held := make(map[string]map[string]uint64) // userID -> token -> amount
+	add := func(userID string, assets map[string]uint64) {
+		if len(assets) == 0 {
+			return
+		}
+		if _, ok := held[userID]; !ok {
+			held[userID] = make(map[string]uint64)
+		}
+		for unit, qty := range assets {
+			held[userID][unit] += qty
+		}
+	}
+	for userID, userStakeList := range userStakes {
+		for _, stake := range userStakeList {
+			add(userID, byStake[stake])
+		}
+	}
+	for userID, userAddressList := range userAddresses {
+		for _, address := range userAddressList {
+			// addresses without UTxOs are left out of the batched result
+			if byAddress != nil && batched[address] {
+				add(userID, byAddress[address])
+				continue
+			}
+			utxos, err := cardano.QueryUTxOs(ctx, address)
+			if err != nil {
+				log.With("USER", userID, "WALLET", address).Warn("Cannot query linked wallet", "ERROR", err)
+				continue
+			}
+			add(userID, utxos.Assets())
+		}
+	}
+	return held
+}
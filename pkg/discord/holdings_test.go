@@ -0,0 +1,144 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// fixtureHoldings answers the batched lookups from the fixture ledger and
+// counts the addresses each batch asked for.
+type fixtureHoldings struct {
+	f         *chain.Fixture
+	fail      bool
+	addresses *int
+}
+
+func (h fixtureHoldings) StakeHoldings(ctx context.Context, stakes []string) (map[string]map[string]uint64, error) {
+	if h.fail {
+		return nil, errors.New("koios is down")
+	}
+	held := make(map[string]map[string]uint64)
+	for _, stake := range stakes {
+		held[stake] = make(map[string]uint64)
+	}
+	for _, u := range h.f.UTxOs {
+		addr, err := cardano.ParseAddress(u.Address)
+		if err != nil {
+			continue
+		}
+		stake, _ := addr.StakeAddress()
+		if assets, ok := held[stake]; ok {
+			for unit, qty := range u.Assets {
+				assets[unit] += qty
+			}
+		}
+	}
+	return held, nil
+}
+
+func (h fixtureHoldings) AddressHoldings(ctx context.Context, addresses []string) (map[string]map[string]uint64, error) {
+	if h.fail {
+		return nil, errors.New("koios is down")
+	}
+	*h.addresses += len(addresses)
+	held := make(map[string]map[string]uint64)
+	for _, u := range h.f.UTxOs {
+		for _, address := range addresses {
+			if u.Address != address {
+				continue
+			}
+			if held[address] == nil {
+				held[address] = make(map[string]uint64)
+			}
+			for unit, qty := range u.Assets {
+				held[address][unit] += qty
+			}
+		}
+	}
+	return held, nil
+}
+
+func TestHolderHoldings(t *testing.T) {
+	const (
+		policy = "e633efbf5e4a0c5bd1e6c3d8bdcd82ea93ad6c4dc0b1a1a7c0f1a2b3"
+		crop   = policy + "43524f50"
+		seed   = policy + "53454544"
+	)
+	f, _ := chain.UseFixtureT(t)
+	first, _ := testAddress(t)
+	second, _ := testAddress(t)
+	contested, _ := testAddress(t)
+	other, _ := testAddress(t)
+
+	// a wallet whose stake key signed, with tokens at an unlinked address
+	stakeKey, err := cardano.NewSigningKey(cardano.StakeSigningKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, paymentKey := testAddress(t)
+	_, unlinkedKey := testAddress(t)
+	staked, _ := cardano.BaseAddress(paymentKey.Public(), stakeKey.Public())
+	unlinked, _ := cardano.BaseAddress(unlinkedKey.Public(), stakeKey.Public())
+
+	for n, u := range []chain.UTxO{
+		{Address: first, Lovelace: 2_000_000, Assets: map[string]uint64{crop: 100}},
+		{Address: first, Lovelace: 2_000_000, Assets: map[string]uint64{crop: 50, seed: 1}},
+		{Address: second, Lovelace: 2_000_000, Assets: map[string]uint64{crop: 25}},
+		{Address: contested, Lovelace: 2_000_000, Assets: map[string]uint64{seed: 7}},
+		{Address: other, Lovelace: 2_000_000, Assets: map[string]uint64{crop: 3}},
+		{Address: staked, Lovelace: 2_000_000, Assets: map[string]uint64{crop: 10}},
+		{Address: unlinked, Lovelace: 2_000_000, Assets: map[string]uint64{seed: 2}},
+	} {
+		u.TxHash = fmt.Sprintf("%064x", n+1)
+		f.AddUTxO(u)
+	}
+
+	users := cv.Users{
+		{ID: "alice", LinkedWallets: []cv.Wallet{{Payment: first}, {Payment: second}, {Payment: contested}}},
+		{ID: "bob", LinkedWallets: []cv.Wallet{{Payment: other}, {Payment: contested}, {Payment: "addr1notanaddress"}, {Payment: staked, StakeVerified: true}}},
+	}
+	// the contested wallet was proven by bob
+	owners := map[string]string{first: "alice", second: "alice", contested: "bob", other: "bob", staked: "bob"}
+
+	t.Run("batched", func(t *testing.T) {
+		var looked int
+		current := holdings
+		holdings = fixtureHoldings{f: f, addresses: &looked}
+		t.Cleanup(func() { holdings = current })
+
+		got := holderHoldings(context.Background(), users, owners, logger.Record)
+		want := map[string]map[string]uint64{
+			"alice": {crop: 175, seed: 1},
+			"bob":   {crop: 13, seed: 9},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("holderHoldings = %v, want %v", got, want)
+		}
+		if looked != 4 {
+			t.Errorf("%d payment addresses looked up in the batch, want 4", looked)
+		}
+	})
+
+	t.Run("batch lookups fail", func(t *testing.T) {
+		current := holdings
+		holdings = fixtureHoldings{f: f, fail: true}
+		t.Cleanup(func() { holdings = current })
+
+		// without the stake lookup only the linked address counts
+		got := holderHoldings(context.Background(), users, owners, logger.Record)
+		want := map[string]map[string]uint64{
+			"alice": {crop: 175, seed: 1},
+			"bob":   {crop: 13, seed: 7},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("holderHoldings = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/db"
+	"cardano-valley/pkg/logger"
+	"context"
+	"os"
+	"strings"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  KEY ROTATION: re-encrypt stored secrets under the current master key
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// keyRotation runs once at startup. Everything already under the current
+// key is skipped, so with nothing to rotate it only reads.
+func keyRotation(ctx context.Context) {
+	if _, err := cv.RotateKeys(ctx, nil); err != nil {
+		logger.Record.Error("KEY ROTATION", "ERROR", err)
+	}
+	reencryptSessionKeys()
+}
+
+// reencryptSessionKeys re-seals the airdrop session keys kept in the session
+// files.
+func reencryptSessionKeys() {
+	l := logger.Record.WithGroup("KEY ROTATION").With("KEY", db.Keys.Current)
+
+	entries, err := os.ReadDir(sessionDir())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.Error("could not read sessions", "ERROR", err)
+		}
+		return
+	}
+
+	var done, failed, busy int
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		sessionID := strings.TrimSuffix(entry.Name(), ".json")
+
+		// a session held by its watcher is left to the janitor, which
+		// re-seals it once the watcher is done
+		unlock, ok := tryLockSession(sessionID)
+		if !ok {
+			busy++
+			continue
+		}
+		ses, err := loadSession(sessionID)
+		if err != nil || db.Keys.IsCurrent(ses.SKeyEncrypted) {
+			unlock()
+			continue
+		}
+		ses.SKeyEncrypted, err = db.Keys.Reseal(ses.SKeyEncrypted, nil)
+		if err == nil {
+			err = saveSession(ses)
+		}
+		unlock()
+
+		if err != nil {
+			l.Error("could not re-encrypt session key", "SESSION", sessionID, "ERROR", err)
+			failed++
+			continue
+		}
+		done++
+	}
+	if done > 0 || failed > 0 || busy > 0 {
+		l.Info("session keys", "REENCRYPTED", done, "FAILED", failed, "BUSY", busy)
+	}
+}
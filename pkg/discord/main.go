@@ -2,14 +2,14 @@
 package discord
 
 import (
-	"cardano-valley/pkg/blockfrost"
+	"cardano-valley/pkg/confirm"
 	"cardano-valley/pkg/cv"
 	"cardano-valley/pkg/logger"
 	"context"
 	"log"
+	"log/slog"
 	"net/url"
 	"os"
-	"strconv"
 	"sync"
 	"time"
 
@@ -32,11 +32,10 @@ type Verification struct {
 	ResponseChan  chan string
 }
 
-func init() {
-	// Skip initialization during testing
-	if os.Getenv("GO_TESTING") == "true" {
-		return
-	}
+// Start opens the bot session and starts the background workers. It is
+// called from main rather than init, so subcommands like hd-backup and tests
+// can use the packages without the bot running.
+func Start() {
 	initDiscord()
 	initWebhook()
 }
@@ -58,6 +57,19 @@ func initDiscord() {
 
 	go rewardRoleUpdater(ctx)
 	go rewardHolderUpdater(ctx)
+	go airdropScheduler(ctx)
+	go airdropJanitor(ctx)
+	go stakeDelegator(ctx)
+	go keyRotation(ctx)
+	go multisigExpiry(ctx)
+	go walletClaimBackfill(ctx)
+
+	confirm.Subscribe(onAirdropTxEvent(S))
+	confirm.Subscribe(onHarvestTxEvent(S))
+	confirm.Subscribe(onDelegationTxEvent())
+	confirm.Subscribe(onClaimFeeTxEvent(S))
+	cv.SubscribeWalletContests(onWalletContest(S))
+	go confirm.Run(ctx)
 }
 
 func RefreshCommands() {
@@ -93,14 +105,18 @@ func rewardRoleUpdater(ctx context.Context) {
 		configs := cv.LoadConfigs()
 		users := cv.LoadUsers()
 		rewardLog := logger.Record.WithGroup("ROLE CYCLE")
+
+		// reward rules may check holdings, which the holder cycle otherwise looks up
+		owners := cv.WalletOwners()
+		var holdings map[string]map[string]uint64
+		if rulesUse(configs, cv.RuleHolds) {
+			holdings = holderHoldings(ctx, users, owners, rewardLog)
+		}
+		facts := newRuleFacts(ctx, owners, holdings)
+
 		for _, user := range users {
 			userLog := rewardLog.With("USER", user.ID)
 
-			// Ensure user.Rewards is initialized
-			if user.Rewards == nil {
-				user.Rewards = make(map[cv.ServerID]cv.Balance)
-			}
-
 			for _, config := range configs {
 				guildLog := userLog.With("GUILD", config.GuildID)
 
@@ -110,37 +126,34 @@ func rewardRoleUpdater(ctx context.Context) {
 					continue
 				}
 
-				// Ensure user.Rewards[GuildID] is initialized
-				if _, ok := user.Rewards[config.GuildID]; !ok {
-					user.Rewards[config.GuildID] = make(cv.Balance)
-				}
-
+				credits := make(map[cv.Asset]uint64)
 				for key, reward := range config.Rewards {
 					rewardLog := guildLog.With("REWARD", reward.Name, "BALANCE", reward.Balance)
 					matchingRoles := cv.SliceMatches(member.Roles, reward.RolesEligible)
+					amount := reward.RoleAmount
+					eligible := len(matchingRoles) > 0
+
+					// a rule replaces the role check and may scale the amount
+					if reward.Rule != nil {
+						result := reward.Rule.Evaluate(facts.For(ctx, member, user, *reward.Rule))
+						eligible = result.Pass && reward.RoleAmount > 0
+						amount = uint64(float64(reward.RoleAmount) * result.Multiplier)
+					}
 
-					if len(matchingRoles) > 0 {
-						if reward.Balance - reward.RoleAmount <= 0 {
+					if eligible {
+						if reward.Balance - amount <= 0 {
 							rewardLog.Error("Reward balance is empty!")
 						}
-						rewardLog.Info("ELIGIBLE", "AMOUNT", reward.RoleAmount)
-
-						// Get current reward entry or create a new one
-						entry := user.Rewards[config.GuildID][reward.RewardToken]
-						entry.Earned += reward.RoleAmount
-						entry.LastClaimed = time.Now()
+						rewardLog.Info("ELIGIBLE", "AMOUNT", amount)
+						credits[reward.RewardToken] += amount
 
 						// Reduce the reward balance available.
-						config.Rewards[key].Balance -= reward.RoleAmount
+						config.Rewards[key].Balance -= amount
 						config.Save()
-
-						// Save it back to the map
-						user.Rewards[config.GuildID][reward.RewardToken] = entry
 					}
 				}
+				creditRewards(ctx, user.ID, config.GuildID, credits, guildLog)
 			}
-
-			user.Save()
 		}
 	}
 }
@@ -153,121 +166,169 @@ func rewardHolderUpdater(ctx context.Context) {
 		// next := now.Add(10*time.Second) // for testing
 		time.Sleep(time.Until(next))
 
-		logger.Record.Info("Updating holder rewards...")
-
-		// Get all guilds associated with Cardano Valley
-		configs := cv.LoadConfigs()
-
-		// Get all wallets of users associated with Cardano Valley
-		holders := make(map[string]map[string]uint64) // userID -> token -> amount
-		users := cv.LoadUsers()
-		tokenSum := make(map[string]uint64) // token -> total amount held
-		rewardLog := logger.Record.WithGroup("HOLDER CYCLE")
-		for _, user := range users {
-			userLog := rewardLog.With("USER", user.ID)
-			for _, wallet := range user.LinkedWallets {
-				addressLog := userLog.With("WALLET", wallet.Payment)
-				// Check if the wallet is valid
-				address, err := blockfrost.GetAddress(ctx, wallet.Payment)
-				if err != nil {
-					addressLog.Warn("Invalid linked wallet address")
-					continue
-				}
+		runHolderCycle(ctx, S)
+	}
+}
 
-				for _, amount := range address.Amount {
-					if amount.Unit == "lovelace" {
-						// Skip ADA balance
-						continue
-					}
+// memberLookup finds a user's membership of a guild; *discordgo.Session is
+// one.
+type memberLookup interface {
+	GuildMember(guildID, userID string, options ...discordgo.RequestOption) (*discordgo.Member, error)
+}
 
-					if _, ok := holders[user.ID]; !ok {
-						holders[user.ID] = make(map[string]uint64)
-					}
-					qty, err := strconv.ParseInt(amount.Quantity, 10, 64)
-					if err != nil {
-						addressLog.Error("Invalid quantity for token", "TOKEN", amount.Unit, "QUANTITY", amount.Quantity, "ERROR", err)
-						continue
-					}
+// runHolderCycle credits one day of holder rewards to every guild member
+// holding an eligible asset.
+func runHolderCycle(ctx context.Context, members memberLookup) {
+	logger.Record.Info("Updating holder rewards...")
+
+	// Get all guilds associated with Cardano Valley
+	configs := cv.LoadConfigs()
+
+	// Get all wallets of users associated with Cardano Valley
+	users := cv.LoadUsers()
+	rewardLog := logger.Record.WithGroup("HOLDER CYCLE")
+	// a wallet linked to several accounts only counts for its owner
+	owners := cv.WalletOwners()
+	holders := holderHoldings(ctx, users, owners, rewardLog) // userID -> token -> amount
+	tokenSum := make(map[string]uint64)                      // token -> total amount held
+	for _, holdings := range holders {
+		for unit, qty := range holdings {
+			tokenSum[unit] += qty
+		}
+	}
 
-					holders[user.ID][amount.Unit] += uint64(qty)
-					tokenSum[amount.Unit] += uint64(qty)
-				}
+	maxHoldDays := 0
+	eligible := make(map[string]bool) // units some reward pays for
+	for _, config := range configs {
+		for _, reward := range config.Rewards {
+			maxHoldDays = max(maxHoldDays, reward.MinHoldDays)
+			for _, asset := range reward.AssetsEligible {
+				eligible[asset] = true
 			}
 		}
+	}
 
+	// hold periods are checked against the daily snapshots, which only
+	// keep the assets a reward is paid for, and are audited against the
+	// wallet ledger
+	snapshots := make(map[string]map[string]uint64)
+	counted := make(map[string][]string) // userID -> wallets the holdings came from
+	for _, user := range users {
+		for _, wallet := range user.ClaimedWallets(owners) {
+			counted[user.ID] = append(counted[user.ID], wallet.Payment)
+		}
+	}
+	for userID, holdings := range holders {
+		for unit, qty := range holdings {
+			if !eligible[unit] {
+				continue
+			}
+			if _, ok := snapshots[userID]; !ok {
+				snapshots[userID] = make(map[string]uint64)
+			}
+			snapshots[userID][unit] = qty
+		}
+	}
+	snapshotAt := time.Now()
+	if err := cv.SaveHoldingSnapshots(snapshotAt, snapshots, counted); err != nil {
+		rewardLog.Warn("Could not save holding snapshots", "ERROR", err)
+	}
+	for userID := range snapshots {
+		if missing := cv.AuditSnapshotWallets(userID, snapshotAt, counted[userID]); len(missing) > 0 {
+			rewardLog.Warn("Snapshot counts wallets missing from the ledger", "USER", userID, "WALLETS", missing)
+		}
+	}
+	var cycles []string
+	if maxHoldDays > 0 {
+		cycles = cv.LoadHoldingCycles(int64(maxHoldDays))
+	}
 
+	facts := newRuleFacts(ctx, owners, holders)
 
-		for userID, holdings := range holders {
-			userLog := rewardLog.With("USER", userID)
+	for userID, holdings := range holders {
+		userLog := rewardLog.With("USER", userID)
+		var history cv.HoldingHistory
+		if len(cycles) > 0 {
+			history = cv.LoadHoldingHistory(userID, cycles[len(cycles)-1])
+		}
 
-			// Ensure user.Rewards is initialized
-			user := cv.LoadUser(userID)
-			if user.Rewards == nil {
-				user.Rewards = make(map[cv.ServerID]cv.Balance)
-			}
+		user := cv.LoadUser(userID)
 
-			for _, config := range configs {
-				guildLog := userLog.With("GUILD", config.GuildID)
+		for _, config := range configs {
+			guildLog := userLog.With("GUILD", config.GuildID)
 
-				_, err := S.GuildMember(string(config.GuildID), userID)
-				if err != nil {
-					// User not in guild, skip
-					continue
-				}
+			member, err := members.GuildMember(string(config.GuildID), userID)
+			if err != nil {
+				// User not in guild, skip
+				continue
+			}
 
-				// Ensure user.Rewards[GuildID] is initialized
-				if _, ok := user.Rewards[config.GuildID]; !ok {
-					user.Rewards[config.GuildID] = make(cv.Balance)
+			credits := make(map[cv.Asset]uint64)
+			for key, reward := range config.Rewards {
+				rewardLog := guildLog.With("REWARD", reward.Name, "BALANCE", reward.Balance)
+				multiplier := 1.0
+				if reward.Rule != nil && len(reward.AssetsEligible) > 0 {
+					result := reward.Rule.Evaluate(facts.For(ctx, member, user, *reward.Rule))
+					if !result.Pass {
+						rewardLog.Info("RULE NOT MET")
+						continue
+					}
+					multiplier = result.Multiplier
 				}
-
-				for key, reward := range config.Rewards {
-					rewardLog := guildLog.With("REWARD", reward.Name, "BALANCE", reward.Balance)
-					for _, asset := range reward.AssetsEligible {
-						// Check if the user holds the asset
-						assetLog := rewardLog.With("ASSET", asset)
-						if amount, ok := holdings[asset]; ok && amount > reward.AssetMinimum {
-							assetLog.Info("HOLDER ELIGIBLE", "ASSET", asset, "AMOUNT", amount)
-
-							// Get current reward entry or create a new one
-							entry := user.Rewards[config.GuildID][reward.RewardToken]
-							entry.Earned += reward.Balance / tokenSum[asset] * amount
-							entry.LastClaimed = time.Now()
-
-							// Reduce the reward balance available.
-							config.Rewards[key].Balance -= entry.Earned
-							config.Save()
-
-							// Save it back to the map
-							user.Rewards[config.GuildID][reward.RewardToken] = entry
+				for _, asset := range reward.AssetsEligible {
+					// Check if the user holds the asset
+					assetLog := rewardLog.With("ASSET", asset)
+					if amount, ok := holdings[asset]; ok && amount > reward.AssetMinimum {
+						if streak := history.Streak(cycles, asset, reward.AssetMinimum); streak < reward.MinHoldDays {
+							assetLog.Info("HOLD PERIOD NOT MET", "STREAK", streak, "REQUIRED", reward.MinHoldDays)
+							continue
 						}
-						
+						assetLog.Info("HOLDER ELIGIBLE", "ASSET", asset, "AMOUNT", amount)
+						earned := uint64(float64(reward.Balance / tokenSum[asset] * amount) * multiplier)
+						credits[reward.RewardToken] += earned
+
+						// Reduce the reward balance available.
+						config.Rewards[key].Balance -= earned
+						config.Save()
 					}
-					// if len(matchingRoles) > 0 {
-					// 	if reward.Balance - reward.RoleAmount <= 0 {
-					// 		rewardLog.Error("Reward balance is empty!")
-					// 	}
-					// 	rewardLog.Info("ELIGIBLE", "AMOUNT", reward.RoleAmount)
-
-					// 	// Get current reward entry or create a new one
-					// 	entry := user.Rewards[config.GuildID][reward.RewardToken]
-					// 	entry.Earned += reward.RoleAmount
-					// 	entry.LastClaimed = time.Now()
-
-					// 	// Reduce the reward balance available.
-					// 	config.Rewards[key].Balance -= reward.RoleAmount
-					// 	config.Save()
-
-					// 	// Save it back to the map
-					// 	user.Rewards[config.GuildID][reward.RewardToken] = entry
-					// }
+					
 				}
+				// if len(matchingRoles) > 0 {
+				// 	if reward.Balance - reward.RoleAmount <= 0 {
+				// 		rewardLog.Error("Reward balance is empty!")
+				// 	}
+				// 	rewardLog.Info("ELIGIBLE", "AMOUNT", reward.RoleAmount)
+
+				// 	// Get current reward entry or create a new one
+				// 	entry := user.Rewards[config.GuildID][reward.RewardToken]
+				// 	entry.Earned += reward.RoleAmount
+				// 	entry.LastClaimed = time.Now()
+
+				// 	// Reduce the reward balance available.
+				// 	config.Rewards[key].Balance -= reward.RoleAmount
+				// 	config.Save()
+
+				// 	// Save it back to the map
+				// 	user.Rewards[config.GuildID][reward.RewardToken] = entry
+				// }
 			}
-
-			user.Save()
+			creditRewards(ctx, userID, config.GuildID, credits, guildLog)
 		}
 	}
 }
 
+// creditRewards adds a cycle's rewards to the user's balance in a guild. The
+// user is not saved as a whole, that would undo a harvest reserving the
+// balance while the cycle ran.
+func creditRewards(ctx context.Context, userID string, guildID cv.ServerID, credits map[cv.Asset]uint64, log *slog.Logger) {
+	if len(credits) == 0 {
+		return
+	}
+	if err := cv.CreditRewards(ctx, userID, guildID, credits); err != nil {
+		log.Error("Could not credit rewards", "CREDITS", credits, "ERROR", err)
+	}
+}
+
 func initWebhook() {
 	// DISCORD_WEBHOOK_URL
 	webhook, ok := os.LookupEnv("DISCORD_WEBHOOK_URL")
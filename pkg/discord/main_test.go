@@ -0,0 +1,136 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/db"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// guildMembers answers GuildMember for the users it lists per guild.
+type guildMembers map[string][]string
+
+func (m guildMembers) GuildMember(guildID, userID string, _ ...discordgo.RequestOption) (*discordgo.Member, error) {
+	for _, id := range m[guildID] {
+		if id == userID {
+			return &discordgo.Member{GuildID: guildID, User: &discordgo.User{ID: userID}}, nil
+		}
+	}
+	return nil, errors.New("unknown member")
+}
+
+// useKeyring seals secrets with a random key for the length of the test.
+func useKeyring(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	keys, err := db.NewKeyring("test", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.Keys
+	db.Keys = keys
+	t.Cleanup(func() { db.Keys = previous })
+}
+
+func TestHolderCycleAndHarvest(t *testing.T) {
+	const (
+		policy = "e633efbf5e4a0c5bd1e6c3d8bdcd82ea93ad6c4dc0b1a1a7c0f1a2b3"
+		crop   = policy + "43524f50"
+		seed   = policy + "53454544"
+	)
+	ctx := context.Background()
+	f, cli := chain.UseFixtureT(t)
+	cardano.Builder = &cardano.CLIBuilder{}
+	chain.Submitters = chain.NewSubmitFailover(&chain.Node{})
+	useKeyring(t)
+	current := holdings
+	holdings = fixtureHoldings{f: f, addresses: new(int)}
+	t.Cleanup(func() { holdings = current })
+
+	farm, farmKey := testAddress(t)
+	sealed, err := db.Encrypt(farmKey.Envelope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _ := testAddress(t)
+	carol, _ := testAddress(t)
+	for n, u := range []chain.UTxO{
+		{Address: farm, Lovelace: 20_000_000, Assets: map[string]uint64{crop: 10_000}},
+		{Address: alice, Lovelace: 2_000_000, Assets: map[string]uint64{seed: 100}},
+		{Address: carol, Lovelace: 2_000_000, Assets: map[string]uint64{seed: 400}},
+	} {
+		u.TxHash = fmt.Sprintf("%064x", n+1)
+		f.AddUTxO(u)
+	}
+
+	reward := cv.Asset(policy + ".43524f50")
+	cv.Config{
+		GuildID: "guild",
+		Wallet:  cardano.Keys{Address: farm, SigningPaymentKey: sealed},
+		Rewards: []cv.Reward{{Name: "crop", RewardToken: reward, AssetsEligible: []string{seed}, Balance: 10_000, GuildID: "guild"}},
+	}.Save()
+	cv.User{ID: "alice", LinkedWallets: []cv.Wallet{{Payment: alice}}}.Save()
+	// carol holds seed too but is not in the guild
+	cv.User{ID: "carol", LinkedWallets: []cv.Wallet{{Payment: carol}}}.Save()
+
+	runHolderCycle(ctx, guildMembers{"guild": {"alice"}})
+
+	// alice holds 100 of the 500 seed held
+	user := cv.LoadUser("alice")
+	if earned := user.Rewards["guild"][reward].Earned; earned != 2_000 {
+		t.Fatalf("alice earned %d, want 2000", earned)
+	}
+	if _, ok := cv.LoadUser("carol").Rewards["guild"]; ok {
+		t.Error("carol earned rewards outside the guild")
+	}
+	if balance := cv.LoadConfig("guild").Rewards[0].Balance; balance != 8_000 {
+		t.Errorf("reward balance %d, want 8000", balance)
+	}
+	if len(cli.Calls) != 0 {
+		t.Errorf("the reward cycle ran cardano-cli %d times", len(cli.Calls))
+	}
+
+	harvests, err := user.HarvestRewards(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(harvests) != 1 || harvests[0].Assets[reward] != 2_000 {
+		t.Fatalf("harvests %+v", harvests)
+	}
+
+	if got, want := cli.TxSubcommands(), []string{"build", "txid", "sign", "submit"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cardano-cli ran transaction %v, want %v", got, want)
+	}
+	build := strings.Join(cli.Recorded("transaction build")[0].Args, " ")
+	if !strings.Contains(build, "--change-address "+farm) || !strings.Contains(build, "+2000 "+policy+".43524f50") {
+		t.Errorf("build does not pay the harvest from the farm wallet: %s", build)
+	}
+	if !cli.Recorded("transaction sign")[0].HasStdin {
+		t.Error("the farm key was not passed over stdin")
+	}
+
+	tx := f.Transactions[harvests[0].TxHash]
+	if tx == nil {
+		t.Fatalf("harvest %s is not on the ledger", harvests[0].TxHash)
+	}
+	paid := make(map[string]uint64)
+	for _, out := range tx.Outputs {
+		paid[out.Address] += out.Assets[crop]
+	}
+	if paid[alice] != 2_000 || paid[farm] != 8_000 {
+		t.Errorf("harvest paid %v", paid)
+	}
+	if earned := cv.LoadUser("alice").Rewards["guild"][reward].Earned; earned != 0 {
+		t.Errorf("alice still has %d earned after the harvest", earned)
+	}
+}
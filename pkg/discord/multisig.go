@@ -0,0 +1,216 @@
+package discord
+
+import (
+	"cardano-valley/pkg/confirm"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  MULTISIG: approval requests for payouts from the farm's script address
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// How often approvals past their validity are closed
+const multisigExpiryInterval = 30 * time.Minute
+
+func multisigStatusEmbed(guildID string, m cv.Multisig) *discordgo.MessageEmbed {
+	var signers, registered strings.Builder
+	scriptSigners := 0
+	for _, s := range m.Signers {
+		fmt.Fprintf(&registered, "<@%s> `%s…`\n", s.UserID, s.KeyHash[:16])
+		if signer, ok := m.Signer(s.UserID); ok {
+			fmt.Fprintf(&signers, "<@%s> `%s…`\n", signer.UserID, signer.KeyHash[:16])
+			scriptSigners++
+		}
+	}
+
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Registered keys", Value: registered.String()},
+	}
+	if m.Enabled() {
+		var thresholds strings.Builder
+		fmt.Fprintf(&thresholds, "%.6f ADA\n", float64(m.Threshold)/1_000_000)
+		for unit, qty := range m.AssetThresholds {
+			fmt.Fprintf(&thresholds, "%d `%s…`\n", qty, unit[:16])
+		}
+		thresholds.WriteString("every other token")
+		fields = append(fields,
+			&discordgo.MessageEmbedField{Name: "Address", Value: "`" + m.Address + "`"},
+			&discordgo.MessageEmbedField{Name: "Script signers", Value: signers.String()},
+			&discordgo.MessageEmbedField{Name: "Required", Value: fmt.Sprintf("the bot + %d of %d admins", m.Required, scriptSigners), Inline: true},
+			&discordgo.MessageEmbedField{Name: "Threshold", Value: thresholds.String(), Inline: true},
+			&discordgo.MessageEmbedField{Name: "Approvals", Value: "<#" + m.ChannelID + ">", Inline: true},
+		)
+	}
+
+	var pending []string
+	for _, p := range cv.LoadMultisigTxs(cv.MultisigAwaiting) {
+		if string(p.GuildID) == guildID {
+			pending = append(pending, fmt.Sprintf("`%s…` %d/%d signed", p.TxHash[:16], len(p.Signed), m.Required))
+		}
+	}
+	if len(pending) > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Pending", Value: strings.Join(pending, "\n")})
+	}
+
+	description := "Payouts at or above the threshold are paid from the address and need admin approval. Fund it to use it."
+	if !m.Enabled() {
+		description = "Not set up yet, run `/farm-multisig setup` once every co-signing admin has registered."
+	}
+	return &discordgo.MessageEmbed{
+		Title:       "Farm Multisig",
+		Description: description,
+		Color:       0x3aa657,
+		Fields:      fields,
+	}
+}
+
+func multisigTxEmbed(p cv.MultisigTx) *discordgo.MessageEmbed {
+	var outputs strings.Builder
+	for _, out := range p.Outputs {
+		fmt.Fprintf(&outputs, "`%s`\n%.6f ADA", out.Address, float64(out.Lovelace)/1_000_000)
+		for unit, qty := range out.Assets {
+			fmt.Fprintf(&outputs, ", %d `%s…`", qty, unit[:16])
+		}
+		outputs.WriteString("\n")
+	}
+
+	mention := func(ids []string) string {
+		if len(ids) == 0 {
+			return "—"
+		}
+		out := make([]string, len(ids))
+		for n, id := range ids {
+			out[n] = "<@" + id + ">"
+		}
+		return strings.Join(out, " ")
+	}
+
+	color := 0x3aa657
+	status := "Awaiting approval"
+	switch p.Status {
+	case cv.MultisigSubmitted:
+		status = "✅ Submitted"
+	case cv.MultisigRejected:
+		status, color = "❌ Rejected", 0xd9534f
+	case cv.MultisigExpired:
+		status, color = "⌛ Expired", 0xd9534f
+	}
+
+	return &discordgo.MessageEmbed{
+		Title:       "Payout Approval",
+		Description: p.Description,
+		Color:       color,
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Transaction", Value: "`" + p.TxHash + "`"},
+			{Name: "Outputs", Value: outputs.String()},
+			{Name: "Signed", Value: mention(p.Signed), Inline: true},
+			{Name: "Rejected", Value: mention(p.Rejected), Inline: true},
+			{Name: "Status", Value: status, Inline: true},
+		},
+		Timestamp: p.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func multisigButtons(p cv.MultisigTx) []discordgo.MessageComponent {
+	if p.Status != cv.MultisigAwaiting {
+		return []discordgo.MessageComponent{}
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Get Transaction",
+					Style:    discordgo.SecondaryButton,
+					CustomID: MULTISIG_TX_COMPONENT_NAME + "_" + p.TxHash,
+				},
+				discordgo.Button{
+					Label:    "Sign",
+					Style:    discordgo.SuccessButton,
+					CustomID: MULTISIG_SIGN_COMPONENT_NAME + "_" + p.TxHash,
+				},
+				discordgo.Button{
+					Label:    "Reject",
+					Style:    discordgo.DangerButton,
+					CustomID: MULTISIG_REJECT_COMPONENT_NAME + "_" + p.TxHash,
+				},
+			},
+		},
+	}
+}
+
+// postMultisigApproval asks the admins to approve a payout in the guild's
+// approval channel.
+func postMultisigApproval(s *discordgo.Session, p cv.MultisigTx) {
+	if p.ChannelID == "" {
+		logger.Record.Warn("MULTISIG", "TX", p.TxHash, "ERROR", "no approval channel")
+		return
+	}
+	msg, err := s.ChannelMessageSendComplex(p.ChannelID, &discordgo.MessageSend{
+		Content:    "A payout needs your signatures.",
+		Embeds:     []*discordgo.MessageEmbed{multisigTxEmbed(p)},
+		Components: multisigButtons(p),
+	})
+	if err != nil {
+		logger.Record.Warn("MULTISIG", "TX", p.TxHash, "CHANNEL", p.ChannelID, "ERROR", err)
+		return
+	}
+	p.MessageID = msg.ID
+	p.Save()
+}
+
+// refreshMultisigMessage shows the latest signatures, and drops the buttons
+// once the tx is no longer open.
+func refreshMultisigMessage(s *discordgo.Session, p cv.MultisigTx) {
+	if p.ChannelID == "" || p.MessageID == "" {
+		return
+	}
+	embeds := []*discordgo.MessageEmbed{multisigTxEmbed(p)}
+	components := multisigButtons(p)
+	_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:         p.MessageID,
+		Channel:    p.ChannelID,
+		Embeds:     &embeds,
+		Components: &components,
+	})
+	if err != nil {
+		logger.Record.Warn("MULTISIG", "TX", p.TxHash, "MESSAGE", p.MessageID, "ERROR", err)
+	}
+}
+
+// notifyMultisigClosed tells whoever was being paid that the payout will not
+// happen.
+func notifyMultisigClosed(s *discordgo.Session, p cv.MultisigTx) {
+	switch p.Purpose {
+	case confirm.PurposeHarvest:
+		reason := "was rejected by the admins"
+		if p.Status == cv.MultisigExpired {
+			reason = "was not approved in time"
+		}
+		sendDM(s, p.Reference, fmt.Sprintf("⚠️ Your harvest `%s` %s. The rewards are back in your balance.", p.TxHash, reason))
+	}
+}
+
+// multisigExpiry closes approvals whose tx can no longer make it on-chain.
+func multisigExpiry(ctx context.Context) {
+	for {
+		expired, err := cv.ExpireMultisigTxs(ctx)
+		if err != nil {
+			logger.Record.Warn("MULTISIG", "EXPIRY", err)
+		}
+		for _, p := range expired {
+			logger.Record.Info("MULTISIG", "TX", p.TxHash, "STATUS", p.Status)
+			refreshMultisigMessage(S, p)
+			notifyMultisigClosed(S, p)
+		}
+		time.Sleep(multisigExpiryInterval)
+	}
+}
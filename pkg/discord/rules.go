@@ -0,0 +1,123 @@
+package discord
+
+import (
+	"cardano-valley/pkg/blockfrost"
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  REWARD RULES: gathering what rules are checked against
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// ruleFacts gathers the facts for reward rules, caching delegation lookups
+// for the cycle it is made for.
+type ruleFacts struct {
+	owners   map[string]string
+	holdings map[string]map[string]uint64 // userID -> unit -> quantity
+	epoch    int
+	since    map[string]int // stake address + pool ID -> first epoch delegated
+}
+
+func newRuleFacts(ctx context.Context, owners map[string]string, holdings map[string]map[string]uint64) *ruleFacts {
+	f := &ruleFacts{owners: owners, holdings: holdings, since: make(map[string]int)}
+	if tip, err := chain.Current.Tip(ctx); err == nil {
+		f.epoch = tip.Epoch
+	} else {
+		logger.Record.Warn("RULES", "TIP", err)
+	}
+	return f
+}
+
+// rulesUse reports whether any reward rule in configs checks op.
+func rulesUse(configs cv.Configs, op cv.RuleOp) bool {
+	for _, config := range configs {
+		for _, reward := range config.Rewards {
+			if reward.Rule != nil && reward.Rule.Uses(op) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *ruleFacts) For(ctx context.Context, member *discordgo.Member, user cv.User, rule cv.Rule) cv.Facts {
+	wallets := user.ClaimedWallets(f.owners)
+	facts := cv.Facts{
+		Holdings:  f.holdings[user.ID],
+		Delegated: make(map[string]int),
+		Wallets:   len(wallets),
+	}
+	if member != nil {
+		facts.Roles = member.Roles
+	}
+	if created, err := discordgo.SnowflakeTimestamp(user.ID); err == nil {
+		facts.AccountAge = time.Since(created)
+	}
+
+	pools := rule.Pools()
+	if len(pools) == 0 || f.epoch == 0 {
+		return facts
+	}
+	// only a stake key that signed proves whose delegation it is
+	var stakes []string
+	for _, w := range wallets {
+		if !w.StakeVerified {
+			continue
+		}
+		addr, err := cardano.ParseAddress(w.Payment)
+		if err != nil {
+			continue
+		}
+		if stake, _ := addr.StakeAddress(); stake != "" && !slices.Contains(stakes, stake) {
+			stakes = append(stakes, stake)
+		}
+	}
+	for _, pool := range pools {
+		for _, stake := range stakes {
+			since, ok := f.since[stake+pool]
+			if !ok {
+				if epoch, err := blockfrost.EpochsDelegatedToPool(ctx, stake, pool); err == nil && epoch != nil {
+					since = *epoch
+				}
+				f.since[stake+pool] = since
+			}
+			// delegation counts from the epoch it became active
+			if since > 0 && since <= f.epoch {
+				facts.Delegated[pool] = max(facts.Delegated[pool], f.epoch-since+1)
+			}
+		}
+	}
+	return facts
+}
+
+// formatRuleResult explains a rule result line by line, one level of
+// indentation per nested rule.
+func formatRuleResult(res cv.RuleResult, depth int, lines *[]string) {
+	mark := "❌"
+	if res.Pass {
+		mark = "✅"
+	}
+	line := fmt.Sprintf("%s%s %s", strings.Repeat("　", depth), mark, res.Rule.Describe())
+	if res.Detail != "" {
+		line += fmt.Sprintf(" (%s)", res.Detail)
+	}
+	if res.Rule.Multiplier > 0 && res.Rule.Multiplier != 1 {
+		line += fmt.Sprintf(" ×%g", res.Rule.Multiplier)
+	}
+	*lines = append(*lines, line)
+	for _, sub := range res.Results {
+		formatRuleResult(sub, depth+1, lines)
+	}
+}
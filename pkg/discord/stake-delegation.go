@@ -0,0 +1,130 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/confirm"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  STAKE DELEGATION: register and delegate custodial wallets once they are funded
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+const (
+	// How often undelegated farm and user wallets are checked
+	stakeDelegationInterval = 6 * time.Hour
+
+	// Lovelace a wallet keeps on top of the deposit before it is delegated,
+	// enough for the fee and a change output
+	stakeDelegationReserve = 2_000_000
+)
+
+func farmDelegationReference(guildID string) string { return "guild:" + guildID }
+
+func userDelegationReference(userID string) string { return "user:" + userID }
+
+// stakeDelegator delegates every farm and user wallet that has no delegation
+// yet to the network's default pool. Farms whose admins picked a pool with
+// /farm-delegate already have one and are left alone.
+func stakeDelegator(ctx context.Context) {
+	if network.Current.PoolID == "" {
+		logger.Record.Warn("DELEGATION", "NETWORK", network.Current.Name, "SKIPPED", "no default pool, only /farm-delegate delegates")
+	}
+	for {
+		delegateWallets(ctx)
+		time.Sleep(stakeDelegationInterval)
+	}
+}
+
+func delegateWallets(ctx context.Context) {
+	if network.Current.PoolID == "" {
+		return
+	}
+
+	for _, config := range cv.LoadConfigs() {
+		if config.Wallet.Address == "" || config.Delegation.PoolID != "" {
+			continue
+		}
+		delegation := delegateFunded(ctx, config.Wallet, network.Current.PoolID, farmDelegationReference(string(config.GuildID)))
+		if delegation != nil {
+			config.Delegation = *delegation
+			config.Save()
+		}
+	}
+
+	for _, user := range cv.LoadUsers() {
+		if user.Wallet.Address == "" || user.Delegation.PoolID != "" {
+			continue
+		}
+		delegation := delegateFunded(ctx, user.Wallet, network.Current.PoolID, userDelegationReference(user.ID))
+		if delegation != nil {
+			user.Delegation = *delegation
+			user.SaveDelegation()
+		}
+	}
+}
+
+// delegateFunded delegates the wallet when it holds enough for the deposit.
+// A wallet that is already delegated to the pool is recorded as such.
+func delegateFunded(ctx context.Context, wallet cardano.Keys, poolID, reference string) *cv.Delegation {
+	l := logger.Record.WithGroup("STAKE DELEGATION").With("WALLET", reference)
+
+	utxos, err := cardano.QueryUTxOs(ctx, wallet.Address)
+	if err != nil {
+		l.Warn("could not query wallet", "ERROR", err)
+		return nil
+	}
+	if utxos.Lovelace() < cardano.Params.StakeAddressDeposit+stakeDelegationReserve {
+		return nil
+	}
+
+	delegation, err := cv.DelegateWallet(ctx, wallet, poolID, reference)
+	if errors.Is(err, cv.ErrAlreadyDelegated) {
+		return &cv.Delegation{PoolID: poolID}
+	}
+	if err != nil {
+		l.Error("delegation failed", "POOL", poolID, "ERROR", err)
+		return nil
+	}
+	l.Info("delegation submitted", "POOL", delegation.PoolID, "TX", delegation.TxHash)
+	return delegation
+}
+
+// onDelegationTxEvent forgets a delegation whose tx was dropped, so the next
+// cycle or /farm-delegate submits it again.
+func onDelegationTxEvent() func(confirm.Event) {
+	return func(e confirm.Event) {
+		if e.Tx.Purpose != confirm.PurposeDelegation || e.Status != confirm.StatusDropped {
+			return
+		}
+
+		kind, id, _ := strings.Cut(e.Tx.Reference, ":")
+		switch kind {
+		case "guild":
+			config := cv.LoadConfig(id)
+			if config.Delegation.TxHash != e.Tx.TxHash {
+				return
+			}
+			config.Delegation = cv.Delegation{}
+			config.Save()
+		case "user":
+			user := cv.LoadUser(id)
+			if user.Delegation.TxHash != e.Tx.TxHash {
+				return
+			}
+			user.Delegation = cv.Delegation{}
+			user.SaveDelegation()
+		default:
+			return
+		}
+		logger.Record.Warn("DELEGATION", "DROPPED", e.Tx.TxHash, "WALLET", e.Tx.Reference)
+	}
+}
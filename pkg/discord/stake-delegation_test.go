@@ -0,0 +1,99 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/chain"
+	"cardano-valley/pkg/confirm"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/db"
+	"cardano-valley/pkg/network"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// custodialWallet is a user wallet with sealed payment and stake keys, as
+// /register creates them.
+func custodialWallet(t *testing.T) cardano.Keys {
+	t.Helper()
+	seal := func(envelope string) string {
+		sealed, err := db.Encrypt(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	payment, err := cardano.NewSigningKey(cardano.PaymentSigningKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stake, err := cardano.NewSigningKey(cardano.StakeSigningKeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := cardano.BaseAddress(payment.Public(), stake.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cardano.Keys{
+		Address:           address,
+		PaymentKey:        seal(payment.VerificationEnvelope()),
+		SigningPaymentKey: seal(payment.Envelope()),
+		StakeKey:          seal(stake.VerificationEnvelope()),
+		SigningStakeKey:   seal(stake.Envelope()),
+	}
+}
+
+func TestDelegateUserWallets(t *testing.T) {
+	f, _ := chain.UseFixtureT(t)
+	useKeyring(t)
+	pool := network.Current.PoolID
+	network.Current.PoolID = "pool19peeq2czwunkwe3s70yuvwpsrqcyndlqnxvt67usz98px57z7fk"
+	t.Cleanup(func() { network.Current.PoolID = pool })
+
+	funded, unfunded := custodialWallet(t), custodialWallet(t)
+	balance := cardano.Params.StakeAddressDeposit + stakeDelegationReserve
+	f.AddUTxO(chain.UTxO{TxHash: fmt.Sprintf("%064x", 1), Address: funded.Address, Lovelace: balance})
+	f.AddUTxO(chain.UTxO{TxHash: fmt.Sprintf("%064x", 2), Address: unfunded.Address, Lovelace: balance - 1})
+	cv.User{ID: "alice", Wallet: funded}.Save()
+	cv.User{ID: "bob", Wallet: unfunded}.Save()
+
+	delegateWallets(context.Background())
+
+	alice := cv.LoadUser("alice")
+	if !alice.Delegation.Registered || alice.Delegation.PoolID != network.Current.PoolID {
+		t.Fatalf("alice's delegation %+v", alice.Delegation)
+	}
+	tx := f.Transactions[alice.Delegation.TxHash]
+	if tx == nil {
+		t.Fatalf("delegation %s is not on the ledger", alice.Delegation.TxHash)
+	}
+	// the ledger counts what the outputs do not return as fee, the deposit
+	// is paid from the wallet on top of the actual fee
+	if tx.Fee <= cardano.Params.StakeAddressDeposit {
+		t.Errorf("the wallet paid %d lovelace, less than the deposit", tx.Fee)
+	}
+	if kept := balances(f)[funded.Address]; kept != balance-tx.Fee {
+		t.Errorf("alice's wallet kept %d lovelace", kept)
+	}
+	if bob := cv.LoadUser("bob"); bob.Delegation.TxHash != "" {
+		t.Errorf("bob's wallet cannot pay the deposit but was delegated in %s", bob.Delegation.TxHash)
+	}
+
+	// rewards credited since the cycle loaded alice survive the dropped tx
+	if err := cv.CreditRewards(context.Background(), "alice", "guild", map[cv.Asset]uint64{"policy.crop": 5}); err != nil {
+		t.Fatal(err)
+	}
+	onDelegationTxEvent()(confirm.Event{Status: confirm.StatusDropped, Tx: confirm.TrackedTx{
+		TxHash:    alice.Delegation.TxHash,
+		Purpose:   confirm.PurposeDelegation,
+		Reference: userDelegationReference("alice"),
+	}})
+	alice = cv.LoadUser("alice")
+	if alice.Delegation.TxHash != "" {
+		t.Errorf("the dropped delegation %s is still recorded", alice.Delegation.TxHash)
+	}
+	if alice.Rewards["guild"]["policy.crop"].Earned != 5 {
+		t.Error("forgetting the delegation dropped alice's rewards")
+	}
+}
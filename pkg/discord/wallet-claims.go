@@ -0,0 +1,119 @@
+package discord
+
+import (
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────────────
+//  WALLET CLAIMS: one Discord account per wallet
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// walletClaimBackfill creates the claims and ledger entries of wallets
+// linked before they existed, retrying until the database is up.
+func walletClaimBackfill(ctx context.Context) {
+	for {
+		err := cv.BackfillWalletClaims(ctx)
+		if err == nil {
+			err = cv.BackfillWalletLedger(ctx)
+		}
+		if err == nil {
+			return
+		}
+		logger.Record.Warn("WALLET CLAIMS", "BACKFILL", err)
+		time.Sleep(time.Minute)
+	}
+}
+
+// onWalletContest tells the accounts that lost a wallet to a newer proof.
+func onWalletContest(s *discordgo.Session) func(cv.WalletContest) {
+	return func(c cv.WalletContest) {
+		logger.Record.Info("WALLET CLAIMS", "KEY", c.Key, "OUTCOME", c.Outcome, "FROM", c.From, "TO", c.To)
+		if c.Outcome != cv.ContestTransferred {
+			return
+		}
+		for _, id := range c.From {
+			sendDM(s, id, fmt.Sprintf("⚠️ Your linked wallet `%s` was linked to another Discord account, which proved it owns the wallet more recently. "+
+				"It was unlinked from your account and no longer earns you rewards. If this wallet is yours, link it again with `/link-wallet`.", c.Payment))
+		}
+	}
+}
+
+// contestedWalletsEmbed reports the contests that involve members of the
+// guild, and the wallets still waiting for one of their accounts to prove
+// them.
+func contestedWalletsEmbed(s *discordgo.Session, guildID string) *discordgo.MessageEmbed {
+	members := make(map[string]bool)
+	inGuild := func(ids ...string) bool {
+		for _, id := range ids {
+			member, ok := members[id]
+			if !ok {
+				_, err := s.GuildMember(guildID, id)
+				member = err == nil
+				members[id] = member
+			}
+			if member {
+				return true
+			}
+		}
+		return false
+	}
+	mentions := func(ids []string) string {
+		var m []string
+		for _, id := range ids {
+			m = append(m, "<@"+id+">")
+		}
+		return strings.Join(m, ", ")
+	}
+
+	var unresolved []string
+	for _, c := range cv.LoadContestedClaims() {
+		if inGuild(c.Contenders...) {
+			unresolved = append(unresolved, fmt.Sprintf("`%s` %s", cv.TruncateMiddle(c.Key, 32), mentions(c.Contenders)))
+		}
+	}
+	var history []string
+	for _, c := range cv.LoadWalletContests(100) {
+		if c.Outcome == cv.ContestUnresolved || !inGuild(append(c.From, c.To)...) {
+			continue
+		}
+		line := fmt.Sprintf("<t:%d:d> `%s` %s", c.At.Unix(), cv.TruncateMiddle(c.Payment, 32), mentions(c.From))
+		switch c.Outcome {
+		case cv.ContestTransferred:
+			line += fmt.Sprintf(" → <@%s> (%s)", c.To, c.Method)
+		case cv.ContestRefused:
+			line += fmt.Sprintf(" kept it, <@%s> was refused", c.To)
+		}
+		history = append(history, line)
+	}
+
+	field := func(name string, lines []string) *discordgo.MessageEmbedField {
+		count := len(lines)
+		if count > 15 {
+			lines = append(lines[:15], fmt.Sprintf("…and %d more", len(lines)-15))
+		}
+		value := strings.Join(lines, "\n")
+		if value == "" {
+			value = "None"
+		}
+		return &discordgo.MessageEmbedField{Name: fmt.Sprintf("%s (%d)", name, count), Value: value}
+	}
+	return &discordgo.MessageEmbed{
+		Title: "Contested Wallets",
+		Description: "A wallet can only be linked to one Discord account. The newest proof of ownership wins. " +
+			"Unresolved wallets were linked to several accounts before this was enforced and earn no holder rewards until one of them links it again.",
+		Color: 0x3aa657,
+		Fields: []*discordgo.MessageEmbedField{
+			field("Unresolved", unresolved),
+			field("Recent Contests", history),
+		},
+	}
+}
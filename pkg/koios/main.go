@@ -2,6 +2,7 @@ package koios
 
 import (
 	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
 	"context"
 	"encoding/json"
 	"errors"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/cardano-community/koios-go-client/v4"
 )
@@ -20,13 +22,13 @@ import (
 var ( 
 	client *koios.Client
 	koiosToken string
-	KOIOS_URL = "https://api.koios.rest/api/v1/"
+	KOIOS_URL = network.Current.KoiosURL()
 )
 type EpochNo koios.EpochNo
 
 func init() {
 	var err error
-	client, err = koios.New()
+	client, err = koios.New(koios.Host(network.Current.KoiosHost))
 	if err != nil {
 		slog.Error("could not connect koios api", "ERROR", err)
 	}
@@ -87,7 +89,7 @@ func loadKoiosToken() string {
 
 func init() {
 	var err error
-	client, err = koios.New()
+	client, err = koios.New(koios.Host(network.Current.KoiosHost))
 	if err != nil {
 		slog.Error("could not connect koios api", "ERROR", err)
 	}
@@ -242,32 +244,50 @@ func GetPolicyAssetList(ctx context.Context, policyID string) ([]koios.PolicyAss
 }
 
 func GetBatchedStakeAddressAssets(ctx context.Context, stakeAddresses []string) (map[string]uint64, error) {
-	const batchSize = 100 // Koios allows up to 100 addresses per call
+	holdings, err := GetStakeAddressHoldings(ctx, stakeAddresses)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge results
 	allAssets := make(map[string]uint64)
-	
+	for _, assets := range holdings {
+		for unit, quantity := range assets {
+			allAssets[unit] += quantity
+		}
+	}
+
+	return allAssets, nil
+}
+
+// GetStakeAddressHoldings returns the native assets held under each stake
+// address, across every payment address of the wallet.
+func GetStakeAddressHoldings(ctx context.Context, stakeAddresses []string) (map[string]map[string]uint64, error) {
+	const batchSize = 100 // Koios allows up to 100 addresses per call
+	holdings := make(map[string]map[string]uint64)
+
 	// Process in batches of 100
 	for i := 0; i < len(stakeAddresses); i += batchSize {
 		end := i + batchSize
 		if end > len(stakeAddresses) {
 			end = len(stakeAddresses)
 		}
-		
+
 		batch := stakeAddresses[i:end]
-		batchAssets, err := getBatchStakeAssets(ctx, batch)
+		batchHoldings, err := getBatchStakeAssets(ctx, batch)
 		if err != nil {
 			return nil, err
 		}
-		
-		// Merge results
-		for unit, quantity := range batchAssets {
-			allAssets[unit] += quantity
+
+		for stake, assets := range batchHoldings {
+			holdings[stake] = assets
 		}
 	}
-	
-	return allAssets, nil
+
+	return holdings, nil
 }
 
-func getBatchStakeAssets(ctx context.Context, stakeAddresses []string) (map[string]uint64, error) {
+func getBatchStakeAssets(ctx context.Context, stakeAddresses []string) (map[string]map[string]uint64, error) {
 	// Convert stake addresses to koios.Address slice for batched call
 	koiosStakeAddrs := make([]koios.Address, len(stakeAddresses))
 	for i, stake := range stakeAddresses {
@@ -286,15 +306,19 @@ func getBatchStakeAssets(ctx context.Context, stakeAddresses []string) (map[stri
 	}
 	
 	// Collect all addresses from all stake addresses
+	holdings := make(map[string]map[string]uint64)
+	stakeOf := make(map[string]string) // address -> stake address
 	var allAddresses []string
 	for _, accountAddr := range result.Data {
+		holdings[string(accountAddr.StakeAddress)] = make(map[string]uint64)
 		for _, addr := range accountAddr.Addresses {
+			stakeOf[string(addr)] = string(accountAddr.StakeAddress)
 			allAddresses = append(allAddresses, string(addr))
 		}
 	}
 	
 	if len(allAddresses) == 0 {
-		return make(map[string]uint64), nil
+		return holdings, nil
 	}
 	
 	// ✅ Batched calls to get address info for all addresses (batch by 100)
@@ -303,18 +327,44 @@ func getBatchStakeAssets(ctx context.Context, stakeAddresses []string) (map[stri
 		return nil, err
 	}
 	
-	// Convert to asset map
-	assets := make(map[string]uint64)
+	// Convert to asset maps per stake address
 	for _, addrInfo := range addressInfos {
+		stake, ok := stakeOf[string(addrInfo.Address)]
+		if !ok {
+			continue
+		}
 		for _, utxo := range addrInfo.UTxOs {
 			for _, asset := range utxo.AssetList {
 				qty, _ := strconv.ParseUint(asset.Quantity.String(), 10, 64)
-				assets[string(asset.PolicyID)+string(asset.AssetName)] += qty
+				holdings[stake][string(asset.PolicyID)+string(asset.AssetName)] += qty
 			}
 		}
 	}
 	
-	return assets, nil
+	return holdings, nil
+}
+
+// GetAddressHoldings returns the native assets held at each address, looked
+// up in batches.
+func GetAddressHoldings(ctx context.Context, addresses []string) (map[string]map[string]uint64, error) {
+	addressInfos, err := getBatchedAddressInformation(ctx, addresses)
+	if err != nil {
+		return nil, err
+	}
+
+	holdings := make(map[string]map[string]uint64)
+	for _, addrInfo := range addressInfos {
+		assets := make(map[string]uint64)
+		for _, utxo := range addrInfo.UTxOs {
+			for _, asset := range utxo.AssetList {
+				qty, _ := strconv.ParseUint(asset.Quantity.String(), 10, 64)
+				assets[string(asset.PolicyID)+string(asset.AssetName)] += qty
+			}
+		}
+		holdings[string(addrInfo.Address)] = assets
+	}
+
+	return holdings, nil
 }
 
 func getBatchedAddressInformation(ctx context.Context, addresses []string) ([]koios.AddressInfo, error) {
@@ -390,4 +440,103 @@ func GetPolicyHolders(policyID string) (map[string]uint64, error) {
 	}
 
 	return all, nil
-}
\ No newline at end of file
+}
+
+// GetAssetsByFingerprint resolves asset fingerprints (asset1...) to their
+// policy ID and hex asset name.
+func GetAssetsByFingerprint(ctx context.Context, fingerprints []string) ([]koios.AssetListItem, error) {
+	var all []koios.AssetListItem
+	const batchSize = 50
+	client := &http.Client{}
+
+	for i := 0; i < len(fingerprints); i += batchSize {
+		end := i + batchSize
+		if end > len(fingerprints) {
+			end = len(fingerprints)
+		}
+
+		//curl -X GET "https://api.koios.rest/api/v1/asset_list?fingerprint=in.(asset1...,asset1...)" -H "accept: application/json"
+		endpoint, _ := url.Parse(fmt.Sprintf("%sasset_list", KOIOS_URL))
+		q := endpoint.Query()
+		q.Set("fingerprint", fmt.Sprintf("in.(%s)", strings.Join(fingerprints[i:end], ",")))
+		endpoint.RawQuery = q.Encode()
+		slog.Info("Fetching Koios asset list", "URL", endpoint.String())
+
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("API error: %s", string(data))
+		}
+
+		var page []koios.AssetListItem
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+	}
+
+	return all, nil
+}
+
+// GetAssetHolders returns the payment addresses holding a single asset.
+func GetAssetHolders(ctx context.Context, policyID, assetName string) (map[string]uint64, error) {
+	all := make(map[string]uint64)
+	offset := 0
+	client := &http.Client{}
+
+	for {
+		//curl -X GET "https://api.koios.rest/api/v1/asset_addresses?_asset_policy=...&_asset_name=..." -H "accept: application/json"
+		endpoint, _ := url.Parse(fmt.Sprintf("%sasset_addresses?_asset_policy=%s&_asset_name=%s&offset=%d", KOIOS_URL, policyID, assetName, offset))
+		slog.Info("Fetching Koios asset addresses", "URL", endpoint.String())
+
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("API error: %s", string(data))
+		}
+
+		var page []koios.AssetHolder
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, err
+		}
+
+		for _, holder := range page {
+			qty, _ := strconv.ParseUint(holder.Quantity.String(), 10, 64)
+			all[holder.PaymentAddress.String()] += qty
+		}
+
+		if len(page) == 1000 {
+			offset += 1000
+		} else {
+			break
+		}
+	}
+
+	return all, nil
+}
@@ -3,6 +3,7 @@ package maestro
 
 import (
 	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -16,7 +17,7 @@ import (
 )
 
 var (
-	MAESTRO_URL = "https://mainnet.gomaestro-api.org/v1/"
+	MAESTRO_URL = network.Current.MaestroURL()
 	maestroClient *client.Client
 	maestroToken  string
 	httpClient    *http.Client
@@ -66,7 +67,7 @@ func loadMaestroToken() string {
 func init() {
 	maestroToken = loadMaestroToken()
 	if maestroToken != "" {
-		maestroClient = client.NewClient(maestroToken, "mainnet")
+		maestroClient = client.NewClient(maestroToken, network.Current.MaestroNetwork)
 		httpClient = &http.Client{}
 		slog.Info("Maestro client initialized successfully")
 	} else {
@@ -0,0 +1,125 @@
+// Package network holds the Cardano network the bot runs against. Everything
+// that used to assume mainnet (cardano-cli flags, provider URLs, address
+// prefixes and the delegation pool) reads from Current instead.
+package network
+
+import (
+	"cardano-valley/pkg/logger"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Network struct {
+	Name           string
+	Magic          uint32 // Protocol magic, only passed to cardano-cli on testnets
+	NetworkID      byte   // Network ID in Shelley address headers
+	AddressPrefix  string
+	StakePrefix    string
+	BlockfrostURL  string
+	KoiosHost      string
+	MaestroNetwork string
+	PoolID         string // Pool new wallets delegate to
+}
+
+const (
+	Mainnet = "mainnet"
+	Preprod = "preprod"
+	Preview = "preview"
+)
+
+var (
+	networks = map[string]Network{
+		Mainnet: {
+			Name:           Mainnet,
+			Magic:          764824073,
+			NetworkID:      1,
+			AddressPrefix:  "addr",
+			StakePrefix:    "stake",
+			BlockfrostURL:  "https://cardano-mainnet.blockfrost.io/api/v0",
+			KoiosHost:      "api.koios.rest",
+			MaestroNetwork: "mainnet",
+			PoolID:         "pool19peeq2czwunkwe3s70yuvwpsrqcyndlqnxvt67usz98px57z7fk", // PREEB
+		},
+		Preprod: {
+			Name:           Preprod,
+			Magic:          1,
+			NetworkID:      0,
+			AddressPrefix:  "addr_test",
+			StakePrefix:    "stake_test",
+			BlockfrostURL:  "https://cardano-preprod.blockfrost.io/api/v0",
+			KoiosHost:      "preprod.koios.rest",
+			MaestroNetwork: "preprod",
+		},
+		Preview: {
+			Name:           Preview,
+			Magic:          2,
+			NetworkID:      0,
+			AddressPrefix:  "addr_test",
+			StakePrefix:    "stake_test",
+			BlockfrostURL:  "https://cardano-preview.blockfrost.io/api/v0",
+			KoiosHost:      "preview.koios.rest",
+			MaestroNetwork: "preview",
+		},
+	}
+
+	// Current is selected by CARDANO_VALLEY_NETWORK and defaults to mainnet
+	// when it is unset
+	Current Network
+)
+
+func init() {
+	name, ok := os.LookupEnv("CARDANO_VALLEY_NETWORK")
+	if !ok || name == "" {
+		name = Mainnet
+	}
+
+	// never fall back to mainnet, a typo would put a test bot on real funds
+	n, err := Lookup(name)
+	if err != nil {
+		log.Fatalf("Invalid CARDANO_VALLEY_NETWORK: %v", err)
+	}
+
+	if pool, ok := os.LookupEnv("CARDANO_VALLEY_POOL_ID"); ok && pool != "" {
+		n.PoolID = pool
+	}
+
+	Current = n
+	logger.Record.Info("NETWORK", "SELECTED", Current.Name, "POOL", Current.PoolID)
+	if Current.PoolID == "" {
+		logger.Record.Warn("NETWORK", "NO POOL", "wallets will not be delegated, set CARDANO_VALLEY_POOL_ID to delegate on "+Current.Name)
+	}
+}
+
+// Lookup returns the named network's settings.
+func Lookup(name string) (Network, error) {
+	n, ok := networks[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Network{}, fmt.Errorf("unknown network %q, expected mainnet, preprod or preview", name)
+	}
+	return n, nil
+}
+
+func (n Network) IsMainnet() bool {
+	return n.Name == Mainnet
+}
+
+// CLIArgs are the cardano-cli flags selecting this network.
+func (n Network) CLIArgs() []string {
+	if n.IsMainnet() {
+		return []string{"--mainnet"}
+	}
+	return []string{"--testnet-magic", strconv.FormatUint(uint64(n.Magic), 10)}
+}
+
+// KoiosURL is the base of the Koios REST API, with a trailing slash.
+func (n Network) KoiosURL() string {
+	return "https://" + n.KoiosHost + "/api/v1/"
+}
+
+// MaestroURL is the base of the Maestro API, with a trailing slash.
+func (n Network) MaestroURL() string {
+	return "https://" + n.MaestroNetwork + ".gomaestro-api.org/v1/"
+}
@@ -0,0 +1,268 @@
+// Package vault keeps wallet signing keys encrypted at rest with envelope
+// encryption. Every wallet gets its own random data key; the data key is
+// stored wrapped by the master key (db.Keys) and the secrets are sealed with
+// the data key. Secrets are only decrypted into memory, when a tx is signed,
+// and never written to disk. Rotating the master key only re-wraps the data
+// keys; see Rewrap.
+package vault
+
+import (
+	mongo "cardano-valley/pkg/db"
+	"cardano-valley/pkg/logger"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type (
+	// Record is everything the vault holds for one wallet.
+	Record struct {
+		WalletID   string            `bson:"wallet_id"`
+		WrappedKey string            `bson:"wrapped_key"` // data key sealed with the master key
+		Secrets    map[string]string `bson:"secrets"`     // name -> secret sealed with the data key
+		CreatedAt  time.Time         `bson:"created_at"`
+		UpdatedAt  time.Time         `bson:"updated_at"`
+	}
+
+	Store interface {
+		Load(ctx context.Context, walletID string) (Record, error)
+		Save(ctx context.Context, r Record) error
+		Delete(ctx context.Context, walletID string) error
+		IDs(ctx context.Context) ([]string, error)
+	}
+
+	Vault struct {
+		Store   Store
+		Keyring *mongo.Keyring // wraps the data keys, db.Keys when nil
+
+		mu sync.Mutex // serialises read-modify-write of records
+	}
+)
+
+// Secret names used for wallet keys.
+const (
+	PaymentSigningKey = "payment.skey"
+	StakeSigningKey   = "stake.skey"
+	RootMnemonic      = "root.mnemonic" // the HD root phrase
+
+	dataKeySize = 32
+)
+
+var (
+	ErrNotFound = errors.New("not in the vault")
+
+	// Default uses the master keys in db.Keys and Mongo, or memory in offline
+	// mode.
+	Default = newDefault()
+)
+
+func newDefault() *Vault {
+	var store Store = MongoStore{}
+	if os.Getenv("CARDANO_VALLEY_OFFLINE") != "" {
+		store = NewMemoryStore()
+	}
+	return New(store, nil)
+}
+
+// keyring is read on use, Default is made before main loads db.Keys.
+func (v *Vault) keyring() *mongo.Keyring {
+	if v.Keyring != nil {
+		return v.Keyring
+	}
+	return mongo.Keys
+}
+
+func New(store Store, keyring *mongo.Keyring) *Vault {
+	return &Vault{Store: store, Keyring: keyring}
+}
+
+// Put seals secret under name in the wallet's record, creating the record
+// and its data key on first use.
+func Put(walletID, name string, secret []byte) error { return Default.Put(walletID, name, secret) }
+
+// Get decrypts a secret into memory. Callers should Wipe it when done.
+func Get(walletID, name string) ([]byte, error) { return Default.Get(walletID, name) }
+
+// Has reports whether the wallet has a secret under name.
+func Has(walletID, name string) bool { return Default.Has(walletID, name) }
+
+// Delete drops the wallet's record, data key included.
+func Delete(walletID string) error { return Default.Delete(walletID) }
+
+// Rewrap re-wraps the wallet's data key under the current master key.
+func Rewrap(walletID string) (bool, error) { return Default.Rewrap(walletID) }
+
+// IDs lists the wallets in the vault.
+func IDs() ([]string, error) { return Default.Store.IDs(context.Background()) }
+
+func (v *Vault) Put(walletID, name string, secret []byte) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ctx := context.Background()
+
+	record, err := v.Store.Load(ctx, walletID)
+	now := time.Now().UTC()
+	switch {
+	case errors.Is(err, ErrNotFound):
+		record = Record{WalletID: walletID, Secrets: make(map[string]string), CreatedAt: now}
+		dataKey := make([]byte, dataKeySize)
+		if _, err := rand.Read(dataKey); err != nil {
+			return err
+		}
+		defer Wipe(dataKey)
+		if record.WrappedKey, err = v.keyring().Seal(dataKey, []byte(walletID)); err != nil {
+			return fmt.Errorf("vault %s: wrap data key: %w", walletID, err)
+		}
+	case err != nil:
+		return fmt.Errorf("vault %s: %w", walletID, err)
+	}
+
+	dataKey, err := v.dataKey(record)
+	if err != nil {
+		return err
+	}
+	defer Wipe(dataKey)
+
+	sealed, err := seal(dataKey, secret, walletID+"/"+name)
+	if err != nil {
+		return fmt.Errorf("vault %s: seal %s: %w", walletID, name, err)
+	}
+	if record.Secrets == nil {
+		record.Secrets = make(map[string]string)
+	}
+	record.Secrets[name] = sealed
+	record.UpdatedAt = now
+
+	if err := v.Store.Save(ctx, record); err != nil {
+		return fmt.Errorf("vault %s: %w", walletID, err)
+	}
+	logger.Record.Info("VAULT", "STORED", name, "WALLET", walletID)
+	return nil
+}
+
+func (v *Vault) Get(walletID, name string) ([]byte, error) {
+	record, err := v.Store.Load(context.Background(), walletID)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s: %w", walletID, err)
+	}
+	sealed, ok := record.Secrets[name]
+	if !ok {
+		return nil, fmt.Errorf("vault %s: %s: %w", walletID, name, ErrNotFound)
+	}
+
+	dataKey, err := v.dataKey(record)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(dataKey)
+
+	secret, err := open(dataKey, sealed, walletID+"/"+name)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s: open %s: %w", walletID, name, err)
+	}
+	return secret, nil
+}
+
+func (v *Vault) Has(walletID, name string) bool {
+	record, err := v.Store.Load(context.Background(), walletID)
+	if err != nil {
+		return false
+	}
+	_, ok := record.Secrets[name]
+	return ok
+}
+
+func (v *Vault) Delete(walletID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.Store.Delete(context.Background(), walletID)
+}
+
+// Rewrap seals the wallet's data key again under the current master key.
+// The secrets themselves are untouched. It reports whether the record
+// changed.
+func (v *Vault) Rewrap(walletID string) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ctx := context.Background()
+
+	record, err := v.Store.Load(ctx, walletID)
+	if err != nil {
+		return false, fmt.Errorf("vault %s: %w", walletID, err)
+	}
+	if v.keyring().IsCurrent(record.WrappedKey) {
+		return false, nil
+	}
+	wrapped, err := v.keyring().Reseal(record.WrappedKey, []byte(walletID))
+	if err != nil {
+		return false, fmt.Errorf("vault %s: rewrap data key: %w", walletID, err)
+	}
+	record.WrappedKey = wrapped
+	record.UpdatedAt = time.Now().UTC()
+	if err := v.Store.Save(ctx, record); err != nil {
+		return false, fmt.Errorf("vault %s: %w", walletID, err)
+	}
+	return true, nil
+}
+
+func (v *Vault) dataKey(record Record) ([]byte, error) {
+	dataKey, err := v.keyring().Open(record.WrappedKey, []byte(record.WalletID))
+	if err != nil {
+		return nil, fmt.Errorf("vault %s: unwrap data key: %w", record.WalletID, err)
+	}
+	return dataKey, nil
+}
+
+// Wipe zeroes a decrypted secret.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// ─── AES-GCM ───
+
+// seal encrypts plaintext with key, binding it to aad as additional data so
+// a sealed value cannot be swapped between wallets or secrets.
+func seal(key, plaintext []byte, aad string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, []byte(aad))), nil
+}
+
+func open(key []byte, sealed, aad string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, []byte(aad))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
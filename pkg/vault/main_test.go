@@ -0,0 +1,165 @@
+package vault
+
+import (
+	"bytes"
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func keyring(t *testing.T, id string, key []byte) *mongo.Keyring {
+	t.Helper()
+	k, err := mongo.NewKeyring(id, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// rotated has k2 as the current key and k1 retired.
+func rotated(t *testing.T) *mongo.Keyring {
+	t.Helper()
+	k := keyring(t, "k2", newKey)
+	if err := k.Add("k1", oldKey); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	v := New(NewMemoryStore(), keyring(t, "k1", oldKey))
+	secret := []byte("ed25519_sk1secret")
+
+	if err := v.Put("wallet", PaymentSigningKey, secret); err != nil {
+		t.Fatal(err)
+	}
+	got, err := v.Get("wallet", PaymentSigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, secret) {
+		t.Errorf("Get = %q, want %q", got, secret)
+	}
+	if !v.Has("wallet", PaymentSigningKey) || v.Has("wallet", StakeSigningKey) {
+		t.Error("Has does not match the stored secrets")
+	}
+
+	record, _ := v.Store.Load(context.Background(), "wallet")
+	if strings.Contains(record.Secrets[PaymentSigningKey], string(secret)) {
+		t.Error("the secret is stored in the clear")
+	}
+
+	if _, err := v.Get("wallet", StakeSigningKey); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a missing secret = %v, want %v", err, ErrNotFound)
+	}
+	if err := v.Delete("wallet"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Get("wallet", PaymentSigningKey); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestKeyIDPrefix(t *testing.T) {
+	store := NewMemoryStore()
+	if err := New(store, keyring(t, "k1", oldKey)).Put("wallet", PaymentSigningKey, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	record, _ := store.Load(context.Background(), "wallet")
+	if id := mongo.KeyID(record.WrappedKey); id != "k1" {
+		t.Errorf("data key wrapped under %q, want k1", id)
+	}
+}
+
+func TestRetiredKey(t *testing.T) {
+	store := NewMemoryStore()
+	if err := New(store, keyring(t, "k1", oldKey)).Put("wallet", PaymentSigningKey, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	v := New(store, rotated(t))
+	got, err := v.Get("wallet", PaymentSigningKey)
+	if err != nil || string(got) != "secret" {
+		t.Fatalf("Get under the retired key = %q, %v", got, err)
+	}
+
+	changed, err := v.Rewrap("wallet")
+	if err != nil || !changed {
+		t.Fatalf("Rewrap = %v, %v", changed, err)
+	}
+	if changed, _ := v.Rewrap("wallet"); changed {
+		t.Error("a second Rewrap changed the record")
+	}
+	record, _ := store.Load(context.Background(), "wallet")
+	if id := mongo.KeyID(record.WrappedKey); id != "k2" {
+		t.Errorf("data key wrapped under %q after Rewrap, want k2", id)
+	}
+
+	// once re-wrapped the old key can go
+	got, err = New(store, keyring(t, "k2", newKey)).Get("wallet", PaymentSigningKey)
+	if err != nil || string(got) != "secret" {
+		t.Errorf("Get without the retired key = %q, %v", got, err)
+	}
+}
+
+func TestUnknownKey(t *testing.T) {
+	store := NewMemoryStore()
+	if err := New(store, keyring(t, "k1", oldKey)).Put("wallet", PaymentSigningKey, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := New(store, keyring(t, "k2", newKey)).Get("wallet", PaymentSigningKey)
+	if !errors.Is(err, mongo.ErrUnknownKey) {
+		t.Errorf("Get = %v, want %v", err, mongo.ErrUnknownKey)
+	}
+}
+
+func TestTamper(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	v := New(store, keyring(t, "k1", oldKey))
+	for _, id := range []string{"wallet", "other"} {
+		for _, name := range []string{PaymentSigningKey, StakeSigningKey} {
+			if err := v.Put(id, name, []byte(id+" "+name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wallet, _ := store.Load(ctx, "wallet")
+	other, _ := store.Load(ctx, "other")
+
+	flipped, _ := base64.StdEncoding.DecodeString(wallet.Secrets[PaymentSigningKey])
+	flipped[len(flipped)-1] ^= 1
+	wrapped := []byte(wallet.WrappedKey)
+	wrapped[len(wrapped)-3] ^= 1
+
+	tests := []struct {
+		name   string
+		tamper func(r *Record)
+	}{
+		{"flipped bit", func(r *Record) { r.Secrets[PaymentSigningKey] = base64.StdEncoding.EncodeToString(flipped) }},
+		{"secret of another name", func(r *Record) { r.Secrets[PaymentSigningKey] = wallet.Secrets[StakeSigningKey] }},
+		{"secret of another wallet", func(r *Record) { r.Secrets[PaymentSigningKey] = other.Secrets[PaymentSigningKey] }},
+		{"data key of another wallet", func(r *Record) { r.WrappedKey = other.WrappedKey }},
+		{"wrapped data key", func(r *Record) { r.WrappedKey = string(wrapped) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := store.Load(ctx, "wallet")
+			tt.tamper(&r)
+			tampered := NewMemoryStore()
+			tampered.Save(ctx, r)
+
+			if got, err := New(tampered, v.Keyring).Get("wallet", PaymentSigningKey); err == nil {
+				t.Errorf("Get of a tampered record = %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package vault
+
+import (
+	mongo "cardano-valley/pkg/db"
+	"context"
+	"errors"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errNoDB = errors.New("database is not connected")
+
+// MongoStore keeps records in the "key-vault" collection.
+type MongoStore struct{}
+
+func (MongoStore) Load(ctx context.Context, walletID string) (Record, error) {
+	var r Record
+	if mongo.DB == nil {
+		return r, errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("key-vault")
+	filter := bson.D{{Key: "wallet_id", Value: walletID}}
+
+	err := collection.FindOne(ctx, filter).Decode(&r)
+	if errors.Is(err, mongodriver.ErrNoDocuments) {
+		return r, ErrNotFound
+	}
+	return r, err
+}
+
+func (MongoStore) Save(ctx context.Context, r Record) error {
+	if mongo.DB == nil {
+		return errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("key-vault")
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "wallet_id", Value: r.WalletID}}
+
+	_, err := collection.ReplaceOne(ctx, filter, r, opts)
+	return err
+}
+
+func (MongoStore) Delete(ctx context.Context, walletID string) error {
+	if mongo.DB == nil {
+		return errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("key-vault")
+	filter := bson.D{{Key: "wallet_id", Value: walletID}}
+
+	_, err := collection.DeleteOne(ctx, filter)
+	return err
+}
+
+func (MongoStore) IDs(ctx context.Context) ([]string, error) {
+	if mongo.DB == nil {
+		return nil, errNoDB
+	}
+	collection := mongo.DB.Database("cardano-valley").Collection("key-vault")
+	values, err := collection.Distinct(ctx, "wallet_id", bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// MemoryStore is used in offline mode, where there is no database.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (m *MemoryStore) Load(ctx context.Context, walletID string) (Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.records[walletID]
+	if !ok {
+		return r, ErrNotFound
+	}
+	// hand out a copy so callers cannot edit the stored secrets
+	secrets := make(map[string]string, len(r.Secrets))
+	for name, sealed := range r.Secrets {
+		secrets[name] = sealed
+	}
+	r.Secrets = secrets
+	return r, nil
+}
+
+func (m *MemoryStore) Save(ctx context.Context, r Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[r.WalletID] = r
+	return nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, walletID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, walletID)
+	return nil
+}
+
+func (m *MemoryStore) IDs(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.records))
+	for id := range m.records {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,233 @@
+// Package web serves the pages users open from Discord links, such as the
+// CIP-30 signing page for txs paid from their own wallet.
+package web
+
+import (
+	"cardano-valley/pkg/cardano"
+	"cardano-valley/pkg/cv"
+	"cardano-valley/pkg/logger"
+	"cardano-valley/pkg/network"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type (
+	// SignPage is what templates/sign.html renders.
+	SignPage struct {
+		Token       string
+		Description string
+		Address     string
+		Amount      string // ADA the user pays, on top of the network fee
+		Network     string
+		NetworkID   int // what a CIP-30 wallet's getNetworkId returns on this network
+		ExpiresAt   time.Time
+		Error       string
+	}
+
+	// LinkPage is what templates/link.html renders.
+	LinkPage struct {
+		Token      string
+		Message    string
+		MessageHex string // the payload signData takes
+		Network    string
+		NetworkID  int
+		ExpiresAt  time.Time
+		Error      string
+	}
+
+	submitRequest struct {
+		Witness string `json:"witness"`
+	}
+
+	linkResponse struct {
+		Address string `json:"address,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}
+
+	txResponse struct {
+		CBOR   string `json:"cbor,omitempty"`
+		TxHash string `json:"txHash,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+)
+
+// Enabled reports whether links to the web server can be handed out. It needs
+// CARDANO_VALLEY_WEB_URL, the public URL the server is reachable at.
+func Enabled() bool {
+	return os.Getenv("CARDANO_VALLEY_WEB_URL") != ""
+}
+
+// SigningLink is the page a signing request is signed on.
+func SigningLink(token string) string {
+	return strings.TrimSuffix(os.Getenv("CARDANO_VALLEY_WEB_URL"), "/") + "/sign/" + token
+}
+
+// LinkWalletLink is the page a wallet link challenge is signed on.
+func LinkWalletLink(token string) string {
+	return strings.TrimSuffix(os.Getenv("CARDANO_VALLEY_WEB_URL"), "/") + "/link/" + token
+}
+
+// Serve listens on CARDANO_VALLEY_WEB_ADDR, :8080 by default.
+func Serve() {
+	addr := os.Getenv("CARDANO_VALLEY_WEB_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sign/{token}", signPage)
+	mux.HandleFunc("POST /sign/{token}/build", buildTx)
+	mux.HandleFunc("POST /sign/{token}/submit", submitTx)
+	mux.HandleFunc("GET /link/{token}", linkPage)
+	mux.HandleFunc("POST /link/{token}/verify", verifyLink)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Record.Info("WEB", "LISTENING", addr)
+	if err := server.ListenAndServe(); err != nil {
+		logger.Record.Error("WEB", "ERROR", err)
+	}
+}
+
+func signPage(w http.ResponseWriter, r *http.Request) {
+	page := SignPage{Network: network.Current.Name, NetworkID: int(network.Current.NetworkID)}
+	status := http.StatusOK
+	req, err := cv.LoadSigningRequest(r.PathValue("token"))
+	if err != nil {
+		status = http.StatusNotFound
+		page.Error = "This signing link does not exist."
+	} else {
+		page.Token = req.Token
+		page.Description = req.Description
+		page.Address = req.Address
+		page.ExpiresAt = req.ExpiresAt
+		var lovelace uint64
+		for _, out := range req.Outputs {
+			lovelace += out.Lovelace
+		}
+		page.Amount = fmt.Sprintf("%.6f", float64(lovelace)/1_000_000)
+		if err := req.Open(); err != nil {
+			page.Error = err.Error()
+		}
+	}
+
+	tmp, err := template.ParseFiles("templates/sign.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmp.Execute(w, page); err != nil {
+		logger.Record.Warn("WEB", "TEMPLATE", err)
+	}
+}
+
+// buildTx returns the unsigned tx for the wallet to sign.
+func buildTx(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	req, err := cv.LoadSigningRequest(r.PathValue("token"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, txResponse{Error: "This signing link does not exist."})
+		return
+	}
+	if err := req.Build(ctx); err != nil {
+		writeJSON(w, http.StatusBadRequest, txResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, txResponse{CBOR: req.CBOR, TxHash: req.TxHash})
+}
+
+// submitTx takes the witness set from the wallet's signTx and submits.
+func submitTx(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	req, err := cv.LoadSigningRequest(r.PathValue("token"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, txResponse{Error: "This signing link does not exist."})
+		return
+	}
+	var body submitRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, txResponse{Error: "invalid request"})
+		return
+	}
+	txHash, err := req.Submit(ctx, body.Witness)
+	if err != nil {
+		logger.Record.Warn("WEB", "SIGNING REQUEST", req.Token, "USER", req.UserID, "ERROR", err)
+		writeJSON(w, http.StatusBadRequest, txResponse{Error: err.Error()})
+		return
+	}
+	logger.Record.Info("WEB", "SIGNING REQUEST", req.Token, "USER", req.UserID, "TX", txHash)
+	writeJSON(w, http.StatusOK, txResponse{TxHash: txHash})
+}
+
+func linkPage(w http.ResponseWriter, r *http.Request) {
+	page := LinkPage{Network: network.Current.Name, NetworkID: int(network.Current.NetworkID)}
+	status := http.StatusOK
+	c, err := cv.LoadLinkChallenge(r.PathValue("token"))
+	if err != nil {
+		status = http.StatusNotFound
+		page.Error = "This link request does not exist."
+	} else {
+		page.Token = c.Token
+		page.Message = c.Message()
+		page.MessageHex = hex.EncodeToString([]byte(c.Message()))
+		page.ExpiresAt = c.ExpiresAt
+		if err := c.Open(); err != nil {
+			page.Error = err.Error()
+		}
+	}
+
+	tmp, err := template.ParseFiles("templates/link.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmp.Execute(w, page); err != nil {
+		logger.Record.Warn("WEB", "TEMPLATE", err)
+	}
+}
+
+// verifyLink takes the wallet's signData result and links the wallet.
+func verifyLink(w http.ResponseWriter, r *http.Request) {
+	c, err := cv.LoadLinkChallenge(r.PathValue("token"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, linkResponse{Error: "This link request does not exist."})
+		return
+	}
+	var signed cardano.SignedData
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&signed); err != nil {
+		writeJSON(w, http.StatusBadRequest, linkResponse{Error: "invalid request"})
+		return
+	}
+	wallet, _, err := c.VerifySignature(signed)
+	if err != nil {
+		logger.Record.Warn("WEB", "LINK WALLET", c.UserID, "ERROR", err)
+		writeJSON(w, http.StatusBadRequest, linkResponse{Error: err.Error()})
+		return
+	}
+	logger.Record.Info("WEB", "LINK WALLET", c.UserID, "ADDRESS", wallet.Payment)
+	writeJSON(w, http.StatusOK, linkResponse{Address: wallet.Payment})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}